@@ -31,6 +31,14 @@ func main() {
 	if err != nil {
 		panic("failed to parse APP_MAXCHATS .env:" + err.Error())
 	}
+	maxPinned, err := strconv.Atoi(os.Getenv("APP_MAXPINNED"))
+	if err != nil {
+		panic("failed to parse APP_MAXPINNED .env:" + err.Error())
+	}
+	idempotencyWindow, err := strconv.Atoi(os.Getenv("APP_IDEMPOTENCYWINDOW"))
+	if err != nil {
+		panic("failed to parse APP_IDEMPOTENCYWINDOW .env:" + err.Error())
+	}
 	serverConfig := config.MustConfigInit(
 		os.Getenv("APP_ADDRESS"),
 		os.Getenv("APP_PORTGRPC"),
@@ -38,6 +46,8 @@ func main() {
 		os.Getenv("APP_ENV"),
 		maxChatSize,
 		maxChats,
+		maxPinned,
+		idempotencyWindow,
 		os.Getenv("APP_DB"),
 	)
 
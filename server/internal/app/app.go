@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Rolan335/grpcMessenger/server/internal/app/health"
@@ -15,6 +16,7 @@ import (
 	"github.com/Rolan335/grpcMessenger/server/internal/kafka"
 	"github.com/Rolan335/grpcMessenger/server/internal/logger"
 	"github.com/Rolan335/grpcMessenger/server/internal/repository/inmemory"
+	"github.com/Rolan335/grpcMessenger/server/internal/repository/localfs"
 	"github.com/Rolan335/grpcMessenger/server/internal/repository/postgres"
 	"github.com/Rolan335/grpcMessenger/server/internal/repository/redis"
 	"github.com/Rolan335/grpcMessenger/server/internal/service/messenger"
@@ -31,34 +33,62 @@ type ServiceServer struct {
 	grpcServer    *grpc.Server
 	httpServerMux *runtime.ServeMux
 	httpServer    *http.Server
-	storage       messenger.Storage
+	service       controller.Server
 }
 
 func NewServiceServer(config config.ServiceCfg) *ServiceServer {
 	//kafka initialization
 	kafka.Init(os.Getenv("KAFKA_BROKER_0"))
 
+	db := storageInit(config.StorageType, config.MaxChats, config.MaxChatSize)
+	blobs, limits := blobStoreInit()
+	//service is created before grpc server, presence interceptors report activity of sessions to it
+	service := controller.NewServer(db, config.MaxPinned, config.IdempotencyWindow, blobs, limits)
+
 	//making interceptors chain
 	chainUnaryInterceptor := grpc.ChainUnaryInterceptor(
 		interceptors.Metric,
 		interceptors.Log,
+		interceptors.Presence(service.Seen),
+	)
+
+	chainStreamInterceptor := grpc.ChainStreamInterceptor(
+		interceptors.MetricStream,
+		interceptors.LogStream,
+		interceptors.PresenceStream(service.Seen),
 	)
 
-	serverOptions := []grpc.ServerOption{chainUnaryInterceptor}
+	serverOptions := []grpc.ServerOption{chainUnaryInterceptor, chainStreamInterceptor}
 	//Make new grpc server instance
 	s := grpc.NewServer(serverOptions...)
 
-	db := storageInit(config.StorageType, config.MaxChats, config.MaxChatSize)
-
 	return &ServiceServer{
 		config:        config,
 		grpcServer:    s,
 		httpServerMux: runtime.NewServeMux(),
 		httpServer:    &http.Server{Addr: config.PortHTTP, Handler: nil},
-		storage:       db,
+		service:       service,
 	}
 }
 
+// initializing store of attachments content and limits of attachments
+func blobStoreInit() (messenger.BlobStore, messenger.AttachmentLimits) {
+	maxSize, err := strconv.ParseInt(os.Getenv("ATTACHMENTS_MAXSIZE"), 10, 64)
+	if err != nil {
+		panic("failed to parse .env ATTACHMENTS_MAXSIZE: " + err.Error())
+	}
+	chatQuota, err := strconv.ParseInt(os.Getenv("ATTACHMENTS_CHATQUOTA"), 10, 64)
+	if err != nil {
+		panic("failed to parse .env ATTACHMENTS_CHATQUOTA: " + err.Error())
+	}
+	limits := messenger.AttachmentLimits{
+		MaxSize:   maxSize,
+		ChatQuota: chatQuota,
+		Types:     strings.Split(os.Getenv("ATTACHMENTS_TYPES"), ","),
+	}
+	return localfs.NewStorage(os.Getenv("ATTACHMENTS_PATH")), limits
+}
+
 func storageInit(storageType string, maxChats int, maxChatSize int) messenger.Storage {
 	//initializing storage
 	var db messenger.Storage
@@ -91,11 +121,16 @@ func storageInit(storageType string, maxChats int, maxChatSize int) messenger.St
 		if err != nil {
 			panic("failed to parse .env REDIS_DB: " + err.Error())
 		}
+		keyspaceEvents, err := strconv.ParseBool(os.Getenv("REDIS_KEYSPACE_EVENTS"))
+		if err != nil {
+			panic("failed to parse .env REDIS_KEYSPACE_EVENTS: " + err.Error())
+		}
 		redisConfig := redis.Config{
-			Addr:     os.Getenv("REDIS_ADDRESS"),
-			Password: os.Getenv("REDIS_PASSWORD"),
-			DB:       redisDb,
-			FlushAll: freshstart,
+			Addr:           os.Getenv("REDIS_ADDRESS"),
+			Password:       os.Getenv("REDIS_PASSWORD"),
+			DB:             redisDb,
+			FlushAll:       freshstart,
+			KeyspaceEvents: keyspaceEvents,
 		}
 		db = redis.NewStorage(redisConfig, maxChatSize, maxChats)
 	default:
@@ -111,7 +146,7 @@ func (s *ServiceServer) MustStartGRPC() {
 		panic(err)
 	}
 	//Registering server
-	proto.RegisterMessengerServiceServer(s.grpcServer, controller.NewServer(s.storage))
+	proto.RegisterMessengerServiceServer(s.grpcServer, s.service)
 	go func() {
 		err := s.grpcServer.Serve(lis)
 		if err != nil {
@@ -150,6 +185,7 @@ func (s *ServiceServer) GracefulStop() {
 		logger.Logger.ErrorContext(ctx, "failed to gracefully shutdown http: "+err.Error())
 	}
 	s.grpcServer.GracefulStop()
+	s.service.Close()
 	redis.GracefulStop()
 	postgres.GracefulStop()
 	kafka.Close()
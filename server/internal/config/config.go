@@ -13,13 +13,18 @@ type ServiceCfg struct {
 	Env         string
 	MaxChatSize int
 	MaxChats    int
-	StorageType string
+	MaxPinned   int
+	// seconds idempotency keys of SendMessage are remembered for, 0 - keys are not remembered
+	IdempotencyWindow int
+	StorageType       string
 }
 
 // Initializing Config and panic if couldn't
-func MustConfigInit(address string, portGRPC string, portHTTP string, env string, maxChatSize int, maxChats int, storageType string) ServiceCfg {
+func MustConfigInit(address string, portGRPC string, portHTTP string, env string, maxChatSize int, maxChats int, maxPinned int, idempotencyWindow int, storageType string) ServiceCfg {
 	maxChatsAvailable := 1000
 	maxChatSizeAvailable := 5000
+	maxPinnedAvailable := 100
+	maxIdempotencyWindowAvailable := 7 * 24 * 60 * 60
 	maxPort := 65535
 
 	reg := regexp.MustCompile(`:\d{1,5}`)
@@ -45,13 +50,23 @@ func MustConfigInit(address string, portGRPC string, portHTTP string, env string
 		maxChatSize = maxChatSizeAvailable
 	}
 
+	if maxPinned > maxPinnedAvailable {
+		maxPinned = maxPinnedAvailable
+	}
+
+	if idempotencyWindow > maxIdempotencyWindowAvailable {
+		idempotencyWindow = maxIdempotencyWindowAvailable
+	}
+
 	return ServiceCfg{
-		Address:     address,
-		PortGRPC:    portGRPC,
-		PortHTTP:    portHTTP,
-		Env:         env,
-		MaxChatSize: maxChatSize,
-		MaxChats:    maxChats,
-		StorageType: storageType,
+		Address:           address,
+		PortGRPC:          portGRPC,
+		PortHTTP:          portHTTP,
+		Env:               env,
+		MaxChatSize:       maxChatSize,
+		MaxChats:          maxChats,
+		MaxPinned:         maxPinned,
+		IdempotencyWindow: idempotencyWindow,
+		StorageType:       storageType,
 	}
 }
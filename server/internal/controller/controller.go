@@ -6,13 +6,19 @@ package controller
 import (
 	"context"
 	"errors"
+	"io"
+	"time"
 
 	"github.com/Rolan335/grpcMessenger/server/internal/kafka"
+	"github.com/Rolan335/grpcMessenger/server/internal/repository/entities"
 	"github.com/Rolan335/grpcMessenger/server/internal/service/messenger"
 	"github.com/Rolan335/grpcMessenger/server/pkg/proto"
 
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type Server struct {
@@ -20,10 +26,17 @@ type Server struct {
 	proto.UnimplementedMessengerServiceServer
 }
 
-// Server creation. Initializing storage and returning service
-func NewServer(storage messenger.Storage) Server {
+// Server creation. Initializing storage and returning service. Attachments are disabled if blobs is nil.
+// idempotencyWindow is in seconds
+func NewServer(storage messenger.Storage, maxPinned int, idempotencyWindow int, blobs messenger.BlobStore, limits messenger.AttachmentLimits) Server {
+	m := messenger.NewMessenger(storage, maxPinned, idempotencyWindow)
+	//Chats deleted by ttl are reported like created and deleted ones
+	m.OnChatExpired(kafka.ChatExpiredEvent)
+	if blobs != nil {
+		m.EnableAttachments(blobs, limits)
+	}
 	return Server{
-		m: messenger.NewMessenger(storage),
+		m: m,
 	}
 }
 
@@ -39,9 +52,14 @@ func (s Server) InitSession(_ context.Context, _ *proto.InitSessionRequest) (*pr
 
 // Implementation of CreateChat rpc
 func (s Server) CreateChat(_ context.Context, r *proto.CreateChatRequest) (*proto.CreateChatResponse, error) {
-	ChatUUID, err := s.m.CreateChat(r.GetSessionUuid(), int(r.GetTtl()), r.GetReadOnly())
+	info := entities.ChatInfo{
+		Title:       r.GetTitle(),
+		Description: r.GetDescription(),
+		Tags:        r.GetTags(),
+	}
+	ChatUUID, err := s.m.CreateChat(r.GetSessionUuid(), int(r.GetTtl()), r.GetReadOnly(), entities.Visibility(r.GetVisibility()), info)
 	if err != nil {
-		if errors.Is(err, messenger.ErrInvalidSessionUUID) {
+		if errors.Is(err, messenger.ErrInvalidSessionUUID) || errors.Is(err, messenger.ErrInvalidVisibility) || isInvalidChatInfo(err) {
 			return nil, status.Error(codes.InvalidArgument, err.Error())
 		}
 		if errors.Is(err, messenger.ErrUserDoesNotExist) {
@@ -58,64 +76,1027 @@ func (s Server) CreateChat(_ context.Context, r *proto.CreateChatRequest) (*prot
 	return response, nil
 }
 
-// Implementation of SendMessage rpc
-func (s Server) SendMessage(_ context.Context, r *proto.SendMessageRequest) (*proto.SendMessageResponse, error) {
-	err := s.m.SendMessage(r.GetSessionUuid(), r.GetChatUuid(), r.GetMessage())
+// Implementation of OpenDirectChat rpc
+func (s Server) OpenDirectChat(_ context.Context, r *proto.OpenDirectChatRequest) (*proto.OpenDirectChatResponse, error) {
+	chatUUID, created, err := s.m.OpenDirectChat(r.GetSessionUuid(), r.GetPeerSessionUuid())
 	if err != nil {
-		if errors.Is(err, messenger.ErrInvalidSessionUUID) || errors.Is(err, messenger.ErrInvalidChatUUID) {
+		if errors.Is(err, messenger.ErrInvalidSessionUUID) || errors.Is(err, messenger.ErrInvalidPeerSessionUUID) {
 			return nil, status.Error(codes.InvalidArgument, err.Error())
 		}
-		if errors.Is(err, messenger.ErrChatNotFound) || errors.Is(err, messenger.ErrUserDoesNotExist) {
+		if errors.Is(err, messenger.ErrUserDoesNotExist) {
 			return nil, status.Error(codes.NotFound, err.Error())
 		}
-		if errors.Is(err, messenger.ErrProhibited) {
-			return nil, status.Error(codes.PermissionDenied, err.Error())
-		}
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
+	//sending msg to kafka only when chat is really created
+	if created {
+		kafka.CreateChatEvent(chatUUID)
+	}
+
 	//Creating, sending response
-	response := &proto.SendMessageResponse{}
+	response := &proto.OpenDirectChatResponse{ChatUuid: chatUUID}
 	return response, nil
 }
 
+// Implementation of SendMessage rpc
+func (s Server) SendMessage(_ context.Context, r *proto.SendMessageRequest) (*proto.SendMessageResponse, error) {
+	message, err := s.m.SendMessage(r.GetSessionUuid(), r.GetChatUuid(), r.GetMessage(), r.GetAttachmentIds(), r.GetReplyToMessageUuid(),
+		int(r.GetExpireAfterSeconds()), r.GetIdempotencyKey())
+	if err != nil {
+		return nil, sendMessageError(err)
+	}
+
+	//Creating, sending response
+	response := &proto.SendMessageResponse{
+		MessageUuid: message.MessageUUID,
+	}
+	return response, nil
+}
+
+// Mapping errors of sending message to grpc status. Shared between SendMessage and Chat so the rules stay identical
+func sendMessageError(err error) error {
+	if errors.Is(err, messenger.ErrInvalidSessionUUID) || errors.Is(err, messenger.ErrInvalidChatUUID) || errors.Is(err, messenger.ErrInvalidMessageUUID) ||
+		errors.Is(err, messenger.ErrInvalidAttachmentID) || errors.Is(err, messenger.ErrTooManyAttachments) || errors.Is(err, messenger.ErrInvalidDuration) ||
+		errors.Is(err, messenger.ErrInvalidIdempotencyKey) || errors.Is(err, messenger.ErrIdempotencyKeyReused) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	//Retry can succeed once the first request is sent
+	if errors.Is(err, messenger.ErrIdempotencyKeyInFlight) {
+		return status.Error(codes.Aborted, err.Error())
+	}
+	//Message is stored, retry with the same key returns it instead of sending it again
+	if errors.Is(err, messenger.ErrIdempotencyKeyNotMarked) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	if errors.Is(err, messenger.ErrChatNotFound) || errors.Is(err, messenger.ErrUserDoesNotExist) ||
+		errors.Is(err, messenger.ErrMessageNotFound) || errors.Is(err, messenger.ErrAttachmentNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	if errors.Is(err, messenger.ErrAttachmentsDisabled) {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if errors.Is(err, messenger.ErrProhibited) || errors.Is(err, messenger.ErrNotMember) ||
+		errors.Is(err, messenger.ErrBanned) || errors.Is(err, messenger.ErrMuted) {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// Implementation of EditMessage rpc
+func (s Server) EditMessage(_ context.Context, r *proto.EditMessageRequest) (*proto.EditMessageResponse, error) {
+	err := s.m.EditMessage(r.GetSessionUuid(), r.GetChatUuid(), r.GetMessageUuid(), r.GetText())
+	if err != nil {
+		return nil, changeMessageError(err)
+	}
+
+	//Creating, sending response
+	response := &proto.EditMessageResponse{}
+	return response, nil
+}
+
+// Implementation of DeleteMessage rpc
+func (s Server) DeleteMessage(_ context.Context, r *proto.DeleteMessageRequest) (*proto.DeleteMessageResponse, error) {
+	err := s.m.DeleteMessage(r.GetSessionUuid(), r.GetChatUuid(), r.GetMessageUuid())
+	if err != nil {
+		return nil, changeMessageError(err)
+	}
+
+	//Creating, sending response
+	response := &proto.DeleteMessageResponse{}
+	return response, nil
+}
+
+// Mapping errors of changing message to grpc status
+func changeMessageError(err error) error {
+	if errors.Is(err, messenger.ErrInvalidSessionUUID) || errors.Is(err, messenger.ErrInvalidChatUUID) || errors.Is(err, messenger.ErrInvalidMessageUUID) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if errors.Is(err, messenger.ErrChatNotFound) || errors.Is(err, messenger.ErrMessageNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	if errors.Is(err, messenger.ErrChangeMessageProhibited) || errors.Is(err, messenger.ErrDeleteMessageProhibited) || errors.Is(err, messenger.ErrNotMember) ||
+		errors.Is(err, messenger.ErrBanned) || errors.Is(err, messenger.ErrMuted) {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// Implementation of AddReaction rpc
+func (s Server) AddReaction(_ context.Context, r *proto.AddReactionRequest) (*proto.AddReactionResponse, error) {
+	added, err := s.m.AddReaction(r.GetSessionUuid(), r.GetChatUuid(), r.GetMessageUuid(), r.GetEmoji())
+	if err != nil {
+		return nil, reactionError(err)
+	}
+
+	//sending msg to kafka only when reaction is really added
+	if added {
+		kafka.ReactionAddedEvent(kafka.Reaction{
+			ChatUUID:    r.GetChatUuid(),
+			MessageUUID: r.GetMessageUuid(),
+			SessionUUID: r.GetSessionUuid(),
+			Emoji:       r.GetEmoji(),
+		})
+	}
+
+	//Creating, sending response
+	response := &proto.AddReactionResponse{}
+	return response, nil
+}
+
+// Implementation of RemoveReaction rpc
+func (s Server) RemoveReaction(_ context.Context, r *proto.RemoveReactionRequest) (*proto.RemoveReactionResponse, error) {
+	err := s.m.RemoveReaction(r.GetSessionUuid(), r.GetChatUuid(), r.GetMessageUuid(), r.GetEmoji())
+	if err != nil {
+		return nil, reactionError(err)
+	}
+
+	//Creating, sending response
+	response := &proto.RemoveReactionResponse{}
+	return response, nil
+}
+
+// Mapping errors of reactions to grpc status
+func reactionError(err error) error {
+	if errors.Is(err, messenger.ErrInvalidSessionUUID) || errors.Is(err, messenger.ErrInvalidChatUUID) ||
+		errors.Is(err, messenger.ErrInvalidMessageUUID) || errors.Is(err, messenger.ErrInvalidEmoji) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if errors.Is(err, messenger.ErrChatNotFound) || errors.Is(err, messenger.ErrMessageNotFound) || errors.Is(err, messenger.ErrUserDoesNotExist) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	if errors.Is(err, messenger.ErrNotMember) || errors.Is(err, messenger.ErrBanned) || errors.Is(err, messenger.ErrMuted) {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
 // Implementation of GetHistory rpc
 func (s Server) GetHistory(_ context.Context, r *proto.GetHistoryRequest) (*proto.GetHistoryResponse, error) {
-	messages, err := s.m.GetHistory(r.GetChatUuid())
+	direction := entities.DirectionOlder
+	if r.GetDirection() == proto.HistoryDirection_HISTORY_DIRECTION_NEWER {
+		direction = entities.DirectionNewer
+	}
+	messages, nextPageToken, err := s.m.GetHistory(r.GetSessionUuid(), r.GetChatUuid(), int(r.GetPageSize()), r.GetPageToken(), direction)
 	if err != nil {
+		if errors.Is(err, messenger.ErrInvalidChatUUID) || errors.Is(err, messenger.ErrInvalidSessionUUID) ||
+			errors.Is(err, messenger.ErrInvalidPageSize) || errors.Is(err, messenger.ErrInvalidPageToken) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
 		if errors.Is(err, messenger.ErrChatNotFound) {
 			return nil, status.Error(codes.NotFound, err.Error())
 		}
+		if errors.Is(err, messenger.ErrNotMember) || errors.Is(err, messenger.ErrBanned) {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
 	//Create response
 	history := make([]*proto.ChatMessage, 0, len(messages))
 	for _, v := range messages {
-		history = append(history, &proto.ChatMessage{
+		history = append(history, toProtoMessage(v))
+	}
+	response := &proto.GetHistoryResponse{Messages: history, NextPageToken: nextPageToken}
+	return response, nil
+}
+
+// Implementation of GetThread rpc
+func (s Server) GetThread(_ context.Context, r *proto.GetThreadRequest) (*proto.GetThreadResponse, error) {
+	root, replies, err := s.m.GetThread(r.GetSessionUuid(), r.GetChatUuid(), r.GetRootMessageUuid())
+	if err != nil {
+		if errors.Is(err, messenger.ErrInvalidChatUUID) || errors.Is(err, messenger.ErrInvalidSessionUUID) || errors.Is(err, messenger.ErrInvalidMessageUUID) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if errors.Is(err, messenger.ErrChatNotFound) || errors.Is(err, messenger.ErrMessageNotFound) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		if errors.Is(err, messenger.ErrNotMember) || errors.Is(err, messenger.ErrBanned) {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	response := &proto.GetThreadResponse{Root: toProtoMessage(root), Replies: make([]*proto.ChatMessage, 0, len(replies))}
+	for _, v := range replies {
+		response.Replies = append(response.Replies, toProtoMessage(v))
+	}
+	return response, nil
+}
+
+// Implementation of SubscribeChat rpc. Stream ends with OK status and "chat-closed" trailer when chat is deleted or evicted
+func (s Server) SubscribeChat(r *proto.SubscribeChatRequest, stream proto.MessengerService_SubscribeChatServer) error {
+	sub, replay, err := s.m.SubscribeChat(r.GetSessionUuid(), r.GetChatUuid(), int(r.GetReplayLast()))
+	if err != nil {
+		if errors.Is(err, messenger.ErrInvalidChatUUID) || errors.Is(err, messenger.ErrInvalidSessionUUID) {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		if errors.Is(err, messenger.ErrChatNotFound) {
+			return status.Error(codes.NotFound, err.Error())
+		}
+		if errors.Is(err, messenger.ErrNotMember) || errors.Is(err, messenger.ErrBanned) {
+			return status.Error(codes.PermissionDenied, err.Error())
+		}
+		return status.Error(codes.Internal, err.Error())
+	}
+	defer s.m.Unsubscribe(sub)
+
+	//Sending replayed history first, remembering it to not send the same message twice
+	replayed := make(map[string]struct{}, len(replay))
+	for _, v := range replay {
+		replayed[v.MessageUUID] = struct{}{}
+		if err := stream.Send(toProtoMessage(v)); err != nil {
+			return err
+		}
+	}
+
+	send := func(message entities.Message) error {
+		//edited or deleted message is sent again even if it was replayed
+		if _, ok := replayed[message.MessageUUID]; ok && message.EditedAt.IsZero() && !message.Deleted {
+			return nil
+		}
+		return stream.Send(toProtoMessage(message))
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case message := <-sub.Messages():
+			if err := send(message); err != nil {
+				return err
+			}
+		case <-sub.Done():
+			//Delivering messages that were sent before subscription ended
+			for len(sub.Messages()) > 0 {
+				if err := send(<-sub.Messages()); err != nil {
+					return err
+				}
+			}
+			return subscriptionEnd(stream, sub.Err())
+		}
+	}
+}
+
+// Implementation of Chat rpc. First request of stream chooses chat and session, every request with message is sent to chat and acked,
+// rejected one is nacked and stream stays open. Changing chat or session ends stream. Every event of chat is delivered on the same stream,
+// except for messages that stream acked itself.
+func (s Server) Chat(stream proto.MessengerService_ChatServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	chatUUID, sessionUUID := first.GetChatUuid(), first.GetSessionUuid()
+	sub, _, err := s.m.SubscribeChat(sessionUUID, chatUUID, 0)
+	if err != nil {
+		return sendMessageError(err)
+	}
+	defer s.m.Unsubscribe(sub)
+
+	//Only this goroutine sends to stream, so requests are received in separate one
+	requests := make(chan *proto.ChatRequest)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			r, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case requests <- r:
+			case <-stream.Context().Done():
+				return
+			}
+		}
+	}()
+
+	//Messages sent from this stream, their events are skipped since they are acked. Retry with idempotency key is acked
+	//with message sent before, which event may never come, so only event of sending is skipped
+	acked := make(map[string]struct{})
+	send := func(r *proto.ChatRequest) error {
+		if (r.GetChatUuid() != "" && r.GetChatUuid() != chatUUID) || (r.GetSessionUuid() != "" && r.GetSessionUuid() != sessionUUID) {
+			return status.Error(codes.InvalidArgument, messenger.ErrStreamTargetChanged.Error())
+		}
+		//request without message and attachments only opens stream
+		if r.GetMessage() == "" && len(r.GetAttachmentIds()) == 0 {
+			return nil
+		}
+		message, err := s.m.SendMessage(sessionUUID, chatUUID, r.GetMessage(), r.GetAttachmentIds(), r.GetReplyToMessageUuid(),
+			int(r.GetExpireAfterSeconds()), r.GetIdempotencyKey())
+		if err != nil {
+			rejected := status.Convert(sendMessageError(err))
+			return stream.Send(&proto.ChatResponse{Event: &proto.ChatResponse_Nack{Nack: &proto.ChatNack{
+				Code:    uint32(rejected.Code()),
+				Message: rejected.Message(),
+			}}})
+		}
+		acked[message.MessageUUID] = struct{}{}
+		return stream.Send(&proto.ChatResponse{Event: &proto.ChatResponse_Ack{Ack: &proto.ChatAck{
+			ChatUuid:    chatUUID,
+			MessageUuid: message.MessageUUID,
+			Seq:         message.Seq,
+		}}})
+	}
+
+	if err := send(first); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case err := <-recvErr:
+			//client closed sending side - stream is over
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		case r := <-requests:
+			if err := send(r); err != nil {
+				return err
+			}
+		case message := <-sub.Messages():
+			//message sent from this stream is already acked, its later edits and deletion are delivered
+			if _, ok := acked[message.MessageUUID]; ok && message.EditedAt.IsZero() && !message.Deleted {
+				delete(acked, message.MessageUUID)
+				continue
+			}
+			if err := stream.Send(&proto.ChatResponse{Event: &proto.ChatResponse_Message{Message: toProtoMessage(message)}}); err != nil {
+				return err
+			}
+		case <-sub.Done():
+			return subscriptionEnd(stream, sub.Err())
+		}
+	}
+}
+
+// Implementation of UpdateChat rpc
+func (s Server) UpdateChat(_ context.Context, r *proto.UpdateChatRequest) (*proto.UpdateChatResponse, error) {
+	update := entities.ChatUpdate{
+		Title:       r.Title,
+		Description: r.Description,
+		ReadOnly:    r.ReadOnly,
+	}
+	if r.GetTags() != nil {
+		tags := r.GetTags().GetTags()
+		update.Tags = &tags
+	}
+	if r.Ttl != nil {
+		ttl := int(r.GetTtl())
+		update.TTL = &ttl
+	}
+	chat, err := s.m.UpdateChat(r.GetSessionUuid(), r.GetChatUuid(), update)
+	if err != nil {
+		if errors.Is(err, messenger.ErrInvalidSessionUUID) || errors.Is(err, messenger.ErrInvalidChatUUID) || isInvalidChatInfo(err) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if errors.Is(err, messenger.ErrChatNotFound) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		if errors.Is(err, messenger.ErrUpdateProhibited) {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	//Creating, sending response
+	response := &proto.UpdateChatResponse{Chat: toProtoChat(chat)}
+	return response, nil
+}
+
+func isInvalidChatInfo(err error) bool {
+	return errors.Is(err, messenger.ErrInvalidTitle) || errors.Is(err, messenger.ErrInvalidDescription) || errors.Is(err, messenger.ErrInvalidTags)
+}
+
+// Implementation of DeleteChat rpc
+func (s Server) DeleteChat(_ context.Context, r *proto.DeleteChatRequest) (*proto.DeleteChatResponse, error) {
+	err := s.m.DeleteChat(r.GetSessionUuid(), r.GetChatUuid())
+	if err != nil {
+		if errors.Is(err, messenger.ErrInvalidSessionUUID) || errors.Is(err, messenger.ErrInvalidChatUUID) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if errors.Is(err, messenger.ErrChatNotFound) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		if errors.Is(err, messenger.ErrDeleteProhibited) {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	//sending msg to kafka
+	kafka.DeleteChatEvent(r.GetChatUuid())
+
+	//Creating, sending response
+	response := &proto.DeleteChatResponse{}
+	return response, nil
+}
+
+// Implementation of JoinChat rpc
+func (s Server) JoinChat(_ context.Context, r *proto.JoinChatRequest) (*proto.JoinChatResponse, error) {
+	err := s.m.JoinChat(r.GetSessionUuid(), r.GetChatUuid())
+	if err != nil {
+		return nil, membershipError(err)
+	}
+
+	//Creating, sending response
+	response := &proto.JoinChatResponse{}
+	return response, nil
+}
+
+// Implementation of LeaveChat rpc
+func (s Server) LeaveChat(_ context.Context, r *proto.LeaveChatRequest) (*proto.LeaveChatResponse, error) {
+	err := s.m.LeaveChat(r.GetSessionUuid(), r.GetChatUuid())
+	if err != nil {
+		return nil, membershipError(err)
+	}
+
+	//Creating, sending response
+	response := &proto.LeaveChatResponse{}
+	return response, nil
+}
+
+// Implementation of ListParticipants rpc
+func (s Server) ListParticipants(_ context.Context, r *proto.ListParticipantsRequest) (*proto.ListParticipantsResponse, error) {
+	members, err := s.m.ListParticipants(r.GetSessionUuid(), r.GetChatUuid())
+	if err != nil {
+		return nil, membershipError(err)
+	}
+
+	participants := make([]*proto.Participant, 0, len(members))
+	for _, v := range members {
+		participants = append(participants, &proto.Participant{
 			SessionUuid: v.SessionUUID,
-			MessageUuid: v.MessageUUID,
-			Text:        v.Text,
+			JoinedAt:    timestamppb.New(v.JoinedAt),
+			Role:        proto.ChatRole(v.Role),
 		})
 	}
-	response := &proto.GetHistoryResponse{Messages: history}
+	response := &proto.ListParticipantsResponse{Participants: participants}
+	return response, nil
+}
+
+// Implementation of SetMemberRole rpc
+func (s Server) SetMemberRole(_ context.Context, r *proto.SetMemberRoleRequest) (*proto.SetMemberRoleResponse, error) {
+	err := s.m.SetMemberRole(r.GetSessionUuid(), r.GetChatUuid(), r.GetMemberSessionUuid(), entities.Role(r.GetRole()))
+	if err != nil {
+		return nil, membershipError(err)
+	}
+
+	//Creating, sending response
+	response := &proto.SetMemberRoleResponse{}
+	return response, nil
+}
+
+// Implementation of BanMember rpc
+func (s Server) BanMember(_ context.Context, r *proto.RestrictMemberRequest) (*proto.RestrictMemberResponse, error) {
+	err := s.m.BanMember(r.GetSessionUuid(), r.GetChatUuid(), r.GetMemberSessionUuid(), time.Duration(r.GetDurationSeconds())*time.Second)
+	if err != nil {
+		return nil, membershipError(err)
+	}
+
+	//Creating, sending response
+	response := &proto.RestrictMemberResponse{}
+	return response, nil
+}
+
+// Implementation of UnbanMember rpc
+func (s Server) UnbanMember(_ context.Context, r *proto.LiftRestrictionRequest) (*proto.LiftRestrictionResponse, error) {
+	err := s.m.UnbanMember(r.GetSessionUuid(), r.GetChatUuid(), r.GetMemberSessionUuid())
+	if err != nil {
+		return nil, membershipError(err)
+	}
+
+	//Creating, sending response
+	response := &proto.LiftRestrictionResponse{}
+	return response, nil
+}
+
+// Implementation of MuteMember rpc
+func (s Server) MuteMember(_ context.Context, r *proto.RestrictMemberRequest) (*proto.RestrictMemberResponse, error) {
+	err := s.m.MuteMember(r.GetSessionUuid(), r.GetChatUuid(), r.GetMemberSessionUuid(), time.Duration(r.GetDurationSeconds())*time.Second)
+	if err != nil {
+		return nil, membershipError(err)
+	}
+
+	//Creating, sending response
+	response := &proto.RestrictMemberResponse{}
+	return response, nil
+}
+
+// Implementation of UnmuteMember rpc
+func (s Server) UnmuteMember(_ context.Context, r *proto.LiftRestrictionRequest) (*proto.LiftRestrictionResponse, error) {
+	err := s.m.UnmuteMember(r.GetSessionUuid(), r.GetChatUuid(), r.GetMemberSessionUuid())
+	if err != nil {
+		return nil, membershipError(err)
+	}
+
+	//Creating, sending response
+	response := &proto.LiftRestrictionResponse{}
+	return response, nil
+}
+
+// Mapping errors of membership rpcs to grpc status
+func membershipError(err error) error {
+	if errors.Is(err, messenger.ErrInvalidSessionUUID) || errors.Is(err, messenger.ErrInvalidChatUUID) ||
+		errors.Is(err, messenger.ErrInvalidRole) || errors.Is(err, messenger.ErrInvalidDuration) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if errors.Is(err, messenger.ErrChatNotFound) || errors.Is(err, messenger.ErrUserDoesNotExist) || errors.Is(err, messenger.ErrMemberNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	if errors.Is(err, messenger.ErrNotMember) || errors.Is(err, messenger.ErrLeaveProhibited) || errors.Is(err, messenger.ErrSetRoleProhibited) ||
+		errors.Is(err, messenger.ErrBanned) || errors.Is(err, messenger.ErrRestrictProhibited) || errors.Is(err, messenger.ErrDirectChatProhibited) {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// Implementation of MarkRead rpc
+func (s Server) MarkRead(_ context.Context, r *proto.MarkReadRequest) (*proto.MarkReadResponse, error) {
+	err := s.m.MarkRead(r.GetSessionUuid(), r.GetChatUuid(), r.GetUpToMessageUuid())
+	if err != nil {
+		return nil, readError(err)
+	}
+
+	//Creating, sending response
+	response := &proto.MarkReadResponse{}
+	return response, nil
+}
+
+// Implementation of ListMyChats rpc
+func (s Server) ListMyChats(_ context.Context, r *proto.ListMyChatsRequest) (*proto.ListMyChatsResponse, error) {
+	chats, err := s.m.ListMyChats(r.GetSessionUuid())
+	if err != nil {
+		return nil, readError(err)
+	}
+
+	//Creating, sending response
+	response := &proto.ListMyChatsResponse{Chats: make([]*proto.MyChat, 0, len(chats))}
+	for _, v := range chats {
+		response.Chats = append(response.Chats, &proto.MyChat{Chat: toProtoChat(v.Chat), UnreadCount: int32(v.UnreadCount)})
+	}
+	return response, nil
+}
+
+// Implementation of GetReadReceipts rpc
+func (s Server) GetReadReceipts(_ context.Context, r *proto.GetReadReceiptsRequest) (*proto.GetReadReceiptsResponse, error) {
+	receipts, err := s.m.GetReadReceipts(r.GetSessionUuid(), r.GetChatUuid(), r.GetMessageUuid())
+	if err != nil {
+		return nil, readError(err)
+	}
+
+	//Creating, sending response
+	response := &proto.GetReadReceiptsResponse{Receipts: make([]*proto.ReadReceipt, 0, len(receipts))}
+	for _, v := range receipts {
+		response.Receipts = append(response.Receipts, &proto.ReadReceipt{SessionUuid: v.SessionUUID, ReadAt: timestamppb.New(v.ReadAt)})
+	}
+	return response, nil
+}
+
+// Mapping errors of read receipts to grpc status
+func readError(err error) error {
+	if errors.Is(err, messenger.ErrInvalidSessionUUID) || errors.Is(err, messenger.ErrInvalidChatUUID) || errors.Is(err, messenger.ErrInvalidMessageUUID) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if errors.Is(err, messenger.ErrChatNotFound) || errors.Is(err, messenger.ErrMessageNotFound) || errors.Is(err, messenger.ErrUserDoesNotExist) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	if errors.Is(err, messenger.ErrNotMember) || errors.Is(err, messenger.ErrBanned) || errors.Is(err, messenger.ErrReadReceiptsProhibited) {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// Implementation of SetTyping rpc
+func (s Server) SetTyping(_ context.Context, r *proto.SetTypingRequest) (*proto.SetTypingResponse, error) {
+	err := s.m.SetTyping(r.GetSessionUuid(), r.GetChatUuid())
+	if err != nil {
+		return nil, presenceError(err)
+	}
+
+	//Creating, sending response
+	response := &proto.SetTypingResponse{}
+	return response, nil
+}
+
+// Implementation of GetTyping rpc
+func (s Server) GetTyping(_ context.Context, r *proto.GetTypingRequest) (*proto.GetTypingResponse, error) {
+	sessions, err := s.m.GetTyping(r.GetSessionUuid(), r.GetChatUuid())
+	if err != nil {
+		return nil, presenceError(err)
+	}
+
+	//Creating, sending response
+	response := &proto.GetTypingResponse{SessionUuids: sessions}
+	return response, nil
+}
+
+// Implementation of GetPresence rpc
+func (s Server) GetPresence(_ context.Context, r *proto.GetPresenceRequest) (*proto.GetPresenceResponse, error) {
+	presences, err := s.m.GetPresence(r.GetSessionUuids())
+	if err != nil {
+		return nil, presenceError(err)
+	}
+
+	//Creating, sending response
+	response := &proto.GetPresenceResponse{Presences: make([]*proto.Presence, 0, len(presences))}
+	for _, v := range presences {
+		presence := &proto.Presence{SessionUuid: v.SessionUUID, Online: v.Online}
+		if !v.LastSeen.IsZero() {
+			presence.LastSeen = timestamppb.New(v.LastSeen)
+		}
+		response.Presences = append(response.Presences, presence)
+	}
+	return response, nil
+}
+
+// Registering activity of session for its presence. Used by presence interceptors
+func (s Server) Seen(sessionUUID string) {
+	s.m.Seen(sessionUUID)
+}
+
+// Stopping background work of messenger, called after server stopped serving requests and before storage is closed
+func (s Server) Close() {
+	s.m.Close()
+}
+
+// Mapping errors of typing and presence to grpc status
+func presenceError(err error) error {
+	if errors.Is(err, messenger.ErrInvalidSessionUUID) || errors.Is(err, messenger.ErrInvalidChatUUID) || errors.Is(err, messenger.ErrTooManySessions) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if errors.Is(err, messenger.ErrChatNotFound) || errors.Is(err, messenger.ErrUserDoesNotExist) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	if errors.Is(err, messenger.ErrProhibited) || errors.Is(err, messenger.ErrNotMember) ||
+		errors.Is(err, messenger.ErrBanned) || errors.Is(err, messenger.ErrMuted) {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// Implementation of PinMessage rpc
+func (s Server) PinMessage(_ context.Context, r *proto.PinMessageRequest) (*proto.PinMessageResponse, error) {
+	if _, err := s.m.PinMessage(r.GetSessionUuid(), r.GetChatUuid(), r.GetMessageUuid()); err != nil {
+		return nil, pinError(err)
+	}
+
+	//Creating, sending response
+	response := &proto.PinMessageResponse{}
+	return response, nil
+}
+
+// Implementation of UnpinMessage rpc
+func (s Server) UnpinMessage(_ context.Context, r *proto.UnpinMessageRequest) (*proto.UnpinMessageResponse, error) {
+	if err := s.m.UnpinMessage(r.GetSessionUuid(), r.GetChatUuid(), r.GetMessageUuid()); err != nil {
+		return nil, pinError(err)
+	}
+
+	//Creating, sending response
+	response := &proto.UnpinMessageResponse{}
+	return response, nil
+}
+
+func pinError(err error) error {
+	if errors.Is(err, messenger.ErrInvalidSessionUUID) || errors.Is(err, messenger.ErrInvalidChatUUID) || errors.Is(err, messenger.ErrInvalidMessageUUID) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if errors.Is(err, messenger.ErrChatNotFound) || errors.Is(err, messenger.ErrMessageNotFound) || errors.Is(err, messenger.ErrUserDoesNotExist) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	if errors.Is(err, messenger.ErrPinProhibited) || errors.Is(err, messenger.ErrNotMember) || errors.Is(err, messenger.ErrBanned) {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	if errors.Is(err, messenger.ErrTooManyPinned) {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// Implementation of ScheduleMessage rpc
+func (s Server) ScheduleMessage(_ context.Context, r *proto.ScheduleMessageRequest) (*proto.ScheduleMessageResponse, error) {
+	scheduled, err := s.m.ScheduleMessage(r.GetSessionUuid(), r.GetChatUuid(), r.GetText(), r.GetSendAt().AsTime())
+	if err != nil {
+		return nil, scheduleError(err)
+	}
+
+	//Creating, sending response
+	response := &proto.ScheduleMessageResponse{ScheduledUuid: scheduled.ScheduledUUID}
+	return response, nil
+}
+
+// Implementation of ListScheduled rpc
+func (s Server) ListScheduled(_ context.Context, r *proto.ListScheduledRequest) (*proto.ListScheduledResponse, error) {
+	scheduled, err := s.m.ListScheduled(r.GetSessionUuid(), r.GetChatUuid())
+	if err != nil {
+		return nil, scheduleError(err)
+	}
+
+	//Creating, sending response
+	response := &proto.ListScheduledResponse{Messages: make([]*proto.ScheduledMessage, len(scheduled))}
+	for i, v := range scheduled {
+		response.Messages[i] = &proto.ScheduledMessage{
+			ScheduledUuid: v.ScheduledUUID,
+			ChatUuid:      v.ChatUUID,
+			SessionUuid:   v.SessionUUID,
+			Text:          v.Text,
+			SendAt:        timestamppb.New(v.SendAt),
+			CreatedAt:     timestamppb.New(v.CreatedAt),
+		}
+	}
+	return response, nil
+}
+
+// Implementation of CancelScheduled rpc
+func (s Server) CancelScheduled(_ context.Context, r *proto.CancelScheduledRequest) (*proto.CancelScheduledResponse, error) {
+	if err := s.m.CancelScheduled(r.GetSessionUuid(), r.GetChatUuid(), r.GetScheduledUuid()); err != nil {
+		return nil, scheduleError(err)
+	}
+
+	//Creating, sending response
+	response := &proto.CancelScheduledResponse{}
 	return response, nil
 }
 
+func scheduleError(err error) error {
+	if errors.Is(err, messenger.ErrInvalidSessionUUID) || errors.Is(err, messenger.ErrInvalidChatUUID) ||
+		errors.Is(err, messenger.ErrInvalidScheduledUUID) || errors.Is(err, messenger.ErrInvalidSendAt) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if errors.Is(err, messenger.ErrChatNotFound) || errors.Is(err, messenger.ErrUserDoesNotExist) || errors.Is(err, messenger.ErrScheduledNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	if errors.Is(err, messenger.ErrProhibited) || errors.Is(err, messenger.ErrNotMember) ||
+		errors.Is(err, messenger.ErrBanned) || errors.Is(err, messenger.ErrMuted) {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	if errors.Is(err, messenger.ErrTooManyScheduled) {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// Implementation of SearchMessages rpc
+func (s Server) SearchMessages(_ context.Context, r *proto.SearchMessagesRequest) (*proto.SearchMessagesResponse, error) {
+	found, nextPageToken, err := s.m.SearchMessages(r.GetSessionUuid(), r.GetQuery(), r.GetChatUuid(), int(r.GetPageSize()), r.GetPageToken())
+	if err != nil {
+		if errors.Is(err, messenger.ErrInvalidChatUUID) || errors.Is(err, messenger.ErrInvalidSessionUUID) || errors.Is(err, messenger.ErrInvalidQuery) ||
+			errors.Is(err, messenger.ErrInvalidPageSize) || errors.Is(err, messenger.ErrInvalidPageToken) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if errors.Is(err, messenger.ErrChatNotFound) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		if errors.Is(err, messenger.ErrNotMember) || errors.Is(err, messenger.ErrBanned) {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	//Creating, sending response
+	results := make([]*proto.FoundMessage, 0, len(found))
+	for _, v := range found {
+		highlights := make([]*proto.TextRange, 0, len(v.Highlights))
+		for _, h := range v.Highlights {
+			highlights = append(highlights, &proto.TextRange{Start: int32(h.Start), End: int32(h.End)})
+		}
+		results = append(results, &proto.FoundMessage{ChatUuid: v.ChatUUID, Message: toProtoMessage(v.Message), Highlights: highlights})
+	}
+	return &proto.SearchMessagesResponse{Results: results, NextPageToken: nextPageToken}, nil
+}
+
+// Size of chunks content of attachment is sent in
+const attachmentChunkSize = 64 << 10
+
+// Implementation of UploadAttachment rpc. First request of stream carries info, content is read from chunks of the rest until client closes sending
+func (s Server) UploadAttachment(stream proto.MessengerService_UploadAttachmentServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	info := first.GetInfo()
+	if info == nil {
+		return status.Error(codes.InvalidArgument, messenger.ErrUploadInfoMissing.Error())
+	}
+
+	content := &chunkReader{stream: stream}
+	attachment, err := s.m.UploadAttachment(info.GetSessionUuid(), info.GetChatUuid(), info.GetName(), content)
+	if err != nil {
+		//stream broke while content was being read
+		if content.err != nil {
+			return content.err
+		}
+		if errors.Is(err, messenger.ErrInvalidSessionUUID) || errors.Is(err, messenger.ErrInvalidChatUUID) ||
+			errors.Is(err, messenger.ErrInvalidAttachmentName) || errors.Is(err, messenger.ErrAttachmentEmpty) ||
+			errors.Is(err, messenger.ErrAttachmentTypeNotAllowed) {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		if errors.Is(err, messenger.ErrAttachmentTooLarge) || errors.Is(err, messenger.ErrAttachmentQuotaExceeded) {
+			return status.Error(codes.ResourceExhausted, err.Error())
+		}
+		if errors.Is(err, messenger.ErrChatNotFound) {
+			return status.Error(codes.NotFound, err.Error())
+		}
+		if errors.Is(err, messenger.ErrProhibited) || errors.Is(err, messenger.ErrNotMember) ||
+			errors.Is(err, messenger.ErrBanned) || errors.Is(err, messenger.ErrMuted) {
+			return status.Error(codes.PermissionDenied, err.Error())
+		}
+		if errors.Is(err, messenger.ErrAttachmentsDisabled) {
+			return status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	return stream.SendAndClose(&proto.UploadAttachmentResponse{Attachment: toProtoAttachment(attachment)})
+}
+
+// Reading content of attachment from chunks of upload stream. err is error of stream, end of stream is not an error
+type chunkReader struct {
+	stream proto.MessengerService_UploadAttachmentServer
+	chunk  []byte
+	err    error
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		req, err := r.stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return 0, io.EOF
+		}
+		if err != nil {
+			r.err = err
+			return 0, err
+		}
+		if req.GetInfo() != nil {
+			r.err = status.Error(codes.InvalidArgument, messenger.ErrUploadInfoRepeated.Error())
+			return 0, r.err
+		}
+		r.chunk = req.GetChunk()
+	}
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+// Implementation of DownloadAttachment rpc. Attachment is sent first, then its content in chunks
+func (s Server) DownloadAttachment(r *proto.DownloadAttachmentRequest, stream proto.MessengerService_DownloadAttachmentServer) error {
+	attachment, content, err := s.m.DownloadAttachment(r.GetSessionUuid(), r.GetChatUuid(), r.GetAttachmentId())
+	if err != nil {
+		if errors.Is(err, messenger.ErrInvalidSessionUUID) || errors.Is(err, messenger.ErrInvalidChatUUID) || errors.Is(err, messenger.ErrInvalidAttachmentID) {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		if errors.Is(err, messenger.ErrChatNotFound) || errors.Is(err, messenger.ErrAttachmentNotFound) {
+			return status.Error(codes.NotFound, err.Error())
+		}
+		if errors.Is(err, messenger.ErrNotMember) || errors.Is(err, messenger.ErrBanned) {
+			return status.Error(codes.PermissionDenied, err.Error())
+		}
+		if errors.Is(err, messenger.ErrAttachmentsDisabled) {
+			return status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return status.Error(codes.Internal, err.Error())
+	}
+	defer content.Close()
+
+	err = stream.Send(&proto.DownloadAttachmentResponse{Payload: &proto.DownloadAttachmentResponse_Attachment{Attachment: toProtoAttachment(attachment)}})
+	if err != nil {
+		return err
+	}
+	chunk := make([]byte, attachmentChunkSize)
+	for {
+		n, err := content.Read(chunk)
+		if n > 0 {
+			if err := stream.Send(&proto.DownloadAttachmentResponse{Payload: &proto.DownloadAttachmentResponse_Chunk{Chunk: chunk[:n]}}); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+}
+
 // implementation of GetActiveChats rpc
-func (s Server) GetActiveChats(_ context.Context, _ *proto.GetActiveChatsRequest) (*proto.GetActiveChatsResponse, error) {
-	chats := s.m.GetActiveChats()
+func (s Server) GetActiveChats(_ context.Context, r *proto.GetActiveChatsRequest) (*proto.GetActiveChatsResponse, error) {
+	filter := entities.ChatFilter{
+		Viewer:      r.GetViewerSessionUuid(),
+		SessionUUID: r.GetSessionUuid(),
+		ReadOnly:    r.ReadOnly,
+		HasTTL:      r.HasTtl,
+		Title:       r.GetTitle(),
+		Tag:         r.GetTag(),
+	}
+	if r.GetCreatedAfter() != nil {
+		filter.CreatedAfter = r.GetCreatedAfter().AsTime()
+	}
+	chats, nextPageToken, err := s.m.GetActiveChats(filter, int(r.GetPageSize()), r.GetPageToken())
+	if err != nil {
+		if errors.Is(err, messenger.ErrInvalidSessionUUID) || errors.Is(err, messenger.ErrInvalidPageSize) || errors.Is(err, messenger.ErrInvalidPageToken) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
 	res := make([]*proto.Chat, 0, len(chats))
 	for _, v := range chats {
-		res = append(res, &proto.Chat{
+		res = append(res, toProtoChat(v))
+	}
+	response := &proto.GetActiveChatsResponse{Chats: res, NextPageToken: nextPageToken}
+	return response, nil
+}
+
+func toProtoChat(chat entities.Chat) *proto.Chat {
+	return &proto.Chat{
+		SessionUuid:  chat.SessionUUID,
+		ChatUuid:     chat.ChatUUID,
+		Ttl:          int32(chat.TTL),
+		ReadOnly:     chat.ReadOnly,
+		CreatedAt:    timestamppb.New(chat.CreatedAt),
+		TtlRemaining: int32(ttlRemaining(chat).Seconds()),
+		MessageCount: int32(chat.MessageCount),
+		Visibility:   proto.ChatVisibility(chat.Visibility),
+		Title:        chat.Info.Title,
+		Description:  chat.Info.Description,
+		Tags:         chat.Info.Tags,
+		Pinned:       toProtoPins(chat.Pinned),
+	}
+}
+
+func toProtoPins(pins []entities.Pin) []*proto.PinnedMessage {
+	pinned := make([]*proto.PinnedMessage, 0, len(pins))
+	for _, v := range pins {
+		pinned = append(pinned, &proto.PinnedMessage{
+			MessageUuid: v.MessageUUID,
 			SessionUuid: v.SessionUUID,
-			ChatUuid:    v.ChatUUID,
-			Ttl:         int32(v.TTL),
-			ReadOnly:    v.ReadOnly,
+			PinnedAt:    timestamppb.New(v.PinnedAt),
 		})
 	}
-	response := &proto.GetActiveChatsResponse{Chats: res}
-	return response, nil
+	return pinned
+}
+
+// Time left before chat with ttl is deleted, zero if chat has no ttl
+func ttlRemaining(chat entities.Chat) time.Duration {
+	if chat.TTL <= 0 {
+		return 0
+	}
+	return max(time.Until(chat.CreatedAt.Add(time.Duration(chat.TTL)*time.Second)), 0)
+}
+
+// Mapping reason of subscription end to result of stream. Deleted or evicted chat ends stream cleanly with "chat-closed" trailer
+func subscriptionEnd(stream grpc.ServerStream, err error) error {
+	if errors.Is(err, messenger.ErrChatDeleted) || errors.Is(err, messenger.ErrChatEvicted) || errors.Is(err, messenger.ErrChatLeft) {
+		stream.SetTrailer(metadata.Pairs("chat-closed", err.Error()))
+		return nil
+	}
+	if errors.Is(err, messenger.ErrSlowSubscriber) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if errors.Is(err, messenger.ErrBanned) {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func toProtoAttachment(attachment entities.Attachment) *proto.Attachment {
+	return &proto.Attachment{
+		AttachmentId: attachment.ID,
+		SessionUuid:  attachment.SessionUUID,
+		Name:         attachment.Name,
+		ContentType:  attachment.ContentType,
+		Size:         attachment.Size,
+		Sha256:       attachment.Key,
+		CreatedAt:    timestamppb.New(attachment.CreatedAt),
+	}
+}
+
+func toProtoMessage(message entities.Message) *proto.ChatMessage {
+	res := &proto.ChatMessage{
+		SessionUuid:        message.SessionUUID,
+		MessageUuid:        message.MessageUUID,
+		Text:               message.Text,
+		Deleted:            message.Deleted,
+		Seq:                message.Seq,
+		AttachmentIds:      message.Attachments,
+		ReplyToMessageUuid: message.ReplyTo,
+		ReplyCount:         int32(message.ReplyCount),
+	}
+	for _, v := range message.Reactions {
+		res.Reactions = append(res.Reactions, &proto.Reaction{Emoji: v.Emoji, Count: int32(v.Count), Reacted: v.Reacted})
+	}
+	if !message.CreatedAt.IsZero() {
+		res.CreatedAt = timestamppb.New(message.CreatedAt)
+	}
+	if !message.EditedAt.IsZero() {
+		res.EditedAt = timestamppb.New(message.EditedAt)
+	}
+	if !message.ExpiresAt.IsZero() {
+		res.ExpiresAt = timestamppb.New(message.ExpiresAt)
+	}
+	return res
 }
 
 func (s Server) HealthCheck(_ context.Context, _ *proto.HealthCheckRequest) (*proto.HealthCheckResponse, error) {
@@ -27,3 +27,12 @@ func Log(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handl
 	logger.LogRequest(ctx, info.FullMethod, req.(Stringer).String(), resp.(Stringer).String(), err)
 	return resp, err
 }
+
+//nolint:wrapcheck
+func LogStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	//serving stream
+	err := handler(srv, ss)
+	//log stream when it's over
+	logger.LogStream(ss.Context(), info.FullMethod, err)
+	return err
+}
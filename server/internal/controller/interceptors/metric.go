@@ -49,3 +49,37 @@ func Metric(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, ha
 	}
 	return resp, err
 }
+
+//nolint:wrapcheck
+func MetricStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	//start timer
+	start := time.Now()
+
+	//serving stream
+	err := handler(srv, &metricServerStream{ServerStream: ss})
+
+	statusCode := status.Code(err)
+
+	//incrementing request counter according to label values
+	metric.RequestsCounter.WithLabelValues(info.FullMethod, statusCode.String()).Inc()
+
+	if statusCode == codes.OK {
+		metric.ResponseDuration.WithLabelValues(statusCode.String()).Observe(time.Since(start).Seconds())
+	}
+	return err
+}
+
+// Wrapper of stream to count messages that were sent within stream
+type metricServerStream struct {
+	grpc.ServerStream
+}
+
+//nolint:wrapcheck
+func (s *metricServerStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	//every ack means that message was sent to chat
+	if resp, ok := m.(*proto.ChatResponse); ok && err == nil && resp.GetAck() != nil {
+		metric.MessagesPerChat.WithLabelValues(resp.GetAck().GetChatUuid()).Inc()
+	}
+	return err
+}
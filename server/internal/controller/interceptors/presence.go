@@ -0,0 +1,61 @@
+// grpc interceptor for presence of sessions
+package interceptors
+
+import (
+	"context"
+
+	"github.com/Rolan335/grpcMessenger/server/pkg/proto"
+	"google.golang.org/grpc"
+)
+
+// requests that are made on behalf of session
+type sessionRequest interface {
+	GetSessionUuid() string
+}
+
+// seen is called with session of every successful request, and with session created by InitSession
+func Presence(seen func(sessionUUID string)) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		//making request
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return resp, err
+		}
+		if r, ok := req.(sessionRequest); ok && r.GetSessionUuid() != "" {
+			seen(r.GetSessionUuid())
+		}
+		if r, ok := resp.(*proto.InitSessionResponse); ok {
+			seen(r.GetSessionUuid())
+		}
+		return resp, nil
+	}
+}
+
+// seen is called with session of every request received within stream
+func PresenceStream(seen func(sessionUUID string)) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &presenceServerStream{ServerStream: ss, seen: seen})
+	}
+}
+
+// Wrapper of stream to register activity of sessions that send requests within stream
+type presenceServerStream struct {
+	grpc.ServerStream
+	seen func(sessionUUID string)
+}
+
+//nolint:wrapcheck
+func (s *presenceServerStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err != nil {
+		return err
+	}
+	if r, ok := m.(sessionRequest); ok && r.GetSessionUuid() != "" {
+		s.seen(r.GetSessionUuid())
+	}
+	//session of upload is carried by its first request
+	if r, ok := m.(*proto.UploadAttachmentRequest); ok && r.GetInfo().GetSessionUuid() != "" {
+		s.seen(r.GetInfo().GetSessionUuid())
+	}
+	return nil
+}
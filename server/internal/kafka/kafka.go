@@ -1,6 +1,7 @@
 package kafka
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/IBM/sarama"
@@ -8,8 +9,11 @@ import (
 )
 
 var (
-	topicName = "createchat_topic"
-	producer  sarama.AsyncProducer
+	topicName            = "createchat_topic"
+	topicNameDeleteChat  = "deletechat_topic"
+	topicNameChatExpired = "chatexpired_topic"
+	topicNameReaction    = "reaction.added"
+	producer             sarama.AsyncProducer
 )
 
 func Init(brokers ...string) {
@@ -51,3 +55,41 @@ func CreateChatEvent(chatUUID string) {
 	}
 	producer.Input() <- message
 }
+
+func DeleteChatEvent(chatUUID string) {
+	message := &sarama.ProducerMessage{
+		Key:   sarama.StringEncoder("chat_uuid"),
+		Topic: topicNameDeleteChat,
+		Value: sarama.StringEncoder(chatUUID),
+	}
+	producer.Input() <- message
+}
+
+// Chat was deleted because its ttl elapsed
+func ChatExpiredEvent(chatUUID string) {
+	message := &sarama.ProducerMessage{
+		Key:   sarama.StringEncoder("chat_uuid"),
+		Topic: topicNameChatExpired,
+		Value: sarama.StringEncoder(chatUUID),
+	}
+	producer.Input() <- message
+}
+
+// Reaction is published with the message it was added to
+type Reaction struct {
+	ChatUUID    string `json:"chat_uuid"`
+	MessageUUID string `json:"message_uuid"`
+	SessionUUID string `json:"session_uuid"`
+	Emoji       string `json:"emoji"`
+}
+
+// Session added reaction to message
+func ReactionAddedEvent(reaction Reaction) {
+	value, _ := json.Marshal(reaction)
+	message := &sarama.ProducerMessage{
+		Key:   sarama.StringEncoder("chat_uuid"),
+		Topic: topicNameReaction,
+		Value: sarama.ByteEncoder(value),
+	}
+	producer.Input() <- message
+}
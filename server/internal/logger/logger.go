@@ -42,6 +42,17 @@ func LogRequest(ctx context.Context, route string, req string, res string, err e
 	)
 }
 
+// Function logs stream when it's finished
+func LogStream(ctx context.Context, route string, err error) {
+	if err != nil {
+		Logger.LogAttrs(ctx, slog.LevelInfo, route,
+			slog.String("Err", err.Error()),
+		)
+		return
+	}
+	Logger.LogAttrs(ctx, slog.LevelInfo, route)
+}
+
 func LogKafkaSuccess(partition int, offset int) {
 	Logger.LogAttrs(context.Background(), slog.LevelInfo, "Successfully sent message to kafka",
 		slog.Int("Partition", partition),
@@ -72,3 +83,73 @@ func LogChatDelete(sessionUUID string, ChatUUID string, err error) {
 		slog.String("chatUuid", ChatUUID),
 	)
 }
+
+// Function logs deletion of message by ttl
+func LogMessageDelete(sessionUUID string, chatUUID string, messageUUID string, err error) {
+	// if error from deletion provided - logging error
+	if err != nil {
+		Logger.LogAttrs(context.Background(), slog.LevelError, "DeleteMessage",
+			slog.String("sessionUuid", sessionUUID),
+			slog.String("chatUuid", chatUUID),
+			slog.String("messageUuid", messageUUID),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	Logger.LogAttrs(context.Background(), slog.LevelInfo, "DeleteMessage",
+		slog.String("sessionUuid", sessionUUID),
+		slog.String("chatUuid", chatUUID),
+		slog.String("messageUuid", messageUUID),
+	)
+}
+
+// Function logs failure to load pending deletions of chats or messages by ttl from storage
+func LogExpirationsSync(err error) {
+	Logger.LogAttrs(context.Background(), slog.LevelError, "SyncExpirations",
+		slog.String("error", err.Error()),
+	)
+}
+
+// Function logs sending of scheduled message
+func LogScheduledSend(sessionUUID string, chatUUID string, scheduledUUID string, err error) {
+	// if error from sending provided - logging error
+	if err != nil {
+		Logger.LogAttrs(context.Background(), slog.LevelError, "SendScheduled",
+			slog.String("sessionUuid", sessionUUID),
+			slog.String("chatUuid", chatUUID),
+			slog.String("scheduledUuid", scheduledUUID),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	Logger.LogAttrs(context.Background(), slog.LevelInfo, "SendScheduled",
+		slog.String("sessionUuid", sessionUUID),
+		slog.String("chatUuid", chatUUID),
+		slog.String("scheduledUuid", scheduledUUID),
+	)
+}
+
+// Function logs failure to load pending scheduled messages from storage
+func LogScheduledSync(err error) {
+	Logger.LogAttrs(context.Background(), slog.LevelError, "SyncScheduled",
+		slog.String("error", err.Error()),
+	)
+}
+
+// Function logs failure to forget idempotency key of message that wasn't sent or to mark it as sent
+func LogIdempotencyKeyUpdate(sessionUUID string, chatUUID string, messageUUID string, err error) {
+	Logger.LogAttrs(context.Background(), slog.LevelError, "UpdateIdempotencyKey",
+		slog.String("sessionUuid", sessionUUID),
+		slog.String("chatUuid", chatUUID),
+		slog.String("messageUuid", messageUUID),
+		slog.String("error", err.Error()),
+	)
+}
+
+// Function logs failure to delete attachments of deleted chat
+func LogAttachmentsDelete(chatUUID string, err error) {
+	Logger.LogAttrs(context.Background(), slog.LevelError, "DeleteAttachments",
+		slog.String("chatUuid", chatUUID),
+		slog.String("error", err.Error()),
+	)
+}
@@ -48,6 +48,41 @@ var MessagesPerChat = prometheus.NewCounterVec(
 	[]string{"chat_uuid"},
 )
 
+var ChatExpirationsPending = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "chat_expirations_pending",
+		Help: "number of chats waiting for deletion by ttl in scheduler",
+	},
+)
+
+var ChatsExpiredTotal = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "chats_expired_total",
+		Help: "total number of chats deleted because ttl elapsed",
+	},
+)
+
+var MessageExpirationsPending = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "message_expirations_pending",
+		Help: "number of messages waiting for deletion by ttl in scheduler",
+	},
+)
+
+var MessagesExpiredTotal = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "messages_expired_total",
+		Help: "total number of messages deleted because ttl elapsed",
+	},
+)
+
+var ScheduledMessagesPending = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "scheduled_messages_pending",
+		Help: "number of scheduled messages waiting for sending in scheduler",
+	},
+)
+
 var once sync.Once
 
 func MustInit() {
@@ -58,6 +93,11 @@ func MustInit() {
 			ChatsCreatedTTL,
 			UsersRegisteredTotal,
 			MessagesPerChat,
+			ChatExpirationsPending,
+			ChatsExpiredTotal,
+			MessageExpirationsPending,
+			MessagesExpiredTotal,
+			ScheduledMessagesPending,
 		)
 	})
 }
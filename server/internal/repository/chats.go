@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Rolan335/grpcMessenger/server/internal/repository/entities"
+)
+
+// Checking if chat satisfies filter. Direct chats are never listed, members-only chats are listed only to their members.
+// isMember reports if filter.Viewer is a member of chat, it's called only for members-only chats
+func MatchChat(chat entities.Chat, filter entities.ChatFilter, isMember func(chatUUID string) bool) bool {
+	if chat.Visibility == entities.VisibilityDirect {
+		return false
+	}
+	if chat.Visibility == entities.VisibilityMembersOnly && (filter.Viewer == "" || !isMember(chat.ChatUUID)) {
+		return false
+	}
+	if filter.SessionUUID != "" && chat.SessionUUID != filter.SessionUUID {
+		return false
+	}
+	if filter.ReadOnly != nil && chat.ReadOnly != *filter.ReadOnly {
+		return false
+	}
+	if filter.HasTTL != nil && (chat.TTL > 0) != *filter.HasTTL {
+		return false
+	}
+	if !filter.CreatedAfter.IsZero() && !chat.CreatedAt.After(filter.CreatedAfter) {
+		return false
+	}
+	if filter.Title != "" && !strings.Contains(strings.ToLower(chat.Info.Title), strings.ToLower(filter.Title)) {
+		return false
+	}
+	if filter.Tag != "" && !slices.Contains(chat.Info.Tags, filter.Tag) {
+		return false
+	}
+	return true
+}
+
+// Applying update to description of chat
+func UpdateChatInfo(info *entities.ChatInfo, update entities.ChatUpdate) {
+	if update.Title != nil {
+		info.Title = *update.Title
+	}
+	if update.Description != nil {
+		info.Description = *update.Description
+	}
+	if update.Tags != nil {
+		info.Tags = *update.Tags
+	}
+}
+
+// Filtering chats and cutting page of them ordered by creation time. Used by storages that can't filter and page chats natively.
+// Cursor of page is the creation time and uuid of the last chat of previous page. isMember is passed to MatchChat
+func PageChats(chats []entities.Chat, filter entities.ChatFilter, page entities.Page, isMember func(chatUUID string) bool) ([]entities.Chat, string, error) {
+	var cursorCreatedAt time.Time
+	var cursorChatUUID string
+	if page.Cursor != "" {
+		var err error
+		cursorCreatedAt, cursorChatUUID, err = ParseKeysetCursor(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	slices.SortFunc(chats, compareChats)
+
+	res := make([]entities.Chat, 0, min(len(chats), max(page.Limit, 0)))
+	for _, chat := range chats {
+		if page.Cursor != "" && compareChats(chat, entities.Chat{CreatedAt: cursorCreatedAt, ChatUUID: cursorChatUUID}) <= 0 {
+			continue
+		}
+		if !MatchChat(chat, filter, isMember) {
+			continue
+		}
+		//page is full and there is one more chat - so there is the next page
+		if page.Limit > 0 && len(res) == page.Limit {
+			last := res[len(res)-1]
+			return res, FormatKeysetCursor(last.CreatedAt, last.ChatUUID), nil
+		}
+		res = append(res, chat)
+	}
+	return res, "", nil
+}
+
+func compareChats(a entities.Chat, b entities.Chat) int {
+	if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+		return c
+	}
+	return strings.Compare(a.ChatUUID, b.ChatUUID)
+}
+
+// Cursor for keyset pagination on creation time and uuid of record
+func FormatKeysetCursor(createdAt time.Time, id string) string {
+	return createdAt.Format(time.RFC3339Nano) + "," + id
+}
+
+func ParseKeysetCursor(cursor string) (time.Time, string, error) {
+	createdAtStr, id, ok := strings.Cut(cursor, ",")
+	if !ok || id == "" {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, createdAtStr)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	return createdAt, id, nil
+}
+
+// How often deletions of chats by ttl are synced: messenger reloads its scheduler from storage,
+// storage that deletes chats by itself looks for deleted chats it hasn't reported
+const ExpirationsSyncInterval = time.Minute
+
+// Time when chat is deleted by ttl, false if chat has no ttl
+func ChatExpiresAt(chat entities.Chat) (time.Time, bool) {
+	if chat.TTL <= 0 {
+		return time.Time{}, false
+	}
+	return chat.CreatedAt.Add(time.Duration(chat.TTL) * time.Second), true
+}
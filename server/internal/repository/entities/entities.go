@@ -1,20 +1,224 @@
 package entities
 
+import "time"
+
 // К каждому сообщению привязан id юзера, id сообщения и само сообщение
+// Если сообщение изменено - хранится время изменения, удаленное сообщение остается без текста с флагом Deleted
+// Время создания и порядковый номер сообщения в чате (Seq, строго возрастает) назначаются хранилищем.
+// Attachments - id вложений чата, приложенных к сообщению.
+// ReplyTo - id корневого сообщения треда, если сообщение - ответ. Когда корень вытесняется по MaxChatSize,
+// его ответы становятся обычными сообщениями без ReplyTo. ReplyCount - количество ответов на корневое сообщение,
+// хранилище заполняет его только в истории и треде. Reactions заполняет messenger для юзера, который запрашивает сообщения.
+// ExpiresAt - время, когда сообщение удалится само, нулевое время - сообщение не удаляется
 type Message struct {
-	SessionUUID string `json:"session_uuid,omitempty"`
-	MessageUUID string `json:"message_uuid,omitempty"`
-	Text        string `json:"text,omitempty"`
+	SessionUUID string     `json:"session_uuid,omitempty"`
+	MessageUUID string     `json:"message_uuid,omitempty"`
+	Text        string     `json:"text,omitempty"`
+	EditedAt    time.Time  `json:"edited_at,omitempty"`
+	Deleted     bool       `json:"deleted,omitempty"`
+	CreatedAt   time.Time  `json:"created_at,omitempty"`
+	Seq         int64      `json:"seq,omitempty"`
+	Attachments []string   `json:"attachments,omitempty"`
+	ReplyTo     string     `json:"reply_to,omitempty"`
+	ReplyCount  int        `json:"reply_count,omitempty"`
+	Reactions   []Reaction `json:"reactions,omitempty"`
+	ExpiresAt   time.Time  `json:"expires_at,omitempty"`
+}
+
+// Реакции на сообщение одним эмодзи: сколько юзеров отреагировали и есть ли среди них юзер, который запрашивает сообщение
+type Reaction struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	Reacted bool   `json:"reacted"`
+}
+
+// Вложение, загруженное в чат. Содержимое лежит в хранилище файлов по ключу Key (SHA-256 содержимого),
+// хранилище чатов знает только описание вложения. Вложение живет, пока существует чат
+type Attachment struct {
+	ID          string
+	SessionUUID string
+	Key         string
+	Name        string
+	ContentType string
+	Size        int64
+	CreatedAt   time.Time
 }
 
 // Чат хранит в себе id юзера создавшего чат, могут ли другие юзеры писать в чат, время (в секундах) через сколько чат удалится,
+// время создания, количество сообщений в чате, кто имеет доступ к чату, описание чата для юзеров
+// и закрепленные сообщения, отсортированные repository.SortPins
 type Chat struct {
+	SessionUUID  string
+	ReadOnly     bool
+	TTL          int
+	ChatUUID     string
+	CreatedAt    time.Time
+	MessageCount int
+	Visibility   Visibility
+	Info         ChatInfo
+	Pinned       []Pin
+}
+
+// Закрепленное сообщение чата: кто и когда его закрепил. Закрепленное сообщение не вытесняется по MaxChatSize,
+// пока его не открепят. Удаленное сообщение открепляется
+type Pin struct {
+	MessageUUID string
 	SessionUUID string
-	ReadOnly    bool
-	TTL         int
+	PinnedAt    time.Time
+}
+
+// Описание чата, которое видят юзеры: название, описание и теги. Теги хранятся без повторов и отсортированными
+type ChatInfo struct {
+	Title       string
+	Description string
+	Tags        []string
+}
+
+// Изменение чата. Nil поля не меняются. TTL, как и в чате, считается от времени создания чата, 0 - чат не удаляется
+type ChatUpdate struct {
+	Title       *string
+	Description *string
+	Tags        *[]string
+	TTL         *int
+	ReadOnly    *bool
+}
+
+// Запланированное удаление чата по TTL: чат удаляется в ExpiresAt = время создания + TTL
+type ChatExpiration struct {
+	ChatUUID    string
+	SessionUUID string
+	ExpiresAt   time.Time
+}
+
+// Запланированное удаление сообщения: в ExpiresAt сообщение заменяется пустым удаленным сообщением
+type MessageExpiration struct {
 	ChatUUID    string
+	MessageUUID string
+	SessionUUID string
+	ExpiresAt   time.Time
+}
+
+// Запрос, отправленный с ключом идемпотентности: сообщение MessageUUID в чат ChatUUID, RequestHash - хэш остальных полей запроса.
+// Sent - сообщение сохранено, до этого запрос выполняется. RememberedAt - время, когда запомнили ключ
+type IdempotentRequest struct {
+	MessageUUID  string
+	ChatUUID     string
+	RequestHash  string
+	Sent         bool
+	RememberedAt time.Time
+}
+
+// Отложенное сообщение: юзер SessionUUID отправит Text в чат в SendAt. Удаляется, когда отправлено или отменено, и вместе с чатом
+type ScheduledMessage struct {
+	ScheduledUUID string
+	ChatUUID      string
+	SessionUUID   string
+	Text          string
+	SendAt        time.Time
+	CreatedAt     time.Time
+}
+
+// Кто имеет доступ к чату: открытый чат читать и писать может любой юзер, чат только для участников - только вступившие в него.
+// Чат только для участников видят в списке активных чатов только его участники.
+// Личный чат - только два его участника, в него нельзя вступить, он не показывается в списке активных чатов и не вытесняется по MaxChats
+type Visibility int
+
+const (
+	VisibilityOpen Visibility = iota
+	VisibilityMembersOnly
+	VisibilityDirect
+)
+
+// Найденное поиском сообщение и чат, в котором оно находится. Cursor - курсор страницы, следующей за этим сообщением.
+// Highlights - диапазоны символов текста, совпавшие с запросом, их заполняет messenger
+type FoundMessage struct {
+	ChatUUID   string
+	Message    Message
+	Cursor     string
+	Highlights []TextRange
+}
+
+// Диапазон символов текста [Start, End)
+type TextRange struct {
+	Start int
+	End   int
+}
+
+// Участник чата, время вступления в чат и роль участника. Создатель чата становится участником с ролью владельца при создании
+type Member struct {
+	SessionUUID string
+	JoinedAt    time.Time
+	Role        Role
+}
+
+// Чат, в котором состоит юзер: когда юзер вступил в него и сколько в нем непрочитанных юзером сообщений
+type MemberChat struct {
+	Chat        Chat
+	JoinedAt    time.Time
+	UnreadCount int
+}
+
+// Указатель прочтения юзера в чате: Seq последнего прочитанного сообщения и время, когда указатель передвинули.
+// Все сообщения до Seq включительно юзер прочитал
+type ReadReceipt struct {
+	SessionUUID string
+	Seq         int64
+	ReadAt      time.Time
+}
+
+// Роль участника чата. Роли упорядочены: каждая следующая роль может все, что может предыдущая
+type Role int
+
+const (
+	RoleMember Role = iota
+	RoleModerator
+	RoleAdmin
+	RoleOwner
+)
+
+// Ограничение юзера в чате: забаненный юзер не может читать чат и писать в него, замьюченный может только читать.
+// Until - время, когда ограничение снимается само, нулевое время - ограничение бессрочное
+type Restriction struct {
+	SessionUUID string
+	Kind        RestrictionKind
+	Until       time.Time
+}
+
+type RestrictionKind int
+
+const (
+	RestrictionBan RestrictionKind = iota
+	RestrictionMute
+)
+
+// Фильтр активных чатов. Пустые поля не фильтруют.
+// Title - часть названия чата без учета регистра, Tag - один из тегов чата.
+// Viewer - юзер, который запрашивает чаты: чаты только для участников показываются только их участникам, без Viewer - не показываются
+type ChatFilter struct {
+	Viewer       string
+	SessionUUID  string
+	ReadOnly     *bool
+	HasTTL       *bool
+	CreatedAfter time.Time
+	Title        string
+	Tag          string
 }
 
 type User struct {
 	SessionUUID string
 }
+
+// Направление чтения истории относительно курсора
+type Direction int
+
+const (
+	DirectionOlder Direction = iota
+	DirectionNewer
+)
+
+// Запрос страницы: курсор предыдущей страницы (пустой для первой), размер страницы (0 - без ограничения) и направление
+type Page struct {
+	Cursor    string
+	Limit     int
+	Direction Direction
+}
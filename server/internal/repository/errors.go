@@ -3,6 +3,11 @@ package repository
 import "errors"
 
 var ErrNotFound = errors.New("not found")
-var ErrProhibited = errors.New("prohibited. Only creator can send")
 var ErrUserDoesntExist = errors.New("user doesn't exist")
-var ErrTimeout = errors.New("operation timed out")
\ No newline at end of file
+var ErrTimeout = errors.New("operation timed out")
+var ErrInvalidCursor = errors.New("invalid cursor")
+var ErrMessageNotFound = errors.New("message not found")
+var ErrNotMember = errors.New("not a member of chat")
+var ErrAttachmentNotFound = errors.New("attachment not found")
+var ErrTooManyPinned = errors.New("too many pinned messages")
+var ErrAttachmentQuotaExceeded = errors.New("attachment quota of chat exceeded")
@@ -3,7 +3,10 @@ package inmemory
 // Implementation of storage interface for concurrent-safe inmemory storage
 
 import (
+	"cmp"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/Rolan335/grpcMessenger/server/internal/repository"
 	"github.com/Rolan335/grpcMessenger/server/internal/repository/entities"
@@ -16,29 +19,92 @@ type Message struct {
 	SessionUUID string
 	MessageUUID string
 	Text        string
+	EditedAt    time.Time
+	Deleted     bool
+	CreatedAt   time.Time
+	Seq         int64
+	Attachments []string
+	ReplyTo     string
+	ExpiresAt   time.Time
 }
 
 // Чат хранит в себе id юзера создавшего чат, могут ли другие юзеры писать в чат, время (в секундах) через сколько чат удалится,
+// Added - сколько всего сообщений было добавлено в чат, это Seq последнего сообщения. По нему считается абсолютная позиция сообщения для пагинации
+// Members - участники чата, Restrictions - баны и мьюты юзеров в чате со временем окончания, Info - описание чата.
+// Terms - обратный индекс для поиска: слово -> id сообщений, в которых оно есть. Удаленные и вытесненные сообщения из индекса убираются.
+// Threads - индекс тредов: id корневого сообщения -> id ответов на него, вытесненные ответы из индекса убираются.
+// Reactions - реакции: id сообщения -> эмодзи -> id юзеров, которые им отреагировали. Удаляются вместе с сообщением.
+// Reads - указатели прочтения юзеров: id юзера -> Seq последнего прочитанного им сообщения.
+// Pins - закрепленные сообщения по id. Закрепленное сообщение, вытесненное из lru, переезжает в Kept и остается в чате,
+// пока его не открепят, вместе со своим тредом и реакциями. Сообщения из Kept старше всех сообщений lru.
+// Scheduled - отложенные сообщения по id, удаляются, когда отправлены или отменены.
+// Claims - до какого времени отложенное сообщение занято отправкой или отменой, по id.
+// Attachments - вложения, загруженные в чат, по id. Они, ReadOnly и TTL защищены mu вместе с сообщениями
 type Chat struct {
+	SessionUUID  string
+	ReadOnly     bool
+	TTL          int
+	ChatUUID     string
+	Visibility   entities.Visibility
+	Info         entities.ChatInfo
+	Messages     *lru.Cache
+	Members      map[string]Member
+	Restrictions map[restrictionKey]time.Time
+	Terms        map[string]map[string]struct{}
+	Threads      map[string]map[string]struct{}
+	Reactions    map[string]map[string]map[string]struct{}
+	Reads        map[string]ReadPointer
+	Pins         map[string]entities.Pin
+	Kept         map[string]*Message
+	Scheduled    map[string]entities.ScheduledMessage
+	Claims       map[string]time.Time
+	Attachments  map[string]entities.Attachment
+	Added        int64
+	CreatedAt    time.Time
+	mu           sync.RWMutex
+}
+
+// Участник чата: время вступления и роль
+type Member struct {
+	JoinedAt time.Time
+	Role     entities.Role
+}
+
+// Указатель прочтения: Seq последнего прочитанного сообщения и время, когда указатель передвинули
+type ReadPointer struct {
+	Seq    int64
+	ReadAt time.Time
+}
+
+// Ограничение одного вида для одного юзера
+type restrictionKey struct {
 	SessionUUID string
-	ReadOnly    bool
-	TTL         int
-	ChatUUID    string
-	Messages    *lru.Cache
+	Kind        entities.RestrictionKind
 }
 
 type User struct {
 	SessionUUID string
 }
 
+// Запрос, отправленный с ключом идемпотентности, и время, до которого ключ помнится
+type IdempotencyKey struct {
+	Request   entities.IdempotentRequest
+	ExpiresAt time.Time
+}
+
 // mutex для конкурентой работы с мапой юзеров. MaxChatSize и MaxChats хранят максимальный размер чата и максимальное кол-во чатов соответственно.
 // Все чаты хранятся в lru. Все юзеры в мапе для оптимизации поиска.
+// Личные чаты хранятся отдельно от lru в мапе DirectChats под mu, они не вытесняются и не учитываются в MaxChats.
+// IdempotencyKeys - ключи идемпотентности юзеров под mu: id юзера -> ключ -> запрос. Истекшие ключи юзера удаляются, когда он отправляет сообщение с ключом
 type Storage struct {
-	MaxChatSize int
-	MaxChats    int
-	mu          *sync.RWMutex
-	ChatsData   *lru.Cache
-	Users       map[User]struct{}
+	MaxChatSize     int
+	MaxChats        int
+	mu              *sync.RWMutex
+	ChatsData       *lru.Cache
+	DirectChats     map[string]*Chat
+	Users           map[User]struct{}
+	IdempotencyKeys map[string]map[string]IdempotencyKey
+	onChatEvicted   func(chatUUID string)
 }
 
 func NewStorage(maxChatSize int, maxChats int) *Storage {
@@ -46,11 +112,13 @@ func NewStorage(maxChatSize int, maxChats int) *Storage {
 	lru, _ := lru.New(maxChats)
 	//Initializing new inmemory storage
 	return &Storage{
-		MaxChatSize: maxChatSize,
-		MaxChats:    maxChats,
-		mu:          &sync.RWMutex{},
-		ChatsData:   lru,
-		Users:       make(map[User]struct{}),
+		MaxChatSize:     maxChatSize,
+		MaxChats:        maxChats,
+		mu:              &sync.RWMutex{},
+		ChatsData:       lru,
+		DirectChats:     make(map[string]*Chat),
+		Users:           make(map[User]struct{}),
+		IdempotencyKeys: make(map[string]map[string]IdempotencyKey),
 	}
 }
 
@@ -61,142 +129,1255 @@ func (s *Storage) AddSession(sessionUUID string) {
 	s.Users[User{SessionUUID: sessionUUID}] = struct{}{}
 }
 
-func (s *Storage) AddChat(sessionUUID string, ttl int, readOnly bool, chatUUID string) error {
-	//Lock for reading to retrieve a data
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+func (s *Storage) AddChat(sessionUUID string, ttl int, readOnly bool, visibility entities.Visibility, chatUUID string, info entities.ChatInfo) error {
+	//Locking for writing, so concurrent chats can't both pass the check of size and make lru evict chat silently
+	s.mu.Lock()
 	_, ok := s.Users[User{SessionUUID: sessionUUID}]
 	//if not found send error
 	if !ok {
+		s.mu.Unlock()
 		return repository.ErrNotFound
 	}
 
+	//Creating new chat as a pointer to add messages directly. Creator is the first member and owner of chat
+	createdAt := time.Now()
+	newChat := &Chat{
+		SessionUUID:  sessionUUID,
+		ReadOnly:     readOnly,
+		ChatUUID:     chatUUID,
+		TTL:          ttl,
+		Visibility:   visibility,
+		Info:         info,
+		Members:      map[string]Member{sessionUUID: {JoinedAt: createdAt, Role: entities.RoleOwner}},
+		Restrictions: make(map[restrictionKey]time.Time),
+		Terms:        make(map[string]map[string]struct{}),
+		Threads:      make(map[string]map[string]struct{}),
+		Reactions:    make(map[string]map[string]map[string]struct{}),
+		Reads:        make(map[string]ReadPointer),
+		Pins:         make(map[string]entities.Pin),
+		Kept:         make(map[string]*Message),
+		Scheduled:    make(map[string]entities.ScheduledMessage),
+		Claims:       make(map[string]time.Time),
+		Attachments:  make(map[string]entities.Attachment),
+		CreatedAt:    createdAt,
+	}
 	//Creating new lru for chat to store messages.
-	lru, _ := lru.New(s.MaxChatSize)
+	newChat.Messages, _ = lru.NewWithEvict(s.MaxChatSize, newChat.onMessageEvicted)
 
-	//Creating new chat as a pointer to add messages directly
-	newChat := &Chat{
-		SessionUUID: sessionUUID,
-		ReadOnly:    readOnly,
-		ChatUUID:    chatUUID,
-		TTL:         ttl,
-		Messages:    lru,
+	//Evicting least recently used chat by ourselves instead of lru, so it can be reported
+	var evicted any
+	if s.ChatsData.Len() >= s.MaxChats && !s.ChatsData.Contains(chatUUID) {
+		evicted, _, _ = s.ChatsData.RemoveOldest()
 	}
 
 	//Add new chat to lru in storage struct
 	s.ChatsData.Add(chatUUID, newChat)
+	s.mu.Unlock()
+
+	//Reporting after unlock, handler can use storage
+	if evicted != nil && s.onChatEvicted != nil {
+		s.onChatEvicted(evicted.(string))
+	}
 	return nil
 }
 
-func (s *Storage) AddMessage(sessionUUID string, chatUUID string, messageUUID string, text string) error {
+// Creating direct chat of two sessions if it doesn't exist yet. Both sessions are members of chat with owner role
+func (s *Storage) AddDirectChat(sessionUUID string, peerSessionUUID string, chatUUID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range []string{sessionUUID, peerSessionUUID} {
+		if _, ok := s.Users[User{SessionUUID: v}]; !ok {
+			return false, repository.ErrUserDoesntExist
+		}
+	}
+	if _, ok := s.DirectChats[chatUUID]; ok {
+		return false, nil
+	}
 
-	//Making message
-	newMessage := Message{
+	createdAt := time.Now()
+	newChat := &Chat{
 		SessionUUID: sessionUUID,
-		MessageUUID: messageUUID,
-		Text:        text,
+		ChatUUID:    chatUUID,
+		Visibility:  entities.VisibilityDirect,
+		Members: map[string]Member{
+			sessionUUID:     {JoinedAt: createdAt, Role: entities.RoleOwner},
+			peerSessionUUID: {JoinedAt: createdAt, Role: entities.RoleOwner},
+		},
+		Restrictions: make(map[restrictionKey]time.Time),
+		Terms:        make(map[string]map[string]struct{}),
+		Threads:      make(map[string]map[string]struct{}),
+		Reactions:    make(map[string]map[string]map[string]struct{}),
+		Reads:        make(map[string]ReadPointer),
+		Pins:         make(map[string]entities.Pin),
+		Kept:         make(map[string]*Message),
+		Scheduled:    make(map[string]entities.ScheduledMessage),
+		Claims:       make(map[string]time.Time),
+		Attachments:  make(map[string]entities.Attachment),
+		CreatedAt:    createdAt,
 	}
+	newChat.Messages, _ = lru.NewWithEvict(s.MaxChatSize, newChat.onMessageEvicted)
+	s.DirectChats[chatUUID] = newChat
+	return true, nil
+}
 
+// Getting chat from lru or direct chats. Getting marks chat in lru as recently used
+func (s *Storage) getChat(chatUUID string) (*Chat, bool) {
+	if chat, ok := s.ChatsData.Get(chatUUID); ok {
+		return chat.(*Chat), true
+	}
+	return s.getDirectChat(chatUUID)
+}
+
+// Peek is used so checking chat doesn't change the order of eviction
+func (s *Storage) peekChat(chatUUID string) (*Chat, bool) {
+	if chat, ok := s.ChatsData.Peek(chatUUID); ok {
+		return chat.(*Chat), true
+	}
+	return s.getDirectChat(chatUUID)
+}
+
+func (s *Storage) getDirectChat(chatUUID string) (*Chat, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	chat, ok := s.DirectChats[chatUUID]
+	return chat, ok
+}
+
+func (s *Storage) AddMessage(chatUUID string, message entities.Message) (entities.Message, error) {
 	//Trying to get chat from lru
-	chat, ok := s.ChatsData.Get(chatUUID)
+	chatAsserted, ok := s.getChat(chatUUID)
 	//send error if not found
 	if !ok {
-		return repository.ErrNotFound
+		return entities.Message{}, repository.ErrNotFound
 	}
 	s.mu.RLock()
-	_, ok = s.Users[User{SessionUUID: sessionUUID}]
+	_, ok = s.Users[User{SessionUUID: message.SessionUUID}]
 	s.mu.RUnlock()
 	//send error if not found
 	if !ok {
-		return repository.ErrUserDoesntExist
+		return entities.Message{}, repository.ErrUserDoesntExist
 	}
 
-	//type assert retrieved chat
-	chatAsserted := chat.(*Chat)
-
-	//check if we can send message to chat
-	if chatAsserted.ReadOnly && chatAsserted.SessionUUID != sessionUUID {
-		return repository.ErrProhibited
+	//add new message to chat. Seq is assigned under lock so messages in lru are always in seq order
+	chatAsserted.mu.Lock()
+	defer chatAsserted.mu.Unlock()
+	if _, ok := chatAsserted.message(message.ReplyTo); message.ReplyTo != "" && !ok {
+		return entities.Message{}, repository.ErrMessageNotFound
 	}
-
-	//add new message to chat
-	chatAsserted.Messages.Add(messageUUID, newMessage)
-	return nil
+	chatAsserted.Added++
+	//Making message. Stored as pointer so it can be changed without changing order of messages in lru
+	newMessage := &Message{
+		SessionUUID: message.SessionUUID,
+		MessageUUID: message.MessageUUID,
+		Text:        message.Text,
+		CreatedAt:   time.Now(),
+		Seq:         chatAsserted.Added,
+		Attachments: message.Attachments,
+		ReplyTo:     message.ReplyTo,
+		ExpiresAt:   message.ExpiresAt,
+	}
+	//Reply is added to thread before message is added to lru, so if its root is evicted by it, reply leaves thread like others
+	if newMessage.ReplyTo != "" {
+		if chatAsserted.Threads[newMessage.ReplyTo] == nil {
+			chatAsserted.Threads[newMessage.ReplyTo] = make(map[string]struct{})
+		}
+		chatAsserted.Threads[newMessage.ReplyTo][newMessage.MessageUUID] = struct{}{}
+	}
+	chatAsserted.Messages.Add(message.MessageUUID, newMessage)
+	chatAsserted.index(newMessage)
+	return newMessage.toEntity(), nil
 }
 
-func (s *Storage) GetHistory(chatUUID string) (history []entities.Message, err error) {
+func (s *Storage) GetHistory(chatUUID string, page entities.Page) (history []entities.Message, nextCursor string, err error) {
 	//get chat with provided chatUUID
-	chat, ok := s.ChatsData.Get(chatUUID)
+	chatAsserted, ok := s.getChat(chatUUID)
 
 	//if not found, return error
 	if !ok {
-		return nil, repository.ErrNotFound
+		return nil, "", repository.ErrNotFound
 	}
 
-	//type assert chat
-	chatAsserted := chat.(*Chat)
+	chatAsserted.mu.RLock()
+	defer chatAsserted.mu.RUnlock()
 
-	//getting keys from chat to iterate
+	//getting keys from chat from oldest to newest. Absolute position of the first key is Added - len(keys),
+	//kept pinned messages are older than all of them, absolute position of message is Seq - 1
 	keys := chatAsserted.Messages.Keys()
+	kept := chatAsserted.keptMessages()
+	keptPositions := make([]int64, 0, len(kept))
+	for _, v := range kept {
+		keptPositions = append(keptPositions, v.Seq-1)
+	}
+	first := chatAsserted.Added - int64(len(keys))
+	from, to, nextCursor, err := repository.PageRange(repository.Positions(keptPositions, first, int64(len(keys))), page)
+	if err != nil {
+		return nil, "", err
+	}
 
 	//slice for storing ChatMessages
-	msgArr := make([]entities.Message, 0, len(keys))
+	msgArr := make([]entities.Message, 0, to-from)
 
-	//iterating through retrieved keys and getting all Message struct with it
-	for _, v := range keys {
+	//iterating through messages of requested page only. Peek is used so reading doesn't change the order of messages
+	for i := from; i < to; i++ {
+		if i < len(kept) {
+			msgArr = append(msgArr, chatAsserted.withReplyCount(kept[i]))
+			continue
+		}
 		//get chat struct with key
-		msg, _ := chatAsserted.Messages.Get(v)
+		msg, _ := chatAsserted.Messages.Peek(keys[i-len(kept)])
 		//type assert gotten message
-		msgAsserted := msg.(Message)
+		msgAsserted := msg.(*Message)
 		//creating struct for proto response and append it to slice
-		msgArr = append(msgArr, entities.Message{
-			SessionUUID: msgAsserted.SessionUUID,
-			MessageUUID: msgAsserted.MessageUUID,
-			Text:        msgAsserted.Text,
-		})
+		msgArr = append(msgArr, chatAsserted.withReplyCount(msgAsserted))
 	}
-	//returning completed history of messages
-	return msgArr, nil
+	//returning requested page of messages
+	return msgArr, nextCursor, nil
 }
 
-func (s *Storage) DeleteChat(sessionUUID string, chatUUID string) error {
-	//Check if chat is present
-	chat, ok := s.ChatsData.Get(chatUUID)
+// Getting message of chat. Deleted message is not found
+func (s *Storage) GetMessage(chatUUID string, messageUUID string) (entities.Message, error) {
+	chatAsserted, ok := s.peekChat(chatUUID)
+	if !ok {
+		return entities.Message{}, repository.ErrNotFound
+	}
+
+	chatAsserted.mu.RLock()
+	defer chatAsserted.mu.RUnlock()
+	msg, ok := chatAsserted.message(messageUUID)
+	if !ok || msg.Deleted {
+		return entities.Message{}, repository.ErrMessageNotFound
+	}
+	return msg.toEntity(), nil
+}
+
+// Thread is found by index of threads, replies are ordered by seq
+func (s *Storage) GetThread(chatUUID string, messageUUID string) ([]entities.Message, error) {
+	chatAsserted, ok := s.getChat(chatUUID)
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	chatAsserted.mu.RLock()
+	defer chatAsserted.mu.RUnlock()
+	root, ok := chatAsserted.message(messageUUID)
+	if !ok {
+		return nil, repository.ErrMessageNotFound
+	}
+	if root.ReplyTo != "" {
+		//Root is always in chat while it has replies
+		root, _ = chatAsserted.message(root.ReplyTo)
+	}
+
+	thread := make([]entities.Message, 0, len(chatAsserted.Threads[root.MessageUUID])+1)
+	thread = append(thread, chatAsserted.withReplyCount(root))
+	for replyUUID := range chatAsserted.Threads[root.MessageUUID] {
+		if reply, ok := chatAsserted.message(replyUUID); ok {
+			thread = append(thread, reply.toEntity())
+		}
+	}
+	slices.SortFunc(thread[1:], func(a, b entities.Message) int {
+		return cmp.Compare(a.Seq, b.Seq)
+	})
+	return thread, nil
+}
+
+// Message with number of replies if it's root of thread. mu must be held
+func (c *Chat) withReplyCount(message *Message) entities.Message {
+	messageEntity := message.toEntity()
+	if message.ReplyTo == "" {
+		messageEntity.ReplyCount = len(c.Threads[message.MessageUUID])
+	}
+	return messageEntity
+}
+
+func (s *Storage) EditMessage(chatUUID string, messageUUID string, text string) (entities.Message, error) {
+	return s.updateMessage(chatUUID, messageUUID, func(message *Message) {
+		message.Text = text
+		message.EditedAt = time.Now()
+	})
+}
+
+func (s *Storage) DeleteMessage(chatUUID string, messageUUID string) (entities.Message, error) {
+	return s.updateMessage(chatUUID, messageUUID, func(message *Message) {
+		//Leaving tombstone instead of message so positions of messages don't change
+		message.Text = ""
+		message.Attachments = nil
+		message.Deleted = true
+	})
+}
+
+// Changing message in place
+func (s *Storage) updateMessage(chatUUID string, messageUUID string, update func(message *Message)) (entities.Message, error) {
+	//Trying to get chat from lru
+	chatAsserted, ok := s.getChat(chatUUID)
+	//send error if not found
+	if !ok {
+		return entities.Message{}, repository.ErrNotFound
+	}
+
+	chatAsserted.mu.Lock()
+	defer chatAsserted.mu.Unlock()
+	msgAsserted, ok := chatAsserted.message(messageUUID)
+	if !ok || msgAsserted.Deleted {
+		return entities.Message{}, repository.ErrMessageNotFound
+	}
+	//Message is indexed again by its new text, deleted message is not indexed, loses its reactions and is unpinned
+	chatAsserted.unindex(msgAsserted)
+	update(msgAsserted)
+	if !msgAsserted.Deleted {
+		chatAsserted.index(msgAsserted)
+	} else {
+		delete(chatAsserted.Reactions, messageUUID)
+		chatAsserted.unpin(messageUUID)
+	}
+	return msgAsserted.toEntity(), nil
+}
+
+func (s *Storage) AddReaction(chatUUID string, messageUUID string, sessionUUID string, emoji string) (bool, error) {
+	chatAsserted, ok := s.peekChat(chatUUID)
+	if !ok {
+		return false, repository.ErrNotFound
+	}
+	s.mu.RLock()
+	_, ok = s.Users[User{SessionUUID: sessionUUID}]
+	s.mu.RUnlock()
+	if !ok {
+		return false, repository.ErrUserDoesntExist
+	}
+
+	chatAsserted.mu.Lock()
+	defer chatAsserted.mu.Unlock()
+	msg, ok := chatAsserted.message(messageUUID)
+	if !ok || msg.Deleted {
+		return false, repository.ErrMessageNotFound
+	}
+	reactions := chatAsserted.Reactions[messageUUID]
+	if _, ok := reactions[emoji][sessionUUID]; ok {
+		return false, nil
+	}
+	if reactions == nil {
+		reactions = make(map[string]map[string]struct{})
+		chatAsserted.Reactions[messageUUID] = reactions
+	}
+	if reactions[emoji] == nil {
+		reactions[emoji] = make(map[string]struct{})
+	}
+	reactions[emoji][sessionUUID] = struct{}{}
+	return true, nil
+}
+
+func (s *Storage) RemoveReaction(chatUUID string, messageUUID string, sessionUUID string, emoji string) (bool, error) {
+	chatAsserted, ok := s.peekChat(chatUUID)
+	if !ok {
+		return false, repository.ErrNotFound
+	}
+
+	chatAsserted.mu.Lock()
+	defer chatAsserted.mu.Unlock()
+	reactions := chatAsserted.Reactions[messageUUID]
+	if _, ok := reactions[emoji][sessionUUID]; !ok {
+		return false, nil
+	}
+	delete(reactions[emoji], sessionUUID)
+	if len(reactions[emoji]) == 0 {
+		delete(reactions, emoji)
+	}
+	if len(reactions) == 0 {
+		delete(chatAsserted.Reactions, messageUUID)
+	}
+	return true, nil
+}
+
+func (s *Storage) GetReactions(chatUUID string, messageUUIDs []string, sessionUUID string) (map[string][]entities.Reaction, error) {
+	chatAsserted, ok := s.peekChat(chatUUID)
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	chatAsserted.mu.RLock()
+	defer chatAsserted.mu.RUnlock()
+	reactions := make(map[string][]entities.Reaction)
+	for _, v := range messageUUIDs {
+		if len(chatAsserted.Reactions[v]) == 0 {
+			continue
+		}
+		messageReactions := make([]entities.Reaction, 0, len(chatAsserted.Reactions[v]))
+		for emoji, sessions := range chatAsserted.Reactions[v] {
+			_, reacted := sessions[sessionUUID]
+			messageReactions = append(messageReactions, entities.Reaction{Emoji: emoji, Count: len(sessions), Reacted: reacted})
+		}
+		repository.SortReactions(messageReactions)
+		reactions[v] = messageReactions
+	}
+	return reactions, nil
+}
+
+func (s *Storage) AddAttachment(chatUUID string, attachment entities.Attachment, quota int64) error {
+	chatAsserted, ok := s.peekChat(chatUUID)
 	if !ok {
 		return repository.ErrNotFound
 	}
+	chatAsserted.mu.Lock()
+	defer chatAsserted.mu.Unlock()
+	if quota > 0 {
+		used := attachment.Size
+		for _, v := range chatAsserted.Attachments {
+			used += v.Size
+		}
+		if used > quota {
+			return repository.ErrAttachmentQuotaExceeded
+		}
+	}
+	chatAsserted.Attachments[attachment.ID] = attachment
+	return nil
+}
+
+func (s *Storage) GetAttachment(chatUUID string, attachmentID string) (entities.Attachment, error) {
+	chatAsserted, ok := s.peekChat(chatUUID)
+	if !ok {
+		return entities.Attachment{}, repository.ErrNotFound
+	}
+	chatAsserted.mu.RLock()
+	defer chatAsserted.mu.RUnlock()
+	attachment, ok := chatAsserted.Attachments[attachmentID]
+	if !ok {
+		return entities.Attachment{}, repository.ErrAttachmentNotFound
+	}
+	return attachment, nil
+}
 
-	//type Assertion
-	chatAsserted := chat.(*Chat)
-	//Deletion is can be made only by chat Creator
-	if chatAsserted.SessionUUID != sessionUUID {
-		return repository.ErrProhibited
+func (s *Storage) GetAttachments(chatUUID string) ([]entities.Attachment, error) {
+	chatAsserted, ok := s.peekChat(chatUUID)
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	chatAsserted.mu.RLock()
+	defer chatAsserted.mu.RUnlock()
+	attachments := make([]entities.Attachment, 0, len(chatAsserted.Attachments))
+	for _, v := range chatAsserted.Attachments {
+		attachments = append(attachments, v)
+	}
+	return attachments, nil
+}
+
+// Messages are added under the same mu, so attachment can't be referenced while it's deleted
+func (s *Storage) DeleteUnreferencedAttachments(chatUUID string, ids []string, uploadedBefore time.Time) ([]entities.Attachment, error) {
+	chatAsserted, ok := s.peekChat(chatUUID)
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	chatAsserted.mu.Lock()
+	defer chatAsserted.mu.Unlock()
+	referenced := make(map[string]struct{})
+	for _, key := range chatAsserted.Messages.Keys() {
+		if msg, ok := chatAsserted.Messages.Peek(key); ok {
+			for _, v := range msg.(*Message).Attachments {
+				referenced[v] = struct{}{}
+			}
+		}
+	}
+	for _, message := range chatAsserted.Kept {
+		for _, v := range message.Attachments {
+			referenced[v] = struct{}{}
+		}
+	}
+	var deleted []entities.Attachment
+	for id, v := range chatAsserted.Attachments {
+		if _, ok := referenced[id]; ok || !v.CreatedAt.Before(uploadedBefore) || (ids != nil && !slices.Contains(ids, id)) {
+			continue
+		}
+		delete(chatAsserted.Attachments, id)
+		deleted = append(deleted, v)
+	}
+	return deleted, nil
+}
+
+func (s *Storage) JoinChat(sessionUUID string, chatUUID string) error {
+	chatAsserted, ok := s.getChat(chatUUID)
+	if !ok {
+		return repository.ErrNotFound
+	}
+	s.mu.RLock()
+	_, ok = s.Users[User{SessionUUID: sessionUUID}]
+	s.mu.RUnlock()
+	if !ok {
+		return repository.ErrUserDoesntExist
+	}
+
+	chatAsserted.mu.Lock()
+	defer chatAsserted.mu.Unlock()
+	//Joining again doesn't change time of joining and role
+	if _, ok := chatAsserted.Members[sessionUUID]; !ok {
+		chatAsserted.Members[sessionUUID] = Member{JoinedAt: time.Now(), Role: entities.RoleMember}
+	}
+	return nil
+}
+
+func (s *Storage) LeaveChat(sessionUUID string, chatUUID string) error {
+	chatAsserted, ok := s.getChat(chatUUID)
+	if !ok {
+		return repository.ErrNotFound
+	}
+
+	chatAsserted.mu.Lock()
+	defer chatAsserted.mu.Unlock()
+	if _, ok := chatAsserted.Members[sessionUUID]; !ok {
+		return repository.ErrNotMember
+	}
+	delete(chatAsserted.Members, sessionUUID)
+	return nil
+}
+
+func (s *Storage) GetMember(sessionUUID string, chatUUID string) (entities.Member, error) {
+	chatAsserted, ok := s.peekChat(chatUUID)
+	if !ok {
+		return entities.Member{}, repository.ErrNotFound
+	}
+
+	chatAsserted.mu.RLock()
+	defer chatAsserted.mu.RUnlock()
+	member, ok := chatAsserted.Members[sessionUUID]
+	if !ok {
+		return entities.Member{}, repository.ErrNotMember
+	}
+	return member.toEntity(sessionUUID), nil
+}
+
+func (s *Storage) GetParticipants(chatUUID string) ([]entities.Member, error) {
+	chatAsserted, ok := s.getChat(chatUUID)
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	chatAsserted.mu.RLock()
+	defer chatAsserted.mu.RUnlock()
+	members := make([]entities.Member, 0, len(chatAsserted.Members))
+	for sessionUUID, member := range chatAsserted.Members {
+		members = append(members, member.toEntity(sessionUUID))
+	}
+	repository.SortMembers(members)
+	return members, nil
+}
+
+func (s *Storage) SetMemberRole(sessionUUID string, chatUUID string, role entities.Role) error {
+	chatAsserted, ok := s.getChat(chatUUID)
+	if !ok {
+		return repository.ErrNotFound
+	}
+
+	chatAsserted.mu.Lock()
+	defer chatAsserted.mu.Unlock()
+	member, ok := chatAsserted.Members[sessionUUID]
+	if !ok {
+		return repository.ErrNotMember
+	}
+	member.Role = role
+	chatAsserted.Members[sessionUUID] = member
+	return nil
+}
+
+func (s *Storage) Restrict(chatUUID string, restriction entities.Restriction) error {
+	chatAsserted, ok := s.peekChat(chatUUID)
+	if !ok {
+		return repository.ErrNotFound
+	}
+	s.mu.RLock()
+	_, ok = s.Users[User{SessionUUID: restriction.SessionUUID}]
+	s.mu.RUnlock()
+	if !ok {
+		return repository.ErrUserDoesntExist
+	}
+
+	chatAsserted.mu.Lock()
+	defer chatAsserted.mu.Unlock()
+	//Dropping expired restrictions of chat so they don't pile up
+	now := time.Now()
+	for key, until := range chatAsserted.Restrictions {
+		if repository.RestrictionExpired(entities.Restriction{Until: until}, now) {
+			delete(chatAsserted.Restrictions, key)
+		}
+	}
+	chatAsserted.Restrictions[restrictionKey{SessionUUID: restriction.SessionUUID, Kind: restriction.Kind}] = restriction.Until
+	return nil
+}
+
+func (s *Storage) Unrestrict(sessionUUID string, chatUUID string, kind entities.RestrictionKind) error {
+	chatAsserted, ok := s.peekChat(chatUUID)
+	if !ok {
+		return repository.ErrNotFound
+	}
+
+	chatAsserted.mu.Lock()
+	defer chatAsserted.mu.Unlock()
+	delete(chatAsserted.Restrictions, restrictionKey{SessionUUID: sessionUUID, Kind: kind})
+	return nil
+}
+
+// Peek is used so checking restrictions doesn't change the order of eviction
+func (s *Storage) GetRestrictions(sessionUUID string, chatUUID string) ([]entities.Restriction, error) {
+	chatAsserted, ok := s.peekChat(chatUUID)
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	chatAsserted.mu.RLock()
+	defer chatAsserted.mu.RUnlock()
+	now := time.Now()
+	var restrictions []entities.Restriction
+	for _, kind := range []entities.RestrictionKind{entities.RestrictionBan, entities.RestrictionMute} {
+		until, ok := chatAsserted.Restrictions[restrictionKey{SessionUUID: sessionUUID, Kind: kind}]
+		restriction := entities.Restriction{SessionUUID: sessionUUID, Kind: kind, Until: until}
+		if ok && !repository.RestrictionExpired(restriction, now) {
+			restrictions = append(restrictions, restriction)
+		}
+	}
+	return restrictions, nil
+}
+
+func (s *Storage) MarkRead(chatUUID string, sessionUUID string, seq int64) error {
+	chatAsserted, ok := s.getChat(chatUUID)
+	if !ok {
+		return repository.ErrNotFound
+	}
+	s.mu.RLock()
+	_, ok = s.Users[User{SessionUUID: sessionUUID}]
+	s.mu.RUnlock()
+	if !ok {
+		return repository.ErrUserDoesntExist
+	}
+
+	chatAsserted.mu.Lock()
+	defer chatAsserted.mu.Unlock()
+	if chatAsserted.Reads[sessionUUID].Seq >= seq {
+		return nil
+	}
+	chatAsserted.Reads[sessionUUID] = ReadPointer{Seq: seq, ReadAt: time.Now()}
+	return nil
+}
+
+// Chats of session are found by members of every chat, unread messages are counted in lru of chat
+func (s *Storage) GetMemberChats(sessionUUID string) ([]entities.MemberChat, error) {
+	all := make([]*Chat, 0, s.ChatsData.Len())
+	for _, key := range s.ChatsData.Keys() {
+		if chat, ok := s.ChatsData.Peek(key); ok {
+			all = append(all, chat.(*Chat))
+		}
+	}
+	s.mu.RLock()
+	for _, v := range s.DirectChats {
+		all = append(all, v)
+	}
+	s.mu.RUnlock()
+
+	var chats []entities.MemberChat
+	for _, v := range all {
+		chatEntity := v.toEntity()
+		v.mu.RLock()
+		member, ok := v.Members[sessionUUID]
+		if ok {
+			chats = append(chats, entities.MemberChat{Chat: chatEntity, JoinedAt: member.JoinedAt, UnreadCount: v.unreadCount(sessionUUID)})
+		}
+		v.mu.RUnlock()
+	}
+	repository.SortMemberChats(chats)
+	return chats, nil
+}
+
+// Number of messages unread by session. mu must be held
+func (c *Chat) unreadCount(sessionUUID string) int {
+	unread := 0
+	for _, key := range c.Messages.Keys() {
+		if msg, ok := c.Messages.Peek(key); ok && repository.Unread(msg.(*Message).toEntity(), sessionUUID, c.Reads[sessionUUID].Seq) {
+			unread++
+		}
+	}
+	for _, v := range c.Kept {
+		if repository.Unread(v.toEntity(), sessionUUID, c.Reads[sessionUUID].Seq) {
+			unread++
+		}
+	}
+	return unread
+}
+
+func (s *Storage) GetReadReceipts(chatUUID string, seq int64) ([]entities.ReadReceipt, error) {
+	chatAsserted, ok := s.peekChat(chatUUID)
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	chatAsserted.mu.RLock()
+	defer chatAsserted.mu.RUnlock()
+	var receipts []entities.ReadReceipt
+	for sessionUUID, v := range chatAsserted.Reads {
+		if v.Seq >= seq {
+			receipts = append(receipts, entities.ReadReceipt{SessionUUID: sessionUUID, Seq: v.Seq, ReadAt: v.ReadAt})
+		}
+	}
+	repository.SortReadReceipts(receipts)
+	return receipts, nil
+}
+
+func (m Member) toEntity(sessionUUID string) entities.Member {
+	return entities.Member{
+		SessionUUID: sessionUUID,
+		JoinedAt:    m.JoinedAt,
+		Role:        m.Role,
+	}
+}
+
+func (m *Message) toEntity() entities.Message {
+	return entities.Message{
+		SessionUUID: m.SessionUUID,
+		MessageUUID: m.MessageUUID,
+		Text:        m.Text,
+		EditedAt:    m.EditedAt,
+		Deleted:     m.Deleted,
+		CreatedAt:   m.CreatedAt,
+		Seq:         m.Seq,
+		Attachments: m.Attachments,
+		ReplyTo:     m.ReplyTo,
+		ExpiresAt:   m.ExpiresAt,
+	}
+}
+
+// Getting settings of chat. Peek is used so checking chat doesn't change the order of eviction
+func (s *Storage) GetChat(chatUUID string) (entities.Chat, error) {
+	chat, ok := s.peekChat(chatUUID)
+	if !ok {
+		return entities.Chat{}, repository.ErrNotFound
+	}
+	return chat.toEntity(), nil
+}
+
+func (s *Storage) UpdateChat(chatUUID string, update entities.ChatUpdate) (entities.Chat, error) {
+	chat, ok := s.peekChat(chatUUID)
+	if !ok {
+		return entities.Chat{}, repository.ErrNotFound
+	}
+	chat.mu.Lock()
+	repository.UpdateChatInfo(&chat.Info, update)
+	if update.TTL != nil {
+		chat.TTL = *update.TTL
+	}
+	if update.ReadOnly != nil {
+		chat.ReadOnly = *update.ReadOnly
+	}
+	chat.mu.Unlock()
+	return chat.toEntity(), nil
+}
+
+func (s *Storage) DeleteChat(chatUUID string) error {
+	//Direct chat is not in lru
+	s.mu.Lock()
+	_, ok := s.DirectChats[chatUUID]
+	delete(s.DirectChats, chatUUID)
+	s.mu.Unlock()
+	if ok {
+		return nil
+	}
+
+	//Check if chat is present
+	if !s.ChatsData.Contains(chatUUID) {
+		return repository.ErrNotFound
 	}
 	//Delete chat
 	s.ChatsData.Remove(chatUUID)
 	return nil
 }
 
-func (s *Storage) GetActiveChats() (chats []entities.Chat) {
-	//Get keys for all chats in lru
+// Messages of every chat are found by its own index, then results of all chats are ordered together
+func (s *Storage) SearchMessages(terms []string, chatUUID string, page entities.Page) (found []entities.FoundMessage, nextCursor string, err error) {
+	var cursorTime time.Time
+	var cursorID string
+	if page.Cursor != "" {
+		cursorTime, cursorID, err = repository.ParseSearchCursor(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	var chats []*Chat
+	if chatUUID != "" {
+		chatAsserted, ok := s.peekChat(chatUUID)
+		if !ok {
+			return nil, "", repository.ErrNotFound
+		}
+		chats = append(chats, chatAsserted)
+	} else {
+		for _, key := range s.ChatsData.Keys() {
+			if chat, ok := s.ChatsData.Peek(key); ok {
+				chats = append(chats, chat.(*Chat))
+			}
+		}
+		s.mu.RLock()
+		for _, v := range s.DirectChats {
+			chats = append(chats, v)
+		}
+		s.mu.RUnlock()
+	}
+
+	for _, v := range chats {
+		for _, message := range v.search(terms) {
+			if page.Cursor != "" && repository.CompareSearchResults(message.CreatedAt, message.MessageUUID, cursorTime, cursorID) <= 0 {
+				continue
+			}
+			found = append(found, entities.FoundMessage{
+				ChatUUID: v.ChatUUID,
+				Message:  message,
+				Cursor:   repository.SearchCursor(message.CreatedAt, message.MessageUUID),
+			})
+		}
+	}
+	slices.SortFunc(found, func(a, b entities.FoundMessage) int {
+		return repository.CompareSearchResults(a.Message.CreatedAt, a.Message.MessageUUID, b.Message.CreatedAt, b.Message.MessageUUID)
+	})
+	if page.Limit > 0 && len(found) > page.Limit {
+		found = found[:page.Limit]
+		nextCursor = found[len(found)-1].Cursor
+	}
+	return found, nextCursor, nil
+}
+
+// Messages of chat that contain all terms. Search starts from the rarest term
+func (c *Chat) search(terms []string) []entities.Message {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(terms) == 0 {
+		return nil
+	}
+	rarest := terms[0]
+	for _, v := range terms {
+		if len(c.Terms[v]) < len(c.Terms[rarest]) {
+			rarest = v
+		}
+	}
+
+	var messages []entities.Message
+	for messageUUID := range c.Terms[rarest] {
+		matches := true
+		for _, v := range terms {
+			if _, ok := c.Terms[v][messageUUID]; !ok {
+				matches = false
+				break
+			}
+		}
+		if !matches {
+			continue
+		}
+		if msg, ok := c.message(messageUUID); ok {
+			messages = append(messages, msg.toEntity())
+		}
+	}
+	return messages
+}
+
+// Adding message to index of chat. mu must be held
+func (c *Chat) index(message *Message) {
+	for _, v := range repository.SearchTerms(message.Text) {
+		if c.Terms[v] == nil {
+			c.Terms[v] = make(map[string]struct{})
+		}
+		c.Terms[v][message.MessageUUID] = struct{}{}
+	}
+}
+
+// Removing message from index of chat. mu must be held
+func (c *Chat) unindex(message *Message) {
+	for _, v := range repository.SearchTerms(message.Text) {
+		delete(c.Terms[v], message.MessageUUID)
+		if len(c.Terms[v]) == 0 {
+			delete(c.Terms, v)
+		}
+	}
+}
+
+// Message is evicted from lru of chat when MaxChatSize exceeded, it happens in AddMessage under mu.
+// Pinned message is kept in chat, others are dropped
+func (c *Chat) onMessageEvicted(_ interface{}, value interface{}) {
+	message := value.(*Message)
+	if _, ok := c.Pins[message.MessageUUID]; ok {
+		c.Kept[message.MessageUUID] = message
+		return
+	}
+	c.drop(message)
+}
+
+// Dropping message that is not in chat anymore. Dropped reply leaves its thread, replies of dropped root become ordinary messages.
+// Reactions are dropped with message. mu must be held
+func (c *Chat) drop(message *Message) {
+	c.unindex(message)
+	delete(c.Reactions, message.MessageUUID)
+	if message.ReplyTo != "" {
+		delete(c.Threads[message.ReplyTo], message.MessageUUID)
+		if len(c.Threads[message.ReplyTo]) == 0 {
+			delete(c.Threads, message.ReplyTo)
+		}
+	}
+	for replyUUID := range c.Threads[message.MessageUUID] {
+		if reply, ok := c.message(replyUUID); ok {
+			reply.ReplyTo = ""
+		}
+	}
+	delete(c.Threads, message.MessageUUID)
+}
+
+// Message of chat from lru or kept pinned messages. Peek is used so reading doesn't change the order of messages. mu must be held
+func (c *Chat) message(messageUUID string) (*Message, bool) {
+	if msg, ok := c.Messages.Peek(messageUUID); ok {
+		return msg.(*Message), true
+	}
+	msg, ok := c.Kept[messageUUID]
+	return msg, ok
+}
+
+// Kept pinned messages ordered by seq. mu must be held
+func (c *Chat) keptMessages() []*Message {
+	kept := make([]*Message, 0, len(c.Kept))
+	for _, v := range c.Kept {
+		kept = append(kept, v)
+	}
+	slices.SortFunc(kept, func(a, b *Message) int {
+		return cmp.Compare(a.Seq, b.Seq)
+	})
+	return kept
+}
+
+// Pinning message that is not deleted, limit is checked under mu so chat never has more pinned messages
+func (s *Storage) PinMessage(chatUUID string, pin entities.Pin, maxPinned int) (bool, error) {
+	chatAsserted, ok := s.peekChat(chatUUID)
+	if !ok {
+		return false, repository.ErrNotFound
+	}
+	s.mu.RLock()
+	_, ok = s.Users[User{SessionUUID: pin.SessionUUID}]
+	s.mu.RUnlock()
+	if !ok {
+		return false, repository.ErrUserDoesntExist
+	}
+
+	chatAsserted.mu.Lock()
+	defer chatAsserted.mu.Unlock()
+	msg, ok := chatAsserted.message(pin.MessageUUID)
+	if !ok || msg.Deleted {
+		return false, repository.ErrMessageNotFound
+	}
+	if _, ok := chatAsserted.Pins[pin.MessageUUID]; ok {
+		return false, nil
+	}
+	if len(chatAsserted.Pins) >= maxPinned {
+		return false, repository.ErrTooManyPinned
+	}
+	chatAsserted.Pins[pin.MessageUUID] = pin
+	return true, nil
+}
+
+func (s *Storage) UnpinMessage(chatUUID string, messageUUID string) (bool, error) {
+	chatAsserted, ok := s.peekChat(chatUUID)
+	if !ok {
+		return false, repository.ErrNotFound
+	}
+
+	chatAsserted.mu.Lock()
+	defer chatAsserted.mu.Unlock()
+	return chatAsserted.unpin(messageUUID), nil
+}
+
+// Unpinning message, kept message is dropped as it's already out of lru. Returns false if message wasn't pinned. mu must be held
+func (c *Chat) unpin(messageUUID string) bool {
+	if _, ok := c.Pins[messageUUID]; !ok {
+		return false
+	}
+	delete(c.Pins, messageUUID)
+	if kept, ok := c.Kept[messageUUID]; ok {
+		delete(c.Kept, messageUUID)
+		c.drop(kept)
+	}
+	return true
+}
+
+func (s *Storage) GetActiveChats(filter entities.ChatFilter, page entities.Page) (chats []entities.Chat, nextCursor string, err error) {
+	//Get keys for all chats in lru, direct chats are not listed
 	chatKeys := s.ChatsData.Keys()
 
 	//Range over keys
+	all := make([]entities.Chat, 0, len(chatKeys))
+	members := make(map[string]bool)
 	for _, key := range chatKeys {
 
-		//Get data from lru with key
-		chat, _ := s.ChatsData.Get(key)
+		//Get data from lru with key. Peek is used so listing chats doesn't change the order of eviction
+		chat, ok := s.ChatsData.Peek(key)
+		if !ok {
+			continue
+		}
 
 		//type assert
-		chatAsserted := *chat.(*Chat)
+		chatAsserted := chat.(*Chat)
+
+		//Create Chat instance and append it to slice of all chats
+		all = append(all, chatAsserted.toEntity())
+		chatAsserted.mu.RLock()
+		_, members[chatAsserted.ChatUUID] = chatAsserted.Members[filter.Viewer]
+		chatAsserted.mu.RUnlock()
+	}
+	//Filtering and cutting requested page
+	return repository.PageChats(all, filter, page, func(chatUUID string) bool { return members[chatUUID] })
+}
 
-		//Create Chat instance and append it to returned slice
-		chats = append(chats, entities.Chat{
-			SessionUUID: chatAsserted.SessionUUID,
-			ChatUUID:    chatAsserted.ChatUUID,
-			ReadOnly:    chatAsserted.ReadOnly,
-			TTL:         chatAsserted.TTL,
-		})
+func (c *Chat) toEntity() entities.Chat {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var pinned []entities.Pin
+	for _, v := range c.Pins {
+		pinned = append(pinned, v)
 	}
+	repository.SortPins(pinned)
+	return entities.Chat{
+		SessionUUID:  c.SessionUUID,
+		ChatUUID:     c.ChatUUID,
+		ReadOnly:     c.ReadOnly,
+		TTL:          c.TTL,
+		CreatedAt:    c.CreatedAt,
+		MessageCount: c.Messages.Len() + len(c.Kept),
+		Visibility:   c.Visibility,
+		Info:         c.Info,
+		Pinned:       pinned,
+	}
+}
+
+// All chats of lru and direct chats. Peek is used so listing chats doesn't change the order of eviction
+func (s *Storage) allChats() []*Chat {
+	chats := make([]*Chat, 0, s.ChatsData.Len())
+	for _, key := range s.ChatsData.Keys() {
+		if chat, ok := s.ChatsData.Peek(key); ok {
+			chats = append(chats, chat.(*Chat))
+		}
+	}
+	s.mu.RLock()
+	for _, v := range s.DirectChats {
+		chats = append(chats, v)
+	}
+	s.mu.RUnlock()
 	return chats
 }
+
+func (s *Storage) GetExpiringChats(before time.Time) ([]entities.ChatExpiration, error) {
+	//Direct chats can have ttl too
+	chats := s.allChats()
+
+	var expirations []entities.ChatExpiration
+	for _, v := range chats {
+		chatEntity := v.toEntity()
+		if expiresAt, ok := repository.ChatExpiresAt(chatEntity); ok && expiresAt.Before(before) {
+			expirations = append(expirations, entities.ChatExpiration{
+				ChatUUID:    chatEntity.ChatUUID,
+				SessionUUID: chatEntity.SessionUUID,
+				ExpiresAt:   expiresAt,
+			})
+		}
+	}
+	slices.SortFunc(expirations, func(a, b entities.ChatExpiration) int {
+		return a.ExpiresAt.Compare(b.ExpiresAt)
+	})
+	return expirations, nil
+}
+
+// Chat is checked and removed under its lock, so ttl can't be changed in between
+func (s *Storage) ExpireChat(chatUUID string) (bool, error) {
+	chatAsserted, ok := s.peekChat(chatUUID)
+	if !ok {
+		return false, nil
+	}
+	chatAsserted.mu.RLock()
+	defer chatAsserted.mu.RUnlock()
+	expiresAt, ok := repository.ChatExpiresAt(entities.Chat{TTL: chatAsserted.TTL, CreatedAt: chatAsserted.CreatedAt})
+	if !ok || time.Now().Before(expiresAt) {
+		return false, nil
+	}
+	if chatAsserted.Visibility == entities.VisibilityDirect {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		_, ok := s.DirectChats[chatUUID]
+		delete(s.DirectChats, chatUUID)
+		return ok, nil
+	}
+	return s.ChatsData.Remove(chatUUID), nil
+}
+
+func (s *Storage) GetExpiringMessages(before time.Time) ([]entities.MessageExpiration, error) {
+	var expirations []entities.MessageExpiration
+	for _, v := range s.allChats() {
+		v.mu.RLock()
+		messages := v.keptMessages()
+		for _, key := range v.Messages.Keys() {
+			if message, ok := v.Messages.Peek(key); ok {
+				messages = append(messages, message.(*Message))
+			}
+		}
+		for _, message := range messages {
+			if !message.Deleted && !message.ExpiresAt.IsZero() && message.ExpiresAt.Before(before) {
+				expirations = append(expirations, entities.MessageExpiration{
+					ChatUUID:    v.ChatUUID,
+					MessageUUID: message.MessageUUID,
+					SessionUUID: message.SessionUUID,
+					ExpiresAt:   message.ExpiresAt,
+				})
+			}
+		}
+		v.mu.RUnlock()
+	}
+	slices.SortFunc(expirations, func(a, b entities.MessageExpiration) int {
+		return a.ExpiresAt.Compare(b.ExpiresAt)
+	})
+	return expirations, nil
+}
+
+// Expired message is deleted like any other, storage doesn't keep expirations apart from messages
+func (s *Storage) ExpireMessage(expiration entities.MessageExpiration) (entities.Message, error) {
+	return s.DeleteMessage(expiration.ChatUUID, expiration.MessageUUID)
+}
+
+func (s *Storage) RememberIdempotencyKey(sessionUUID string, key string, request entities.IdempotentRequest, expiresAt time.Time) (entities.IdempotentRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.Users[User{SessionUUID: sessionUUID}]; !ok {
+		return entities.IdempotentRequest{}, repository.ErrUserDoesntExist
+	}
+
+	now := time.Now()
+	keys := s.IdempotencyKeys[sessionUUID]
+	if keys == nil {
+		keys = make(map[string]IdempotencyKey)
+		s.IdempotencyKeys[sessionUUID] = keys
+	}
+	for k, v := range keys {
+		if !v.ExpiresAt.After(now) {
+			delete(keys, k)
+		}
+	}
+	if remembered, ok := keys[key]; ok {
+		return remembered.Request, nil
+	}
+	keys[key] = IdempotencyKey{Request: request, ExpiresAt: expiresAt}
+	return request, nil
+}
+
+func (s *Storage) MarkIdempotencyKeySent(sessionUUID string, key string, messageUUID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if remembered, ok := s.IdempotencyKeys[sessionUUID][key]; ok && remembered.Request.MessageUUID == messageUUID {
+		remembered.Request.Sent = true
+		s.IdempotencyKeys[sessionUUID][key] = remembered
+	}
+	return nil
+}
+
+func (s *Storage) ForgetIdempotencyKey(sessionUUID string, key string, messageUUID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if remembered, ok := s.IdempotencyKeys[sessionUUID][key]; ok && remembered.Request.MessageUUID == messageUUID {
+		delete(s.IdempotencyKeys[sessionUUID], key)
+	}
+	return nil
+}
+
+func (s *Storage) AddScheduled(scheduled entities.ScheduledMessage) error {
+	chatAsserted, ok := s.peekChat(scheduled.ChatUUID)
+	if !ok {
+		return repository.ErrNotFound
+	}
+	s.mu.RLock()
+	_, ok = s.Users[User{SessionUUID: scheduled.SessionUUID}]
+	s.mu.RUnlock()
+	if !ok {
+		return repository.ErrUserDoesntExist
+	}
+
+	chatAsserted.mu.Lock()
+	defer chatAsserted.mu.Unlock()
+	chatAsserted.Scheduled[scheduled.ScheduledUUID] = scheduled
+	return nil
+}
+
+func (s *Storage) GetScheduled(chatUUID string, sessionUUID string) ([]entities.ScheduledMessage, error) {
+	chatAsserted, ok := s.peekChat(chatUUID)
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	chatAsserted.mu.RLock()
+	defer chatAsserted.mu.RUnlock()
+	var scheduled []entities.ScheduledMessage
+	for _, v := range chatAsserted.Scheduled {
+		if v.SessionUUID == sessionUUID {
+			scheduled = append(scheduled, v)
+		}
+	}
+	repository.SortScheduled(scheduled)
+	return scheduled, nil
+}
+
+func (s *Storage) GetDueScheduled(before time.Time) ([]entities.ScheduledMessage, error) {
+	var scheduled []entities.ScheduledMessage
+	for _, chat := range s.allChats() {
+		chat.mu.RLock()
+		for _, v := range chat.Scheduled {
+			if v.SendAt.Before(before) {
+				scheduled = append(scheduled, v)
+			}
+		}
+		chat.mu.RUnlock()
+	}
+	repository.SortScheduled(scheduled)
+	return scheduled, nil
+}
+
+func (s *Storage) DeleteScheduled(chatUUID string, scheduledUUID string) (bool, error) {
+	chatAsserted, ok := s.peekChat(chatUUID)
+	if !ok {
+		return false, repository.ErrNotFound
+	}
+
+	chatAsserted.mu.Lock()
+	defer chatAsserted.mu.Unlock()
+	_, ok = chatAsserted.Scheduled[scheduledUUID]
+	delete(chatAsserted.Scheduled, scheduledUUID)
+	delete(chatAsserted.Claims, scheduledUUID)
+	return ok, nil
+}
+
+func (s *Storage) ClaimScheduled(chatUUID string, scheduledUUID string, lease time.Duration) (bool, error) {
+	chatAsserted, ok := s.peekChat(chatUUID)
+	if !ok {
+		return false, repository.ErrNotFound
+	}
+
+	chatAsserted.mu.Lock()
+	defer chatAsserted.mu.Unlock()
+	now := time.Now()
+	if _, ok := chatAsserted.Scheduled[scheduledUUID]; !ok || chatAsserted.Claims[scheduledUUID].After(now) {
+		return false, nil
+	}
+	chatAsserted.Claims[scheduledUUID] = now.Add(lease)
+	return true, nil
+}
+
+func (s *Storage) ReleaseScheduled(chatUUID string, scheduledUUID string) error {
+	chatAsserted, ok := s.peekChat(chatUUID)
+	if !ok {
+		return repository.ErrNotFound
+	}
+
+	chatAsserted.mu.Lock()
+	defer chatAsserted.mu.Unlock()
+	delete(chatAsserted.Claims, scheduledUUID)
+	return nil
+}
+
+// Storage doesn't delete chats by itself, messenger deletes them by ttl
+func (s *Storage) OnChatExpired(_ func(expiration entities.ChatExpiration)) bool {
+	return false
+}
+
+func (s *Storage) OnChatEvicted(fn func(chatUUID string)) {
+	s.onChatEvicted = fn
+}
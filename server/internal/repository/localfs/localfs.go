@@ -0,0 +1,182 @@
+package localfs
+
+// Implementation of blob store that keeps content of attachments in local directory.
+// Content of chat is kept in its own directory under name that is SHA-256 of content: {root}/{chat_UUID}/{sha256}
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Rolan335/grpcMessenger/server/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+// Prefix of files that are being written. They are renamed to key of content when it's written completely
+const uploadPrefix = ".upload-"
+
+// mu orders renaming of written content and deleting of content, so content stored again while it's being deleted is kept
+type Storage struct {
+	root string
+	mu   sync.Mutex
+}
+
+// Creating store in directory provided, directory is created if it doesn't exist. Panics if couldn't
+func NewStorage(root string) *Storage {
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		panic("failed to create attachments directory: " + err.Error())
+	}
+	return &Storage{root: root}
+}
+
+// Writing content to temporary file while hashing it, then renaming file to hash. The same content is stored once in chat,
+// storing it again replaces the file, so its modification time is the time content was stored last. If r returns error nothing is stored
+func (s *Storage) Put(chatUUID string, r io.Reader) (string, int64, error) {
+	dir, err := s.chatDir(chatUUID)
+	if err != nil {
+		return "", 0, err
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", 0, fmt.Errorf("localfs: %w", err)
+	}
+	file, err := os.CreateTemp(dir, uploadPrefix+"*")
+	if err != nil {
+		return "", 0, fmt.Errorf("localfs: %w", err)
+	}
+	//After rename there is nothing to remove
+	defer os.Remove(file.Name())
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), r)
+	if err != nil {
+		file.Close()
+		return "", 0, fmt.Errorf("localfs: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return "", 0, fmt.Errorf("localfs: %w", err)
+	}
+	key := hex.EncodeToString(hash.Sum(nil))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Rename(file.Name(), filepath.Join(dir, key)); err != nil {
+		return "", 0, fmt.Errorf("localfs: %w", err)
+	}
+	return key, size, nil
+}
+
+// Opening content for reading. repository.ErrNotFound if there is no content with such key in chat
+func (s *Storage) Get(chatUUID string, key string) (io.ReadCloser, error) {
+	dir, err := s.chatDir(chatUUID)
+	if err != nil {
+		return nil, err
+	}
+	if !validKey(key) {
+		return nil, repository.ErrNotFound
+	}
+	file, err := os.Open(filepath.Join(dir, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("localfs: %w", err)
+	}
+	return file, nil
+}
+
+// Deleting content if it was stored before time provided. Content that doesn't exist or is stored later is not an error
+func (s *Storage) Delete(chatUUID string, key string, storedBefore time.Time) error {
+	dir, err := s.chatDir(chatUUID)
+	if err != nil {
+		return err
+	}
+	if !validKey(key) {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info, err := os.Stat(filepath.Join(dir, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("localfs: %w", err)
+	}
+	if !info.ModTime().Before(storedBefore) {
+		return nil
+	}
+	if err := os.Remove(filepath.Join(dir, key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("localfs: %w", err)
+	}
+	return nil
+}
+
+// Returns keys of content of chat, content that is being written is skipped
+func (s *Storage) Keys(chatUUID string) ([]string, error) {
+	dir, err := s.chatDir(chatUUID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("localfs: %w", err)
+	}
+	keys := make([]string, 0, len(entries))
+	for _, v := range entries {
+		if validKey(v.Name()) && v.Type().IsRegular() {
+			keys = append(keys, v.Name())
+		}
+	}
+	return keys, nil
+}
+
+// Deleting all content of chat, including content that is being written right now
+func (s *Storage) DeleteChat(chatUUID string) error {
+	dir, err := s.chatDir(chatUUID)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("localfs: %w", err)
+	}
+	return nil
+}
+
+// Returns chats that have content
+func (s *Storage) Chats() ([]string, error) {
+	entries, err := os.ReadDir(s.root)
+	if err != nil {
+		return nil, fmt.Errorf("localfs: %w", err)
+	}
+	chats := make([]string, 0, len(entries))
+	for _, v := range entries {
+		if _, err := uuid.Parse(v.Name()); err == nil && v.IsDir() {
+			chats = append(chats, v.Name())
+		}
+	}
+	return chats, nil
+}
+
+// Key is checked so it can't point outside of chat directory
+func validKey(key string) bool {
+	decoded, err := hex.DecodeString(key)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+// Directory of chat. Chat uuid is parsed so it can't point outside of root
+func (s *Storage) chatDir(chatUUID string) (string, error) {
+	id, err := uuid.Parse(chatUUID)
+	if err != nil {
+		return "", repository.ErrNotFound
+	}
+	return filepath.Join(s.root, id.String()), nil
+}
@@ -0,0 +1,24 @@
+package repository
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Rolan335/grpcMessenger/server/internal/repository/entities"
+)
+
+// Sorting members of chat by time of joining, so participants are listed in the same order by every storage
+func SortMembers(members []entities.Member) {
+	slices.SortFunc(members, func(a, b entities.Member) int {
+		if c := a.JoinedAt.Compare(b.JoinedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.SessionUUID, b.SessionUUID)
+	})
+}
+
+// Restriction with Until in the past is lifted, every storage treats it as absent
+func RestrictionExpired(restriction entities.Restriction, now time.Time) bool {
+	return !restriction.Until.IsZero() && !now.Before(restriction.Until)
+}
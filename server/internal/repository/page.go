@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"slices"
+	"strconv"
+
+	"github.com/Rolan335/grpcMessenger/server/internal/repository/entities"
+)
+
+// Computing range [from, to) of indexes of positions for page of list. Positions are sorted absolute positions of messages present in list,
+// they have gaps when older messages are trimmed but pinned ones are kept. Cursor of page is the absolute position of boundary message.
+// Returns cursor of the next page, empty if there are no more pages.
+func PageRange(positions []int64, page entities.Page) (from int, to int, next string, err error) {
+	var cursor int64 = -1
+	if page.Cursor != "" {
+		cursor, err = strconv.ParseInt(page.Cursor, 10, 64)
+		if err != nil || cursor < 0 {
+			return 0, 0, "", ErrInvalidCursor
+		}
+	}
+
+	from, to = 0, len(positions)
+	if page.Direction == entities.DirectionNewer {
+		if cursor >= 0 {
+			from, _ = slices.BinarySearch(positions, cursor+1)
+		}
+		if page.Limit > 0 && from+page.Limit < to {
+			to = from + page.Limit
+			next = strconv.FormatInt(positions[to-1], 10)
+		}
+		return from, to, next, nil
+	}
+
+	if cursor >= 0 {
+		to, _ = slices.BinarySearch(positions, cursor)
+	}
+	if page.Limit > 0 && to-page.Limit > 0 {
+		from = to - page.Limit
+		next = strconv.FormatInt(positions[from], 10)
+	}
+	return from, to, next, nil
+}
+
+// Absolute positions of count messages starting from first, following positions of kept messages
+func Positions(kept []int64, first int64, count int64) []int64 {
+	positions := make([]int64, 0, int64(len(kept))+count)
+	positions = append(positions, kept...)
+	for i := range count {
+		positions = append(positions, first+i)
+	}
+	return positions
+}
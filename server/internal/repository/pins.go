@@ -0,0 +1,18 @@
+package repository
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/Rolan335/grpcMessenger/server/internal/repository/entities"
+)
+
+// Sorting pinned messages from the most recently pinned, so every storage lists them in the same order
+func SortPins(pins []entities.Pin) {
+	slices.SortFunc(pins, func(a, b entities.Pin) int {
+		if c := b.PinnedAt.Compare(a.PinnedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.MessageUUID, b.MessageUUID)
+	})
+}
@@ -4,6 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Rolan335/grpcMessenger/server/internal/repository"
@@ -26,32 +29,61 @@ type Config struct {
 }
 
 type Storage struct {
-	MaxChats    int
-	MaxChatSize int
-	Timeout     int
-	Db          *pgxpool.Pool
+	MaxChats      int
+	MaxChatSize   int
+	Timeout       int
+	Db            *pgxpool.Pool
+	onChatEvicted func(chatUUID string)
 }
 
 type Message struct {
-	MessageUUID uuid.UUID `pg:"message_uuid"`
-	SessionUUID uuid.UUID `pg:"session_uuid"`
-	ChatUUID    string    `pg:"chat_uuid"`
-	Text        string    `pg:"text"`
-	CreatedAt   time.Time `pg:"created_at"`
+	MessageUUID uuid.UUID  `pg:"message_uuid"`
+	SessionUUID uuid.UUID  `pg:"session_uuid"`
+	ChatUUID    string     `pg:"chat_uuid"`
+	Text        string     `pg:"text"`
+	CreatedAt   time.Time  `pg:"created_at"`
+	EditedAt    *time.Time `pg:"edited_at"`
+	Deleted     bool       `pg:"deleted"`
+	Seq         int64      `pg:"seq"`
+	Attachments []string   `pg:"attachment_ids"`
+	ReplyTo     *uuid.UUID `pg:"reply_to"`
+	ReplyCount  int        `pg:"reply_count"`
+	ExpiresAt   *time.Time `pg:"expires_at"`
 }
 
 type Chat struct {
-	ChatUUID    uuid.UUID `pg:"chat_uuid"`
-	SessionUUID uuid.UUID `pg:"session_uuid"`
-	ReadOnly    bool      `pg:"read_only"`
-	TTL         int       `pg:"ttl"`
-	CreatedAt   time.Time `pg:"created_at"`
+	ChatUUID    uuid.UUID           `pg:"chat_uuid"`
+	SessionUUID uuid.UUID           `pg:"session_uuid"`
+	ReadOnly    bool                `pg:"read_only"`
+	TTL         int                 `pg:"ttl"`
+	CreatedAt   time.Time           `pg:"created_at"`
+	Visibility  entities.Visibility `pg:"visibility"`
+	Title       string              `pg:"title"`
+	Description string              `pg:"description"`
+	Tags        []string            `pg:"tags"`
+}
+
+type Member struct {
+	ChatUUID    uuid.UUID     `pg:"chat_uuid"`
+	SessionUUID uuid.UUID     `pg:"session_uuid"`
+	JoinedAt    time.Time     `pg:"joined_at"`
+	Role        entities.Role `pg:"role"`
 }
 
 type User struct {
 	SessionUUID uuid.UUID `pg:"session_uuid"`
 }
 
+type Attachment struct {
+	AttachmentUUID uuid.UUID `pg:"attachment_uuid"`
+	SessionUUID    uuid.UUID `pg:"session_uuid"`
+	Key            string    `pg:"blob_key"`
+	Name           string    `pg:"name"`
+	ContentType    string    `pg:"content_type"`
+	Size           int64     `pg:"size"`
+	CreatedAt      time.Time `pg:"created_at"`
+}
+
 var conn *pgxpool.Pool
 
 func NewStorage(cfg Config, maxChats int, maxChatSize int, timeout int, migrationsPath string) *Storage {
@@ -124,7 +156,7 @@ func (p *Storage) AddSession(sessionUUID string) {
 	p.Db.Exec(ctx, "INSERT INTO users (session_uuid) VALUES ($1)", sessionUUID)
 }
 
-func (p *Storage) AddChat(sessionUUID string, ttl int, readOnly bool, chatUUID string) error {
+func (p *Storage) AddChat(sessionUUID string, ttl int, readOnly bool, visibility entities.Visibility, chatUUID string, info entities.ChatInfo) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
 	defer cancel()
 	tx, err := p.Db.Begin(ctx)
@@ -147,20 +179,37 @@ func (p *Storage) AddChat(sessionUUID string, ttl int, readOnly bool, chatUUID s
 	}
 
 	//Add new record to chats table
-	if _, err := tx.Exec(ctx, "INSERT INTO chats (chat_uuid, session_uuid, read_only, ttl) VALUES ($1, $2, $3, $4)", chatUUID, sessionUUID, readOnly, ttl); err != nil {
+	//Tags are never NULL
+	tags := info.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	//Chat without ttl never expires
+	query := `
+	INSERT INTO chats (chat_uuid, session_uuid, read_only, ttl, visibility, title, description, tags, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, CASE WHEN $4 > 0 THEN now() + $4 * interval '1 second' END)
+	`
+	if _, err := tx.Exec(ctx, query, chatUUID, sessionUUID, readOnly, ttl, visibility, info.Title, info.Description, tags); err != nil {
+		tx.Rollback(ctx)
+		return fmt.Errorf("postgres: %w", err)
+	}
+
+	//Creator is the first member and owner of chat
+	if _, err := tx.Exec(ctx, "INSERT INTO chat_members (chat_uuid, session_uuid, role) VALUES ($1, $2, $3)", chatUUID, sessionUUID, entities.RoleOwner); err != nil {
 		tx.Rollback(ctx)
 		return fmt.Errorf("postgres: %w", err)
 	}
 
-	//Check if any chats should be deleted
+	//Check if any chats should be deleted. Direct chats are not counted
 	var chatsCount int
-	if err := tx.QueryRow(ctx, "SELECT COUNT(*) FROM chats").Scan(&chatsCount); err != nil {
+	if err := tx.QueryRow(ctx, "SELECT COUNT(*) FROM chats WHERE visibility <> $1", entities.VisibilityDirect).Scan(&chatsCount); err != nil {
 		tx.Rollback(ctx)
 		return fmt.Errorf("postgres: %w", err)
 	}
 
+	var evicted []string
 	if chatsCount > p.MaxChats {
-		err := p.DeleteLeastChats(ctx, tx, chatsCount)
+		evicted, err = p.DeleteLeastChats(ctx, tx, chatsCount)
 		if err != nil {
 			return fmt.Errorf("postgres: %w", err)
 		}
@@ -171,32 +220,56 @@ func (p *Storage) AddChat(sessionUUID string, ttl int, readOnly bool, chatUUID s
 		return fmt.Errorf("postgres: %w", err)
 	}
 
+	//Reporting evicted chats only after they are really deleted
+	if p.onChatEvicted != nil {
+		for _, v := range evicted {
+			p.onChatEvicted(v)
+		}
+	}
+
 	return nil
 }
 
-func (p *Storage) DeleteLeastChats(ctx context.Context, tx pgx.Tx, chatsCount int) error {
+// Deleting oldest chats that exceed MaxChats. Direct chats are never deleted. Returns uuids of deleted chats
+func (p *Storage) DeleteLeastChats(ctx context.Context, tx pgx.Tx, chatsCount int) ([]string, error) {
 	query := `
 	DELETE FROM chats 
 	USING (
         SELECT chat_uuid FROM chats
+        WHERE visibility <> $2
         ORDER BY created_at ASC
         LIMIT $1
 	) AS to_delete
-	WHERE chats.chat_uuid = to_delete.chat_uuid;
+	WHERE chats.chat_uuid = to_delete.chat_uuid
+	RETURNING chats.chat_uuid;
 	`
-	if _, err := tx.Exec(ctx, query, chatsCount-p.MaxChats); err != nil {
-		return fmt.Errorf("postgres: %w", err)
+	rows, err := tx.Query(ctx, query, chatsCount-p.MaxChats, entities.VisibilityDirect)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: %w", err)
 	}
-	return nil
+	defer rows.Close()
+
+	var evicted []string
+	for rows.Next() {
+		var chatUUID uuid.UUID
+		if err := rows.Scan(&chatUUID); err != nil {
+			return nil, fmt.Errorf("postgres: %w", err)
+		}
+		evicted = append(evicted, chatUUID.String())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	return evicted, nil
 }
 
-func (p *Storage) DeleteChat(sessionUUID string, chatUUID string) error {
+// Creating direct chat of two sessions if it doesn't exist yet. Both sessions are members of chat with owner role
+func (p *Storage) AddDirectChat(sessionUUID string, peerSessionUUID string, chatUUID string) (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
 	defer cancel()
-
 	tx, err := p.Db.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("postgres: %w", err)
+		return false, fmt.Errorf("postgres: %w", err)
 	}
 	defer func() {
 		if r := recover(); r != nil {
@@ -204,33 +277,147 @@ func (p *Storage) DeleteChat(sessionUUID string, chatUUID string) error {
 		}
 	}()
 
-	var recievedSessionUUID uuid.UUID
-	if err := tx.QueryRow(ctx, "SELECT session_uuid FROM chats WHERE chat_uuid = $1 LIMIT 1", chatUUID).Scan(&recievedSessionUUID); err != nil {
+	var usersCount int
+	if err := tx.QueryRow(ctx, "SELECT COUNT(*) FROM users WHERE session_uuid IN ($1, $2)", sessionUUID, peerSessionUUID).Scan(&usersCount); err != nil {
+		tx.Rollback(ctx)
+		return false, fmt.Errorf("postgres: %w", err)
+	}
+	if usersCount != 2 {
+		tx.Rollback(ctx)
+		return false, repository.ErrUserDoesntExist
+	}
+
+	//Chat uuid is the same for the same sessions, so chat that already exists is not changed
+	tag, err := tx.Exec(ctx, "INSERT INTO chats (chat_uuid, session_uuid, read_only, ttl, visibility) VALUES ($1, $2, false, 0, $3) ON CONFLICT DO NOTHING",
+		chatUUID, sessionUUID, entities.VisibilityDirect)
+	if err != nil {
 		tx.Rollback(ctx)
-		return repository.ErrUserDoesntExist
+		return false, fmt.Errorf("postgres: %w", err)
 	}
-	if parsedSessionUUID, _ := uuid.Parse(sessionUUID); parsedSessionUUID != recievedSessionUUID {
+	if tag.RowsAffected() == 0 {
 		tx.Rollback(ctx)
-		return repository.ErrProhibited
+		return false, nil
 	}
-	if _, err := tx.Exec(ctx, "DELETE FROM chats WHERE chat_uuid = $1", chatUUID); err != nil {
+	if _, err := tx.Exec(ctx, "INSERT INTO chat_members (chat_uuid, session_uuid, role) VALUES ($1, $2, $4), ($1, $3, $4)",
+		chatUUID, sessionUUID, peerSessionUUID, entities.RoleOwner); err != nil {
 		tx.Rollback(ctx)
-		return fmt.Errorf("postgres: %w", err)
+		return false, fmt.Errorf("postgres: %w", err)
 	}
 
 	if err := tx.Commit(ctx); err != nil {
 		tx.Rollback(ctx)
-		return fmt.Errorf("postgres: %w", err)
+		return false, fmt.Errorf("postgres: %w", err)
+	}
+	return true, nil
+}
+
+func (p *Storage) GetChat(chatUUID string) (entities.Chat, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+
+	var chat Chat
+	if err := p.Db.QueryRow(ctx, "SELECT chat_uuid, session_uuid, read_only, ttl, created_at, visibility, title, description, tags FROM chats WHERE chat_uuid = $1", chatUUID).
+		Scan(&chat.ChatUUID, &chat.SessionUUID, &chat.ReadOnly, &chat.TTL, &chat.CreatedAt, &chat.Visibility, &chat.Title, &chat.Description, &chat.Tags); err != nil {
+		if err == pgx.ErrNoRows {
+			return entities.Chat{}, repository.ErrNotFound
+		}
+		return entities.Chat{}, fmt.Errorf("postgres: %w", err)
+	}
+	pins, err := p.getPins(ctx, []string{chatUUID})
+	if err != nil {
+		return entities.Chat{}, err
+	}
+	entity := chat.toEntity()
+	entity.Pinned = pins[chatUUID]
+	return entity, nil
+}
+
+// Pinned messages of chats with one query, sorted by repository.SortPins
+func (p *Storage) getPins(ctx context.Context, chatUUIDs []string) (map[string][]entities.Pin, error) {
+	rows, err := p.Db.Query(ctx, "SELECT chat_uuid, message_uuid, session_uuid, pinned_at FROM pins WHERE chat_uuid = ANY($1::uuid[])", chatUUIDs)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	defer rows.Close()
+
+	pins := make(map[string][]entities.Pin)
+	for rows.Next() {
+		var chatUUID, messageUUID, sessionUUID uuid.UUID
+		var pin entities.Pin
+		if err := rows.Scan(&chatUUID, &messageUUID, &sessionUUID, &pin.PinnedAt); err != nil {
+			return nil, fmt.Errorf("postgres: %w", err)
+		}
+		pin.MessageUUID, pin.SessionUUID = messageUUID.String(), sessionUUID.String()
+		pins[chatUUID.String()] = append(pins[chatUUID.String()], pin)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	for _, v := range pins {
+		repository.SortPins(v)
+	}
+	return pins, nil
+}
+
+// Changing chat. NULL parameter keeps value of column
+func (p *Storage) UpdateChat(chatUUID string, update entities.ChatUpdate) (entities.Chat, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+
+	query := `
+	UPDATE chats SET
+		title = COALESCE($2, title),
+		description = COALESCE($3, description),
+		tags = COALESCE($4, tags),
+		ttl = COALESCE($5, ttl),
+		expires_at = CASE
+			WHEN $5::int IS NULL THEN expires_at
+			WHEN $5 > 0 THEN created_at + $5 * interval '1 second'
+		END,
+		read_only = COALESCE($6, read_only)
+	WHERE chat_uuid = $1
+	RETURNING chat_uuid, session_uuid, read_only, ttl, created_at, visibility, title, description, tags
+	`
+	var tags []string
+	if update.Tags != nil {
+		tags = append([]string{}, *update.Tags...)
+	}
+	var chat Chat
+	if err := p.Db.QueryRow(ctx, query, chatUUID, update.Title, update.Description, tags, update.TTL, update.ReadOnly).
+		Scan(&chat.ChatUUID, &chat.SessionUUID, &chat.ReadOnly, &chat.TTL, &chat.CreatedAt, &chat.Visibility, &chat.Title, &chat.Description, &chat.Tags); err != nil {
+		if err == pgx.ErrNoRows {
+			return entities.Chat{}, repository.ErrNotFound
+		}
+		return entities.Chat{}, fmt.Errorf("postgres: %w", err)
+	}
+	pins, err := p.getPins(ctx, []string{chatUUID})
+	if err != nil {
+		return entities.Chat{}, err
 	}
+	entity := chat.toEntity()
+	entity.Pinned = pins[chatUUID]
+	return entity, nil
+}
+
+func (p *Storage) DeleteChat(chatUUID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
 
+	tag, err := p.Db.Exec(ctx, "DELETE FROM chats WHERE chat_uuid = $1", chatUUID)
+	if err != nil {
+		return fmt.Errorf("postgres: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return repository.ErrNotFound
+	}
 	return nil
 }
-func (p *Storage) AddMessage(sessionUUID string, chatUUID string, messageUUID string, message string) error {
+func (p *Storage) AddMessage(chatUUID string, message entities.Message) (entities.Message, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
 	defer cancel()
 	tx, err := p.Db.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("postgres: %w", err)
+		return entities.Message{}, fmt.Errorf("postgres: %w", err)
 	}
 	defer func() {
 		if r := recover(); r != nil {
@@ -240,130 +427,1540 @@ func (p *Storage) AddMessage(sessionUUID string, chatUUID string, messageUUID st
 
 	//Check if user exists
 	var userCount int
-	if err := tx.QueryRow(ctx, "SELECT COUNT(*) FROM users WHERE session_uuid = $1", sessionUUID).Scan(&userCount); err != nil {
+	if err := tx.QueryRow(ctx, "SELECT COUNT(*) FROM users WHERE session_uuid = $1", message.SessionUUID).Scan(&userCount); err != nil {
 		tx.Rollback(ctx)
 		if err == pgx.ErrNoRows {
-			return repository.ErrUserDoesntExist
+			return entities.Message{}, repository.ErrUserDoesntExist
 		}
-		return fmt.Errorf("postgres: %w", err)
+		return entities.Message{}, fmt.Errorf("postgres: %w", err)
 	}
 
-	var chat Chat
-	//проверить существует ли чат
-	if err := tx.QueryRow(ctx, "SELECT chat_uuid, session_uuid, read_only, ttl FROM chats WHERE chat_uuid = $1 LIMIT 1", chatUUID).
-		Scan(&chat.ChatUUID, &chat.SessionUUID, &chat.ReadOnly, &chat.TTL); err != nil {
+	//Получить номер сообщения в чате, заодно проверить существует ли чат.
+	//Строка чата блокируется до конца транзакции, поэтому номера идут строго по порядку
+	newMessage := Message{Text: message.Text, Attachments: message.Attachments}
+	if err := tx.QueryRow(ctx, "UPDATE chats SET last_seq = last_seq + 1 WHERE chat_uuid = $1 RETURNING last_seq", chatUUID).Scan(&newMessage.Seq); err != nil {
 		tx.Rollback(ctx)
 		if err == pgx.ErrNoRows {
-			return repository.ErrNotFound
+			return entities.Message{}, repository.ErrNotFound
 		}
-		return fmt.Errorf("postgres: %w", err)
+		return entities.Message{}, fmt.Errorf("postgres: %w", err)
 	}
 
-	//Проверить ридонли ли чат
-	if chat.ReadOnly && sessionUUID != chat.SessionUUID.String() {
-		tx.Rollback(ctx)
-		return repository.ErrProhibited
+	//Корень треда должен быть в этом же чате. Сообщения чата не вытесняются, пока строка чата заблокирована
+	if message.ReplyTo != "" {
+		if err := tx.QueryRow(ctx, "SELECT message_uuid FROM messages WHERE message_uuid = $1 AND chat_uuid = $2", message.ReplyTo, chatUUID).Scan(nil); err != nil {
+			tx.Rollback(ctx)
+			if err == pgx.ErrNoRows {
+				return entities.Message{}, repository.ErrMessageNotFound
+			}
+			return entities.Message{}, fmt.Errorf("postgres: %w", err)
+		}
 	}
 
 	//Добавить запись в чат
-	if _, err := tx.Exec(ctx, "INSERT INTO messages (message_uuid, session_uuid, chat_uuid, text) VALUES ($1, $2, $3, $4)",
-		messageUUID, sessionUUID, chatUUID, message,
-	); err != nil {
-		tx.Rollback(ctx)
-		return fmt.Errorf("postgres: %w", err)
+	if !message.ExpiresAt.IsZero() {
+		newMessage.ExpiresAt = &message.ExpiresAt
 	}
-
-	//Добавить проверку логики lru
-	var messageCount int
-	if err := tx.QueryRow(ctx, "SELECT COUNT(*) FROM messages WHERE chat_uuid = $1", chatUUID).Scan(&messageCount); err != nil {
+	if err := tx.QueryRow(ctx, "INSERT INTO messages (message_uuid, session_uuid, chat_uuid, text, seq, attachment_ids, reply_to, expires_at) VALUES ($1, $2, $3, $4, $5, COALESCE($6::uuid[], '{}'), NULLIF($7, '')::uuid, $8) RETURNING message_uuid, session_uuid, created_at",
+		message.MessageUUID, message.SessionUUID, chatUUID, message.Text, newMessage.Seq, message.Attachments, message.ReplyTo, newMessage.ExpiresAt,
+	).Scan(&newMessage.MessageUUID, &newMessage.SessionUUID, &newMessage.CreatedAt); err != nil {
 		tx.Rollback(ctx)
-		return fmt.Errorf("postgres: %w", err)
+		return entities.Message{}, fmt.Errorf("postgres: %w", err)
 	}
 
-	if messageCount > p.MaxChatSize {
-		err := p.DeleteLeastMsg(ctx, tx, messageCount, chatUUID)
+	//Добавить проверку логики lru
+	if newMessage.Seq > int64(p.MaxChatSize) {
+		err := p.DeleteLeastMsg(ctx, tx, chatUUID)
 		if err != nil {
-			return fmt.Errorf("postgres: %w", err)
+			tx.Rollback(ctx)
+			return entities.Message{}, fmt.Errorf("postgres: %w", err)
 		}
 	}
 
+	//Корень треда мог быть вытеснен этим же сообщением, тогда reply_to обнулен внешним ключом
+	if err := tx.QueryRow(ctx, "SELECT reply_to FROM messages WHERE message_uuid = $1", message.MessageUUID).Scan(&newMessage.ReplyTo); err != nil {
+		tx.Rollback(ctx)
+		return entities.Message{}, fmt.Errorf("postgres: %w", err)
+	}
+
 	//Коммит
 	if err := tx.Commit(ctx); err != nil {
 		tx.Rollback(ctx)
-		return fmt.Errorf("postgres: %w", err)
+		return entities.Message{}, fmt.Errorf("postgres: %w", err)
 	}
-	return nil
+	return newMessage.toEntity(), nil
 }
 
-func (p *Storage) DeleteLeastMsg(ctx context.Context, tx pgx.Tx, messageCount int, chatUUID string) error {
+// Deleting messages older than last MaxChatSize messages of chat. Pinned messages are kept until they are unpinned
+func (p *Storage) DeleteLeastMsg(ctx context.Context, tx pgx.Tx, chatUUID string) error {
 	query := `
 	DELETE FROM messages
-	USING (
-		SELECT message_uuid FROM messages
-		WHERE chat_uuid = $1
-		ORDER BY created_at ASC
-		LIMIT $2
-	) AS to_delete
-	WHERE messages.message_uuid = to_delete.message_uuid;
+	WHERE chat_uuid = $1
+		AND seq <= (SELECT last_seq FROM chats WHERE chat_uuid = $1) - $2
+		AND NOT EXISTS (SELECT 1 FROM pins WHERE pins.message_uuid = messages.message_uuid)
 	`
-	if _, err := tx.Exec(ctx, query, chatUUID, messageCount-p.MaxChatSize); err != nil {
-		fmt.Println(messageCount - p.MaxChatSize)
+	if _, err := tx.Exec(ctx, query, chatUUID, p.MaxChatSize); err != nil {
 		return fmt.Errorf("postgres: %w", err)
 	}
 	return nil
 }
 
-func (p *Storage) GetHistory(chatUUID string) (history []entities.Message, err error) {
+// Cursor of history page is seq of boundary message, keyset pagination is done on it
+func (p *Storage) GetHistory(chatUUID string, page entities.Page) (history []entities.Message, nextCursor string, err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
 	defer cancel()
 	//Check if chat exists
+	if err := p.Db.QueryRow(ctx, "SELECT chat_uuid FROM chats WHERE chat_uuid = $1 LIMIT 1", chatUUID).Scan(nil); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, "", repository.ErrNotFound
+		}
+		return nil, "", fmt.Errorf("postgres: %w", err)
+	}
+
+	var cursor *int64
+	if page.Cursor != "" {
+		seq, err := strconv.ParseInt(page.Cursor, 10, 64)
+		if err != nil {
+			return nil, "", repository.ErrInvalidCursor
+		}
+		cursor = &seq
+	}
+
+	//Requesting one more message than needed to know if there is next page
+	var limit *int
+	if page.Limit > 0 {
+		limit = new(int)
+		*limit = page.Limit + 1
+	}
+
+	//Replies are counted by index of reply_to, replies themselves have none
+	query := `
+	SELECT session_uuid, message_uuid, text, created_at, edited_at, deleted, seq, attachment_ids, reply_to, expires_at,
+		(SELECT COUNT(*) FROM messages AS replies WHERE replies.reply_to = messages.message_uuid) AS reply_count
+	FROM messages
+	WHERE chat_uuid = $1 AND ($2::bigint IS NULL OR seq < $2)
+	ORDER BY seq DESC
+	LIMIT $3
+	`
+	if page.Direction == entities.DirectionNewer {
+		query = `
+		SELECT session_uuid, message_uuid, text, created_at, edited_at, deleted, seq, attachment_ids, reply_to, expires_at,
+			(SELECT COUNT(*) FROM messages AS replies WHERE replies.reply_to = messages.message_uuid) AS reply_count
+		FROM messages
+		WHERE chat_uuid = $1 AND ($2::bigint IS NULL OR seq > $2)
+		ORDER BY seq ASC
+		LIMIT $3
+		`
+	}
+	rows, err := p.Db.Query(ctx, query, chatUUID, cursor, limit)
+	if err != nil {
+		return nil, "", fmt.Errorf("postgres: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		var message Message
+		if err := rows.Scan(&message.SessionUUID, &message.MessageUUID, &message.Text, &message.CreatedAt, &message.EditedAt, &message.Deleted, &message.Seq, &message.Attachments, &message.ReplyTo, &message.ExpiresAt, &message.ReplyCount); err != nil {
+			return nil, "", fmt.Errorf("postgres: %w", err)
+		}
+		messages = append(messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("postgres: %w", err)
+	}
+
+	if page.Limit > 0 && len(messages) > page.Limit {
+		messages = messages[:page.Limit]
+		nextCursor = strconv.FormatInt(messages[len(messages)-1].Seq, 10)
+	}
+	//Older messages are selected from newest, but page is always returned in seq order
+	if page.Direction == entities.DirectionOlder {
+		slices.Reverse(messages)
+	}
+
+	history = make([]entities.Message, 0, len(messages))
+	for _, message := range messages {
+		history = append(history, message.toEntity())
+	}
+	return history, nextCursor, nil
+}
+
+// Getting message of chat. Deleted message is not found
+func (p *Storage) GetMessage(chatUUID string, messageUUID string) (entities.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+	if err := p.Db.QueryRow(ctx, "SELECT chat_uuid FROM chats WHERE chat_uuid = $1 LIMIT 1", chatUUID).Scan(nil); err != nil {
+		if err == pgx.ErrNoRows {
+			return entities.Message{}, repository.ErrNotFound
+		}
+		return entities.Message{}, fmt.Errorf("postgres: %w", err)
+	}
+
+	var message Message
+	if err := p.Db.QueryRow(ctx, "SELECT session_uuid, message_uuid, text, created_at, edited_at, deleted, seq, attachment_ids, reply_to, expires_at FROM messages WHERE message_uuid = $1 AND chat_uuid = $2 AND NOT deleted", messageUUID, chatUUID).
+		Scan(&message.SessionUUID, &message.MessageUUID, &message.Text, &message.CreatedAt, &message.EditedAt, &message.Deleted, &message.Seq, &message.Attachments, &message.ReplyTo, &message.ExpiresAt); err != nil {
+		if err == pgx.ErrNoRows {
+			return entities.Message{}, repository.ErrMessageNotFound
+		}
+		return entities.Message{}, fmt.Errorf("postgres: %w", err)
+	}
+	return message.toEntity(), nil
+}
+
+// Root of thread is reply_to of message or message itself, replies are found by index of reply_to
+func (p *Storage) GetThread(chatUUID string, messageUUID string) ([]entities.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
 	if err := p.Db.QueryRow(ctx, "SELECT chat_uuid FROM chats WHERE chat_uuid = $1 LIMIT 1", chatUUID).Scan(nil); err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, repository.ErrNotFound
 		}
 		return nil, fmt.Errorf("postgres: %w", err)
 	}
-	rows, err := p.Db.Query(ctx, "SELECT session_uuid, message_uuid, text FROM messages WHERE chat_uuid = $1", chatUUID)
+
+	query := `
+	WITH root AS (
+		SELECT COALESCE(reply_to, message_uuid) AS message_uuid FROM messages WHERE message_uuid = $2 AND chat_uuid = $1
+	)
+	SELECT session_uuid, messages.message_uuid, text, created_at, edited_at, deleted, seq, attachment_ids, reply_to, expires_at FROM messages, root
+	WHERE messages.message_uuid = root.message_uuid OR messages.reply_to = root.message_uuid
+	ORDER BY seq ASC
+	`
+	rows, err := p.Db.Query(ctx, query, chatUUID, messageUUID)
 	if err != nil {
 		return nil, fmt.Errorf("postgres: %w", err)
 	}
 	defer rows.Close()
 
+	var thread []entities.Message
 	for rows.Next() {
 		var message Message
-		if err := rows.Scan(&message.SessionUUID, &message.MessageUUID, &message.Text); err != nil {
+		if err := rows.Scan(&message.SessionUUID, &message.MessageUUID, &message.Text, &message.CreatedAt, &message.EditedAt, &message.Deleted, &message.Seq, &message.Attachments, &message.ReplyTo, &message.ExpiresAt); err != nil {
 			return nil, fmt.Errorf("postgres: %w", err)
 		}
-		history = append(history, entities.Message{
-			SessionUUID: message.SessionUUID.String(),
-			MessageUUID: message.MessageUUID.String(),
-			Text:        message.Text,
-		})
+		thread = append(thread, message.toEntity())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	//Root is older than its replies, so it goes first
+	if len(thread) == 0 || thread[0].ReplyTo != "" {
+		return nil, repository.ErrMessageNotFound
 	}
-	return
+	thread[0].ReplyCount = len(thread) - 1
+	return thread, nil
 }
-func (p *Storage) GetActiveChats() (chats []entities.Chat) {
+
+func (p *Storage) EditMessage(chatUUID string, messageUUID string, text string) (entities.Message, error) {
+	return p.updateMessage(chatUUID, messageUUID,
+		"UPDATE messages SET text = $2, edited_at = CURRENT_TIMESTAMP WHERE message_uuid = $1", text,
+	)
+}
+
+func (p *Storage) DeleteMessage(chatUUID string, messageUUID string) (entities.Message, error) {
+	//Leaving tombstone instead of message, reactions and pin are deleted with message
+	return p.updateMessage(chatUUID, messageUUID,
+		"WITH cleared AS (DELETE FROM reactions WHERE message_uuid = $1), unpinned AS (DELETE FROM pins WHERE message_uuid = $1) UPDATE messages SET text = '', attachment_ids = '{}', deleted = TRUE WHERE message_uuid = $1",
+	)
+}
+
+// Changing message with query provided ($1 is message_uuid)
+func (p *Storage) updateMessage(chatUUID string, messageUUID string, query string, args ...any) (entities.Message, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
 	defer cancel()
-	rows, err := p.Db.Query(ctx, "SELECT session_uuid, read_only, ttl, chat_uuid FROM chats")
+	tx, err := p.Db.Begin(ctx)
 	if err != nil {
-		return nil
+		return entities.Message{}, fmt.Errorf("postgres: %w", err)
 	}
-	defer rows.Close()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback(ctx)
+		}
+	}()
 
-	for rows.Next() {
-		var chat Chat
-		if err := rows.Scan(&chat.SessionUUID, &chat.ReadOnly, &chat.TTL, &chat.ChatUUID); err != nil {
-			return nil
-		}
-		chats = append(chats, entities.Chat{
-			SessionUUID: chat.SessionUUID.String(),
-			ReadOnly:    chat.ReadOnly,
-			TTL:         chat.TTL,
-			ChatUUID:    chat.ChatUUID.String(),
-		})
+	if err := tx.QueryRow(ctx, "SELECT chat_uuid FROM chats WHERE chat_uuid = $1 LIMIT 1", chatUUID).Scan(nil); err != nil {
+		tx.Rollback(ctx)
+		if err == pgx.ErrNoRows {
+			return entities.Message{}, repository.ErrNotFound
+		}
+		return entities.Message{}, fmt.Errorf("postgres: %w", err)
+	}
+
+	//Locking message until it's changed
+	var message Message
+	if err := tx.QueryRow(ctx, "SELECT session_uuid, deleted FROM messages WHERE message_uuid = $1 AND chat_uuid = $2 FOR UPDATE", messageUUID, chatUUID).
+		Scan(&message.SessionUUID, &message.Deleted); err != nil {
+		tx.Rollback(ctx)
+		if err == pgx.ErrNoRows {
+			return entities.Message{}, repository.ErrMessageNotFound
+		}
+		return entities.Message{}, fmt.Errorf("postgres: %w", err)
+	}
+	if message.Deleted {
+		tx.Rollback(ctx)
+		return entities.Message{}, repository.ErrMessageNotFound
+	}
+
+	if _, err := tx.Exec(ctx, query, append([]any{messageUUID}, args...)...); err != nil {
+		tx.Rollback(ctx)
+		return entities.Message{}, fmt.Errorf("postgres: %w", err)
+	}
+	if err := tx.QueryRow(ctx, "SELECT session_uuid, message_uuid, text, created_at, edited_at, deleted, seq, attachment_ids, reply_to, expires_at FROM messages WHERE message_uuid = $1", messageUUID).
+		Scan(&message.SessionUUID, &message.MessageUUID, &message.Text, &message.CreatedAt, &message.EditedAt, &message.Deleted, &message.Seq, &message.Attachments, &message.ReplyTo, &message.ExpiresAt); err != nil {
+		tx.Rollback(ctx)
+		return entities.Message{}, fmt.Errorf("postgres: %w", err)
+	}
+	//Deleted message that was kept only because it was pinned is trimmed
+	if message.Deleted {
+		if err := p.DeleteLeastMsg(ctx, tx, chatUUID); err != nil {
+			tx.Rollback(ctx)
+			return entities.Message{}, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		tx.Rollback(ctx)
+		return entities.Message{}, fmt.Errorf("postgres: %w", err)
+	}
+	return message.toEntity(), nil
+}
+
+// Reaction is added only to message that is not deleted. Message is locked for share, so it can't be deleted while reaction is added
+func (p *Storage) AddReaction(chatUUID string, messageUUID string, sessionUUID string, emoji string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+	if err := p.Db.QueryRow(ctx, "SELECT chat_uuid FROM chats WHERE chat_uuid = $1 LIMIT 1", chatUUID).Scan(nil); err != nil {
+		if err == pgx.ErrNoRows {
+			return false, repository.ErrNotFound
+		}
+		return false, fmt.Errorf("postgres: %w", err)
+	}
+	if err := p.Db.QueryRow(ctx, "SELECT session_uuid FROM users WHERE session_uuid = $1 LIMIT 1", sessionUUID).Scan(nil); err != nil {
+		if err == pgx.ErrNoRows {
+			return false, repository.ErrUserDoesntExist
+		}
+		return false, fmt.Errorf("postgres: %w", err)
+	}
+
+	query := `
+	INSERT INTO reactions (chat_uuid, message_uuid, session_uuid, emoji)
+	SELECT chat_uuid, message_uuid, $3, $4 FROM messages WHERE message_uuid = $2 AND chat_uuid = $1 AND NOT deleted FOR SHARE
+	ON CONFLICT DO NOTHING
+	`
+	tag, err := p.Db.Exec(ctx, query, chatUUID, messageUUID, sessionUUID, emoji)
+	if err != nil {
+		return false, fmt.Errorf("postgres: %w", err)
+	}
+	if tag.RowsAffected() == 1 {
+		return true, nil
+	}
+	//Nothing inserted: reaction already exists or there is no such message
+	if err := p.Db.QueryRow(ctx, "SELECT message_uuid FROM messages WHERE message_uuid = $1 AND chat_uuid = $2 AND NOT deleted", messageUUID, chatUUID).Scan(nil); err != nil {
+		if err == pgx.ErrNoRows {
+			return false, repository.ErrMessageNotFound
+		}
+		return false, fmt.Errorf("postgres: %w", err)
+	}
+	return false, nil
+}
+
+// Chat row is locked while message is pinned, so message can't be trimmed and limit of pins can't be exceeded concurrently
+func (p *Storage) PinMessage(chatUUID string, pin entities.Pin, maxPinned int) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+	tx, err := p.Db.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("postgres: %w", err)
 	}
-	return
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback(ctx)
+		}
+	}()
+
+	if err := tx.QueryRow(ctx, "SELECT chat_uuid FROM chats WHERE chat_uuid = $1 FOR UPDATE", chatUUID).Scan(nil); err != nil {
+		tx.Rollback(ctx)
+		if err == pgx.ErrNoRows {
+			return false, repository.ErrNotFound
+		}
+		return false, fmt.Errorf("postgres: %w", err)
+	}
+	if err := tx.QueryRow(ctx, "SELECT session_uuid FROM users WHERE session_uuid = $1 LIMIT 1", pin.SessionUUID).Scan(nil); err != nil {
+		tx.Rollback(ctx)
+		if err == pgx.ErrNoRows {
+			return false, repository.ErrUserDoesntExist
+		}
+		return false, fmt.Errorf("postgres: %w", err)
+	}
+	if err := tx.QueryRow(ctx, "SELECT message_uuid FROM messages WHERE message_uuid = $1 AND chat_uuid = $2 AND NOT deleted", pin.MessageUUID, chatUUID).Scan(nil); err != nil {
+		tx.Rollback(ctx)
+		if err == pgx.ErrNoRows {
+			return false, repository.ErrMessageNotFound
+		}
+		return false, fmt.Errorf("postgres: %w", err)
+	}
+
+	var pinned bool
+	var pinnedCount int
+	if err := tx.QueryRow(ctx, "SELECT COALESCE(BOOL_OR(message_uuid = $2), FALSE), COUNT(*) FROM pins WHERE chat_uuid = $1", chatUUID, pin.MessageUUID).
+		Scan(&pinned, &pinnedCount); err != nil {
+		tx.Rollback(ctx)
+		return false, fmt.Errorf("postgres: %w", err)
+	}
+	if pinned {
+		tx.Rollback(ctx)
+		return false, nil
+	}
+	if pinnedCount >= maxPinned {
+		tx.Rollback(ctx)
+		return false, repository.ErrTooManyPinned
+	}
+	if _, err := tx.Exec(ctx, "INSERT INTO pins (message_uuid, chat_uuid, session_uuid, pinned_at) VALUES ($1, $2, $3, $4)",
+		pin.MessageUUID, chatUUID, pin.SessionUUID, pin.PinnedAt); err != nil {
+		tx.Rollback(ctx)
+		return false, fmt.Errorf("postgres: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		tx.Rollback(ctx)
+		return false, fmt.Errorf("postgres: %w", err)
+	}
+	return true, nil
+}
+
+// Unpinned message that is older than last MaxChatSize messages is trimmed
+func (p *Storage) UnpinMessage(chatUUID string, messageUUID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+	tx, err := p.Db.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("postgres: %w", err)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback(ctx)
+		}
+	}()
+
+	if err := tx.QueryRow(ctx, "SELECT chat_uuid FROM chats WHERE chat_uuid = $1 FOR UPDATE", chatUUID).Scan(nil); err != nil {
+		tx.Rollback(ctx)
+		if err == pgx.ErrNoRows {
+			return false, repository.ErrNotFound
+		}
+		return false, fmt.Errorf("postgres: %w", err)
+	}
+	tag, err := tx.Exec(ctx, "DELETE FROM pins WHERE chat_uuid = $1 AND message_uuid = $2", chatUUID, messageUUID)
+	if err != nil {
+		tx.Rollback(ctx)
+		return false, fmt.Errorf("postgres: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		tx.Rollback(ctx)
+		return false, nil
+	}
+	if err := p.DeleteLeastMsg(ctx, tx, chatUUID); err != nil {
+		tx.Rollback(ctx)
+		return false, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		tx.Rollback(ctx)
+		return false, fmt.Errorf("postgres: %w", err)
+	}
+	return true, nil
+}
+
+func (p *Storage) RemoveReaction(chatUUID string, messageUUID string, sessionUUID string, emoji string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+	if err := p.Db.QueryRow(ctx, "SELECT chat_uuid FROM chats WHERE chat_uuid = $1 LIMIT 1", chatUUID).Scan(nil); err != nil {
+		if err == pgx.ErrNoRows {
+			return false, repository.ErrNotFound
+		}
+		return false, fmt.Errorf("postgres: %w", err)
+	}
+
+	tag, err := p.Db.Exec(ctx, "DELETE FROM reactions WHERE chat_uuid = $1 AND message_uuid = $2 AND session_uuid = $3 AND emoji = $4", chatUUID, messageUUID, sessionUUID, emoji)
+	if err != nil {
+		return false, fmt.Errorf("postgres: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// Reactions are counted by emoji for all messages with one query. Empty session has reacted to nothing
+func (p *Storage) GetReactions(chatUUID string, messageUUIDs []string, sessionUUID string) (map[string][]entities.Reaction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+	if err := p.Db.QueryRow(ctx, "SELECT chat_uuid FROM chats WHERE chat_uuid = $1 LIMIT 1", chatUUID).Scan(nil); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+
+	query := `
+	SELECT message_uuid, emoji, COUNT(*), COALESCE(BOOL_OR(session_uuid = NULLIF($3, '')::uuid), FALSE) FROM reactions
+	WHERE chat_uuid = $1 AND message_uuid = ANY($2::uuid[])
+	GROUP BY message_uuid, emoji
+	`
+	rows, err := p.Db.Query(ctx, query, chatUUID, messageUUIDs, sessionUUID)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	defer rows.Close()
+
+	reactions := make(map[string][]entities.Reaction)
+	for rows.Next() {
+		var messageUUID uuid.UUID
+		var reaction entities.Reaction
+		if err := rows.Scan(&messageUUID, &reaction.Emoji, &reaction.Count, &reaction.Reacted); err != nil {
+			return nil, fmt.Errorf("postgres: %w", err)
+		}
+		reactions[messageUUID.String()] = append(reactions[messageUUID.String()], reaction)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	for _, v := range reactions {
+		repository.SortReactions(v)
+	}
+	return reactions, nil
+}
+
+// Attachment is added only if chat exists. Chat row is locked, so concurrent uploads can't exceed quota
+func (p *Storage) AddAttachment(chatUUID string, attachment entities.Attachment, quota int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+	tx, err := p.Db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("postgres: %w", err)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback(ctx)
+		}
+	}()
+
+	if err := tx.QueryRow(ctx, "SELECT chat_uuid FROM chats WHERE chat_uuid = $1 FOR UPDATE", chatUUID).Scan(nil); err != nil {
+		tx.Rollback(ctx)
+		if err == pgx.ErrNoRows {
+			return repository.ErrNotFound
+		}
+		return fmt.Errorf("postgres: %w", err)
+	}
+	if quota > 0 {
+		var used int64
+		if err := tx.QueryRow(ctx, "SELECT COALESCE(SUM(size), 0) FROM attachments WHERE chat_uuid = $1", chatUUID).Scan(&used); err != nil {
+			tx.Rollback(ctx)
+			return fmt.Errorf("postgres: %w", err)
+		}
+		if used+attachment.Size > quota {
+			tx.Rollback(ctx)
+			return repository.ErrAttachmentQuotaExceeded
+		}
+	}
+	if _, err := tx.Exec(ctx, "INSERT INTO attachments (chat_uuid, attachment_uuid, session_uuid, blob_key, name, content_type, size, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		chatUUID, attachment.ID, attachment.SessionUUID, attachment.Key, attachment.Name, attachment.ContentType, attachment.Size, attachment.CreatedAt); err != nil {
+		tx.Rollback(ctx)
+		return fmt.Errorf("postgres: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		tx.Rollback(ctx)
+		return fmt.Errorf("postgres: %w", err)
+	}
+	return nil
+}
+
+func (p *Storage) GetAttachment(chatUUID string, attachmentID string) (entities.Attachment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+	if err := p.Db.QueryRow(ctx, "SELECT chat_uuid FROM chats WHERE chat_uuid = $1 LIMIT 1", chatUUID).Scan(nil); err != nil {
+		if err == pgx.ErrNoRows {
+			return entities.Attachment{}, repository.ErrNotFound
+		}
+		return entities.Attachment{}, fmt.Errorf("postgres: %w", err)
+	}
+
+	var attachment Attachment
+	if err := p.Db.QueryRow(ctx, "SELECT attachment_uuid, session_uuid, blob_key, name, content_type, size, created_at FROM attachments WHERE chat_uuid = $1 AND attachment_uuid = $2", chatUUID, attachmentID).
+		Scan(&attachment.AttachmentUUID, &attachment.SessionUUID, &attachment.Key, &attachment.Name, &attachment.ContentType, &attachment.Size, &attachment.CreatedAt); err != nil {
+		if err == pgx.ErrNoRows {
+			return entities.Attachment{}, repository.ErrAttachmentNotFound
+		}
+		return entities.Attachment{}, fmt.Errorf("postgres: %w", err)
+	}
+	return attachment.toEntity(), nil
+}
+
+func (p *Storage) GetAttachments(chatUUID string) ([]entities.Attachment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+	if err := p.Db.QueryRow(ctx, "SELECT chat_uuid FROM chats WHERE chat_uuid = $1 LIMIT 1", chatUUID).Scan(nil); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+
+	rows, err := p.Db.Query(ctx, "SELECT attachment_uuid, session_uuid, blob_key, name, content_type, size, created_at FROM attachments WHERE chat_uuid = $1", chatUUID)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	return scanAttachments(rows)
+}
+
+// Chat row is locked like AddMessage does, so attachment can't be referenced by new message while it's deleted.
+// Tombstones have no attachment_ids and trimmed messages are deleted, so only messages that stay reference attachments
+func (p *Storage) DeleteUnreferencedAttachments(chatUUID string, ids []string, uploadedBefore time.Time) ([]entities.Attachment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+	tx, err := p.Db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback(ctx)
+		}
+	}()
+
+	if err := tx.QueryRow(ctx, "SELECT chat_uuid FROM chats WHERE chat_uuid = $1 FOR UPDATE", chatUUID).Scan(nil); err != nil {
+		tx.Rollback(ctx)
+		if err == pgx.ErrNoRows {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	query := `
+	DELETE FROM attachments a
+	WHERE a.chat_uuid = $1 AND a.created_at < $2 AND ($3::uuid[] IS NULL OR a.attachment_uuid = ANY($3::uuid[]))
+		AND NOT EXISTS (SELECT 1 FROM messages m WHERE m.chat_uuid = $1 AND a.attachment_uuid = ANY(m.attachment_ids))
+	RETURNING a.attachment_uuid, a.session_uuid, a.blob_key, a.name, a.content_type, a.size, a.created_at
+	`
+	rows, err := tx.Query(ctx, query, chatUUID, uploadedBefore, ids)
+	if err != nil {
+		tx.Rollback(ctx)
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	deleted, err := scanAttachments(rows)
+	if err != nil {
+		tx.Rollback(ctx)
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		tx.Rollback(ctx)
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	return deleted, nil
+}
+
+// Rows are closed after scanning
+func scanAttachments(rows pgx.Rows) ([]entities.Attachment, error) {
+	defer rows.Close()
+	var attachments []entities.Attachment
+	for rows.Next() {
+		var attachment Attachment
+		if err := rows.Scan(&attachment.AttachmentUUID, &attachment.SessionUUID, &attachment.Key, &attachment.Name, &attachment.ContentType, &attachment.Size, &attachment.CreatedAt); err != nil {
+			return nil, fmt.Errorf("postgres: %w", err)
+		}
+		attachments = append(attachments, attachment.toEntity())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	return attachments, nil
+}
+
+func (p *Storage) JoinChat(sessionUUID string, chatUUID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+
+	if err := p.Db.QueryRow(ctx, "SELECT chat_uuid FROM chats WHERE chat_uuid = $1 LIMIT 1", chatUUID).Scan(nil); err != nil {
+		if err == pgx.ErrNoRows {
+			return repository.ErrNotFound
+		}
+		return fmt.Errorf("postgres: %w", err)
+	}
+	if err := p.Db.QueryRow(ctx, "SELECT session_uuid FROM users WHERE session_uuid = $1 LIMIT 1", sessionUUID).Scan(nil); err != nil {
+		if err == pgx.ErrNoRows {
+			return repository.ErrUserDoesntExist
+		}
+		return fmt.Errorf("postgres: %w", err)
+	}
+
+	//Joining again doesn't change time of joining
+	if _, err := p.Db.Exec(ctx, "INSERT INTO chat_members (chat_uuid, session_uuid) VALUES ($1, $2) ON CONFLICT DO NOTHING", chatUUID, sessionUUID); err != nil {
+		return fmt.Errorf("postgres: %w", err)
+	}
+	return nil
+}
+
+func (p *Storage) LeaveChat(sessionUUID string, chatUUID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+
+	if err := p.Db.QueryRow(ctx, "SELECT chat_uuid FROM chats WHERE chat_uuid = $1 LIMIT 1", chatUUID).Scan(nil); err != nil {
+		if err == pgx.ErrNoRows {
+			return repository.ErrNotFound
+		}
+		return fmt.Errorf("postgres: %w", err)
+	}
+
+	tag, err := p.Db.Exec(ctx, "DELETE FROM chat_members WHERE chat_uuid = $1 AND session_uuid = $2", chatUUID, sessionUUID)
+	if err != nil {
+		return fmt.Errorf("postgres: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return repository.ErrNotMember
+	}
+	return nil
+}
+
+func (p *Storage) GetMember(sessionUUID string, chatUUID string) (entities.Member, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+
+	//Chat is joined to distinguish chat that doesn't exist from session that is not a member
+	var member Member
+	var isMember bool
+	query := `
+	SELECT cm.session_uuid IS NOT NULL, cm.joined_at, cm.role
+	FROM chats c
+	LEFT JOIN chat_members cm ON cm.chat_uuid = c.chat_uuid AND cm.session_uuid = $2
+	WHERE c.chat_uuid = $1
+	`
+	var joinedAt *time.Time
+	var role *entities.Role
+	if err := p.Db.QueryRow(ctx, query, chatUUID, sessionUUID).Scan(&isMember, &joinedAt, &role); err != nil {
+		if err == pgx.ErrNoRows {
+			return entities.Member{}, repository.ErrNotFound
+		}
+		return entities.Member{}, fmt.Errorf("postgres: %w", err)
+	}
+	if !isMember {
+		return entities.Member{}, repository.ErrNotMember
+	}
+	member.SessionUUID, _ = uuid.Parse(sessionUUID)
+	member.JoinedAt, member.Role = *joinedAt, *role
+	return member.toEntity(), nil
+}
+
+func (p *Storage) SetMemberRole(sessionUUID string, chatUUID string, role entities.Role) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+
+	if err := p.Db.QueryRow(ctx, "SELECT chat_uuid FROM chats WHERE chat_uuid = $1 LIMIT 1", chatUUID).Scan(nil); err != nil {
+		if err == pgx.ErrNoRows {
+			return repository.ErrNotFound
+		}
+		return fmt.Errorf("postgres: %w", err)
+	}
+
+	tag, err := p.Db.Exec(ctx, "UPDATE chat_members SET role = $3 WHERE chat_uuid = $1 AND session_uuid = $2", chatUUID, sessionUUID, role)
+	if err != nil {
+		return fmt.Errorf("postgres: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return repository.ErrNotMember
+	}
+	return nil
+}
+
+func (p *Storage) GetParticipants(chatUUID string) ([]entities.Member, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+
+	if err := p.Db.QueryRow(ctx, "SELECT chat_uuid FROM chats WHERE chat_uuid = $1 LIMIT 1", chatUUID).Scan(nil); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+
+	rows, err := p.Db.Query(ctx, "SELECT session_uuid, joined_at, role FROM chat_members WHERE chat_uuid = $1 ORDER BY joined_at ASC, session_uuid ASC", chatUUID)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	defer rows.Close()
+
+	var members []entities.Member
+	for rows.Next() {
+		var member Member
+		if err := rows.Scan(&member.SessionUUID, &member.JoinedAt, &member.Role); err != nil {
+			return nil, fmt.Errorf("postgres: %w", err)
+		}
+		members = append(members, member.toEntity())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	return members, nil
+}
+
+func (p *Storage) Restrict(chatUUID string, restriction entities.Restriction) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+
+	if err := p.Db.QueryRow(ctx, "SELECT chat_uuid FROM chats WHERE chat_uuid = $1 LIMIT 1", chatUUID).Scan(nil); err != nil {
+		if err == pgx.ErrNoRows {
+			return repository.ErrNotFound
+		}
+		return fmt.Errorf("postgres: %w", err)
+	}
+	if err := p.Db.QueryRow(ctx, "SELECT session_uuid FROM users WHERE session_uuid = $1 LIMIT 1", restriction.SessionUUID).Scan(nil); err != nil {
+		if err == pgx.ErrNoRows {
+			return repository.ErrUserDoesntExist
+		}
+		return fmt.Errorf("postgres: %w", err)
+	}
+
+	//NULL until - restriction without end
+	var until *time.Time
+	if !restriction.Until.IsZero() {
+		until = &restriction.Until
+	}
+	//Dropping expired restrictions of chat so they don't pile up
+	if _, err := p.Db.Exec(ctx, "DELETE FROM chat_restrictions WHERE chat_uuid = $1 AND until <= now()", chatUUID); err != nil {
+		return fmt.Errorf("postgres: %w", err)
+	}
+	query := `
+	INSERT INTO chat_restrictions (chat_uuid, session_uuid, kind, until) VALUES ($1, $2, $3, $4)
+	ON CONFLICT (chat_uuid, session_uuid, kind) DO UPDATE SET until = EXCLUDED.until
+	`
+	if _, err := p.Db.Exec(ctx, query, chatUUID, restriction.SessionUUID, restriction.Kind, until); err != nil {
+		return fmt.Errorf("postgres: %w", err)
+	}
+	return nil
+}
+
+func (p *Storage) Unrestrict(sessionUUID string, chatUUID string, kind entities.RestrictionKind) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+
+	if err := p.Db.QueryRow(ctx, "SELECT chat_uuid FROM chats WHERE chat_uuid = $1 LIMIT 1", chatUUID).Scan(nil); err != nil {
+		if err == pgx.ErrNoRows {
+			return repository.ErrNotFound
+		}
+		return fmt.Errorf("postgres: %w", err)
+	}
+
+	_, err := p.Db.Exec(ctx, "DELETE FROM chat_restrictions WHERE chat_uuid = $1 AND session_uuid = $2 AND kind = $3", chatUUID, sessionUUID, kind)
+	if err != nil {
+		return fmt.Errorf("postgres: %w", err)
+	}
+	return nil
+}
+
+func (p *Storage) GetRestrictions(sessionUUID string, chatUUID string) ([]entities.Restriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+
+	if err := p.Db.QueryRow(ctx, "SELECT chat_uuid FROM chats WHERE chat_uuid = $1 LIMIT 1", chatUUID).Scan(nil); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+
+	//Expired restrictions are skipped, they are deleted on next restriction of chat
+	query := `
+	SELECT kind, until FROM chat_restrictions
+	WHERE chat_uuid = $1 AND session_uuid = $2 AND (until IS NULL OR until > now())
+	ORDER BY kind
+	`
+	rows, err := p.Db.Query(ctx, query, chatUUID, sessionUUID)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	defer rows.Close()
+
+	var restrictions []entities.Restriction
+	for rows.Next() {
+		restriction := entities.Restriction{SessionUUID: sessionUUID}
+		var until *time.Time
+		if err := rows.Scan(&restriction.Kind, &until); err != nil {
+			return nil, fmt.Errorf("postgres: %w", err)
+		}
+		if until != nil {
+			restriction.Until = *until
+		}
+		restrictions = append(restrictions, restriction)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	return restrictions, nil
+}
+
+// Pointer only moves forward, so marking older message doesn't change it
+func (p *Storage) MarkRead(chatUUID string, sessionUUID string, seq int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+
+	if err := p.Db.QueryRow(ctx, "SELECT chat_uuid FROM chats WHERE chat_uuid = $1 LIMIT 1", chatUUID).Scan(nil); err != nil {
+		if err == pgx.ErrNoRows {
+			return repository.ErrNotFound
+		}
+		return fmt.Errorf("postgres: %w", err)
+	}
+	if err := p.Db.QueryRow(ctx, "SELECT session_uuid FROM users WHERE session_uuid = $1 LIMIT 1", sessionUUID).Scan(nil); err != nil {
+		if err == pgx.ErrNoRows {
+			return repository.ErrUserDoesntExist
+		}
+		return fmt.Errorf("postgres: %w", err)
+	}
+
+	query := `
+	INSERT INTO chat_reads (chat_uuid, session_uuid, seq, read_at) VALUES ($1, $2, $3, CURRENT_TIMESTAMP)
+	ON CONFLICT (chat_uuid, session_uuid) DO UPDATE SET seq = EXCLUDED.seq, read_at = EXCLUDED.read_at
+	WHERE chat_reads.seq < EXCLUDED.seq
+	`
+	if _, err := p.Db.Exec(ctx, query, chatUUID, sessionUUID, seq); err != nil {
+		return fmt.Errorf("postgres: %w", err)
+	}
+	return nil
+}
+
+// Chats of session are found by its memberships, unread messages are counted by index of seq
+func (p *Storage) GetMemberChats(sessionUUID string) ([]entities.MemberChat, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+
+	query := `
+	SELECT c.session_uuid, c.read_only, c.ttl, c.chat_uuid, c.created_at, c.visibility, c.title, c.description, c.tags,
+		(SELECT COUNT(*) FROM messages m WHERE m.chat_uuid = c.chat_uuid),
+		cm.joined_at,
+		(SELECT COUNT(*) FROM messages m WHERE m.chat_uuid = c.chat_uuid AND m.seq > COALESCE(r.seq, 0) AND NOT m.deleted AND m.session_uuid <> cm.session_uuid)
+	FROM chat_members cm
+	JOIN chats c ON c.chat_uuid = cm.chat_uuid
+	LEFT JOIN chat_reads r ON r.chat_uuid = cm.chat_uuid AND r.session_uuid = cm.session_uuid
+	WHERE cm.session_uuid = $1
+	ORDER BY cm.joined_at DESC, c.chat_uuid ASC
+	`
+	rows, err := p.Db.Query(ctx, query, sessionUUID)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	defer rows.Close()
+
+	var chats []entities.MemberChat
+	for rows.Next() {
+		var chat Chat
+		var memberChat entities.MemberChat
+		var messageCount int
+		if err := rows.Scan(&chat.SessionUUID, &chat.ReadOnly, &chat.TTL, &chat.ChatUUID, &chat.CreatedAt, &chat.Visibility, &chat.Title, &chat.Description, &chat.Tags,
+			&messageCount, &memberChat.JoinedAt, &memberChat.UnreadCount); err != nil {
+			return nil, fmt.Errorf("postgres: %w", err)
+		}
+		memberChat.Chat = chat.toEntity()
+		memberChat.Chat.MessageCount = messageCount
+		chats = append(chats, memberChat)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+
+	chatUUIDs := make([]string, 0, len(chats))
+	for _, v := range chats {
+		chatUUIDs = append(chatUUIDs, v.Chat.ChatUUID)
+	}
+	pins, err := p.getPins(ctx, chatUUIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i := range chats {
+		chats[i].Chat.Pinned = pins[chats[i].Chat.ChatUUID]
+	}
+	return chats, nil
+}
+
+func (p *Storage) GetReadReceipts(chatUUID string, seq int64) ([]entities.ReadReceipt, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+	if err := p.Db.QueryRow(ctx, "SELECT chat_uuid FROM chats WHERE chat_uuid = $1 LIMIT 1", chatUUID).Scan(nil); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+
+	rows, err := p.Db.Query(ctx, "SELECT session_uuid, seq, read_at FROM chat_reads WHERE chat_uuid = $1 AND seq >= $2 ORDER BY read_at ASC, session_uuid ASC", chatUUID, seq)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	defer rows.Close()
+
+	var receipts []entities.ReadReceipt
+	for rows.Next() {
+		var sessionUUID uuid.UUID
+		var receipt entities.ReadReceipt
+		if err := rows.Scan(&sessionUUID, &receipt.Seq, &receipt.ReadAt); err != nil {
+			return nil, fmt.Errorf("postgres: %w", err)
+		}
+		receipt.SessionUUID = sessionUUID.String()
+		receipts = append(receipts, receipt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	return receipts, nil
+}
+
+func (m Member) toEntity() entities.Member {
+	return entities.Member{
+		SessionUUID: m.SessionUUID.String(),
+		JoinedAt:    m.JoinedAt,
+		Role:        m.Role,
+	}
+}
+
+func (a Attachment) toEntity() entities.Attachment {
+	return entities.Attachment{
+		ID:          a.AttachmentUUID.String(),
+		SessionUUID: a.SessionUUID.String(),
+		Key:         a.Key,
+		Name:        a.Name,
+		ContentType: a.ContentType,
+		Size:        a.Size,
+		CreatedAt:   a.CreatedAt,
+	}
+}
+
+func (c Chat) toEntity() entities.Chat {
+	return entities.Chat{
+		SessionUUID: c.SessionUUID.String(),
+		ChatUUID:    c.ChatUUID.String(),
+		ReadOnly:    c.ReadOnly,
+		TTL:         c.TTL,
+		CreatedAt:   c.CreatedAt,
+		Visibility:  c.Visibility,
+		Info: entities.ChatInfo{
+			Title:       c.Title,
+			Description: c.Description,
+			Tags:        c.Tags,
+		},
+	}
+}
+
+func (m Message) toEntity() entities.Message {
+	message := entities.Message{
+		SessionUUID: m.SessionUUID.String(),
+		MessageUUID: m.MessageUUID.String(),
+		Text:        m.Text,
+		Deleted:     m.Deleted,
+		CreatedAt:   m.CreatedAt,
+		Seq:         m.Seq,
+		Attachments: m.Attachments,
+		ReplyCount:  m.ReplyCount,
+	}
+	if m.EditedAt != nil {
+		message.EditedAt = *m.EditedAt
+	}
+	if m.ReplyTo != nil {
+		message.ReplyTo = m.ReplyTo.String()
+	}
+	if m.ExpiresAt != nil {
+		message.ExpiresAt = *m.ExpiresAt
+	}
+	return message
+}
+
+// Parsing keyset cursor with uuid of record
+func parseKeysetCursor(cursor string) (time.Time, uuid.UUID, error) {
+	createdAt, idStr, err := repository.ParseKeysetCursor(cursor)
+	if err != nil {
+		return time.Time{}, uuid.UUID{}, err
+	}
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return time.Time{}, uuid.UUID{}, repository.ErrInvalidCursor
+	}
+	return createdAt, id, nil
+}
+
+// Messages are found by GIN index on tsvector of text. All terms have to be in message
+func (p *Storage) SearchMessages(terms []string, chatUUID string, page entities.Page) (found []entities.FoundMessage, nextCursor string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+
+	var chat *string
+	if chatUUID != "" {
+		if err := p.Db.QueryRow(ctx, "SELECT chat_uuid FROM chats WHERE chat_uuid = $1 LIMIT 1", chatUUID).Scan(nil); err != nil {
+			if err == pgx.ErrNoRows {
+				return nil, "", repository.ErrNotFound
+			}
+			return nil, "", fmt.Errorf("postgres: %w", err)
+		}
+		chat = &chatUUID
+	}
+
+	var cursorTime *time.Time
+	var cursorID *string
+	if page.Cursor != "" {
+		createdAt, id, err := repository.ParseSearchCursor(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := uuid.Parse(id); err != nil {
+			return nil, "", repository.ErrInvalidCursor
+		}
+		cursorTime, cursorID = &createdAt, &id
+	}
+
+	//Requesting one more message than needed to know if there is next page
+	var limit *int
+	if page.Limit > 0 {
+		limit = new(int)
+		*limit = page.Limit + 1
+	}
+
+	//plainto_tsquery joins words with AND
+	query := `
+	SELECT chat_uuid, session_uuid, message_uuid, text, created_at, edited_at, deleted, seq, attachment_ids, reply_to, expires_at FROM messages
+	WHERE search @@ plainto_tsquery('simple', $1) AND NOT deleted
+		AND ($2::uuid IS NULL OR chat_uuid = $2)
+		AND ($3::timestamp IS NULL OR (created_at, message_uuid) < ($3, $4::uuid))
+	ORDER BY created_at DESC, message_uuid DESC
+	LIMIT $5
+	`
+	rows, err := p.Db.Query(ctx, query, strings.Join(terms, " "), chat, cursorTime, cursorID, limit)
+	if err != nil {
+		return nil, "", fmt.Errorf("postgres: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var message Message
+		if err := rows.Scan(&message.ChatUUID, &message.SessionUUID, &message.MessageUUID, &message.Text, &message.CreatedAt, &message.EditedAt, &message.Deleted, &message.Seq, &message.Attachments, &message.ReplyTo, &message.ExpiresAt); err != nil {
+			return nil, "", fmt.Errorf("postgres: %w", err)
+		}
+		found = append(found, entities.FoundMessage{
+			ChatUUID: message.ChatUUID,
+			Message:  message.toEntity(),
+			Cursor:   repository.SearchCursor(message.CreatedAt, message.MessageUUID.String()),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("postgres: %w", err)
+	}
+
+	if page.Limit > 0 && len(found) > page.Limit {
+		found = found[:page.Limit]
+		nextCursor = found[len(found)-1].Cursor
+	}
+	return found, nextCursor, nil
+}
+
+// Chats are filtered and paged natively with keyset pagination on created_at and chat_uuid
+func (p *Storage) GetActiveChats(filter entities.ChatFilter, page entities.Page) (chats []entities.Chat, nextCursor string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+
+	var cursorCreatedAt *time.Time
+	var cursorChatUUID *uuid.UUID
+	if page.Cursor != "" {
+		createdAt, chatUUID, err := parseKeysetCursor(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		cursorCreatedAt, cursorChatUUID = &createdAt, &chatUUID
+	}
+	var sessionUUID *string
+	if filter.SessionUUID != "" {
+		sessionUUID = &filter.SessionUUID
+	}
+	var viewer *string
+	if filter.Viewer != "" {
+		viewer = &filter.Viewer
+	}
+	var createdAfter *time.Time
+	if !filter.CreatedAfter.IsZero() {
+		createdAfterUTC := filter.CreatedAfter.UTC()
+		createdAfter = &createdAfterUTC
+	}
+	var title, tag *string
+	if filter.Title != "" {
+		title = &filter.Title
+	}
+	if filter.Tag != "" {
+		tag = &filter.Tag
+	}
+	//Requesting one more chat than needed to know if there is next page
+	var limit *int
+	if page.Limit > 0 {
+		limit = new(int)
+		*limit = page.Limit + 1
+	}
+
+	query := `
+	SELECT c.session_uuid, c.read_only, c.ttl, c.chat_uuid, c.created_at, c.visibility, c.title, c.description, c.tags,
+		(SELECT COUNT(*) FROM messages m WHERE m.chat_uuid = c.chat_uuid)
+	FROM chats c
+	WHERE ($1::uuid IS NULL OR c.session_uuid = $1)
+		AND ($2::boolean IS NULL OR c.read_only = $2)
+		AND ($3::boolean IS NULL OR (c.ttl > 0) = $3)
+		AND ($4::timestamp IS NULL OR c.created_at > $4)
+		AND ($5::timestamp IS NULL OR (c.created_at, c.chat_uuid) > ($5, $6))
+		AND c.visibility <> $8
+		AND ($9::text IS NULL OR position(lower($9) in lower(c.title)) > 0)
+		AND ($10::text IS NULL OR c.tags @> ARRAY[$10::text])
+		AND (c.visibility <> $11 OR EXISTS (SELECT 1 FROM chat_members cm WHERE cm.chat_uuid = c.chat_uuid AND cm.session_uuid = $12::uuid))
+	ORDER BY c.created_at ASC, c.chat_uuid ASC
+	LIMIT $7
+	`
+	rows, err := p.Db.Query(ctx, query, sessionUUID, filter.ReadOnly, filter.HasTTL, createdAfter, cursorCreatedAt, cursorChatUUID, limit, entities.VisibilityDirect,
+		title, tag, entities.VisibilityMembersOnly, viewer)
+	if err != nil {
+		return nil, "", fmt.Errorf("postgres: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var chat Chat
+		var messageCount int
+		if err := rows.Scan(&chat.SessionUUID, &chat.ReadOnly, &chat.TTL, &chat.ChatUUID, &chat.CreatedAt, &chat.Visibility, &chat.Title, &chat.Description, &chat.Tags, &messageCount); err != nil {
+			return nil, "", fmt.Errorf("postgres: %w", err)
+		}
+		entity := chat.toEntity()
+		entity.MessageCount = messageCount
+		chats = append(chats, entity)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("postgres: %w", err)
+	}
+
+	if page.Limit > 0 && len(chats) > page.Limit {
+		chats = chats[:page.Limit]
+		last := chats[len(chats)-1]
+		nextCursor = repository.FormatKeysetCursor(last.CreatedAt, last.ChatUUID)
+	}
+
+	chatUUIDs := make([]string, 0, len(chats))
+	for _, v := range chats {
+		chatUUIDs = append(chatUUIDs, v.ChatUUID)
+	}
+	pins, err := p.getPins(ctx, chatUUIDs)
+	if err != nil {
+		return nil, "", err
+	}
+	for i := range chats {
+		chats[i].Pinned = pins[chats[i].ChatUUID]
+	}
+	return chats, nextCursor, nil
+}
+
+func (p *Storage) GetExpiringChats(before time.Time) ([]entities.ChatExpiration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+
+	rows, err := p.Db.Query(ctx, "SELECT chat_uuid, session_uuid, expires_at FROM chats WHERE expires_at < $1 ORDER BY expires_at", before)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	defer rows.Close()
+
+	var expirations []entities.ChatExpiration
+	for rows.Next() {
+		var chatUUID, sessionUUID uuid.UUID
+		var expiresAt time.Time
+		if err := rows.Scan(&chatUUID, &sessionUUID, &expiresAt); err != nil {
+			return nil, fmt.Errorf("postgres: %w", err)
+		}
+		expirations = append(expirations, entities.ChatExpiration{
+			ChatUUID:    chatUUID.String(),
+			SessionUUID: sessionUUID.String(),
+			ExpiresAt:   expiresAt,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	return expirations, nil
+}
+
+// Deleting chat by ttl under advisory lock of chat. Replica that didn't get the lock doesn't delete chat
+func (p *Storage) ExpireChat(chatUUID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+	tx, err := p.Db.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("postgres: %w", err)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback(ctx)
+		}
+	}()
+
+	//Lock is released with the end of transaction
+	var locked bool
+	if err := tx.QueryRow(ctx, "SELECT pg_try_advisory_xact_lock(hashtextextended($1, 0))", chatUUID).Scan(&locked); err != nil {
+		tx.Rollback(ctx)
+		return false, fmt.Errorf("postgres: %w", err)
+	}
+	if !locked {
+		tx.Rollback(ctx)
+		return false, nil
+	}
+
+	//ttl could be changed or chat deleted by another replica
+	tag, err := tx.Exec(ctx, "DELETE FROM chats WHERE chat_uuid = $1 AND expires_at <= now()", chatUUID)
+	if err != nil {
+		tx.Rollback(ctx)
+		return false, fmt.Errorf("postgres: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		tx.Rollback(ctx)
+		return false, fmt.Errorf("postgres: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (p *Storage) GetExpiringMessages(before time.Time) ([]entities.MessageExpiration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+
+	rows, err := p.Db.Query(ctx, "SELECT chat_uuid, message_uuid, session_uuid, expires_at FROM messages WHERE expires_at < $1 AND NOT deleted ORDER BY expires_at", before)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	defer rows.Close()
+
+	var expirations []entities.MessageExpiration
+	for rows.Next() {
+		var chatUUID, messageUUID, sessionUUID uuid.UUID
+		var expiresAt time.Time
+		if err := rows.Scan(&chatUUID, &messageUUID, &sessionUUID, &expiresAt); err != nil {
+			return nil, fmt.Errorf("postgres: %w", err)
+		}
+		expirations = append(expirations, entities.MessageExpiration{
+			ChatUUID:    chatUUID.String(),
+			MessageUUID: messageUUID.String(),
+			SessionUUID: sessionUUID.String(),
+			ExpiresAt:   expiresAt,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	return expirations, nil
+}
+
+// Expired message is deleted like any other, expiration is kept in the row of message
+func (p *Storage) ExpireMessage(expiration entities.MessageExpiration) (entities.Message, error) {
+	return p.DeleteMessage(expiration.ChatUUID, expiration.MessageUUID)
+}
+
+// Key is remembered only if it's not remembered yet or its time has elapsed
+func (p *Storage) RememberIdempotencyKey(sessionUUID string, key string, request entities.IdempotentRequest, expiresAt time.Time) (entities.IdempotentRequest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+	tx, err := p.Db.Begin(ctx)
+	if err != nil {
+		return entities.IdempotentRequest{}, fmt.Errorf("postgres: %w", err)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback(ctx)
+		}
+	}()
+
+	if err := tx.QueryRow(ctx, "SELECT session_uuid FROM users WHERE session_uuid = $1 LIMIT 1", sessionUUID).Scan(nil); err != nil {
+		tx.Rollback(ctx)
+		if err == pgx.ErrNoRows {
+			return entities.IdempotentRequest{}, repository.ErrUserDoesntExist
+		}
+		return entities.IdempotentRequest{}, fmt.Errorf("postgres: %w", err)
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM idempotency_keys WHERE session_uuid = $1 AND expires_at <= now()", sessionUUID); err != nil {
+		tx.Rollback(ctx)
+		return entities.IdempotentRequest{}, fmt.Errorf("postgres: %w", err)
+	}
+
+	//Key of the same session remembered concurrently is returned by conflict
+	query := `
+	INSERT INTO idempotency_keys (session_uuid, key, message_uuid, chat_uuid, request_hash, sent, remembered_at, expires_at)
+	VALUES ($1, $2, $3, $4, $5, FALSE, $6, $7)
+	ON CONFLICT (session_uuid, key) DO UPDATE SET session_uuid = idempotency_keys.session_uuid
+	RETURNING message_uuid, COALESCE(chat_uuid::text, ''), request_hash, sent, remembered_at
+	`
+	var messageUUID uuid.UUID
+	var remembered entities.IdempotentRequest
+	if err := tx.QueryRow(ctx, query, sessionUUID, key, request.MessageUUID, request.ChatUUID, request.RequestHash, request.RememberedAt, expiresAt).
+		Scan(&messageUUID, &remembered.ChatUUID, &remembered.RequestHash, &remembered.Sent, &remembered.RememberedAt); err != nil {
+		tx.Rollback(ctx)
+		return entities.IdempotentRequest{}, fmt.Errorf("postgres: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		tx.Rollback(ctx)
+		return entities.IdempotentRequest{}, fmt.Errorf("postgres: %w", err)
+	}
+	remembered.MessageUUID = messageUUID.String()
+	return remembered, nil
+}
+
+func (p *Storage) MarkIdempotencyKeySent(sessionUUID string, key string, messageUUID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+
+	if _, err := p.Db.Exec(ctx, "UPDATE idempotency_keys SET sent = TRUE WHERE session_uuid = $1 AND key = $2 AND message_uuid = $3", sessionUUID, key, messageUUID); err != nil {
+		return fmt.Errorf("postgres: %w", err)
+	}
+	return nil
+}
+
+// Key is deleted only if the same message is remembered with it
+func (p *Storage) ForgetIdempotencyKey(sessionUUID string, key string, messageUUID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+
+	if _, err := p.Db.Exec(ctx, "DELETE FROM idempotency_keys WHERE session_uuid = $1 AND key = $2 AND message_uuid = $3", sessionUUID, key, messageUUID); err != nil {
+		return fmt.Errorf("postgres: %w", err)
+	}
+	return nil
+}
+
+// Scheduled message is added only if chat and user exist
+func (p *Storage) AddScheduled(scheduled entities.ScheduledMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+
+	if err := p.Db.QueryRow(ctx, "SELECT session_uuid FROM users WHERE session_uuid = $1 LIMIT 1", scheduled.SessionUUID).Scan(nil); err != nil {
+		if err == pgx.ErrNoRows {
+			return repository.ErrUserDoesntExist
+		}
+		return fmt.Errorf("postgres: %w", err)
+	}
+
+	query := `
+	INSERT INTO scheduled_messages (scheduled_uuid, chat_uuid, session_uuid, text, send_at, created_at)
+	SELECT $2, chat_uuid, $3, $4, $5, $6 FROM chats WHERE chat_uuid = $1
+	`
+	tag, err := p.Db.Exec(ctx, query, scheduled.ChatUUID, scheduled.ScheduledUUID, scheduled.SessionUUID,
+		scheduled.Text, scheduled.SendAt, scheduled.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("postgres: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+func (p *Storage) GetScheduled(chatUUID string, sessionUUID string) ([]entities.ScheduledMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+	if err := p.Db.QueryRow(ctx, "SELECT chat_uuid FROM chats WHERE chat_uuid = $1 LIMIT 1", chatUUID).Scan(nil); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+
+	return p.queryScheduled(ctx, "WHERE chat_uuid = $1 AND session_uuid = $2", chatUUID, sessionUUID)
+}
+
+func (p *Storage) GetDueScheduled(before time.Time) ([]entities.ScheduledMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+
+	return p.queryScheduled(ctx, "WHERE send_at < $1", before)
+}
+
+func (p *Storage) queryScheduled(ctx context.Context, where string, args ...any) ([]entities.ScheduledMessage, error) {
+	query := "SELECT scheduled_uuid, chat_uuid, session_uuid, text, send_at, created_at FROM scheduled_messages " + where + " ORDER BY send_at, scheduled_uuid"
+	rows, err := p.Db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	defer rows.Close()
+
+	var scheduled []entities.ScheduledMessage
+	for rows.Next() {
+		var scheduledUUID, chatUUID, sessionUUID uuid.UUID
+		var message entities.ScheduledMessage
+		if err := rows.Scan(&scheduledUUID, &chatUUID, &sessionUUID, &message.Text, &message.SendAt, &message.CreatedAt); err != nil {
+			return nil, fmt.Errorf("postgres: %w", err)
+		}
+		message.ScheduledUUID = scheduledUUID.String()
+		message.ChatUUID = chatUUID.String()
+		message.SessionUUID = sessionUUID.String()
+		scheduled = append(scheduled, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	return scheduled, nil
+}
+
+// Only one replica deletes the row, so message is sent once
+func (p *Storage) DeleteScheduled(chatUUID string, scheduledUUID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+	if err := p.Db.QueryRow(ctx, "SELECT chat_uuid FROM chats WHERE chat_uuid = $1 LIMIT 1", chatUUID).Scan(nil); err != nil {
+		if err == pgx.ErrNoRows {
+			return false, repository.ErrNotFound
+		}
+		return false, fmt.Errorf("postgres: %w", err)
+	}
+
+	tag, err := p.Db.Exec(ctx, "DELETE FROM scheduled_messages WHERE chat_uuid = $1 AND scheduled_uuid = $2", chatUUID, scheduledUUID)
+	if err != nil {
+		return false, fmt.Errorf("postgres: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// Lease is counted by database clock, so replicas with different clocks agree when claim elapses
+func (p *Storage) ClaimScheduled(chatUUID string, scheduledUUID string, lease time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+	if err := p.Db.QueryRow(ctx, "SELECT chat_uuid FROM chats WHERE chat_uuid = $1 LIMIT 1", chatUUID).Scan(nil); err != nil {
+		if err == pgx.ErrNoRows {
+			return false, repository.ErrNotFound
+		}
+		return false, fmt.Errorf("postgres: %w", err)
+	}
+
+	query := `
+	UPDATE scheduled_messages SET claimed_until = CURRENT_TIMESTAMP + make_interval(secs => $3)
+	WHERE chat_uuid = $1 AND scheduled_uuid = $2 AND (claimed_until IS NULL OR claimed_until <= CURRENT_TIMESTAMP)
+	`
+	tag, err := p.Db.Exec(ctx, query, chatUUID, scheduledUUID, lease.Seconds())
+	if err != nil {
+		return false, fmt.Errorf("postgres: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (p *Storage) ReleaseScheduled(chatUUID string, scheduledUUID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Second)
+	defer cancel()
+	if err := p.Db.QueryRow(ctx, "SELECT chat_uuid FROM chats WHERE chat_uuid = $1 LIMIT 1", chatUUID).Scan(nil); err != nil {
+		if err == pgx.ErrNoRows {
+			return repository.ErrNotFound
+		}
+		return fmt.Errorf("postgres: %w", err)
+	}
+
+	if _, err := p.Db.Exec(ctx, "UPDATE scheduled_messages SET claimed_until = NULL WHERE chat_uuid = $1 AND scheduled_uuid = $2", chatUUID, scheduledUUID); err != nil {
+		return fmt.Errorf("postgres: %w", err)
+	}
+	return nil
+}
+
+// Storage doesn't delete chats by itself, messenger deletes them by ttl
+func (p *Storage) OnChatExpired(_ func(expiration entities.ChatExpiration)) bool {
+	return false
+}
+
+func (p *Storage) OnChatEvicted(fn func(chatUUID string)) {
+	p.onChatEvicted = fn
 }
 
 func GracefulStop() {
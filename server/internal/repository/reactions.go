@@ -0,0 +1,19 @@
+package repository
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+
+	"github.com/Rolan335/grpcMessenger/server/internal/repository/entities"
+)
+
+// Sorting reactions of message from the most popular, emojis with the same count by emoji, so every storage returns them in the same order
+func SortReactions(reactions []entities.Reaction) {
+	slices.SortFunc(reactions, func(a, b entities.Reaction) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Emoji, b.Emoji)
+	})
+}
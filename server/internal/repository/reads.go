@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/Rolan335/grpcMessenger/server/internal/repository/entities"
+)
+
+// Message is unread by session if it's after last-read pointer of session. Own messages and tombstones are never unread
+func Unread(message entities.Message, sessionUUID string, lastReadSeq int64) bool {
+	return message.Seq > lastReadSeq && !message.Deleted && message.SessionUUID != sessionUUID
+}
+
+// Sorting chats of session from the most recently joined, so every storage lists them in the same order
+func SortMemberChats(chats []entities.MemberChat) {
+	slices.SortFunc(chats, func(a, b entities.MemberChat) int {
+		if c := b.JoinedAt.Compare(a.JoinedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Chat.ChatUUID, b.Chat.ChatUUID)
+	})
+}
+
+// Sorting read receipts by time of reading, the earliest first
+func SortReadReceipts(receipts []entities.ReadReceipt) {
+	slices.SortFunc(receipts, func(a, b entities.ReadReceipt) int {
+		if c := a.ReadAt.Compare(b.ReadAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.SessionUUID, b.SessionUUID)
+	})
+}
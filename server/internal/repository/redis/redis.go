@@ -5,55 +5,582 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/Rolan335/grpcMessenger/server/internal/logger"
 	"github.com/Rolan335/grpcMessenger/server/internal/repository"
 	"github.com/Rolan335/grpcMessenger/server/internal/repository/entities"
 
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 )
 
 var (
 	keyUser            = "users"        // users - set session_UUID
-	keyActiveChats     = "active_chats" // active_chats - list chat_UUID
+	keyActiveChats     = "active_chats" // active_chats - list chat_UUID, direct chats are not in it
 	keyPrefixChat      = "chat:"        // chat:{chat_UUID} - list Chat{...}
 	keyPostfixMessages = ":messages"    // chat:{chat_UUID}:messages - list message{...}
+	keyPostfixSeq      = ":seq"         // chat:{chat_UUID}:seq - number of messages ever sent to chat
+	keyPostfixMembers  = ":members"     // chat:{chat_UUID}:members - hash session_UUID -> Member{...}
+	// chat:{chat_UUID}:restrictions - hash session_UUID:kind -> Restriction{...}
+	keyPostfixRestrictions = ":restrictions"
+	// chat:{chat_UUID}:expire_lock - token of replica that deletes chat by ttl right now
+	keyPostfixExpireLock = ":expire_lock"
+	// chat_expirations - sorted set chat_UUID by time of deletion by ttl (unix ms), chats without ttl are not in it
+	keyChatExpirations = "chat_expirations"
+	// chat_expirations:sessions - hash chat_UUID -> session_UUID of creator for chats in chat_expirations
+	keyChatExpirationSessions = "chat_expirations:sessions"
+	// chat:{chat_UUID}:search - set of words of chat messages,
+	// chat:{chat_UUID}:search:{term} - sorted set seq of messages with the word by time of creation (unix µs).
+	// Trimmed messages are removed from it when search finds them
+	keyPostfixSearch = ":search"
+	// chat:{chat_UUID}:search_result - intersection of words of query, exists only while searchChatScript runs
+	keyPostfixSearchResult = ":search_result"
+	// search_chats - set chat_UUID of chats with indexed messages. Deleted chats are removed from it when search finds them
+	keySearchChats = "search_chats"
+	// chat:{chat_UUID}:attachments - hash attachment_UUID -> Attachment{...}
+	keyPostfixAttachments = ":attachments"
+	// chat:{chat_UUID}:threads - sorted set root_UUID:seq of replies with the same score, replies of root are found by ZRANGEBYLEX
+	keyPostfixThreads = ":threads"
+	// chat:{chat_UUID}:index - hash message_UUID -> seq of messages in chat:{chat_UUID}:messages and chat:{chat_UUID}:kept,
+	// message is found in list by its seq without reading the whole list
+	keyPostfixIndex = ":index"
+	// chat:{chat_UUID}:reactions - set message_UUID of messages with reactions,
+	// chat:{chat_UUID}:reactions:{message_UUID} - hash session_UUID:emoji -> 1
+	keyPostfixReactions = ":reactions"
+	// chat:{chat_UUID}:reads - hash session_UUID -> ReadPointer{...}
+	keyPostfixReads = ":reads"
+	// member_chats:{session_UUID} - set chat_UUID of chats session is a member of. Deleted chats are removed from it when they are listed
+	keyPrefixMemberChats = "member_chats:"
+	// chat:{chat_UUID}:pins - hash message_UUID -> Pin{...}
+	keyPostfixPins = ":pins"
+	// chat:{chat_UUID}:kept - list message{...} of pinned messages trimmed from chat:{chat_UUID}:messages, ordered by seq.
+	// Message stays in it until it's unpinned
+	keyPostfixKept = ":kept"
+	// chat:{chat_UUID}:scheduled - hash scheduled_UUID -> ScheduledMessage{...} of messages that are not sent yet,
+	// chat:{chat_UUID}:scheduled:{scheduled_UUID} - claim of message that is being sent or canceled, expires when lease elapses
+	keyPostfixScheduled = ":scheduled"
+	// scheduled_messages - sorted set chat_UUID:scheduled_UUID by time of sending (unix ms).
+	// Messages of deleted chats are removed from it when they are due
+	keyScheduledMessages = "scheduled_messages"
+	// message_expirations - sorted set chat_UUID:message_UUID:session_UUID by time of deletion of message (unix ms).
+	// Messages of deleted chats and trimmed messages are removed from it when they expire
+	keyMessageExpirations = "message_expirations"
+	// idempotency:{session_UUID}:{key} - hash of request sent with idempotency key: message_UUID, chat_UUID, request_hash,
+	// remembered_at (unix ms) and sent (1 when message is stored). Expires when key is forgotten
+	keyPrefixIdempotency = "idempotency:"
 )
 
+// Все ключи, в которых хранятся данные чата, вместе с реакциями на его сообщения. Удаляются вместе с чатом
+func (r *Storage) chatKeys(ctx context.Context, chatUUID string) []string {
+	keys := []string{
+		fmt.Sprintf("%s%s", keyPrefixChat, chatUUID),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixMessages),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixSeq),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixMembers),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixRestrictions),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixAttachments),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixThreads),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixIndex),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixReactions),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixReads),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixPins),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixKept),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixScheduled),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixSearch),
+	}
+	for _, v := range r.client.SMembers(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixReactions)).Val() {
+		keys = append(keys, reactionsKey(chatUUID, v))
+	}
+	for _, v := range r.client.SMembers(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixSearch)).Val() {
+		keys = append(keys, searchKey(chatUUID, v))
+	}
+	return keys
+}
+
+func reactionsKey(chatUUID string, messageUUID string) string {
+	return fmt.Sprintf("%s%s%s:%s", keyPrefixChat, chatUUID, keyPostfixReactions, messageUUID)
+}
+
+func searchKey(chatUUID string, term string) string {
+	return fmt.Sprintf("%s%s%s:%s", keyPrefixChat, chatUUID, keyPostfixSearch, term)
+}
+
+// Пустой массив cjson в addMessageScript превращает в объект, поэтому вложения без значения не пишутся.
+// ReplyTo без значения не пишется, чтобы addMessageScript мог убрать его у ответов вытесненного корня
 type Message struct {
-	MessageUUID string `json:"message_UUID"`
-	SessionUUID string `json:"session_UUID"`
-	Text        string `json:"text"`
+	MessageUUID string    `json:"message_UUID"`
+	SessionUUID string    `json:"session_UUID"`
+	Text        string    `json:"text"`
+	EditedAt    time.Time `json:"edited_at"`
+	Deleted     bool      `json:"deleted"`
+	CreatedAt   time.Time `json:"created_at"`
+	Seq         int64     `json:"seq"`
+	Attachments []string  `json:"attachments,omitempty"`
+	ReplyTo     string    `json:"reply_to,omitempty"`
+	ExpiresAt   time.Time `json:"expires_at"`
 }
 
+// Добавление сообщения одной операцией: проверка корня треда, увеличение счетчика, запись номера в сообщение, добавление в список и обрезка списка.
+// Закрепленное сообщение при обрезке переносится в список сохраненных вместе со своим тредом и реакциями.
+// Вытесненный ответ убирается из треда, ответы вытесненного корня становятся обычными сообщениями, реакции вытесненного сообщения удаляются.
+// KEYS[1] - список сообщений, KEYS[2] - счетчик, KEYS[3] - треды, KEYS[4] - сообщения с реакциями, KEYS[5] - закрепленные сообщения,
+// KEYS[6] - сохраненные закрепленные сообщения, KEYS[7] - номера сообщений, ARGV[1] - сообщение, ARGV[2] - maxChatSize,
+// ARGV[3] - корень треда или пустая строка, ARGV[4] - время удаления чата (см. expiresAtArg), его получают только созданные ключи.
+// Возвращает номер сообщения и 1, если корень треда вытеснен этим же сообщением, или {-1, 0}, если корня треда нет в чате
+var addMessageScript = redis.NewScript(`
+local function expireNew(key)
+	if ARGV[4] ~= '' and redis.call('PTTL', key) == -1 then
+		redis.call('PEXPIREAT', key, ARGV[4])
+	end
+end
+local root = ARGV[3]
+if root ~= '' and redis.call('HEXISTS', KEYS[7], root) == 0 then
+	return {-1, 0}
+end
+
+local seq = redis.call('INCR', KEYS[2])
+local message = cjson.decode(ARGV[1])
+message['seq'] = seq
+redis.call('RPUSH', KEYS[1], cjson.encode(message))
+redis.call('HSET', KEYS[7], message['message_UUID'], seq)
+if root ~= '' then
+	redis.call('ZADD', KEYS[3], 0, string.format('%s:%020d', root, seq))
+	expireNew(KEYS[3])
+end
+for _, key in ipairs({KEYS[1], KEYS[2], KEYS[7]}) do
+	expireNew(key)
+end
+
+local length = redis.call('LLEN', KEYS[1])
+local excess = length - tonumber(ARGV[2])
+if excess <= 0 then
+	return {seq, 0}
+end
+--номер первого сообщения в списке - 1
+local offset = seq - length
+local orphaned = 0
+for _, v in ipairs(redis.call('LRANGE', KEYS[1], 0, excess - 1)) do
+	local evicted = cjson.decode(v)
+	if redis.call('HEXISTS', KEYS[5], evicted['message_UUID']) == 1 then
+		redis.call('RPUSH', KEYS[6], v)
+		expireNew(KEYS[6])
+	else
+		redis.call('HDEL', KEYS[7], evicted['message_UUID'])
+		if evicted['message_UUID'] == root then
+			orphaned = 1
+		end
+		if evicted['reply_to'] then
+			redis.call('ZREM', KEYS[3], string.format('%s:%020d', evicted['reply_to'], evicted['seq']))
+		end
+		local from, to = '[' .. evicted['message_UUID'] .. ':', '[' .. evicted['message_UUID'] .. ';'
+		for _, member in ipairs(redis.call('ZRANGEBYLEX', KEYS[3], from, to)) do
+			local index = tonumber(string.sub(member, -20)) - offset - 1
+			local reply = cjson.decode(redis.call('LINDEX', KEYS[1], index))
+			reply['reply_to'] = nil
+			redis.call('LSET', KEYS[1], index, cjson.encode(reply))
+		end
+		redis.call('ZREMRANGEBYLEX', KEYS[3], from, to)
+		if redis.call('SREM', KEYS[4], evicted['message_UUID']) == 1 then
+			redis.call('DEL', KEYS[4] .. ':' .. evicted['message_UUID'])
+		end
+	end
+end
+redis.call('LTRIM', KEYS[1], excess, -1)
+return {seq, orphaned}
+`)
+
+// Чтение сообщения по номерам сообщений без чтения всего списка: индекс сообщения в списке считается по его номеру,
+// сохраненное закрепленное сообщение ищется среди сохраненных. Если ARGV[2] = 'thread', читается тред сообщения: корень треда и его ответы по порядку.
+// KEYS[1] - список сообщений, KEYS[2] - счетчик, KEYS[3] - сохраненные закрепленные сообщения, KEYS[4] - номера сообщений, KEYS[5] - треды,
+// ARGV[1] - message_UUID, ARGV[2] - 'thread' или 'message'. Возвращает найденные сообщения, пустой массив, если сообщения нет
+var getMessagesScript = redis.NewScript(`
+--номер первого сообщения в списке - 1
+local offset = tonumber(redis.call('GET', KEYS[2]) or 0) - redis.call('LLEN', KEYS[1])
+local function bySeq(seq)
+	if seq > offset then
+		return redis.call('LINDEX', KEYS[1], seq - offset - 1)
+	end
+	for _, v in ipairs(redis.call('LRANGE', KEYS[3], 0, -1)) do
+		if cjson.decode(v)['seq'] == seq then
+			return v
+		end
+	end
+	return false
+end
+local function byUUID(uuid)
+	local seq = redis.call('HGET', KEYS[4], uuid)
+	if not seq then
+		return false
+	end
+	return bySeq(tonumber(seq))
+end
+
+local message = byUUID(ARGV[1])
+if not message then
+	return {}
+end
+if ARGV[2] ~= 'thread' then
+	return {message}
+end
+local root = cjson.decode(message)['reply_to'] or ARGV[1]
+if root ~= ARGV[1] then
+	message = byUUID(root)
+	if not message then
+		return {}
+	end
+end
+local thread = {message}
+for _, member in ipairs(redis.call('ZRANGEBYLEX', KEYS[5], '[' .. root .. ':', '[' .. root .. ';')) do
+	local reply = bySeq(tonumber(string.sub(member, -20)))
+	if reply then
+		table.insert(thread, reply)
+	end
+end
+return thread
+`)
+
+// Добавление реакции, если сообщение есть в чате и не удалено. KEYS[1] - список сообщений, KEYS[2] - сообщения с реакциями,
+// KEYS[3] - реакции на сообщение, KEYS[4] - сохраненные закрепленные сообщения, ARGV[1] - message_UUID, ARGV[2] - session_UUID:emoji,
+// ARGV[3] - время удаления чата для созданных ключей. Возвращает -1, если сообщения нет, 0, если реакция уже есть, иначе 1
+var addReactionScript = redis.NewScript(`
+local function expireNew(key)
+	if ARGV[3] ~= '' and redis.call('PTTL', key) == -1 then
+		redis.call('PEXPIREAT', key, ARGV[3])
+	end
+end
+local found = false
+for _, key in ipairs({KEYS[1], KEYS[4]}) do
+	for _, v in ipairs(redis.call('LRANGE', key, 0, -1)) do
+		if string.find(v, '"message_UUID":"' .. ARGV[1] .. '"', 1, true) then
+			found = not cjson.decode(v)['deleted']
+			break
+		end
+	end
+end
+if not found then
+	return -1
+end
+redis.call('SADD', KEYS[2], ARGV[1])
+local added = redis.call('HSETNX', KEYS[3], ARGV[2], 1)
+expireNew(KEYS[2])
+expireNew(KEYS[3])
+return added
+`)
+
+// Удаление реакции, сообщение без реакций убирается из множества. KEYS[1] - сообщения с реакциями, KEYS[2] - реакции на сообщение,
+// ARGV[1] - message_UUID, ARGV[2] - session_UUID:emoji. Возвращает 1, если реакция была
+var removeReactionScript = redis.NewScript(`
+local removed = redis.call('HDEL', KEYS[2], ARGV[2])
+if redis.call('EXISTS', KEYS[2]) == 0 then
+	redis.call('SREM', KEYS[1], ARGV[1])
+end
+return removed
+`)
+
+// Закрепление сообщения, если оно есть в чате и не удалено, и закрепленных сообщений меньше максимума. KEYS[1] - список сообщений,
+// KEYS[2] - сохраненные закрепленные сообщения, KEYS[3] - закрепленные сообщения, ARGV[1] - message_UUID, ARGV[2] - Pin{...}, ARGV[3] - максимум,
+// ARGV[4] - время удаления чата для созданных ключей.
+// Возвращает -1, если сообщения нет, -2, если закреплено максимум сообщений, 0, если сообщение уже закреплено, иначе 1
+var pinMessageScript = redis.NewScript(`
+local function find(key)
+	for _, v in ipairs(redis.call('LRANGE', key, 0, -1)) do
+		if string.find(v, '"message_UUID":"' .. ARGV[1] .. '"', 1, true) then
+			return cjson.decode(v)
+		end
+	end
+	return nil
+end
+local message = find(KEYS[1]) or find(KEYS[2])
+if not message or message['deleted'] then
+	return -1
+end
+if redis.call('HEXISTS', KEYS[3], ARGV[1]) == 1 then
+	return 0
+end
+if redis.call('HLEN', KEYS[3]) >= tonumber(ARGV[3]) then
+	return -2
+end
+redis.call('HSET', KEYS[3], ARGV[1], ARGV[2])
+if ARGV[4] ~= '' and redis.call('PTTL', KEYS[3]) == -1 then
+	redis.call('PEXPIREAT', KEYS[3], ARGV[4])
+end
+return 1
+`)
+
+// Открепление сообщения. Сохраненное сообщение уже вытеснено, поэтому удаляется так же, как вытесненное в addMessageScript.
+// KEYS[1] - закрепленные сообщения, KEYS[2] - сохраненные закрепленные сообщения, KEYS[3] - список сообщений, KEYS[4] - счетчик,
+// KEYS[5] - треды, KEYS[6] - сообщения с реакциями, KEYS[7] - номера сообщений, ARGV[1] - message_UUID.
+// Возвращает 1, если сообщение было закреплено
+var unpinMessageScript = redis.NewScript(`
+if redis.call('HDEL', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+for _, v in ipairs(redis.call('LRANGE', KEYS[2], 0, -1)) do
+	local dropped = cjson.decode(v)
+	if dropped['message_UUID'] == ARGV[1] then
+		redis.call('LREM', KEYS[2], 1, v)
+		redis.call('HDEL', KEYS[7], ARGV[1])
+		if dropped['reply_to'] then
+			redis.call('ZREM', KEYS[5], string.format('%s:%020d', dropped['reply_to'], dropped['seq']))
+		end
+		--номер первого сообщения в списке - 1, ответы старше него тоже сохранены
+		local offset = tonumber(redis.call('GET', KEYS[4]) or 0) - redis.call('LLEN', KEYS[3])
+		local from, to = '[' .. ARGV[1] .. ':', '[' .. ARGV[1] .. ';'
+		for _, member in ipairs(redis.call('ZRANGEBYLEX', KEYS[5], from, to)) do
+			local seq = tonumber(string.sub(member, -20))
+			local key, index = KEYS[3], seq - offset - 1
+			if index < 0 then
+				key = KEYS[2]
+				for i, k in ipairs(redis.call('LRANGE', KEYS[2], 0, -1)) do
+					if cjson.decode(k)['seq'] == seq then
+						index = i - 1
+						break
+					end
+				end
+			end
+			if index >= 0 then
+				local reply = cjson.decode(redis.call('LINDEX', key, index))
+				reply['reply_to'] = nil
+				redis.call('LSET', key, index, cjson.encode(reply))
+			end
+		end
+		redis.call('ZREMRANGEBYLEX', KEYS[5], from, to)
+		if redis.call('SREM', KEYS[6], ARGV[1]) == 1 then
+			redis.call('DEL', KEYS[6] .. ':' .. ARGV[1])
+		end
+		break
+	end
+end
+return 1
+`)
+
+// Перемещение указателя прочтения только вперед. KEYS[1] - указатели прочтения чата, ARGV[1] - session_UUID, ARGV[2] - seq, ARGV[3] - ReadPointer{...},
+// ARGV[4] - время удаления чата для созданных ключей. Возвращает 1, если указатель передвинут
+var markReadScript = redis.NewScript(`
+local current = redis.call('HGET', KEYS[1], ARGV[1])
+if current and cjson.decode(current)['seq'] >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[3])
+if ARGV[4] ~= '' and redis.call('PTTL', KEYS[1]) == -1 then
+	redis.call('PEXPIREAT', KEYS[1], ARGV[4])
+end
+return 1
+`)
+
+// Смена роли участника, если он есть в чате. KEYS[1] - участники чата, ARGV[1] - session_UUID, ARGV[2] - роль.
+// Возвращает 0, если участника нет, иначе 1
+var setMemberRoleScript = redis.NewScript(`
+local member = redis.call('HGET', KEYS[1], ARGV[1])
+if not member then
+	return 0
+end
+local decoded = cjson.decode(member)
+decoded['role'] = tonumber(ARGV[2])
+redis.call('HSET', KEYS[1], ARGV[1], cjson.encode(decoded))
+return 1
+`)
+
+// Удаление истекшего ограничения, если его не заменили после чтения. KEYS[1] - ограничения чата, ARGV[1] - поле, ARGV[2] - прочитанное значение
+var deleteExpiredRestrictionScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], ARGV[1]) == ARGV[2] then
+	return redis.call('HDEL', KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+// Снятие блокировки, только если ее держит тот же владелец. KEYS[1] - блокировка, ARGV[1] - токен владельца
+var releaseLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// Захват отложенного сообщения, если оно есть в чате и еще не захвачено. KEYS[1] - отложенные сообщения чата, KEYS[2] - захват сообщения,
+// ARGV[1] - scheduled_UUID, ARGV[2] - время захвата (мс). Возвращает 1, если сообщение захвачено
+var claimScheduledScript = redis.NewScript(`
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+if redis.call('SET', KEYS[2], 1, 'NX', 'PX', ARGV[2]) then
+	return 1
+end
+return 0
+`)
+
+// Запоминание ключа идемпотентности, если его еще нет. KEYS[1] - ключ, ARGV[1] - message_UUID, ARGV[2] - chat_UUID, ARGV[3] - хэш запроса,
+// ARGV[4] - время запоминания (unix ms), ARGV[5] - сколько помнить ключ (мс). Возвращает поля запроса, запомненного с ключом
+var rememberIdempotencyKeyScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	redis.call('HSET', KEYS[1], 'message_UUID', ARGV[1], 'chat_UUID', ARGV[2], 'request_hash', ARGV[3], 'remembered_at', ARGV[4], 'sent', 0)
+	redis.call('PEXPIRE', KEYS[1], ARGV[5])
+end
+return redis.call('HGETALL', KEYS[1])
+`)
+
+// Изменение ключа идемпотентности, только если с ним запомнено то же сообщение: ARGV[2] = 'forget' удаляет ключ, 'sent' отмечает запрос отправленным.
+// KEYS[1] - ключ, ARGV[1] - message_UUID. Возвращает 1, если ключ изменен
+var changeIdempotencyKeyScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'message_UUID') ~= ARGV[1] then
+	return 0
+end
+if ARGV[2] == 'forget' then
+	return redis.call('DEL', KEYS[1])
+end
+redis.call('HSET', KEYS[1], 'sent', 1)
+return 1
+`)
+
+// Чат удален самим Redis по ttl: чат забывается в списках и сообщается только одной реплике, которая первой убрала его из chat_expirations.
+// KEYS[1] - chat_expirations, KEYS[2] - chat_expirations:sessions, KEYS[3] - active_chats, ARGV[1] - chat_UUID. Возвращает создателя чата или nil
+var chatExpiredScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+	return false
+end
+local session = redis.call('HGET', KEYS[2], ARGV[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+redis.call('LREM', KEYS[3], 0, ARGV[1])
+return session or ''
+`)
+
+// Страница сообщений чата, содержащих все слова запроса, от новых к старым. KEYS[1] - временный ключ пересечения, остальные - слова запроса,
+// ARGV[1] - время последнего сообщения предыдущей страницы (включительно, +inf для первой), ARGV[2] - размер страницы.
+// Сообщения с временем ARGV[1] возвращаются сверх страницы: часть из них уже была на предыдущей странице. Возвращает seq и время по очереди
+var searchChatScript = redis.NewScript(`
+for i = 2, #KEYS do
+	if redis.call('EXISTS', KEYS[i]) == 0 then
+		return {}
+	end
+end
+redis.call('ZINTERSTORE', KEYS[1], #KEYS - 1, unpack(KEYS, 2, #KEYS))
+local count = tonumber(ARGV[2]) + redis.call('ZCOUNT', KEYS[1], ARGV[1], ARGV[1])
+local found = redis.call('ZREVRANGEBYSCORE', KEYS[1], ARGV[1], '-inf', 'WITHSCORES', 'LIMIT', 0, count)
+redis.call('DEL', KEYS[1])
+return found
+`)
+
+// Индексация сообщения по словам. KEYS[1] - чаты с индексом, KEYS[2] - слова чата, KEYS[3...] - сообщения со словом по порядку слов,
+// ARGV[1] - chat_UUID, ARGV[2] - время создания сообщения, ARGV[3] - seq, ARGV[4] - время удаления чата для созданных ключей, ARGV[5...] - слова.
+// Возвращает 1, пустой ответ транзакция считала бы ошибкой redis.Nil
+var indexMessageScript = redis.NewScript(`
+local function expireNew(key)
+	if ARGV[4] ~= '' and redis.call('PTTL', key) == -1 then
+		redis.call('PEXPIREAT', key, ARGV[4])
+	end
+end
+redis.call('SADD', KEYS[1], ARGV[1])
+for i = 3, #KEYS do
+	redis.call('SADD', KEYS[2], ARGV[i + 2])
+	redis.call('ZADD', KEYS[i], ARGV[2], ARGV[3])
+	expireNew(KEYS[i])
+end
+expireNew(KEYS[2])
+return 1
+`)
+
+// Время, на которое реплика блокирует удаление чата по ttl. Блокировка снимается сама, если реплика упала
+const expireLockTTL = 10 * time.Second
+
+// Число попыток изменить отслеживаемый через WATCH ключ, если его параллельно изменили
+const updateRetries = 5
+
+// Пауза перед повторной подпиской на уведомления об истекших ключах после ошибки
+const resubscribeDelay = time.Second
+
 type Chat struct {
-	ChatUUID    string `json:"chat_UUID"`
-	SessionUUID string `json:"session_UUID"`
-	ReadOnly    bool   `json:"read_only"`
-	TTL         int    `json:"ttl"`
+	ChatUUID    string              `json:"chat_UUID"`
+	SessionUUID string              `json:"session_UUID"`
+	ReadOnly    bool                `json:"read_only"`
+	TTL         int                 `json:"ttl"`
+	CreatedAt   time.Time           `json:"created_at"`
+	Visibility  entities.Visibility `json:"visibility"`
+	Title       string              `json:"title"`
+	Description string              `json:"description"`
+	Tags        []string            `json:"tags"`
+}
+
+type Member struct {
+	JoinedAt time.Time     `json:"joined_at"`
+	Role     entities.Role `json:"role"`
+}
+
+// Until - время снятия ограничения, нулевое время - бессрочное ограничение
+type Restriction struct {
+	Until time.Time `json:"until"`
+}
+
+// Key - ключ содержимого вложения в хранилище файлов
+type Attachment struct {
+	SessionUUID string    `json:"session_UUID"`
+	Key         string    `json:"key"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
 }
 
 type User struct {
 	SessionUUID string `json:"session_UUID"`
 }
 
+// Указатель прочтения: номер последнего прочитанного сообщения и время, когда указатель передвинули
+type ReadPointer struct {
+	Seq    int64     `json:"seq"`
+	ReadAt time.Time `json:"read_at"`
+}
+
+// Закрепление сообщения: кто и когда его закрепил
+type Pin struct {
+	SessionUUID string    `json:"session_UUID"`
+	PinnedAt    time.Time `json:"pinned_at"`
+}
+
+// Отложенное сообщение, которое еще не отправлено
+type ScheduledMessage struct {
+	SessionUUID string    `json:"session_UUID"`
+	Text        string    `json:"text"`
+	SendAt      time.Time `json:"send_at"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// nativeExpiry - Redis сам удаляет ключи чатов с ttl и присылает уведомления об этом,
+// sweepInterval - как часто искать удаленные чаты, уведомления о которых пропущены,
+// ctx отменяется при закрытии хранилища, с ним останавливается подписка на уведомления
 type Storage struct {
-	MaxChatSize int
-	MaxChats    int
-	client      *redis.Client
+	MaxChatSize   int
+	MaxChats      int
+	client        *redis.Client
+	nativeExpiry  bool
+	sweepInterval time.Duration
+	onChatEvicted func(chatUUID string)
+	ctx           context.Context
+	cancel        context.CancelFunc
 }
 
+// KeyspaceEvents - включить уведомления об истекших ключах командой CONFIG SET, если на сервере они выключены.
+// Без него уведомления включаются в конфиге Redis (notify-keyspace-events Ex), иначе чаты по ttl удаляет messenger.
+// ExpiredSweepInterval - как часто искать удаленные чаты, уведомления о которых пропущены, ноль - repository.ExpirationsSyncInterval
 type Config struct {
-	Addr     string
-	Password string
-	DB       int
-	FlushAll bool
+	Addr                 string
+	Password             string
+	DB                   int
+	FlushAll             bool
+	KeyspaceEvents       bool
+	ExpiredSweepInterval time.Duration
 }
 
-var rdb *redis.Client
+var errExpiredEventsDisabled = errors.New("redis: expired keys notifications are disabled")
+
+var storage *Storage
 
 func NewStorage(cfg Config, maxChatSize int, maxChats int) *Storage {
-	rdb = redis.NewClient(&redis.Options{
+	rdb := redis.NewClient(&redis.Options{
 		Addr:     cfg.Addr,
 		Password: cfg.Password,
 		DB:       0,
@@ -65,18 +592,50 @@ func NewStorage(cfg Config, maxChatSize int, maxChats int) *Storage {
 	if cfg.FlushAll {
 		rdb.FlushAll(context.Background())
 	}
-	return &Storage{
-		MaxChatSize: maxChatSize,
-		MaxChats:    maxChats,
-		client:      rdb,
+	//Без уведомлений об истекших ключах чаты по ttl удаляет messenger
+	nativeExpiry := enableExpiredEvents(context.Background(), rdb, cfg.KeyspaceEvents) == nil
+	sweepInterval := cfg.ExpiredSweepInterval
+	if sweepInterval <= 0 {
+		sweepInterval = repository.ExpirationsSyncInterval
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	storage = &Storage{
+		MaxChatSize:   maxChatSize,
+		MaxChats:      maxChats,
+		client:        rdb,
+		nativeExpiry:  nativeExpiry,
+		sweepInterval: sweepInterval,
+		ctx:           ctx,
+		cancel:        cancel,
 	}
+	return storage
+}
+
+// Проверка уведомлений об истекших ключах (E - keyevent, x - expired). Выключенные уведомления включаются только с configure,
+// уже включенные уведомления сохраняются
+func enableExpiredEvents(ctx context.Context, client *redis.Client, configure bool) error {
+	config, err := client.ConfigGet(ctx, "notify-keyspace-events").Result()
+	if err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+	flags := config["notify-keyspace-events"]
+	if strings.Contains(flags, "E") && (strings.Contains(flags, "x") || strings.Contains(flags, "A")) {
+		return nil
+	}
+	if !configure {
+		return errExpiredEventsDisabled
+	}
+	if err := client.ConfigSet(ctx, "notify-keyspace-events", flags+"Ex").Err(); err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+	return nil
 }
 
 func (r *Storage) AddSession(sessionUUID string) {
 	r.client.SAdd(context.Background(), keyUser, sessionUUID)
 }
 
-func (r *Storage) AddChat(sessionUUID string, ttl int, readOnly bool, chatUUID string) error {
+func (r *Storage) AddChat(sessionUUID string, ttl int, readOnly bool, visibility entities.Visibility, chatUUID string, info entities.ChatInfo) error {
 	ctx := context.Background()
 	isPresent := r.client.SIsMember(ctx, keyUser, sessionUUID).Val()
 	if !isPresent {
@@ -87,115 +646,2105 @@ func (r *Storage) AddChat(sessionUUID string, ttl int, readOnly bool, chatUUID s
 	excessChats := r.client.LRange(ctx, keyActiveChats, 0, -int64(r.MaxChats)-1).Val()
 	for range excessChats {
 		chatDeleted := r.client.LPop(ctx, keyActiveChats).Val()
-		r.client.Del(ctx, fmt.Sprintf("%s%s", keyPrefixChat, chatDeleted))
-		r.client.Del(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, chatDeleted, keyPostfixMessages))
+		r.client.Del(ctx, r.chatKeys(ctx, chatDeleted)...)
+		forgetExpiration(ctx, r.client, chatDeleted)
+		if r.onChatEvicted != nil {
+			r.onChatEvicted(chatDeleted)
+		}
 	}
 
-	chatJSON, _ := json.Marshal(Chat{
+	createdAt := time.Now()
+	chat := Chat{
 		SessionUUID: sessionUUID,
 		ChatUUID:    chatUUID,
 		TTL:         ttl,
 		ReadOnly:    readOnly,
-	})
+		CreatedAt:   createdAt,
+		Visibility:  visibility,
+		Title:       info.Title,
+		Description: info.Description,
+		Tags:        info.Tags,
+	}
+	chatJSON, _ := json.Marshal(chat)
+	//Создатель чата - первый участник и владелец
+	memberJSON, _ := json.Marshal(Member{JoinedAt: createdAt, Role: entities.RoleOwner})
+	r.client.HSet(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixMembers), sessionUUID, memberJSON)
+	r.client.SAdd(ctx, keyPrefixMemberChats+sessionUUID, chatUUID)
 	r.client.Set(ctx, fmt.Sprintf("%s%s", keyPrefixChat, chatUUID), chatJSON, 0)
+	if ttl > 0 {
+		r.setExpiration(ctx, r.client, chat)
+	}
 	return nil
 }
-func (r *Storage) DeleteChat(sessionUUID string, chatUUID string) error {
+
+// Личный чат не попадает в active_chats, поэтому не вытесняется и не показывается в списке активных чатов
+func (r *Storage) AddDirectChat(sessionUUID string, peerSessionUUID string, chatUUID string) (bool, error) {
+	ctx := context.Background()
+	for _, v := range []string{sessionUUID, peerSessionUUID} {
+		if !r.client.SIsMember(ctx, keyUser, v).Val() {
+			return false, repository.ErrUserDoesntExist
+		}
+	}
+
+	createdAt := time.Now()
+	chatJSON, _ := json.Marshal(Chat{
+		SessionUUID: sessionUUID,
+		ChatUUID:    chatUUID,
+		CreatedAt:   createdAt,
+		Visibility:  entities.VisibilityDirect,
+	})
+	//Участники добавляются до чата, чтобы чат не был виден без них. HSETNX не меняет участников существующего чата
+	memberJSON, _ := json.Marshal(Member{JoinedAt: createdAt, Role: entities.RoleOwner})
+	membersKey := fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixMembers)
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSetNX(ctx, membersKey, sessionUUID, memberJSON)
+		pipe.HSetNX(ctx, membersKey, peerSessionUUID, memberJSON)
+		pipe.SAdd(ctx, keyPrefixMemberChats+sessionUUID, chatUUID)
+		pipe.SAdd(ctx, keyPrefixMemberChats+peerSessionUUID, chatUUID)
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("redis: %w", err)
+	}
+	created, err := r.client.SetNX(ctx, fmt.Sprintf("%s%s", keyPrefixChat, chatUUID), chatJSON, 0).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis: %w", err)
+	}
+	return created, nil
+}
+
+func (r *Storage) GetChat(chatUUID string) (entities.Chat, error) {
+	chat, err := getChatFromKey(context.Background(), r, chatUUID)
+	if errors.Is(err, redis.Nil) {
+		return entities.Chat{}, repository.ErrNotFound
+	}
+	if err != nil {
+		return entities.Chat{}, fmt.Errorf("redis: %w", err)
+	}
+	chatEntity := chat.toEntity()
+	chatEntity.Pinned, err = r.getPins(context.Background(), chatUUID)
+	if err != nil {
+		return entities.Chat{}, err
+	}
+	return chatEntity, nil
+}
+
+// Закрепленные сообщения чата, отсортированные repository.SortPins
+func (r *Storage) getPins(ctx context.Context, chatUUID string) ([]entities.Pin, error) {
+	pinsJSON, err := r.client.HGetAll(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixPins)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	return pinsToEntity(pinsJSON)
+}
+
+func pinsToEntity(pinsJSON map[string]string) ([]entities.Pin, error) {
+	var pins []entities.Pin
+	for messageUUID, v := range pinsJSON {
+		var pin Pin
+		if err := json.Unmarshal([]byte(v), &pin); err != nil {
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+		pins = append(pins, entities.Pin{MessageUUID: messageUUID, SessionUUID: pin.SessionUUID, PinnedAt: pin.PinnedAt})
+	}
+	repository.SortPins(pins)
+	return pins, nil
+}
+
+// Изменение чата. Ключ чата отслеживается через WATCH, чтобы не потерять одновременное изменение
+func (r *Storage) UpdateChat(chatUUID string, update entities.ChatUpdate) (entities.Chat, error) {
+	ctx := context.Background()
+	key := fmt.Sprintf("%s%s", keyPrefixChat, chatUUID)
+	var updated Chat
+	//Ошибки оборачиваются один раз после повторов
+	txf := func(tx *redis.Tx) error {
+		chatJSON, err := tx.Get(ctx, key).Result()
+		if errors.Is(err, redis.Nil) {
+			return repository.ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		var chat Chat
+		if err := json.Unmarshal([]byte(chatJSON), &chat); err != nil {
+			return err
+		}
+
+		info := entities.ChatInfo{Title: chat.Title, Description: chat.Description, Tags: chat.Tags}
+		repository.UpdateChatInfo(&info, update)
+		chat.Title, chat.Description, chat.Tags = info.Title, info.Description, info.Tags
+		if update.TTL != nil {
+			chat.TTL = *update.TTL
+		}
+		if update.ReadOnly != nil {
+			chat.ReadOnly = *update.ReadOnly
+		}
+		newChatJSON, _ := json.Marshal(chat)
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.SetArgs(ctx, key, newChatJSON, redis.SetArgs{KeepTTL: true})
+			if update.TTL != nil {
+				r.setExpiration(ctx, pipe, chat)
+			}
+			return nil
+		})
+		updated = chat
+		return err
+	}
+
+	var err error
+	for range updateRetries {
+		err = r.client.Watch(ctx, txf, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+	}
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return entities.Chat{}, err
+		}
+		return entities.Chat{}, fmt.Errorf("redis: %w", err)
+	}
+	chat := updated.toEntity()
+	chat.Pinned, err = r.getPins(ctx, chatUUID)
+	if err != nil {
+		return entities.Chat{}, err
+	}
+	return chat, nil
+}
+
+func (r *Storage) DeleteChat(chatUUID string) error {
 	ctx := context.Background()
 	chat, err := getChatFromKey(ctx, r, chatUUID)
 	if errors.Is(err, redis.Nil) {
 		return repository.ErrNotFound
 	}
-	if chat.SessionUUID != sessionUUID {
-		return repository.ErrProhibited
+	if err != nil {
+		return fmt.Errorf("redis: %w", err)
 	}
 
 	r.client.LRem(ctx, keyActiveChats, 0, chat.ChatUUID)
-	r.client.Del(ctx, fmt.Sprintf("%s%s", keyPrefixChat, chat.ChatUUID))
-	r.client.Del(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, chat.ChatUUID, keyPostfixMessages))
+	r.client.Del(ctx, r.chatKeys(ctx, chat.ChatUUID)...)
+	forgetExpiration(ctx, r.client, chat.ChatUUID)
 
 	return nil
 }
 
-func (r *Storage) AddMessage(sessionUUID string, chatUUID string, messageUUID string, message string) error {
+func (r *Storage) AddMessage(chatUUID string, message entities.Message) (entities.Message, error) {
 	ctx := context.Background()
 	chat, err := getChatFromKey(ctx, r, chatUUID)
 	if errors.Is(err, redis.Nil) {
-		return repository.ErrNotFound
+		return entities.Message{}, repository.ErrNotFound
 	}
-	if !r.client.SIsMember(ctx, keyUser, sessionUUID).Val() {
-		return repository.ErrUserDoesntExist
+	if err != nil {
+		return entities.Message{}, fmt.Errorf("redis: %w", err)
 	}
-	if chat.ReadOnly && chat.SessionUUID != sessionUUID {
-		return repository.ErrProhibited
+	if !r.client.SIsMember(ctx, keyUser, message.SessionUUID).Val() {
+		return entities.Message{}, repository.ErrUserDoesntExist
 	}
 
-	messageJSON, _ := json.Marshal(Message{
-		MessageUUID: messageUUID,
-		SessionUUID: sessionUUID,
-		Text:        message,
-	})
+	newMessage := Message{
+		MessageUUID: message.MessageUUID,
+		SessionUUID: message.SessionUUID,
+		Text:        message.Text,
+		CreatedAt:   time.Now(),
+		Attachments: message.Attachments,
+		ReplyTo:     message.ReplyTo,
+		ExpiresAt:   message.ExpiresAt,
+	}
+	messageJSON, _ := json.Marshal(newMessage)
 
-	r.client.RPush(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, chat.ChatUUID, keyPostfixMessages), messageJSON)
+	//Счетчик сообщений меняется вместе со списком, по нему считается абсолютная позиция сообщения для пагинации
 	//Удаление Сообщения если больше maxChatSize (LRU)
-	r.client.LTrim(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, chat.ChatUUID, keyPostfixMessages), -int64(r.MaxChatSize), -1)
+	expiresAt := r.expiresAtArg(chat)
+	added, err := addMessageScript.Run(ctx, r.client, []string{
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chat.ChatUUID, keyPostfixMessages),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chat.ChatUUID, keyPostfixSeq),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chat.ChatUUID, keyPostfixThreads),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chat.ChatUUID, keyPostfixReactions),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chat.ChatUUID, keyPostfixPins),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chat.ChatUUID, keyPostfixKept),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chat.ChatUUID, keyPostfixIndex),
+	}, messageJSON, r.MaxChatSize, message.ReplyTo, expiresAt).Int64Slice()
+	if err != nil {
+		return entities.Message{}, fmt.Errorf("redis: %w", err)
+	}
+	if added[0] < 0 {
+		return entities.Message{}, repository.ErrMessageNotFound
+	}
+	newMessage.Seq = added[0]
+	//Корень, вытесненный самим ответом, оставляет его без треда
+	if added[1] == 1 {
+		newMessage.ReplyTo = ""
+	}
+	r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		indexMessage(ctx, pipe, chat.ChatUUID, newMessage, expiresAt)
+		if !newMessage.ExpiresAt.IsZero() {
+			pipe.ZAdd(ctx, keyMessageExpirations, redis.Z{
+				Score:  expirationScore(newMessage.ExpiresAt),
+				Member: messageExpirationMember(chat.ChatUUID, newMessage),
+			})
+		}
+		return nil
+	})
 
-	return nil
+	return newMessage.toEntity(), nil
 }
 
+// Ошибки не оборачиваются, их оборачивает вызывающий. redis.Nil - чата нет
 func getChatFromKey(ctx context.Context, r *Storage, chatUUID string) (Chat, error) {
 	chat, err := r.client.Get(ctx, fmt.Sprintf("%s%s", keyPrefixChat, chatUUID)).Result()
 	if err != nil {
-		return Chat{}, fmt.Errorf("redis: %w", err)
+		return Chat{}, err
 	}
 	var chatUnmarshalled Chat
 	err = json.Unmarshal([]byte(chat), &chatUnmarshalled)
 	if err != nil {
-		return Chat{}, fmt.Errorf("redis: %w", err)
+		return Chat{}, err
 	}
 	return chatUnmarshalled, nil
 }
 
-func (r *Storage) GetHistory(chatUUID string) (history []entities.Message, err error) {
+// Вложения отслеживаются через WATCH, чтобы квоту не превысили параллельные загрузки
+func (r *Storage) AddAttachment(chatUUID string, attachment entities.Attachment, quota int64) error {
+	ctx := context.Background()
+	chat, err := getChatFromKey(ctx, r, chatUUID)
+	if errors.Is(err, redis.Nil) {
+		return repository.ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+	attachmentJSON, _ := json.Marshal(Attachment{
+		SessionUUID: attachment.SessionUUID,
+		Key:         attachment.Key,
+		Name:        attachment.Name,
+		ContentType: attachment.ContentType,
+		Size:        attachment.Size,
+		CreatedAt:   attachment.CreatedAt,
+	})
+	key := fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixAttachments)
+	//Ошибки оборачиваются один раз после повторов
+	txf := func(tx *redis.Tx) error {
+		if quota > 0 {
+			attachments, err := tx.HVals(ctx, key).Result()
+			if err != nil {
+				return err
+			}
+			used := attachment.Size
+			for _, v := range attachments {
+				var stored Attachment
+				if err := json.Unmarshal([]byte(v), &stored); err != nil {
+					return err
+				}
+				used += stored.Size
+			}
+			if used > quota {
+				return repository.ErrAttachmentQuotaExceeded
+			}
+		}
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, key, attachment.ID, attachmentJSON)
+			//Вложения создаются после чата, время удаления чата ставится и им
+			expireNewKey(ctx, pipe, key, r.expiresAtArg(chat))
+			return nil
+		})
+		return err
+	}
+
+	for range updateRetries {
+		err = r.client.Watch(ctx, txf, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+	}
+	if err != nil {
+		if errors.Is(err, repository.ErrAttachmentQuotaExceeded) {
+			return err
+		}
+		return fmt.Errorf("redis: %w", err)
+	}
+	return nil
+}
+
+func (r *Storage) GetAttachment(chatUUID string, attachmentID string) (entities.Attachment, error) {
 	ctx := context.Background()
-	messages, err := r.client.LRange(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixMessages), 0, -1).Result()
+	if r.client.Exists(ctx, fmt.Sprintf("%s%s", keyPrefixChat, chatUUID)).Val() == 0 {
+		return entities.Attachment{}, repository.ErrNotFound
+	}
+	attachmentJSON, err := r.client.HGet(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixAttachments), attachmentID).Result()
 	if errors.Is(err, redis.Nil) {
+		return entities.Attachment{}, repository.ErrAttachmentNotFound
+	}
+	if err != nil {
+		return entities.Attachment{}, fmt.Errorf("redis: %w", err)
+	}
+	var attachment Attachment
+	if err := json.Unmarshal([]byte(attachmentJSON), &attachment); err != nil {
+		return entities.Attachment{}, fmt.Errorf("redis: %w", err)
+	}
+	return attachment.toEntity(attachmentID), nil
+}
+
+func (r *Storage) GetAttachments(chatUUID string) ([]entities.Attachment, error) {
+	ctx := context.Background()
+	if r.client.Exists(ctx, fmt.Sprintf("%s%s", keyPrefixChat, chatUUID)).Val() == 0 {
 		return nil, repository.ErrNotFound
 	}
-	for _, v := range messages {
-		var message Message
-		err := json.Unmarshal([]byte(v), &message)
+	attachmentsJSON, err := r.client.HGetAll(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixAttachments)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	attachments, err := unmarshalAttachments(attachmentsJSON)
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	return attachments, nil
+}
+
+// Списки сообщений и вложения отслеживаются через WATCH, чтобы вложение не удалили, пока на него ссылается новое сообщение
+func (r *Storage) DeleteUnreferencedAttachments(chatUUID string, ids []string, uploadedBefore time.Time) ([]entities.Attachment, error) {
+	ctx := context.Background()
+	if r.client.Exists(ctx, fmt.Sprintf("%s%s", keyPrefixChat, chatUUID)).Val() == 0 {
+		return nil, repository.ErrNotFound
+	}
+
+	key := fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixAttachments)
+	messagesKey := fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixMessages)
+	keptKey := fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixKept)
+	var deleted []entities.Attachment
+	//Ошибки оборачиваются один раз после повторов
+	txf := func(tx *redis.Tx) error {
+		deleted = nil
+		attachmentsJSON, err := tx.HGetAll(ctx, key).Result()
 		if err != nil {
-			return nil, fmt.Errorf("redis: %w", err)
+			return err
+		}
+		attachments, err := unmarshalAttachments(attachmentsJSON)
+		if err != nil {
+			return err
 		}
-		history = append(history, entities.Message{
-			SessionUUID: message.SessionUUID,
-			MessageUUID: message.MessageUUID,
-			Text:        message.Text,
+		referenced := make(map[string]struct{})
+		for _, listKey := range []string{messagesKey, keptKey} {
+			messagesJSON, err := tx.LRange(ctx, listKey, 0, -1).Result()
+			if err != nil {
+				return err
+			}
+			for _, messageJSON := range messagesJSON {
+				var message Message
+				if err := json.Unmarshal([]byte(messageJSON), &message); err != nil {
+					return err
+				}
+				for _, v := range message.Attachments {
+					referenced[v] = struct{}{}
+				}
+			}
+		}
+		for _, v := range attachments {
+			if _, ok := referenced[v.ID]; ok || !v.CreatedAt.Before(uploadedBefore) || (ids != nil && !slices.Contains(ids, v.ID)) {
+				continue
+			}
+			deleted = append(deleted, v)
+		}
+		if len(deleted) == 0 {
+			return nil
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, v := range deleted {
+				pipe.HDel(ctx, key, v.ID)
+			}
+			return nil
 		})
+		return err
+	}
+
+	var err error
+	for range updateRetries {
+		err = r.client.Watch(ctx, txf, key, messagesKey, keptKey)
+		if !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	return deleted, nil
+}
+
+func unmarshalAttachments(attachmentsJSON map[string]string) ([]entities.Attachment, error) {
+	attachments := make([]entities.Attachment, 0, len(attachmentsJSON))
+	for id, v := range attachmentsJSON {
+		var attachment Attachment
+		if err := json.Unmarshal([]byte(v), &attachment); err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment.toEntity(id))
 	}
-	return
+	return attachments, nil
 }
-func (r *Storage) GetActiveChats() (chats []entities.Chat) {
+
+func (a Attachment) toEntity(attachmentID string) entities.Attachment {
+	return entities.Attachment{
+		ID:          attachmentID,
+		SessionUUID: a.SessionUUID,
+		Key:         a.Key,
+		Name:        a.Name,
+		ContentType: a.ContentType,
+		Size:        a.Size,
+		CreatedAt:   a.CreatedAt,
+	}
+}
+
+func (r *Storage) JoinChat(sessionUUID string, chatUUID string) error {
 	ctx := context.Background()
-	chatsUUID := r.client.LRange(ctx, keyActiveChats, 0, -1).Val()
-	for _, v := range chatsUUID {
-		chat, _ := getChatFromKey(ctx, r, v)
-		chats = append(chats, entities.Chat{
-			SessionUUID: chat.SessionUUID,
-			ChatUUID:    chat.ChatUUID,
-			ReadOnly:    chat.ReadOnly,
-			TTL:         chat.TTL,
-		})
+	_, err := getChatFromKey(ctx, r, chatUUID)
+	if errors.Is(err, redis.Nil) {
+		return repository.ErrNotFound
 	}
-	return
+	if err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+	if !r.client.SIsMember(ctx, keyUser, sessionUUID).Val() {
+		return repository.ErrUserDoesntExist
+	}
+
+	//HSETNX - повторное вступление не меняет время вступления и роль
+	memberJSON, _ := json.Marshal(Member{JoinedAt: time.Now(), Role: entities.RoleMember})
+	_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSetNX(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixMembers), sessionUUID, memberJSON)
+		pipe.SAdd(ctx, keyPrefixMemberChats+sessionUUID, chatUUID)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+	return nil
+}
+
+func (r *Storage) LeaveChat(sessionUUID string, chatUUID string) error {
+	ctx := context.Background()
+	if r.client.Exists(ctx, fmt.Sprintf("%s%s", keyPrefixChat, chatUUID)).Val() == 0 {
+		return repository.ErrNotFound
+	}
+
+	deleted, err := r.client.HDel(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixMembers), sessionUUID).Result()
+	if err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+	if deleted == 0 {
+		return repository.ErrNotMember
+	}
+	r.client.SRem(ctx, keyPrefixMemberChats+sessionUUID, chatUUID)
+	return nil
+}
+
+func (r *Storage) GetMember(sessionUUID string, chatUUID string) (entities.Member, error) {
+	ctx := context.Background()
+	var exists *redis.IntCmd
+	var memberJSON *redis.StringCmd
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		exists = pipe.Exists(ctx, fmt.Sprintf("%s%s", keyPrefixChat, chatUUID))
+		memberJSON = pipe.HGet(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixMembers), sessionUUID)
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return entities.Member{}, fmt.Errorf("redis: %w", err)
+	}
+	if exists.Val() == 0 {
+		return entities.Member{}, repository.ErrNotFound
+	}
+	if errors.Is(memberJSON.Err(), redis.Nil) {
+		return entities.Member{}, repository.ErrNotMember
+	}
+
+	var member Member
+	if err := json.Unmarshal([]byte(memberJSON.Val()), &member); err != nil {
+		return entities.Member{}, fmt.Errorf("redis: %w", err)
+	}
+	return member.toEntity(sessionUUID), nil
+}
+
+func (r *Storage) GetParticipants(chatUUID string) ([]entities.Member, error) {
+	ctx := context.Background()
+	if r.client.Exists(ctx, fmt.Sprintf("%s%s", keyPrefixChat, chatUUID)).Val() == 0 {
+		return nil, repository.ErrNotFound
+	}
+
+	membersJSON, err := r.client.HGetAll(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixMembers)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	members := make([]entities.Member, 0, len(membersJSON))
+	for memberSessionUUID, v := range membersJSON {
+		var member Member
+		if err := json.Unmarshal([]byte(v), &member); err != nil {
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+		members = append(members, member.toEntity(memberSessionUUID))
+	}
+	repository.SortMembers(members)
+	return members, nil
+}
+
+func (r *Storage) SetMemberRole(sessionUUID string, chatUUID string, role entities.Role) error {
+	ctx := context.Background()
+	if r.client.Exists(ctx, fmt.Sprintf("%s%s", keyPrefixChat, chatUUID)).Val() == 0 {
+		return repository.ErrNotFound
+	}
+
+	//Роль меняется скриптом, чтобы не вернуть в чат участника, который вышел из него между чтением и записью
+	changed, err := setMemberRoleScript.Run(ctx, r.client, []string{
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixMembers),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixRestrictions),
+	}, sessionUUID, int(role)).Int()
+	if err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+	if changed == 0 {
+		return repository.ErrNotMember
+	}
+	return nil
+}
+
+func (r *Storage) Restrict(chatUUID string, restriction entities.Restriction) error {
+	ctx := context.Background()
+	if r.client.Exists(ctx, fmt.Sprintf("%s%s", keyPrefixChat, chatUUID)).Val() == 0 {
+		return repository.ErrNotFound
+	}
+	if !r.client.SIsMember(ctx, keyUser, restriction.SessionUUID).Val() {
+		return repository.ErrUserDoesntExist
+	}
+
+	restrictionJSON, _ := json.Marshal(Restriction{Until: restriction.Until})
+	err := r.client.HSet(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixRestrictions),
+		restrictionField(restriction.SessionUUID, restriction.Kind), restrictionJSON).Err()
+	if err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+	return nil
+}
+
+func (r *Storage) Unrestrict(sessionUUID string, chatUUID string, kind entities.RestrictionKind) error {
+	ctx := context.Background()
+	if r.client.Exists(ctx, fmt.Sprintf("%s%s", keyPrefixChat, chatUUID)).Val() == 0 {
+		return repository.ErrNotFound
+	}
+
+	err := r.client.HDel(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixRestrictions), restrictionField(sessionUUID, kind)).Err()
+	if err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+	return nil
+}
+
+// Expired restrictions are removed from hash when they are read
+func (r *Storage) GetRestrictions(sessionUUID string, chatUUID string) ([]entities.Restriction, error) {
+	ctx := context.Background()
+	kinds := []entities.RestrictionKind{entities.RestrictionBan, entities.RestrictionMute}
+	fields := make([]string, 0, len(kinds))
+	for _, kind := range kinds {
+		fields = append(fields, restrictionField(sessionUUID, kind))
+	}
+	key := fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixRestrictions)
+
+	var exists *redis.IntCmd
+	var values *redis.SliceCmd
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		exists = pipe.Exists(ctx, fmt.Sprintf("%s%s", keyPrefixChat, chatUUID))
+		values = pipe.HMGet(ctx, key, fields...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	if exists.Val() == 0 {
+		return nil, repository.ErrNotFound
+	}
+
+	now := time.Now()
+	var restrictions []entities.Restriction
+	for i, v := range values.Val() {
+		restrictionJSON, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var stored Restriction
+		if err := json.Unmarshal([]byte(restrictionJSON), &stored); err != nil {
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+		restriction := entities.Restriction{SessionUUID: sessionUUID, Kind: kinds[i], Until: stored.Until}
+		if repository.RestrictionExpired(restriction, now) {
+			deleteExpiredRestrictionScript.Run(ctx, r.client, []string{key}, fields[i], restrictionJSON)
+			continue
+		}
+		restrictions = append(restrictions, restriction)
+	}
+	return restrictions, nil
+}
+
+func restrictionField(sessionUUID string, kind entities.RestrictionKind) string {
+	return fmt.Sprintf("%s:%d", sessionUUID, kind)
+}
+
+func (r *Storage) MarkRead(chatUUID string, sessionUUID string, seq int64) error {
+	ctx := context.Background()
+	chat, err := getChatFromKey(ctx, r, chatUUID)
+	if errors.Is(err, redis.Nil) {
+		return repository.ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+	if !r.client.SIsMember(ctx, keyUser, sessionUUID).Val() {
+		return repository.ErrUserDoesntExist
+	}
+
+	key := fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixReads)
+	pointerJSON, _ := json.Marshal(ReadPointer{Seq: seq, ReadAt: time.Now()})
+	//Указатели прочтения создаются после чата, время удаления чата ставится и им
+	if err := markReadScript.Run(ctx, r.client, []string{key}, sessionUUID, seq, pointerJSON, r.expiresAtArg(chat)).Err(); err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+	return nil
+}
+
+// Чаты юзера находятся по member_chats, непрочитанные сообщения считаются по списку сообщений чата
+func (r *Storage) GetMemberChats(sessionUUID string) ([]entities.MemberChat, error) {
+	ctx := context.Background()
+	chatUUIDs, err := r.client.SMembers(ctx, keyPrefixMemberChats+sessionUUID).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+
+	type memberChat struct {
+		chat     *redis.StringCmd
+		member   *redis.StringCmd
+		pointer  *redis.StringCmd
+		messages *redis.StringSliceCmd
+		kept     *redis.StringSliceCmd
+		pins     *redis.MapStringStringCmd
+	}
+	loaded := make([]memberChat, len(chatUUIDs))
+	_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, v := range chatUUIDs {
+			loaded[i] = memberChat{
+				chat:     pipe.Get(ctx, fmt.Sprintf("%s%s", keyPrefixChat, v)),
+				member:   pipe.HGet(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, v, keyPostfixMembers), sessionUUID),
+				pointer:  pipe.HGet(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, v, keyPostfixReads), sessionUUID),
+				messages: pipe.LRange(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, v, keyPostfixMessages), 0, -1),
+				kept:     pipe.LRange(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, v, keyPostfixKept), 0, -1),
+				pins:     pipe.HGetAll(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, v, keyPostfixPins)),
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+
+	var chats []entities.MemberChat
+	var stale []any
+	for i, v := range loaded {
+		//Чат удален или юзер вышел из него
+		if errors.Is(v.chat.Err(), redis.Nil) || errors.Is(v.member.Err(), redis.Nil) {
+			stale = append(stale, chatUUIDs[i])
+			continue
+		}
+		var chat Chat
+		var member Member
+		var pointer ReadPointer
+		if err := json.Unmarshal([]byte(v.chat.Val()), &chat); err != nil {
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+		if err := json.Unmarshal([]byte(v.member.Val()), &member); err != nil {
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+		if v.pointer.Err() == nil {
+			if err := json.Unmarshal([]byte(v.pointer.Val()), &pointer); err != nil {
+				return nil, fmt.Errorf("redis: %w", err)
+			}
+		}
+		unread := 0
+		messages := append(v.kept.Val(), v.messages.Val()...)
+		for _, messageJSON := range messages {
+			var message Message
+			if err := json.Unmarshal([]byte(messageJSON), &message); err != nil {
+				return nil, fmt.Errorf("redis: %w", err)
+			}
+			if repository.Unread(message.toEntity(), sessionUUID, pointer.Seq) {
+				unread++
+			}
+		}
+		chatEntity := chat.toEntity()
+		chatEntity.MessageCount = len(messages)
+		chatEntity.Pinned, err = pinsToEntity(v.pins.Val())
+		if err != nil {
+			return nil, err
+		}
+		chats = append(chats, entities.MemberChat{Chat: chatEntity, JoinedAt: member.JoinedAt, UnreadCount: unread})
+	}
+	if len(stale) > 0 {
+		r.client.SRem(ctx, keyPrefixMemberChats+sessionUUID, stale...)
+	}
+	repository.SortMemberChats(chats)
+	return chats, nil
+}
+
+func (r *Storage) GetReadReceipts(chatUUID string, seq int64) ([]entities.ReadReceipt, error) {
+	ctx := context.Background()
+	if r.client.Exists(ctx, fmt.Sprintf("%s%s", keyPrefixChat, chatUUID)).Val() == 0 {
+		return nil, repository.ErrNotFound
+	}
+
+	pointersJSON, err := r.client.HGetAll(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixReads)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	var receipts []entities.ReadReceipt
+	for sessionUUID, v := range pointersJSON {
+		var pointer ReadPointer
+		if err := json.Unmarshal([]byte(v), &pointer); err != nil {
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+		if pointer.Seq >= seq {
+			receipts = append(receipts, entities.ReadReceipt{SessionUUID: sessionUUID, Seq: pointer.Seq, ReadAt: pointer.ReadAt})
+		}
+	}
+	repository.SortReadReceipts(receipts)
+	return receipts, nil
+}
+
+func (m Member) toEntity(sessionUUID string) entities.Member {
+	return entities.Member{
+		SessionUUID: sessionUUID,
+		JoinedAt:    m.JoinedAt,
+		Role:        m.Role,
+	}
+}
+
+func (r *Storage) GetHistory(chatUUID string, page entities.Page) (history []entities.Message, nextCursor string, err error) {
+	ctx := context.Background()
+	if r.client.Exists(ctx, fmt.Sprintf("%s%s", keyPrefixChat, chatUUID)).Val() == 0 {
+		return nil, "", repository.ErrNotFound
+	}
+
+	messagesKey := fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixMessages)
+	seqKey := fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixSeq)
+	keptKey := fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixKept)
+	//Индексы страницы в списках считаются по их длине, поэтому списки отслеживаются через WATCH: если сообщение добавили
+	//или список обрезали между подсчетом и чтением страницы, страница читается заново. Ошибки оборачиваются один раз после повторов
+	txf := func(tx *redis.Tx) error {
+		history, nextCursor = nil, ""
+		//Позиция первого сообщения в списке = всего сообщений - длина списка. Сохраненные закрепленные сообщения идут перед списком
+		var length *redis.IntCmd
+		var seq *redis.StringCmd
+		var keptJSON *redis.StringSliceCmd
+		_, err := tx.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			length = pipe.LLen(ctx, messagesKey)
+			seq = pipe.Get(ctx, seqKey)
+			keptJSON = pipe.LRange(ctx, keptKey, 0, -1)
+			return nil
+		})
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		total, _ := seq.Int64()
+		first := total - length.Val()
+		keptPositions := make([]int64, 0, len(keptJSON.Val()))
+		for _, v := range keptJSON.Val() {
+			var message Message
+			if err := json.Unmarshal([]byte(v), &message); err != nil {
+				return err
+			}
+			keptPositions = append(keptPositions, message.Seq-1)
+		}
+		kept := len(keptPositions)
+
+		from, to, next, err := repository.PageRange(repository.Positions(keptPositions, first, length.Val()), page)
+		if err != nil {
+			return err
+		}
+		nextCursor = next
+		if from == to {
+			return nil
+		}
+
+		//Страница читается в MULTI, который не выполнится, если списки изменились после WATCH
+		var keptPage, messagesPage *redis.StringSliceCmd
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if from < kept {
+				keptPage = pipe.LRange(ctx, keptKey, int64(from), int64(min(to, kept)-1))
+			}
+			if to > kept {
+				messagesPage = pipe.LRange(ctx, messagesKey, int64(max(from, kept)-kept), int64(to-kept-1))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, cmd := range []*redis.StringSliceCmd{keptPage, messagesPage} {
+			if cmd == nil {
+				continue
+			}
+			for _, v := range cmd.Val() {
+				var message Message
+				if err := json.Unmarshal([]byte(v), &message); err != nil {
+					return err
+				}
+				history = append(history, message.toEntity())
+			}
+		}
+		return nil
+	}
+
+	for range updateRetries {
+		err = r.client.Watch(ctx, txf, messagesKey, seqKey, keptKey)
+		if !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+	}
+	if errors.Is(err, repository.ErrInvalidCursor) {
+		return nil, "", err
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("redis: %w", err)
+	}
+	if len(history) == 0 {
+		return nil, nextCursor, nil
+	}
+	if err := r.countReplies(ctx, chatUUID, history); err != nil {
+		return nil, "", err
+	}
+	return history, nextCursor, nil
+}
+
+// Заполнение количества ответов у сообщений, которые не являются ответами, по индексу тредов
+func (r *Storage) countReplies(ctx context.Context, chatUUID string, messages []entities.Message) error {
+	key := fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixThreads)
+	counts := make([]*redis.IntCmd, len(messages))
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, v := range messages {
+			if v.ReplyTo == "" {
+				from, to := threadRange(v.MessageUUID)
+				counts[i] = pipe.ZLexCount(ctx, key, from, to)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+	for i, v := range counts {
+		if v != nil {
+			messages[i].ReplyCount = int(v.Val())
+		}
+	}
+	return nil
+}
+
+func unmarshalMessages(messagesJSON []string) ([]Message, error) {
+	messages := make([]Message, 0, len(messagesJSON))
+	for _, v := range messagesJSON {
+		var message Message
+		if err := json.Unmarshal([]byte(v), &message); err != nil {
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// Тред читается по номерам его сообщений, корень - первое сообщение треда
+func (r *Storage) GetThread(chatUUID string, messageUUID string) ([]entities.Message, error) {
+	ctx := context.Background()
+	if r.client.Exists(ctx, fmt.Sprintf("%s%s", keyPrefixChat, chatUUID)).Val() == 0 {
+		return nil, repository.ErrNotFound
+	}
+
+	messages, err := r.getMessages(ctx, chatUUID, messageUUID, "thread")
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, repository.ErrMessageNotFound
+	}
+	thread := make([]entities.Message, 0, len(messages))
+	for _, v := range messages {
+		thread = append(thread, v.toEntity())
+	}
+	thread[0].ReplyCount = len(thread) - 1
+	return thread, nil
+}
+
+// Ответы на корень треда в chat:{chat_UUID}:threads
+func threadRange(rootUUID string) (string, string) {
+	return "[" + rootUUID + ":", "[" + rootUUID + ";"
+}
+
+// Getting message of chat. Deleted message is not found
+func (r *Storage) GetMessage(chatUUID string, messageUUID string) (entities.Message, error) {
+	ctx := context.Background()
+	if r.client.Exists(ctx, fmt.Sprintf("%s%s", keyPrefixChat, chatUUID)).Val() == 0 {
+		return entities.Message{}, repository.ErrNotFound
+	}
+
+	messages, err := r.getMessages(ctx, chatUUID, messageUUID, "message")
+	if err != nil {
+		return entities.Message{}, err
+	}
+	if len(messages) == 0 || messages[0].Deleted {
+		return entities.Message{}, repository.ErrMessageNotFound
+	}
+	return messages[0].toEntity(), nil
+}
+
+// Сообщение или тред сообщения из getMessagesScript, mode - 'message' или 'thread'
+func (r *Storage) getMessages(ctx context.Context, chatUUID string, messageUUID string, mode string) ([]Message, error) {
+	messagesJSON, err := getMessagesScript.Run(ctx, r.client, []string{
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixMessages),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixSeq),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixKept),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixIndex),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixThreads),
+	}, messageUUID, mode).StringSlice()
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	return unmarshalMessages(messagesJSON)
+}
+
+func (r *Storage) EditMessage(chatUUID string, messageUUID string, text string) (entities.Message, error) {
+	return r.updateMessage(chatUUID, messageUUID, func(message *Message) {
+		message.Text = text
+		message.EditedAt = time.Now()
+	})
+}
+
+func (r *Storage) DeleteMessage(chatUUID string, messageUUID string) (entities.Message, error) {
+	return r.updateMessage(chatUUID, messageUUID, func(message *Message) {
+		//Оставляем пустое сообщение вместо удаленного, чтобы позиции сообщений не менялись
+		message.Text = ""
+		message.Attachments = nil
+		message.Deleted = true
+	})
+}
+
+// Изменение сообщения в списке или в сохраненных закрепленных сообщениях. Списки отслеживаются через WATCH,
+// чтобы индекс сообщения не сдвинулся до LSET
+func (r *Storage) updateMessage(chatUUID string, messageUUID string, update func(message *Message)) (entities.Message, error) {
+	ctx := context.Background()
+	chat, err := getChatFromKey(ctx, r, chatUUID)
+	if errors.Is(err, redis.Nil) {
+		return entities.Message{}, repository.ErrNotFound
+	}
+	if err != nil {
+		return entities.Message{}, fmt.Errorf("redis: %w", err)
+	}
+
+	key := fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixMessages)
+	keptKey := fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixKept)
+	var updated Message
+	//Ошибки оборачиваются один раз после повторов
+	txf := func(tx *redis.Tx) error {
+		for _, listKey := range []string{key, keptKey} {
+			messages, err := tx.LRange(ctx, listKey, 0, -1).Result()
+			if err != nil {
+				return err
+			}
+			for i, v := range messages {
+				var message Message
+				if err := json.Unmarshal([]byte(v), &message); err != nil {
+					return err
+				}
+				if message.MessageUUID != messageUUID {
+					continue
+				}
+				if message.Deleted {
+					return repository.ErrMessageNotFound
+				}
+				old := message
+				update(&message)
+				messageJSON, _ := json.Marshal(message)
+				//Сообщение индексируется заново по новому тексту, удаленное сообщение не индексируется
+				_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+					pipe.LSet(ctx, listKey, int64(i), messageJSON)
+					unindexMessage(ctx, pipe, chatUUID, old)
+					if !message.Deleted {
+						indexMessage(ctx, pipe, chatUUID, message, r.expiresAtArg(chat))
+					} else {
+						//Реакции и закрепление удаляются вместе с сообщением, сохраненное сообщение удаляется совсем
+						pipe.SRem(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixReactions), messageUUID)
+						pipe.Del(ctx, reactionsKey(chatUUID, messageUUID))
+						unpinMessageScript.Eval(ctx, pipe, r.unpinKeys(chatUUID), messageUUID)
+						pipe.ZRem(ctx, keyMessageExpirations, messageExpirationMember(chatUUID, message))
+					}
+					return nil
+				})
+				updated = message
+				return err
+			}
+		}
+		return repository.ErrMessageNotFound
+	}
+
+	for range updateRetries {
+		err = r.client.Watch(ctx, txf, key, keptKey)
+		if !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+	}
+	if err != nil {
+		if errors.Is(err, repository.ErrMessageNotFound) {
+			return entities.Message{}, err
+		}
+		return entities.Message{}, fmt.Errorf("redis: %w", err)
+	}
+	return updated.toEntity(), nil
+}
+
+func (r *Storage) AddReaction(chatUUID string, messageUUID string, sessionUUID string, emoji string) (bool, error) {
+	ctx := context.Background()
+	chat, err := getChatFromKey(ctx, r, chatUUID)
+	if errors.Is(err, redis.Nil) {
+		return false, repository.ErrNotFound
+	}
+	if err != nil {
+		return false, fmt.Errorf("redis: %w", err)
+	}
+	if !r.client.SIsMember(ctx, keyUser, sessionUUID).Val() {
+		return false, repository.ErrUserDoesntExist
+	}
+
+	added, err := addReactionScript.Run(ctx, r.client, []string{
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixMessages),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixReactions),
+		reactionsKey(chatUUID, messageUUID),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixKept),
+	}, messageUUID, reactionField(sessionUUID, emoji), r.expiresAtArg(chat)).Int()
+	if err != nil {
+		return false, fmt.Errorf("redis: %w", err)
+	}
+	if added < 0 {
+		return false, repository.ErrMessageNotFound
+	}
+	return added == 1, nil
+}
+
+func (r *Storage) PinMessage(chatUUID string, pin entities.Pin, maxPinned int) (bool, error) {
+	ctx := context.Background()
+	chat, err := getChatFromKey(ctx, r, chatUUID)
+	if errors.Is(err, redis.Nil) {
+		return false, repository.ErrNotFound
+	}
+	if err != nil {
+		return false, fmt.Errorf("redis: %w", err)
+	}
+	if !r.client.SIsMember(ctx, keyUser, pin.SessionUUID).Val() {
+		return false, repository.ErrUserDoesntExist
+	}
+
+	pinJSON, _ := json.Marshal(Pin{SessionUUID: pin.SessionUUID, PinnedAt: pin.PinnedAt})
+	pinned, err := pinMessageScript.Run(ctx, r.client, []string{
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixMessages),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixKept),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixPins),
+	}, pin.MessageUUID, pinJSON, maxPinned, r.expiresAtArg(chat)).Int()
+	if err != nil {
+		return false, fmt.Errorf("redis: %w", err)
+	}
+	switch pinned {
+	case -1:
+		return false, repository.ErrMessageNotFound
+	case -2:
+		return false, repository.ErrTooManyPinned
+	}
+	return pinned == 1, nil
+}
+
+func (r *Storage) UnpinMessage(chatUUID string, messageUUID string) (bool, error) {
+	ctx := context.Background()
+	if r.client.Exists(ctx, fmt.Sprintf("%s%s", keyPrefixChat, chatUUID)).Val() == 0 {
+		return false, repository.ErrNotFound
+	}
+	unpinned, err := unpinMessageScript.Run(ctx, r.client, r.unpinKeys(chatUUID), messageUUID).Int()
+	if err != nil {
+		return false, fmt.Errorf("redis: %w", err)
+	}
+	return unpinned == 1, nil
+}
+
+// Ключи unpinMessageScript
+func (r *Storage) unpinKeys(chatUUID string) []string {
+	return []string{
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixPins),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixKept),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixMessages),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixSeq),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixThreads),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixReactions),
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixIndex),
+	}
+}
+
+func (r *Storage) RemoveReaction(chatUUID string, messageUUID string, sessionUUID string, emoji string) (bool, error) {
+	ctx := context.Background()
+	if r.client.Exists(ctx, fmt.Sprintf("%s%s", keyPrefixChat, chatUUID)).Val() == 0 {
+		return false, repository.ErrNotFound
+	}
+	removed, err := removeReactionScript.Run(ctx, r.client, []string{
+		fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixReactions),
+		reactionsKey(chatUUID, messageUUID),
+	}, messageUUID, reactionField(sessionUUID, emoji)).Int()
+	if err != nil {
+		return false, fmt.Errorf("redis: %w", err)
+	}
+	return removed == 1, nil
+}
+
+// Реакции всех сообщений загружаются одним запросом и считаются по эмодзи
+func (r *Storage) GetReactions(chatUUID string, messageUUIDs []string, sessionUUID string) (map[string][]entities.Reaction, error) {
+	ctx := context.Background()
+	if r.client.Exists(ctx, fmt.Sprintf("%s%s", keyPrefixChat, chatUUID)).Val() == 0 {
+		return nil, repository.ErrNotFound
+	}
+	fields := make([]*redis.MapStringStringCmd, len(messageUUIDs))
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, v := range messageUUIDs {
+			fields[i] = pipe.HGetAll(ctx, reactionsKey(chatUUID, v))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+
+	reactions := make(map[string][]entities.Reaction)
+	for i, v := range fields {
+		if len(v.Val()) == 0 {
+			continue
+		}
+		byEmoji := make(map[string]*entities.Reaction)
+		for field := range v.Val() {
+			reactionSession, emoji, _ := strings.Cut(field, ":")
+			if byEmoji[emoji] == nil {
+				byEmoji[emoji] = &entities.Reaction{Emoji: emoji}
+			}
+			byEmoji[emoji].Count++
+			if reactionSession == sessionUUID {
+				byEmoji[emoji].Reacted = true
+			}
+		}
+		messageReactions := make([]entities.Reaction, 0, len(byEmoji))
+		for _, reaction := range byEmoji {
+			messageReactions = append(messageReactions, *reaction)
+		}
+		repository.SortReactions(messageReactions)
+		reactions[messageUUIDs[i]] = messageReactions
+	}
+	return reactions, nil
+}
+
+// Поле реакции в хеше реакций на сообщение. session_UUID не содержит ":", поэтому эмодзи отделяется первым ":"
+func reactionField(sessionUUID string, emoji string) string {
+	return sessionUUID + ":" + emoji
+}
+
+func (m Message) toEntity() entities.Message {
+	return entities.Message{
+		SessionUUID: m.SessionUUID,
+		MessageUUID: m.MessageUUID,
+		Text:        m.Text,
+		EditedAt:    m.EditedAt,
+		Deleted:     m.Deleted,
+		CreatedAt:   m.CreatedAt,
+		Seq:         m.Seq,
+		Attachments: m.Attachments,
+		ReplyTo:     m.ReplyTo,
+		ExpiresAt:   m.ExpiresAt,
+	}
+}
+
+// Количество кандидатов, сообщения которых загружаются одним запросом
+const searchBatchSize = 100
+
+// Найденное пересечением множеств слов сообщение, которое еще может не существовать
+type searchCandidate struct {
+	member    string
+	chatUUID  string
+	seq       int64
+	createdAt time.Time
+}
+
+func searchMember(chatUUID string, seq int64) string {
+	return fmt.Sprintf("%s:%d", chatUUID, seq)
+}
+
+// Новые ключи слов получают время удаления чата expiresAt (см. expiresAtArg)
+func indexMessage(ctx context.Context, pipe redis.Cmdable, chatUUID string, message Message, expiresAt string) {
+	terms := repository.SearchTerms(message.Text)
+	if len(terms) == 0 {
+		return
+	}
+	keys := []string{keySearchChats, fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixSearch)}
+	args := []any{chatUUID, message.CreatedAt.UnixMicro(), message.Seq, expiresAt}
+	for _, v := range terms {
+		keys = append(keys, searchKey(chatUUID, v))
+		args = append(args, v)
+	}
+	indexMessageScript.Eval(ctx, pipe, keys, args...)
+}
+
+func unindexMessage(ctx context.Context, pipe redis.Cmdable, chatUUID string, message Message) {
+	for _, v := range repository.SearchTerms(message.Text) {
+		pipe.ZRem(ctx, searchKey(chatUUID, v), message.Seq)
+	}
+}
+
+// Сообщения каждого чата находятся пересечением множеств слов запроса этого чата (searchChatScript), без chatUUID - во всех чатах
+// из search_chats. Страницы чатов сливаются по порядку выдачи, затем сообщения загружаются из списков сообщений чатов
+func (r *Storage) SearchMessages(terms []string, chatUUID string, page entities.Page) (found []entities.FoundMessage, nextCursor string, err error) {
+	ctx := context.Background()
+	chats := []string{chatUUID}
+	if chatUUID != "" {
+		if r.client.Exists(ctx, fmt.Sprintf("%s%s", keyPrefixChat, chatUUID)).Val() == 0 {
+			return nil, "", repository.ErrNotFound
+		}
+	} else {
+		chats, err = r.searchChats(ctx)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	var cursor *searchCandidate
+	if page.Cursor != "" {
+		cursorTime, cursorID, err := repository.ParseSearchCursor(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		cursor = &searchCandidate{member: cursorID, createdAt: cursorTime}
+	}
+
+	//Кандидаты берутся пачками, пока страница не заполнится: часть кандидатов может оказаться вытесненными или удаленными сообщениями
+	for page.Limit <= 0 || len(found) <= page.Limit {
+		candidates, more, err := r.searchCandidates(ctx, chats, terms, cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(candidates) == 0 {
+			break
+		}
+		batchFound, stale, err := r.loadCandidates(ctx, candidates)
+		if err != nil {
+			return nil, "", err
+		}
+		found = append(found, batchFound...)
+		if len(stale) > 0 {
+			r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+				for _, v := range stale {
+					for _, term := range terms {
+						pipe.ZRem(ctx, searchKey(v.chatUUID, term), v.seq)
+					}
+				}
+				return nil
+			})
+		}
+		cursor = &candidates[len(candidates)-1]
+		if !more {
+			break
+		}
+	}
+
+	if page.Limit > 0 && len(found) > page.Limit {
+		found = found[:page.Limit]
+		nextCursor = found[len(found)-1].Cursor
+	}
+	return found, nextCursor, nil
+}
+
+// Чаты с проиндексированными сообщениями. Удаленные чаты убираются из search_chats
+func (r *Storage) searchChats(ctx context.Context) ([]string, error) {
+	chats, err := r.client.SMembers(ctx, keySearchChats).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	exists := make([]*redis.IntCmd, len(chats))
+	_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, v := range chats {
+			exists[i] = pipe.Exists(ctx, fmt.Sprintf("%s%s", keyPrefixChat, v))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	alive := make([]string, 0, len(chats))
+	var deleted []any
+	for i, v := range chats {
+		if exists[i].Val() == 0 {
+			deleted = append(deleted, v)
+			continue
+		}
+		alive = append(alive, v)
+	}
+	if len(deleted) > 0 {
+		r.client.SRem(ctx, keySearchChats, deleted...)
+	}
+	return alive, nil
+}
+
+// Следующие после cursor searchBatchSize кандидатов в порядке выдачи: от новых к старым, при равном времени по убыванию member, как в курсоре.
+// Из каждого чата берется столько же, поэтому слитые страницы чатов содержат первые кандидаты всех чатов.
+// more - после кандидатов могут быть еще
+func (r *Storage) searchCandidates(ctx context.Context, chats []string, terms []string, cursor *searchCandidate) (candidates []searchCandidate, more bool, err error) {
+	maxScore := "+inf"
+	if cursor != nil {
+		maxScore = strconv.FormatInt(cursor.createdAt.UnixMicro(), 10)
+	}
+	results := make([]*redis.Cmd, len(chats))
+	_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, chatUUID := range chats {
+			keys := make([]string, 0, len(terms)+1)
+			keys = append(keys, fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixSearchResult))
+			for _, v := range terms {
+				keys = append(keys, searchKey(chatUUID, v))
+			}
+			results[i] = searchChatScript.Eval(ctx, pipe, keys, maxScore, searchBatchSize)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("redis: %w", err)
+	}
+
+	for i, chatUUID := range chats {
+		found, err := results[i].StringSlice()
+		if err != nil {
+			return nil, false, fmt.Errorf("redis: %w", err)
+		}
+		chatCandidates := 0
+		for j := 0; j+1 < len(found); j += 2 {
+			seq, err := strconv.ParseInt(found[j], 10, 64)
+			if err != nil {
+				continue
+			}
+			score, err := strconv.ParseFloat(found[j+1], 64)
+			if err != nil {
+				continue
+			}
+			candidate := searchCandidate{
+				member:    searchMember(chatUUID, seq),
+				chatUUID:  chatUUID,
+				seq:       seq,
+				createdAt: time.UnixMicro(int64(score)),
+			}
+			if cursor != nil && repository.CompareSearchResults(candidate.createdAt, candidate.member, cursor.createdAt, cursor.member) <= 0 {
+				continue
+			}
+			candidates = append(candidates, candidate)
+			chatCandidates++
+		}
+		if chatCandidates >= searchBatchSize {
+			more = true
+		}
+	}
+	slices.SortFunc(candidates, func(a, b searchCandidate) int {
+		return repository.CompareSearchResults(a.createdAt, a.member, b.createdAt, b.member)
+	})
+	if len(candidates) > searchBatchSize {
+		candidates, more = candidates[:searchBatchSize], true
+	}
+	return candidates, more, nil
+}
+
+// Загрузка сообщений кандидатов. Возвращает найденные сообщения и кандидатов, которых уже нет (чат удален или сообщение вытеснено)
+func (r *Storage) loadCandidates(ctx context.Context, candidates []searchCandidate) ([]entities.FoundMessage, []searchCandidate, error) {
+	//Позиция первого сообщения в списке = всего сообщений - длина списка
+	//Сообщения старше списка ищутся среди сохраненных закрепленных сообщений
+	lengths := make(map[string]*redis.IntCmd)
+	seqs := make(map[string]*redis.StringCmd)
+	keptJSON := make(map[string]*redis.StringSliceCmd)
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, v := range candidates {
+			if _, ok := lengths[v.chatUUID]; ok {
+				continue
+			}
+			lengths[v.chatUUID] = pipe.LLen(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, v.chatUUID, keyPostfixMessages))
+			seqs[v.chatUUID] = pipe.Get(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, v.chatUUID, keyPostfixSeq))
+			keptJSON[v.chatUUID] = pipe.LRange(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, v.chatUUID, keyPostfixKept), 0, -1)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, nil, fmt.Errorf("redis: %w", err)
+	}
+	kept := make(map[string][]Message, len(keptJSON))
+	for chatUUID, v := range keptJSON {
+		kept[chatUUID], err = unmarshalMessages(v.Val())
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var stale []searchCandidate
+	messages := make([]*redis.StringCmd, len(candidates))
+	keptMessages := make([]*Message, len(candidates))
+	_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, v := range candidates {
+			total, _ := seqs[v.chatUUID].Int64()
+			index := v.seq - 1 - (total - lengths[v.chatUUID].Val())
+			if index < 0 {
+				for j, message := range kept[v.chatUUID] {
+					if message.Seq == v.seq {
+						keptMessages[i] = &kept[v.chatUUID][j]
+						break
+					}
+				}
+				if keptMessages[i] == nil {
+					stale = append(stale, v)
+				}
+				continue
+			}
+			messages[i] = pipe.LIndex(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, v.chatUUID, keyPostfixMessages), index)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, nil, fmt.Errorf("redis: %w", err)
+	}
+
+	var found []entities.FoundMessage
+	for i, v := range candidates {
+		var message Message
+		switch {
+		case keptMessages[i] != nil:
+			message = *keptMessages[i]
+		case messages[i] == nil:
+			continue
+		default:
+			messageJSON, err := messages[i].Result()
+			if errors.Is(err, redis.Nil) {
+				stale = append(stale, v)
+				continue
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("redis: %w", err)
+			}
+			if err := json.Unmarshal([]byte(messageJSON), &message); err != nil {
+				return nil, nil, fmt.Errorf("redis: %w", err)
+			}
+		}
+		//Список мог сдвинуться между запросами
+		if message.Seq != v.seq || message.Deleted {
+			continue
+		}
+		found = append(found, entities.FoundMessage{
+			ChatUUID: v.chatUUID,
+			Message:  message.toEntity(),
+			Cursor:   repository.SearchCursor(v.createdAt, v.member),
+		})
+	}
+	return found, stale, nil
+}
+
+func (r *Storage) GetActiveChats(filter entities.ChatFilter, page entities.Page) (chats []entities.Chat, nextCursor string, err error) {
+	ctx := context.Background()
+	chatsUUID, err := r.client.LRange(ctx, keyActiveChats, 0, -1).Result()
+	if err != nil {
+		return nil, "", fmt.Errorf("redis: %w", err)
+	}
+	if len(chatsUUID) == 0 {
+		return nil, "", nil
+	}
+
+	//Getting all chats with one request
+	keys := make([]string, 0, len(chatsUUID))
+	for _, v := range chatsUUID {
+		keys = append(keys, fmt.Sprintf("%s%s", keyPrefixChat, v))
+	}
+	chatsJSON, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, "", fmt.Errorf("redis: %w", err)
+	}
+	all := make([]entities.Chat, 0, len(chatsJSON))
+	for _, v := range chatsJSON {
+		//chat could be deleted between requests
+		chatJSON, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var chat Chat
+		if err := json.Unmarshal([]byte(chatJSON), &chat); err != nil {
+			return nil, "", fmt.Errorf("redis: %w", err)
+		}
+		all = append(all, chat.toEntity())
+	}
+
+	//Чаты только для участников показываются участникам, чаты юзера находятся по member_chats
+	var memberChats map[string]struct{}
+	if filter.Viewer != "" {
+		memberChats, err = r.client.SMembersMap(ctx, keyPrefixMemberChats+filter.Viewer).Result()
+		if err != nil {
+			return nil, "", fmt.Errorf("redis: %w", err)
+		}
+	}
+	chats, nextCursor, err = repository.PageChats(all, filter, page, func(chatUUID string) bool {
+		_, ok := memberChats[chatUUID]
+		return ok
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	//Counting messages and getting pins only for chats of page with one pipeline
+	counts := make([]*redis.IntCmd, len(chats))
+	keptCounts := make([]*redis.IntCmd, len(chats))
+	pins := make([]*redis.MapStringStringCmd, len(chats))
+	_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, v := range chats {
+			counts[i] = pipe.LLen(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, v.ChatUUID, keyPostfixMessages))
+			keptCounts[i] = pipe.LLen(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, v.ChatUUID, keyPostfixKept))
+			pins[i] = pipe.HGetAll(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, v.ChatUUID, keyPostfixPins))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("redis: %w", err)
+	}
+	for i := range chats {
+		chats[i].MessageCount = int(counts[i].Val() + keptCounts[i].Val())
+		chats[i].Pinned, err = pinsToEntity(pins[i].Val())
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	return chats, nextCursor, nil
+}
+
+func (c Chat) toEntity() entities.Chat {
+	return entities.Chat{
+		SessionUUID: c.SessionUUID,
+		ChatUUID:    c.ChatUUID,
+		ReadOnly:    c.ReadOnly,
+		TTL:         c.TTL,
+		CreatedAt:   c.CreatedAt,
+		Visibility:  c.Visibility,
+		Info: entities.ChatInfo{
+			Title:       c.Title,
+			Description: c.Description,
+			Tags:        c.Tags,
+		},
+	}
+}
+
+// Отложенное сообщение хранится в чате, время его отправки - в scheduled_messages
+func (r *Storage) AddScheduled(scheduled entities.ScheduledMessage) error {
+	ctx := context.Background()
+	chat, err := getChatFromKey(ctx, r, scheduled.ChatUUID)
+	if errors.Is(err, redis.Nil) {
+		return repository.ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+	if !r.client.SIsMember(ctx, keyUser, scheduled.SessionUUID).Val() {
+		return repository.ErrUserDoesntExist
+	}
+
+	key := fmt.Sprintf("%s%s%s", keyPrefixChat, scheduled.ChatUUID, keyPostfixScheduled)
+	scheduledJSON, _ := json.Marshal(ScheduledMessage{
+		SessionUUID: scheduled.SessionUUID,
+		Text:        scheduled.Text,
+		SendAt:      scheduled.SendAt,
+		CreatedAt:   scheduled.CreatedAt,
+	})
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, scheduled.ScheduledUUID, scheduledJSON)
+		pipe.ZAdd(ctx, keyScheduledMessages, redis.Z{
+			Score:  float64(scheduled.SendAt.UnixMilli()),
+			Member: scheduledMember(scheduled.ChatUUID, scheduled.ScheduledUUID),
+		})
+		//Отложенные сообщения создаются после чата, время удаления чата ставится и им
+		expireNewKey(ctx, pipe, key, r.expiresAtArg(chat))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+	return nil
+}
+
+func scheduledMember(chatUUID string, scheduledUUID string) string {
+	return chatUUID + ":" + scheduledUUID
+}
+
+func (r *Storage) GetScheduled(chatUUID string, sessionUUID string) ([]entities.ScheduledMessage, error) {
+	ctx := context.Background()
+	if r.client.Exists(ctx, fmt.Sprintf("%s%s", keyPrefixChat, chatUUID)).Val() == 0 {
+		return nil, repository.ErrNotFound
+	}
+
+	scheduledJSON, err := r.client.HGetAll(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixScheduled)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	var scheduled []entities.ScheduledMessage
+	for scheduledUUID, v := range scheduledJSON {
+		var message ScheduledMessage
+		if err := json.Unmarshal([]byte(v), &message); err != nil {
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+		if message.SessionUUID == sessionUUID {
+			scheduled = append(scheduled, message.toEntity(chatUUID, scheduledUUID))
+		}
+	}
+	repository.SortScheduled(scheduled)
+	return scheduled, nil
+}
+
+// Сообщения находятся по scheduled_messages и загружаются одним запросом. Сообщения удаленных чатов убираются из scheduled_messages
+func (r *Storage) GetDueScheduled(before time.Time) ([]entities.ScheduledMessage, error) {
+	ctx := context.Background()
+	members, err := r.client.ZRangeByScore(ctx, keyScheduledMessages, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprintf("(%d", before.UnixMilli()),
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+
+	fields := make([]*redis.StringCmd, len(members))
+	_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, v := range members {
+			chatUUID, scheduledUUID, _ := strings.Cut(v, ":")
+			fields[i] = pipe.HGet(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixScheduled), scheduledUUID)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+
+	var scheduled []entities.ScheduledMessage
+	var stale []any
+	for i, v := range fields {
+		//chat was deleted or message was sent between requests
+		if errors.Is(v.Err(), redis.Nil) {
+			stale = append(stale, members[i])
+			continue
+		}
+		var message ScheduledMessage
+		if err := json.Unmarshal([]byte(v.Val()), &message); err != nil {
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+		chatUUID, scheduledUUID, _ := strings.Cut(members[i], ":")
+		scheduled = append(scheduled, message.toEntity(chatUUID, scheduledUUID))
+	}
+	if len(stale) > 0 {
+		r.client.ZRem(ctx, keyScheduledMessages, stale...)
+	}
+	repository.SortScheduled(scheduled)
+	return scheduled, nil
+}
+
+// Сообщение удаляет только одна реплика - та, у которой HDEL удалил поле
+func (r *Storage) DeleteScheduled(chatUUID string, scheduledUUID string) (bool, error) {
+	ctx := context.Background()
+	if r.client.Exists(ctx, fmt.Sprintf("%s%s", keyPrefixChat, chatUUID)).Val() == 0 {
+		return false, repository.ErrNotFound
+	}
+
+	var deleted *redis.IntCmd
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		deleted = pipe.HDel(ctx, fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixScheduled), scheduledUUID)
+		pipe.ZRem(ctx, keyScheduledMessages, scheduledMember(chatUUID, scheduledUUID))
+		pipe.Del(ctx, scheduledClaimKey(chatUUID, scheduledUUID))
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("redis: %w", err)
+	}
+	return deleted.Val() == 1, nil
+}
+
+// Захват - ключ с временем жизни, поэтому захват остановленной реплики истекает сам
+func (r *Storage) ClaimScheduled(chatUUID string, scheduledUUID string, lease time.Duration) (bool, error) {
+	ctx := context.Background()
+	if r.client.Exists(ctx, fmt.Sprintf("%s%s", keyPrefixChat, chatUUID)).Val() == 0 {
+		return false, repository.ErrNotFound
+	}
+
+	claimed, err := claimScheduledScript.Run(ctx, r.client,
+		[]string{fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixScheduled), scheduledClaimKey(chatUUID, scheduledUUID)},
+		scheduledUUID, lease.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("redis: %w", err)
+	}
+	return claimed == 1, nil
+}
+
+func (r *Storage) ReleaseScheduled(chatUUID string, scheduledUUID string) error {
+	ctx := context.Background()
+	if r.client.Exists(ctx, fmt.Sprintf("%s%s", keyPrefixChat, chatUUID)).Val() == 0 {
+		return repository.ErrNotFound
+	}
+	if err := r.client.Del(ctx, scheduledClaimKey(chatUUID, scheduledUUID)).Err(); err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+	return nil
+}
+
+func scheduledClaimKey(chatUUID string, scheduledUUID string) string {
+	return fmt.Sprintf("%s%s%s:%s", keyPrefixChat, chatUUID, keyPostfixScheduled, scheduledUUID)
+}
+
+func (m ScheduledMessage) toEntity(chatUUID string, scheduledUUID string) entities.ScheduledMessage {
+	return entities.ScheduledMessage{
+		ScheduledUUID: scheduledUUID,
+		ChatUUID:      chatUUID,
+		SessionUUID:   m.SessionUUID,
+		Text:          m.Text,
+		SendAt:        m.SendAt,
+		CreatedAt:     m.CreatedAt,
+	}
+}
+
+func (r *Storage) RememberIdempotencyKey(sessionUUID string, key string, request entities.IdempotentRequest, expiresAt time.Time) (entities.IdempotentRequest, error) {
+	ctx := context.Background()
+	if !r.client.SIsMember(ctx, keyUser, sessionUUID).Val() {
+		return entities.IdempotentRequest{}, repository.ErrUserDoesntExist
+	}
+	//Ключ, который уже истек, не запоминается
+	ttl := time.Until(expiresAt).Milliseconds()
+	if ttl <= 0 {
+		return request, nil
+	}
+
+	fields, err := rememberIdempotencyKeyScript.Run(ctx, r.client, []string{idempotencyKey(sessionUUID, key)},
+		request.MessageUUID, request.ChatUUID, request.RequestHash, request.RememberedAt.UnixMilli(), ttl).StringSlice()
+	if err != nil {
+		return entities.IdempotentRequest{}, fmt.Errorf("redis: %w", err)
+	}
+	//HGETALL возвращает поля и значения по очереди
+	remembered := make(map[string]string, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		remembered[fields[i]] = fields[i+1]
+	}
+	rememberedAt, _ := strconv.ParseInt(remembered["remembered_at"], 10, 64)
+	return entities.IdempotentRequest{
+		MessageUUID:  remembered["message_UUID"],
+		ChatUUID:     remembered["chat_UUID"],
+		RequestHash:  remembered["request_hash"],
+		Sent:         remembered["sent"] == "1",
+		RememberedAt: time.UnixMilli(rememberedAt),
+	}, nil
+}
+
+func (r *Storage) MarkIdempotencyKeySent(sessionUUID string, key string, messageUUID string) error {
+	ctx := context.Background()
+	if err := changeIdempotencyKeyScript.Run(ctx, r.client, []string{idempotencyKey(sessionUUID, key)}, messageUUID, "sent").Err(); err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+	return nil
+}
+
+// Ключ удаляется, только если с ним запомнено это же сообщение
+func (r *Storage) ForgetIdempotencyKey(sessionUUID string, key string, messageUUID string) error {
+	ctx := context.Background()
+	if err := changeIdempotencyKeyScript.Run(ctx, r.client, []string{idempotencyKey(sessionUUID, key)}, messageUUID, "forget").Err(); err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+	return nil
+}
+
+func idempotencyKey(sessionUUID string, key string) string {
+	return fmt.Sprintf("%s%s:%s", keyPrefixIdempotency, sessionUUID, key)
+}
+
+func messageExpirationMember(chatUUID string, message Message) string {
+	return chatUUID + ":" + message.MessageUUID + ":" + message.SessionUUID
+}
+
+// Сообщения находятся по message_expirations, время удаления берется из него же
+func (r *Storage) GetExpiringMessages(before time.Time) ([]entities.MessageExpiration, error) {
+	ctx := context.Background()
+	members, err := r.client.ZRangeByScoreWithScores(ctx, keyMessageExpirations, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprintf("(%d", before.UnixMilli()),
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+
+	expirations := make([]entities.MessageExpiration, 0, len(members))
+	for _, v := range members {
+		parts := strings.SplitN(v.Member.(string), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		expirations = append(expirations, entities.MessageExpiration{
+			ChatUUID:    parts[0],
+			MessageUUID: parts[1],
+			SessionUUID: parts[2],
+			ExpiresAt:   time.UnixMilli(int64(v.Score)),
+		})
+	}
+	return expirations, nil
+}
+
+// Сообщение удаляется как обычно. Из message_expirations оно убирается, даже если его уже нет (чат удален или сообщение вытеснено)
+func (r *Storage) ExpireMessage(expiration entities.MessageExpiration) (entities.Message, error) {
+	deleted, err := r.DeleteMessage(expiration.ChatUUID, expiration.MessageUUID)
+	if errors.Is(err, repository.ErrNotFound) || errors.Is(err, repository.ErrMessageNotFound) {
+		r.client.ZRem(context.Background(), keyMessageExpirations, messageExpirationMember(expiration.ChatUUID, Message{
+			MessageUUID: expiration.MessageUUID,
+			SessionUUID: expiration.SessionUUID,
+		}))
+	}
+	return deleted, err
+}
+
+// Время удаления в мс округляется вверх, чтобы чат не пытались удалить раньше, чем истечет его ttl
+func expirationScore(expiresAt time.Time) float64 {
+	return float64(expiresAt.Add(time.Millisecond - time.Nanosecond).UnixMilli())
+}
+
+func (r *Storage) GetExpiringChats(before time.Time) ([]entities.ChatExpiration, error) {
+	ctx := context.Background()
+	expiring, err := r.client.ZRangeByScoreWithScores(ctx, keyChatExpirations, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprintf("(%d", before.UnixMilli()),
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	if len(expiring) == 0 {
+		return nil, nil
+	}
+
+	//Getting creators of chats with one request. Chat itself could be already expired by Redis
+	chatsUUID := make([]string, 0, len(expiring))
+	for _, v := range expiring {
+		chatsUUID = append(chatsUUID, v.Member.(string))
+	}
+	sessions, err := r.client.HMGet(ctx, keyChatExpirationSessions, chatsUUID...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	expirations := make([]entities.ChatExpiration, 0, len(expiring))
+	for i, v := range sessions {
+		//chat could be deleted between requests
+		sessionUUID, ok := v.(string)
+		if !ok {
+			continue
+		}
+		expirations = append(expirations, entities.ChatExpiration{
+			ChatUUID:    chatsUUID[i],
+			SessionUUID: sessionUUID,
+			ExpiresAt:   time.UnixMilli(int64(expiring[i].Score)),
+		})
+	}
+	return expirations, nil
+}
+
+// Удаление чата по ttl под блокировкой чата. Реплика, которая не взяла блокировку, чат не удаляет
+func (r *Storage) ExpireChat(chatUUID string) (bool, error) {
+	ctx := context.Background()
+	lockKey := fmt.Sprintf("%s%s%s", keyPrefixChat, chatUUID, keyPostfixExpireLock)
+	token := uuid.NewString()
+	locked, err := r.client.SetNX(ctx, lockKey, token, expireLockTTL).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis: %w", err)
+	}
+	if !locked {
+		return false, nil
+	}
+	defer releaseLockScript.Run(ctx, r.client, []string{lockKey}, token)
+
+	//ttl could be changed or chat deleted by another replica
+	chat, err := getChatFromKey(ctx, r, chatUUID)
+	if errors.Is(err, redis.Nil) {
+		forgetExpiration(ctx, r.client, chatUUID)
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("redis: %w", err)
+	}
+	expiresAt, ok := repository.ChatExpiresAt(chat.toEntity())
+	if !ok || time.Now().Before(expiresAt) {
+		return false, nil
+	}
+
+	keys := r.chatKeys(ctx, chatUUID)
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LRem(ctx, keyActiveChats, 0, chatUUID)
+		pipe.Del(ctx, keys...)
+		forgetExpiration(ctx, pipe, chatUUID)
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("redis: %w", err)
+	}
+	return true, nil
+}
+
+// Время удаления чата по ttl хранится в chat_expirations, создатель чата - в chat_expirations:sessions.
+// Если Redis удаляет чаты сам, ключам чата ставится время удаления, а если ttl убран - оно снимается
+func (r *Storage) setExpiration(ctx context.Context, pipe redis.Cmdable, chat Chat) {
+	expiresAt, ok := repository.ChatExpiresAt(chat.toEntity())
+	if !ok {
+		forgetExpiration(ctx, pipe, chat.ChatUUID)
+		if r.nativeExpiry {
+			for _, key := range r.chatKeys(ctx, chat.ChatUUID) {
+				pipe.Persist(ctx, key)
+			}
+		}
+		return
+	}
+	pipe.ZAdd(ctx, keyChatExpirations, redis.Z{Score: expirationScore(expiresAt), Member: chat.ChatUUID})
+	pipe.HSet(ctx, keyChatExpirationSessions, chat.ChatUUID, chat.SessionUUID)
+	r.expireChatKeys(ctx, pipe, chat.ChatUUID, expiresAt)
+}
+
+// Ставится при создании чата и смене ttl. Ключи, которых еще нет, время удаления не получают - его ставит тот, кто их создает (см. expiresAtArg)
+func (r *Storage) expireChatKeys(ctx context.Context, pipe redis.Cmdable, chatUUID string, expiresAt time.Time) {
+	if !r.nativeExpiry {
+		return
+	}
+	for _, key := range r.chatKeys(ctx, chatUUID) {
+		pipe.PExpireAt(ctx, key, expiresAt)
+	}
+}
+
+// Время удаления чата в мс для ключей, которые создаются после чата. Пустая строка, если у чата нет ttl или Redis не удаляет чаты сам
+func (r *Storage) expiresAtArg(chat Chat) string {
+	expiresAt, ok := repository.ChatExpiresAt(chat.toEntity())
+	if !ok || !r.nativeExpiry {
+		return ""
+	}
+	return strconv.FormatInt(expiresAt.UnixMilli(), 10)
+}
+
+// NX - время удаления ставится только ключу без него, то есть созданному этой записью
+func expireNewKey(ctx context.Context, pipe redis.Pipeliner, key string, expiresAt string) {
+	if expiresAt != "" {
+		pipe.Do(ctx, "PEXPIREAT", key, expiresAt, "NX")
+	}
+}
+
+func forgetExpiration(ctx context.Context, pipe redis.Cmdable, chatUUID string) {
+	pipe.ZRem(ctx, keyChatExpirations, chatUUID)
+	pipe.HDel(ctx, keyChatExpirationSessions, chatUUID)
+}
+
+// Если Redis удаляет чаты сам, подписываемся на уведомления об истекших ключах и сообщаем о каждом удаленном чате.
+// Уведомления не хранятся: чаты, истекшие пока сервис не слушал их (до подписки, при переподключении),
+// находятся по chat_expirations при подписке и затем каждые sweepInterval
+func (r *Storage) OnChatExpired(fn func(expiration entities.ChatExpiration)) bool {
+	if !r.nativeExpiry {
+		return false
+	}
+	pubsub := r.client.Subscribe(r.ctx, fmt.Sprintf("__keyevent@%d__:expired", r.client.Options().DB))
+	//Ждем подтверждения подписки, чтобы не пропустить чаты между поиском истекших и подпиской.
+	//Без подтвержденной подписки чаты по ttl удаляет messenger
+	if _, err := pubsub.Receive(r.ctx); err != nil {
+		logger.Logger.Error("redis: failed to subscribe to expired keys: " + err.Error())
+		pubsub.Close()
+		return false
+	}
+	//Подписка закрывается вместе с хранилищем, это прерывает ожидание уведомления
+	context.AfterFunc(r.ctx, func() { pubsub.Close() })
+	go func() {
+		r.sweepExpiredChats(r.ctx, fn)
+		ticker := time.NewTicker(r.sweepInterval)
+		defer ticker.Stop()
+		for {
+			msg, err := pubsub.ReceiveTimeout(r.ctx, r.sweepInterval)
+			if r.ctx.Err() != nil {
+				return
+			}
+			var netErr net.Error
+			switch {
+			case errors.As(err, &netErr) && netErr.Timeout():
+			case err != nil:
+				//Следующий Receive переподключается и подписывается заново, истекшие за это время чаты находит поиск
+				logger.Logger.Error("redis: failed to receive expired keys, resubscribing: " + err.Error())
+				select {
+				case <-r.ctx.Done():
+					return
+				case <-time.After(resubscribeDelay):
+				}
+				r.sweepExpiredChats(r.ctx, fn)
+				continue
+			default:
+				if msg, ok := msg.(*redis.Message); ok {
+					r.keyExpired(msg.Payload, fn)
+				}
+			}
+			select {
+			case <-ticker.C:
+				r.sweepExpiredChats(r.ctx, fn)
+			default:
+			}
+		}
+	}()
+	return true
+}
+
+func (r *Storage) keyExpired(key string, fn func(expiration entities.ChatExpiration)) {
+	chatUUID, ok := strings.CutPrefix(key, keyPrefixChat)
+	//Остальные ключи чата (сообщения, участники...) истекают вместе с ним
+	if !ok || strings.Contains(chatUUID, ":") {
+		return
+	}
+	r.chatExpired(r.ctx, chatUUID, fn)
+}
+
+func (r *Storage) sweepExpiredChats(ctx context.Context, fn func(expiration entities.ChatExpiration)) {
+	expired, err := r.client.ZRangeByScore(ctx, keyChatExpirations, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(time.Now().UnixMilli(), 10),
+	}).Result()
+	if err != nil {
+		logger.Logger.Error("redis: failed to find expired chats: " + err.Error())
+		return
+	}
+	for _, v := range expired {
+		if r.client.Exists(ctx, fmt.Sprintf("%s%s", keyPrefixChat, v)).Val() == 0 {
+			r.chatExpired(ctx, v, fn)
+		}
+	}
+}
+
+func (r *Storage) chatExpired(ctx context.Context, chatUUID string, fn func(expiration entities.ChatExpiration)) {
+	sessionUUID, err := chatExpiredScript.Run(ctx, r.client, []string{keyChatExpirations, keyChatExpirationSessions, keyActiveChats}, chatUUID).Text()
+	//Чат уже обработан другой репликой или был без ttl
+	if errors.Is(err, redis.Nil) {
+		return
+	}
+	if err != nil {
+		logger.Logger.Error("redis: failed to clean expired chat " + chatUUID + ": " + err.Error())
+		return
+	}
+	//Ключи чата, которые еще не истекли или созданы после истечения чата
+	r.client.Del(ctx, r.chatKeys(ctx, chatUUID)...)
+	fn(entities.ChatExpiration{ChatUUID: chatUUID, SessionUUID: sessionUUID, ExpiresAt: time.Now()})
+}
+
+func (r *Storage) OnChatEvicted(fn func(chatUUID string)) {
+	r.onChatEvicted = fn
+}
+
+// Закрытие хранилища останавливает подписку на уведомления об истекших ключах
+func (r *Storage) Close() {
+	r.cancel()
+	r.client.Close()
 }
 
 func GracefulStop() {
-	if rdb == nil {
+	if storage == nil {
 		return
 	}
-	rdb.Close()
+	storage.Close()
 	fmt.Println("redis closed successfully")
 }
 
@@ -203,5 +2752,5 @@ func GracefulStop() {
 func Ping() error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
-	return rdb.Ping(ctx).Err()
+	return storage.client.Ping(ctx).Err()
 }
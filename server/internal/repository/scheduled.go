@@ -0,0 +1,18 @@
+package repository
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/Rolan335/grpcMessenger/server/internal/repository/entities"
+)
+
+// Sorting scheduled messages from the earliest to send, so every storage lists them in the same order
+func SortScheduled(scheduled []entities.ScheduledMessage) {
+	slices.SortFunc(scheduled, func(a, b entities.ScheduledMessage) int {
+		if c := a.SendAt.Compare(b.SendAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ScheduledUUID, b.ScheduledUUID)
+	})
+}
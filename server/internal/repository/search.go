@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Rolan335/grpcMessenger/server/internal/repository/entities"
+)
+
+// Splitting text into lowercased words of letters and digits without repeats. Every storage indexes messages by these terms
+func SearchTerms(text string) []string {
+	var terms []string
+	for _, v := range strings.FieldsFunc(strings.ToLower(text), isNotWordRune) {
+		if !slices.Contains(terms, v) {
+			terms = append(terms, v)
+		}
+	}
+	return terms
+}
+
+// Ranges of characters of words of text that are among terms, in order of appearance
+func Highlights(text string, terms []string) []entities.TextRange {
+	var highlights []entities.TextRange
+	start := -1
+	runes := []rune(text)
+	for i := 0; i <= len(runes); i++ {
+		if i < len(runes) && !isNotWordRune(runes[i]) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 && slices.Contains(terms, strings.ToLower(string(runes[start:i]))) {
+			highlights = append(highlights, entities.TextRange{Start: start, End: i})
+		}
+		start = -1
+	}
+	return highlights
+}
+
+func isNotWordRune(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// Cursor of search results, which are ordered from newest to oldest message. Messages created at the same time are ordered by id
+func SearchCursor(createdAt time.Time, id string) string {
+	return strconv.FormatInt(createdAt.UnixMicro(), 10) + "_" + id
+}
+
+func ParseSearchCursor(cursor string) (createdAt time.Time, id string, err error) {
+	micro, id, ok := strings.Cut(cursor, "_")
+	if !ok {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	unixMicro, err := strconv.ParseInt(micro, 10, 64)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	return time.UnixMicro(unixMicro).UTC(), id, nil
+}
+
+// Comparing results in search order: negative if a comes before b, that is a is newer
+func CompareSearchResults(aCreatedAt time.Time, aID string, bCreatedAt time.Time, bID string) int {
+	if c := bCreatedAt.Truncate(time.Microsecond).Compare(aCreatedAt.Truncate(time.Microsecond)); c != 0 {
+		return c
+	}
+	return strings.Compare(bID, aID)
+}
@@ -0,0 +1,371 @@
+package messenger
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/Rolan335/grpcMessenger/server/internal/logger"
+	"github.com/Rolan335/grpcMessenger/server/internal/repository"
+	"github.com/Rolan335/grpcMessenger/server/internal/repository/entities"
+)
+
+// BlobStore keeps content of attachments, grouped by chat. Content is addressed by key that store derives from it (ex. SHA-256),
+// so the same content uploaded to chat twice is stored once. Description of attachments is kept by Storage
+type BlobStore interface {
+	// Storing content read from r until EOF. Returns key of content and its size. If r returns error nothing is stored
+	Put(chatUUID string, r io.Reader) (key string, size int64, err error)
+	// Opening content for reading, repository.ErrNotFound if there is no such content in chat
+	Get(chatUUID string, key string) (io.ReadCloser, error)
+	// Deleting content if it was stored before time provided, content stored again after it is kept.
+	// Deleting content that doesn't exist is not an error
+	Delete(chatUUID string, key string, storedBefore time.Time) error
+	// Returns keys of content of chat
+	Keys(chatUUID string) ([]string, error)
+	// Deleting all content of chat
+	DeleteChat(chatUUID string) error
+	// Returns chats that have content
+	Chats() ([]string, error)
+}
+
+// Limits of uploaded attachments. Types are MIME types allowed, type ending with "/*" allows all its subtypes (ex. image/*).
+// ChatQuota is total size of attachments of one chat in bytes, 0 is no quota
+type AttachmentLimits struct {
+	MaxSize   int64
+	ChatQuota int64
+	Types     []string
+}
+
+// Checking if content type detected from content is allowed
+func (l AttachmentLimits) allows(contentType string) bool {
+	for _, v := range l.Types {
+		if v == contentType || (strings.HasSuffix(v, "/*") && strings.HasPrefix(contentType, strings.TrimSuffix(v, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	// Attachments one message can have
+	maxMessageAttachments   = 10
+	maxAttachmentNameLength = 255
+	// Bytes of content that type of content is detected by
+	sniffLength = 512
+	// How often content of chats that don't exist anymore and attachments that no message references are deleted.
+	// Chats are deleted by other replicas or while upload is in progress
+	attachmentsCollectInterval = 10 * time.Minute
+	// Uploaded attachment that no message references is deleted after that, so it can be sent in the meantime
+	unattachedAttachmentGrace = time.Hour
+	// Content that no attachment has is deleted only if it was stored earlier than that,
+	// so content being uploaded again doesn't disappear before its attachment is stored
+	blobDeleteDelay = time.Minute
+)
+
+type attachments struct {
+	blobs  BlobStore
+	limits AttachmentLimits
+}
+
+// Enabling attachments: content is kept in blobs provided. Content of chats that don't exist anymore and attachments
+// that are not sent are deleted at start and then periodically
+func (m *Messenger) EnableAttachments(blobs BlobStore, limits AttachmentLimits) {
+	m.hooksMu.Lock()
+	defer m.hooksMu.Unlock()
+	m.attachments = &attachments{blobs: blobs, limits: limits}
+	m.background(func() { m.collectAttachments(blobs) })
+}
+
+// nil if attachments are disabled
+func (m *Messenger) attachmentStore() *attachments {
+	m.hooksMu.RLock()
+	defer m.hooksMu.RUnlock()
+	return m.attachments
+}
+
+// Uploading attachment to chat. Only those who can post to chat can upload to it. Content is read until EOF,
+// its type is detected from content and has to be allowed by limits, chat quota is checked before and after content is read.
+// Attachment that is not sent in unattachedAttachmentGrace is deleted
+func (m *Messenger) UploadAttachment(sessionUUID string, chatUUID string, name string, content io.Reader) (entities.Attachment, error) {
+	store := m.attachmentStore()
+	if store == nil {
+		return entities.Attachment{}, ErrAttachmentsDisabled
+	}
+	if _, err := uuid.Parse(sessionUUID); err != nil {
+		return entities.Attachment{}, ErrInvalidSessionUUID
+	}
+	if _, err := uuid.Parse(chatUUID); err != nil {
+		return entities.Attachment{}, ErrInvalidChatUUID
+	}
+	if name == "" || len(name) > maxAttachmentNameLength || !utf8.ValidString(name) {
+		return entities.Attachment{}, ErrInvalidAttachmentName
+	}
+
+	p, err := m.permissions(sessionUUID, chatUUID)
+	if err != nil {
+		return entities.Attachment{}, err
+	}
+	if err := p.checkRead(); err != nil {
+		return entities.Attachment{}, err
+	}
+	if err := p.checkPost(); err != nil {
+		return entities.Attachment{}, err
+	}
+	//Content isn't read if chat has no room for it. Quota is checked once more when attachment is stored
+	if store.limits.ChatQuota > 0 {
+		attachments, err := m.storage.GetAttachments(chatUUID)
+		if err != nil {
+			return entities.Attachment{}, attachmentError(err)
+		}
+		var used int64
+		for _, v := range attachments {
+			used += v.Size
+		}
+		if used >= store.limits.ChatQuota {
+			return entities.Attachment{}, ErrAttachmentQuotaExceeded
+		}
+	}
+
+	//Type is detected before content is stored, detected bytes are stored along with the rest
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(content, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return entities.Attachment{}, fmt.Errorf("messenger: %w", err)
+	}
+	if n == 0 {
+		return entities.Attachment{}, ErrAttachmentEmpty
+	}
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(head[:n]))
+	if !store.limits.allows(contentType) {
+		return entities.Attachment{}, ErrAttachmentTypeNotAllowed
+	}
+
+	reader := &sizeLimitedReader{r: io.MultiReader(bytes.NewReader(head[:n]), content), left: store.limits.MaxSize}
+	key, size, err := store.blobs.Put(chatUUID, reader)
+	if err != nil {
+		if errors.Is(err, ErrAttachmentTooLarge) {
+			return entities.Attachment{}, ErrAttachmentTooLarge
+		}
+		return entities.Attachment{}, fmt.Errorf("messenger: %w", err)
+	}
+
+	id, _ := uuid.NewRandom()
+	attachment := entities.Attachment{
+		ID:          id.String(),
+		SessionUUID: sessionUUID,
+		Key:         key,
+		Name:        name,
+		ContentType: contentType,
+		Size:        size,
+		CreatedAt:   time.Now(),
+	}
+	//Content of chat deleted during upload or of attachment over quota is deleted by collectAttachments
+	if err := m.storage.AddAttachment(chatUUID, attachment, store.limits.ChatQuota); err != nil {
+		return entities.Attachment{}, attachmentError(err)
+	}
+	return attachment, nil
+}
+
+// Downloading attachment of chat. Attachment is available to those who can read chat. Content has to be closed after reading.
+// sessionUUID can be empty for open chats
+func (m *Messenger) DownloadAttachment(sessionUUID string, chatUUID string, attachmentID string) (entities.Attachment, io.ReadCloser, error) {
+	store := m.attachmentStore()
+	if store == nil {
+		return entities.Attachment{}, nil, ErrAttachmentsDisabled
+	}
+	if sessionUUID != "" {
+		if _, err := uuid.Parse(sessionUUID); err != nil {
+			return entities.Attachment{}, nil, ErrInvalidSessionUUID
+		}
+	}
+	if _, err := uuid.Parse(chatUUID); err != nil {
+		return entities.Attachment{}, nil, ErrInvalidChatUUID
+	}
+	if _, err := uuid.Parse(attachmentID); err != nil {
+		return entities.Attachment{}, nil, ErrInvalidAttachmentID
+	}
+
+	p, err := m.permissions(sessionUUID, chatUUID)
+	if err != nil {
+		return entities.Attachment{}, nil, err
+	}
+	if err := p.checkRead(); err != nil {
+		return entities.Attachment{}, nil, err
+	}
+
+	attachment, err := m.storage.GetAttachment(chatUUID, attachmentID)
+	if err != nil {
+		return entities.Attachment{}, nil, attachmentError(err)
+	}
+	content, err := store.blobs.Get(chatUUID, attachment.Key)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return entities.Attachment{}, nil, ErrAttachmentNotFound
+		}
+		return entities.Attachment{}, nil, fmt.Errorf("messenger: %w", err)
+	}
+	return attachment, content, nil
+}
+
+// Checking that attachments of message are uploaded to chat. Returns ids of attachments without repeats
+func (m *Messenger) checkAttachments(chatUUID string, attachmentIDs []string) ([]string, error) {
+	if len(attachmentIDs) == 0 {
+		return nil, nil
+	}
+	if m.attachmentStore() == nil {
+		return nil, ErrAttachmentsDisabled
+	}
+	ids := make([]string, 0, len(attachmentIDs))
+	for _, v := range attachmentIDs {
+		if _, err := uuid.Parse(v); err != nil {
+			return nil, ErrInvalidAttachmentID
+		}
+		if !slices.Contains(ids, v) {
+			ids = append(ids, v)
+		}
+	}
+	if len(ids) > maxMessageAttachments {
+		return nil, ErrTooManyAttachments
+	}
+	for _, v := range ids {
+		if _, err := m.storage.GetAttachment(chatUUID, v); err != nil {
+			return nil, attachmentError(err)
+		}
+	}
+	return ids, nil
+}
+
+func attachmentError(err error) error {
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrChatNotFound
+	}
+	if errors.Is(err, repository.ErrAttachmentNotFound) {
+		return ErrAttachmentNotFound
+	}
+	if errors.Is(err, repository.ErrAttachmentQuotaExceeded) {
+		return ErrAttachmentQuotaExceeded
+	}
+	return fmt.Errorf("messenger: %w", err)
+}
+
+// Deleting content of attachments of chat that was deleted
+func (m *Messenger) deleteAttachments(chatUUID string) {
+	store := m.attachmentStore()
+	if store == nil {
+		return
+	}
+	if err := store.blobs.DeleteChat(chatUUID); err != nil {
+		logger.LogAttachmentsDelete(chatUUID, err)
+	}
+}
+
+// Deleting attachments of message that was deleted if no other message references them, and their content if no other attachment has it.
+// Content stored less than blobDeleteDelay ago is left to collectAttachments
+func (m *Messenger) releaseAttachments(chatUUID string, attachmentIDs []string) {
+	store := m.attachmentStore()
+	if store == nil || len(attachmentIDs) == 0 {
+		return
+	}
+	deleted, err := m.storage.DeleteUnreferencedAttachments(chatUUID, attachmentIDs, time.Now())
+	if err != nil {
+		logger.LogAttachmentsDelete(chatUUID, err)
+		return
+	}
+	if len(deleted) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(deleted))
+	for _, v := range deleted {
+		keys = append(keys, v.Key)
+	}
+	m.deleteContent(store.blobs, chatUUID, keys)
+}
+
+// Deleting content of keys that no attachment of chat has
+func (m *Messenger) deleteContent(blobs BlobStore, chatUUID string, keys []string) {
+	attachments, err := m.storage.GetAttachments(chatUUID)
+	if err != nil {
+		logger.LogAttachmentsDelete(chatUUID, err)
+		return
+	}
+	used := make(map[string]struct{}, len(attachments))
+	for _, v := range attachments {
+		used[v.Key] = struct{}{}
+	}
+	storedBefore := time.Now().Add(-blobDeleteDelay)
+	for _, v := range keys {
+		if _, ok := used[v]; ok {
+			continue
+		}
+		if err := blobs.Delete(chatUUID, v, storedBefore); err != nil {
+			logger.LogAttachmentsDelete(chatUUID, err)
+		}
+	}
+}
+
+// Deleting content of chats that don't exist in storage anymore. In chats that exist attachments that no message references
+// are deleted after unattachedAttachmentGrace, then content that no attachment has
+func (m *Messenger) collectAttachments(blobs BlobStore) {
+	ticker := time.NewTicker(attachmentsCollectInterval)
+	defer ticker.Stop()
+	for {
+		chats, err := blobs.Chats()
+		if err != nil {
+			logger.LogAttachmentsDelete("", err)
+		}
+		for _, v := range chats {
+			_, err := m.storage.GetChat(v)
+			if errors.Is(err, repository.ErrNotFound) {
+				if err := blobs.DeleteChat(v); err != nil {
+					logger.LogAttachmentsDelete(v, err)
+				}
+				continue
+			}
+			if err != nil {
+				logger.LogAttachmentsDelete(v, err)
+				continue
+			}
+			if _, err := m.storage.DeleteUnreferencedAttachments(v, nil, time.Now().Add(-unattachedAttachmentGrace)); err != nil {
+				logger.LogAttachmentsDelete(v, err)
+				continue
+			}
+			keys, err := blobs.Keys(v)
+			if err != nil {
+				logger.LogAttachmentsDelete(v, err)
+				continue
+			}
+			m.deleteContent(blobs, v, keys)
+		}
+		if !m.tick(ticker) {
+			return
+		}
+	}
+}
+
+// Reader that fails with ErrAttachmentTooLarge when more than left bytes are read
+type sizeLimitedReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (l *sizeLimitedReader) Read(p []byte) (int, error) {
+	//one byte more than allowed is enough to know that content is too large
+	if int64(len(p)) > l.left+1 {
+		p = p[:l.left+1]
+	}
+	n, err := l.r.Read(p)
+	l.left -= int64(n)
+	if l.left < 0 {
+		return n, ErrAttachmentTooLarge
+	}
+	return n, err
+}
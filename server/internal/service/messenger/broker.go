@@ -0,0 +1,131 @@
+package messenger
+
+import (
+	"sync"
+
+	"github.com/Rolan335/grpcMessenger/server/internal/repository/entities"
+)
+
+// Size of buffered channel of every subscription. If subscriber can't keep up - it gets disconnected.
+const subscriptionBufferSize = 64
+
+// Subscription delivers messages of one chat to one subscriber until chat is closed or subscriber unsubscribed
+type Subscription struct {
+	chatUUID    string
+	sessionUUID string
+	messages    chan entities.Message
+	done        chan struct{}
+	once        sync.Once
+	err         error
+}
+
+// Channel with messages sent to chat after subscription was made
+func (s *Subscription) Messages() <-chan entities.Message {
+	return s.messages
+}
+
+// Channel that is closed when subscription is over
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Reason why subscription is over. Valid only after Done is closed, nil if subscriber unsubscribed by himself
+func (s *Subscription) Err() error {
+	return s.err
+}
+
+func (s *Subscription) close(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+}
+
+// Broker fans out messages sent to chat to all of the chat subscribers
+type Broker struct {
+	mu   sync.RWMutex
+	subs map[string]map[*Subscription]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		subs: make(map[string]map[*Subscription]struct{}),
+	}
+}
+
+// Subscribing session to chat. sessionUUID is empty for anonymous subscriber
+func (b *Broker) Subscribe(chatUUID string, sessionUUID string) *Subscription {
+	sub := &Subscription{
+		chatUUID:    chatUUID,
+		sessionUUID: sessionUUID,
+		messages:    make(chan entities.Message, subscriptionBufferSize),
+		done:        make(chan struct{}),
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[chatUUID]; !ok {
+		b.subs[chatUUID] = make(map[*Subscription]struct{})
+	}
+	b.subs[chatUUID][sub] = struct{}{}
+	return sub
+}
+
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	b.remove(sub)
+	b.mu.Unlock()
+	sub.close(nil)
+}
+
+// Sending message to every subscriber of chat. Subscribers with full buffer are disconnected so they don't block sender
+func (b *Broker) Publish(chatUUID string, message entities.Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs[chatUUID] {
+		select {
+		case sub.messages <- message:
+		default:
+			b.remove(sub)
+			sub.close(ErrSlowSubscriber)
+		}
+	}
+}
+
+// Closing all subscriptions of chat with reason provided (ex. chat was deleted)
+func (b *Broker) CloseChat(chatUUID string, reason error) {
+	b.mu.Lock()
+	subs := b.subs[chatUUID]
+	delete(b.subs, chatUUID)
+	b.mu.Unlock()
+	for sub := range subs {
+		sub.close(reason)
+	}
+}
+
+// Closing subscriptions of one session to chat with reason provided (ex. session was banned)
+func (b *Broker) CloseSession(chatUUID string, sessionUUID string, reason error) {
+	b.mu.Lock()
+	var closed []*Subscription
+	for sub := range b.subs[chatUUID] {
+		if sub.sessionUUID == sessionUUID {
+			b.remove(sub)
+			closed = append(closed, sub)
+		}
+	}
+	b.mu.Unlock()
+	for _, sub := range closed {
+		sub.close(reason)
+	}
+}
+
+// must be called with mutex locked
+func (b *Broker) remove(sub *Subscription) {
+	chatSubs, ok := b.subs[sub.chatUUID]
+	if !ok {
+		return
+	}
+	delete(chatSubs, sub)
+	if len(chatSubs) == 0 {
+		delete(b.subs, sub.chatUUID)
+	}
+}
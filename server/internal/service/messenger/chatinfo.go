@@ -0,0 +1,118 @@
+package messenger
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/Rolan335/grpcMessenger/server/internal/repository"
+	"github.com/Rolan335/grpcMessenger/server/internal/repository/entities"
+)
+
+// Limits of chat description. Lengths are in characters
+const (
+	maxTitleLength       = 128
+	maxDescriptionLength = 1024
+	maxTags              = 10
+	maxTagLength         = 32
+)
+
+// Changing title, description, tags, ttl or read-only of chat. Only owner of chat can change it. Returns changed chat.
+// TTL of update is the number of seconds from now until chat is deleted, 0 or less removes ttl. Pending deletion is rescheduled
+func (m *Messenger) UpdateChat(sessionUUID string, chatUUID string, update entities.ChatUpdate) (entities.Chat, error) {
+	if _, err := uuid.Parse(sessionUUID); err != nil {
+		return entities.Chat{}, ErrInvalidSessionUUID
+	}
+	if _, err := uuid.Parse(chatUUID); err != nil {
+		return entities.Chat{}, ErrInvalidChatUUID
+	}
+	update, err := normalizeChatUpdate(update)
+	if err != nil {
+		return entities.Chat{}, err
+	}
+
+	p, err := m.permissions(sessionUUID, chatUUID)
+	if err != nil {
+		return entities.Chat{}, err
+	}
+	if !p.canUpdateChat() {
+		return entities.Chat{}, ErrUpdateProhibited
+	}
+
+	//Storage keeps ttl counted from creation of chat, so time left before deletion is the same for everyone who reads chat
+	var deleteAt time.Time
+	if update.TTL != nil {
+		ttl := 0
+		if *update.TTL > 0 {
+			ttl = int(math.Ceil(time.Since(p.chat.CreatedAt).Seconds())) + *update.TTL
+			deleteAt = p.chat.CreatedAt.Add(time.Duration(ttl) * time.Second)
+		}
+		update.TTL = &ttl
+	}
+
+	chat, err := m.storage.UpdateChat(chatUUID, update)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return entities.Chat{}, ErrChatNotFound
+		}
+		return entities.Chat{}, fmt.Errorf("messenger: %w", err)
+	}
+
+	if update.TTL != nil {
+		if deleteAt.IsZero() {
+			m.cancelDeleteAfter(chatUUID)
+		} else {
+			m.deleteAfter(time.Until(deleteAt), chat.SessionUUID, chatUUID)
+		}
+	}
+	return chat, nil
+}
+
+// Checking limits of chat description, tags are lowercased, deduplicated and sorted
+func normalizeChatInfo(info entities.ChatInfo) (entities.ChatInfo, error) {
+	update, err := normalizeChatUpdate(entities.ChatUpdate{Title: &info.Title, Description: &info.Description, Tags: &info.Tags})
+	if err != nil {
+		return entities.ChatInfo{}, err
+	}
+	return entities.ChatInfo{Title: *update.Title, Description: *update.Description, Tags: *update.Tags}, nil
+}
+
+func normalizeChatUpdate(update entities.ChatUpdate) (entities.ChatUpdate, error) {
+	if update.Title != nil {
+		title := strings.TrimSpace(*update.Title)
+		if utf8.RuneCountInString(title) > maxTitleLength {
+			return entities.ChatUpdate{}, ErrInvalidTitle
+		}
+		update.Title = &title
+	}
+	if update.Description != nil && utf8.RuneCountInString(*update.Description) > maxDescriptionLength {
+		return entities.ChatUpdate{}, ErrInvalidDescription
+	}
+	if update.Tags != nil {
+		tags := make([]string, 0, len(*update.Tags))
+		for _, v := range *update.Tags {
+			tag := normalizeTag(v)
+			if tag == "" || utf8.RuneCountInString(tag) > maxTagLength {
+				return entities.ChatUpdate{}, ErrInvalidTags
+			}
+			tags = append(tags, tag)
+		}
+		slices.Sort(tags)
+		tags = slices.Compact(tags)
+		if len(tags) > maxTags {
+			return entities.ChatUpdate{}, ErrInvalidTags
+		}
+		update.Tags = &tags
+	}
+	return update, nil
+}
+
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
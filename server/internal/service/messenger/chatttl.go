@@ -4,14 +4,101 @@ import (
 	"time"
 
 	"github.com/Rolan335/grpcMessenger/server/internal/logger"
+	"github.com/Rolan335/grpcMessenger/server/internal/metric"
+	"github.com/Rolan335/grpcMessenger/server/internal/repository"
+	"github.com/Rolan335/grpcMessenger/server/internal/repository/entities"
 )
 
-// ttl is in seconds. Starting goroutine that will invoke DeleteChat method when time elapsed.
-func DeleteAfter(ttl int, sessionUUID string, chatUUID string, storage Storage) {
-	go func() {
-		<-time.After(time.Duration(ttl) * time.Second)
-		err := storage.DeleteChat(sessionUUID, chatUUID)
-		//when chat deleted - log it.
-		logger.LogChatDelete(sessionUUID, chatUUID, err)
-	}()
+// How often scheduler is synced with storage. Sync picks up chats that expire before the next sync,
+// so chats with ttl created before restart or by other replicas are deleted too
+const expirationsSyncInterval = repository.ExpirationsSyncInterval
+
+// Scheduler of all chat deletions by ttl. Deletion of the same chat is kept once, scheduling it again changes its time
+type expiryScheduler = scheduler[entities.ChatExpiration]
+
+// expire is called for every chat when its time comes, one by one
+func newExpiryScheduler(expire func(expiration entities.ChatExpiration)) *expiryScheduler {
+	return newScheduler(
+		func(e entities.ChatExpiration) string { return e.ChatUUID },
+		func(e entities.ChatExpiration) time.Time { return e.ExpiresAt },
+		expire,
+		func(n int) { metric.ChatExpirationsPending.Set(float64(n)) },
+	)
+}
+
+// ttl is in seconds. Scheduling deletion of chat when time elapsed. Deletion can be canceled with cancelDeleteAfter.
+func (m *Messenger) DeleteAfter(ttl int, sessionUUID string, chatUUID string) {
+	m.deleteAfter(time.Duration(ttl)*time.Second, sessionUUID, chatUUID)
+}
+
+// Scheduling deletion of chat. Pending deletion of the same chat is replaced, so chat is deleted only once at the latest scheduled time.
+// Nothing is scheduled if storage deletes chats by ttl by itself
+func (m *Messenger) deleteAfter(after time.Duration, sessionUUID string, chatUUID string) {
+	if m.expirations == nil {
+		return
+	}
+	m.expirations.schedule(entities.ChatExpiration{ChatUUID: chatUUID, SessionUUID: sessionUUID, ExpiresAt: time.Now().Add(after)})
+}
+
+// Canceling pending deletion of chat, if there is one (ex. chat was deleted before ttl elapsed)
+func (m *Messenger) cancelDeleteAfter(chatUUID string) {
+	if m.expirations == nil {
+		return
+	}
+	m.expirations.cancel(chatUUID)
+}
+
+// Registers callback that is invoked when chat is deleted because its ttl elapsed
+func (m *Messenger) OnChatExpired(fn func(chatUUID string)) {
+	m.hooksMu.Lock()
+	defer m.hooksMu.Unlock()
+	m.onChatExpired = fn
+}
+
+// Deleting chat which time has come. Storage deletes chat only if its ttl really elapsed (ttl could be changed by another replica)
+// and only on one replica, others get false and do nothing
+func (m *Messenger) expireChat(expiration entities.ChatExpiration) {
+	deleted, err := m.storage.ExpireChat(expiration.ChatUUID)
+	if err != nil {
+		logger.LogChatDelete(expiration.SessionUUID, expiration.ChatUUID, err)
+		return
+	}
+	if deleted {
+		m.chatExpired(expiration)
+	}
+}
+
+// Chat was deleted by ttl, by scheduler or by storage itself
+func (m *Messenger) chatExpired(expiration entities.ChatExpiration) {
+	//when chat deleted - log it.
+	logger.LogChatDelete(expiration.SessionUUID, expiration.ChatUUID, nil)
+	metric.ChatsExpiredTotal.Inc()
+	//and end subscriptions of chat
+	m.broker.CloseChat(expiration.ChatUUID, ErrChatDeleted)
+	m.deleteAttachments(expiration.ChatUUID)
+
+	m.hooksMu.RLock()
+	onChatExpired := m.onChatExpired
+	m.hooksMu.RUnlock()
+	if onChatExpired != nil {
+		onChatExpired(expiration.ChatUUID)
+	}
+}
+
+// Loading pending deletions from storage at start and then periodically
+func (m *Messenger) syncExpirations() {
+	ticker := time.NewTicker(expirationsSyncInterval)
+	defer ticker.Stop()
+	for {
+		expirations, err := m.storage.GetExpiringChats(time.Now().Add(expirationsSyncInterval))
+		if err != nil {
+			logger.LogExpirationsSync(err)
+		}
+		for _, v := range expirations {
+			m.expirations.schedule(v)
+		}
+		if !m.tick(ticker) {
+			return
+		}
+	}
 }
@@ -0,0 +1,44 @@
+package messenger
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"github.com/Rolan335/grpcMessenger/server/internal/repository"
+)
+
+// Namespace of direct chat uuids. Uuid of direct chat is derived from uuids of its participants
+var directChatNamespace = uuid.MustParse("8ab5807b-eaaf-4af0-9c26-b634fc1f3af4")
+
+// Opening direct chat of session with peer. The same two sessions always get the same chat whatever the order they are passed in.
+// Returns uuid of chat and true if chat was created by this call
+func (m *Messenger) OpenDirectChat(sessionUUID string, peerSessionUUID string) (string, bool, error) {
+	session, err := uuid.Parse(sessionUUID)
+	if err != nil {
+		return "", false, ErrInvalidSessionUUID
+	}
+	peer, err := uuid.Parse(peerSessionUUID)
+	if err != nil || peer == session {
+		return "", false, ErrInvalidPeerSessionUUID
+	}
+
+	chatUUID := directChatUUID(session, peer)
+	created, err := m.storage.AddDirectChat(session.String(), peer.String(), chatUUID)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserDoesntExist) {
+			return "", false, ErrUserDoesNotExist
+		}
+		return "", false, fmt.Errorf("messenger: %w", err)
+	}
+	return chatUUID, created, nil
+}
+
+func directChatUUID(session uuid.UUID, peer uuid.UUID) string {
+	first, second := session.String(), peer.String()
+	if first > second {
+		first, second = second, first
+	}
+	return uuid.NewSHA1(directChatNamespace, []byte(first+second)).String()
+}
@@ -4,7 +4,61 @@ import "errors"
 
 var ErrChatNotFound = errors.New("chat not found")
 var ErrUserDoesNotExist = errors.New("user doesn't exist")
-var ErrProhibited = errors.New("prohibited. Only creator can send")
+var ErrProhibited = errors.New("prohibited. Only owner or admins can send to read-only chat")
+var ErrDeleteProhibited = errors.New("prohibited. Only owner can delete chat")
+var ErrMessageNotFound = errors.New("message not found")
+var ErrChangeMessageProhibited = errors.New("prohibited. Only author or owner of chat can change message")
+var ErrDeleteMessageProhibited = errors.New("prohibited. Only author or moderators of chat can delete message")
+var ErrNotMember = errors.New("prohibited. Only members of chat have access")
+var ErrLeaveProhibited = errors.New("prohibited. Owner can't leave chat")
+var ErrSetRoleProhibited = errors.New("prohibited. Only owner can change roles of other members")
+var ErrMemberNotFound = errors.New("member not found")
+var ErrAttachmentNotFound = errors.New("attachment not found")
+var ErrScheduledNotFound = errors.New("scheduled message not found")
+var ErrBanned = errors.New("prohibited. Session is banned in chat")
+var ErrMuted = errors.New("prohibited. Session is muted in chat")
+var ErrDirectChatProhibited = errors.New("prohibited. Direct chat can't be joined")
+var ErrUpdateProhibited = errors.New("prohibited. Only owner can update chat")
+var ErrRestrictProhibited = errors.New("prohibited. Only moderators of chat can restrict members with lower role")
+var ErrReadReceiptsProhibited = errors.New("prohibited. Only owner can see read receipts")
+var ErrPinProhibited = errors.New("prohibited. Only owner can pin messages")
 
 var ErrInvalidSessionUUID = errors.New("invalid session UUID provided")
-var ErrInvalidChatUUID = errors.New("invalid chat UUID provided")
\ No newline at end of file
+var ErrInvalidChatUUID = errors.New("invalid chat UUID provided")
+var ErrInvalidMessageUUID = errors.New("invalid message UUID provided")
+var ErrInvalidPageToken = errors.New("invalid page token provided")
+var ErrInvalidPageSize = errors.New("invalid page size provided")
+var ErrInvalidVisibility = errors.New("invalid visibility provided")
+var ErrInvalidRole = errors.New("invalid role provided")
+var ErrInvalidPeerSessionUUID = errors.New("invalid peer session UUID provided")
+var ErrInvalidTitle = errors.New("invalid title provided")
+var ErrInvalidDescription = errors.New("invalid description provided")
+var ErrInvalidTags = errors.New("invalid tags provided")
+var ErrInvalidDuration = errors.New("invalid duration provided")
+var ErrInvalidQuery = errors.New("invalid search query provided")
+var ErrInvalidAttachmentID = errors.New("invalid attachment ID provided")
+var ErrInvalidAttachmentName = errors.New("invalid attachment name provided")
+var ErrTooManyAttachments = errors.New("too many attachments provided")
+var ErrAttachmentEmpty = errors.New("attachment is empty")
+var ErrAttachmentTooLarge = errors.New("attachment is too large")
+var ErrAttachmentQuotaExceeded = errors.New("attachment quota of chat exceeded")
+var ErrAttachmentTypeNotAllowed = errors.New("attachment type is not allowed")
+var ErrAttachmentsDisabled = errors.New("attachments are disabled")
+var ErrInvalidEmoji = errors.New("invalid emoji provided")
+var ErrTooManySessions = errors.New("too many sessions provided")
+var ErrTooManyPinned = errors.New("too many pinned messages")
+var ErrInvalidScheduledUUID = errors.New("invalid scheduled message UUID provided")
+var ErrInvalidSendAt = errors.New("invalid time of sending provided")
+var ErrInvalidIdempotencyKey = errors.New("invalid idempotency key provided")
+var ErrIdempotencyKeyReused = errors.New("idempotency key is already used for another request")
+var ErrIdempotencyKeyInFlight = errors.New("request with this idempotency key is in progress")
+var ErrIdempotencyKeyNotMarked = errors.New("message is sent, but its idempotency key isn't marked as sent")
+var ErrTooManyScheduled = errors.New("too many scheduled messages")
+
+var ErrChatDeleted = errors.New("chat deleted")
+var ErrChatEvicted = errors.New("chat evicted")
+var ErrChatLeft = errors.New("session left chat")
+var ErrSlowSubscriber = errors.New("subscriber is too slow")
+var ErrStreamTargetChanged = errors.New("chat and session can't be changed within one stream")
+var ErrUploadInfoMissing = errors.New("first request of upload stream must carry info")
+var ErrUploadInfoRepeated = errors.New("only first request of upload stream can carry info")
@@ -0,0 +1,141 @@
+package messenger
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"github.com/Rolan335/grpcMessenger/server/internal/repository"
+	"github.com/Rolan335/grpcMessenger/server/internal/repository/entities"
+)
+
+// Joining chat. Any existing session that is not banned can join chat, members-only chat is readable and writable only after joining
+func (m *Messenger) JoinChat(sessionUUID string, chatUUID string) error {
+	if err := validateMembership(sessionUUID, chatUUID); err != nil {
+		return err
+	}
+
+	p, err := m.permissions(sessionUUID, chatUUID)
+	if err != nil {
+		return err
+	}
+	if p.banned {
+		return ErrBanned
+	}
+	if p.isDirect() {
+		return ErrDirectChatProhibited
+	}
+
+	err = m.storage.JoinChat(sessionUUID, chatUUID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrChatNotFound
+		}
+		if errors.Is(err, repository.ErrUserDoesntExist) {
+			return ErrUserDoesNotExist
+		}
+		return fmt.Errorf("messenger: %w", err)
+	}
+	return nil
+}
+
+// Leaving chat. Owner can't leave chat, he can only delete it. Subscriptions of session to chat are closed
+func (m *Messenger) LeaveChat(sessionUUID string, chatUUID string) error {
+	if err := validateMembership(sessionUUID, chatUUID); err != nil {
+		return err
+	}
+
+	p, err := m.permissions(sessionUUID, chatUUID)
+	if err != nil {
+		return err
+	}
+	if !p.canLeave() {
+		return ErrLeaveProhibited
+	}
+
+	err = m.storage.LeaveChat(sessionUUID, chatUUID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrChatNotFound
+		}
+		if errors.Is(err, repository.ErrNotMember) {
+			return ErrNotMember
+		}
+		return fmt.Errorf("messenger: %w", err)
+	}
+	m.broker.CloseSession(chatUUID, sessionUUID, ErrChatLeft)
+	return nil
+}
+
+// Listing members of chat ordered by time of joining. sessionUUID can be empty for open chats
+func (m *Messenger) ListParticipants(sessionUUID string, chatUUID string) ([]entities.Member, error) {
+	if _, err := uuid.Parse(chatUUID); err != nil {
+		return nil, ErrInvalidChatUUID
+	}
+	if sessionUUID != "" {
+		if _, err := uuid.Parse(sessionUUID); err != nil {
+			return nil, ErrInvalidSessionUUID
+		}
+	}
+
+	p, err := m.permissions(sessionUUID, chatUUID)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.checkRead(); err != nil {
+		return nil, err
+	}
+
+	members, err := m.storage.GetParticipants(chatUUID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrChatNotFound
+		}
+		return nil, fmt.Errorf("messenger: %w", err)
+	}
+	return members, nil
+}
+
+// Granting or revoking role of member. Only owner can do it, owner role can't be granted
+func (m *Messenger) SetMemberRole(sessionUUID string, chatUUID string, memberSessionUUID string, role entities.Role) error {
+	if err := validateMembership(sessionUUID, chatUUID); err != nil {
+		return err
+	}
+	if _, err := uuid.Parse(memberSessionUUID); err != nil {
+		return ErrInvalidSessionUUID
+	}
+	if role != entities.RoleMember && role != entities.RoleModerator && role != entities.RoleAdmin {
+		return ErrInvalidRole
+	}
+
+	p, err := m.permissions(sessionUUID, chatUUID)
+	if err != nil {
+		return err
+	}
+	if !p.canSetRole(memberSessionUUID) {
+		return ErrSetRoleProhibited
+	}
+
+	err = m.storage.SetMemberRole(memberSessionUUID, chatUUID, role)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrChatNotFound
+		}
+		if errors.Is(err, repository.ErrNotMember) {
+			return ErrMemberNotFound
+		}
+		return fmt.Errorf("messenger: %w", err)
+	}
+	return nil
+}
+
+func validateMembership(sessionUUID string, chatUUID string) error {
+	if _, err := uuid.Parse(sessionUUID); err != nil {
+		return ErrInvalidSessionUUID
+	}
+	if _, err := uuid.Parse(chatUUID); err != nil {
+		return ErrInvalidChatUUID
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package messenger
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Rolan335/grpcMessenger/server/internal/logger"
+	"github.com/Rolan335/grpcMessenger/server/internal/metric"
+	"github.com/Rolan335/grpcMessenger/server/internal/repository"
+	"github.com/Rolan335/grpcMessenger/server/internal/repository/entities"
+)
+
+// expire is called for every message when its time comes, one by one
+func newMessageExpiryScheduler(expire func(expiration entities.MessageExpiration)) *scheduler[entities.MessageExpiration] {
+	return newScheduler(
+		func(e entities.MessageExpiration) string { return e.MessageUUID },
+		func(e entities.MessageExpiration) time.Time { return e.ExpiresAt },
+		expire,
+		func(n int) { metric.MessageExpirationsPending.Set(float64(n)) },
+	)
+}
+
+// Replacing message which time has come with tombstone. Only one replica gets tombstone and delivers it to subscribers,
+// message that was deleted, trimmed or deleted with its chat before is skipped. Attachments of message are released like DeleteMessage does
+func (m *Messenger) expireMessage(expiration entities.MessageExpiration) {
+	//Tombstone has no attachments, so they are taken from message. Attachments that are missed are deleted by collectAttachments
+	var attachmentIDs []string
+	if m.attachmentStore() != nil {
+		if message, err := m.storage.GetMessage(expiration.ChatUUID, expiration.MessageUUID); err == nil {
+			attachmentIDs = message.Attachments
+		}
+	}
+	tombstone, err := m.storage.ExpireMessage(expiration)
+	if errors.Is(err, repository.ErrNotFound) || errors.Is(err, repository.ErrMessageNotFound) {
+		return
+	}
+	if err != nil {
+		logger.LogMessageDelete(expiration.SessionUUID, expiration.ChatUUID, expiration.MessageUUID, err)
+		return
+	}
+
+	//when message deleted - log it.
+	logger.LogMessageDelete(expiration.SessionUUID, expiration.ChatUUID, expiration.MessageUUID, nil)
+	metric.MessagesExpiredTotal.Inc()
+	//and deliver tombstone to subscribers of chat
+	m.broker.Publish(expiration.ChatUUID, tombstone)
+	m.releaseAttachments(expiration.ChatUUID, attachmentIDs)
+}
+
+// Loading pending deletions of messages from storage at start and then periodically.
+// Chats are synced as often, see expirationsSyncInterval
+func (m *Messenger) syncMessageExpirations() {
+	ticker := time.NewTicker(expirationsSyncInterval)
+	defer ticker.Stop()
+	for {
+		expirations, err := m.storage.GetExpiringMessages(time.Now().Add(expirationsSyncInterval))
+		if err != nil {
+			logger.LogExpirationsSync(err)
+		}
+		for _, v := range expirations {
+			m.messageTTLs.schedule(v)
+		}
+		if !m.tick(ticker) {
+			return
+		}
+	}
+}
@@ -1,33 +1,234 @@
 package messenger
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 
+	"github.com/Rolan335/grpcMessenger/server/internal/logger"
 	"github.com/Rolan335/grpcMessenger/server/internal/repository"
 	"github.com/Rolan335/grpcMessenger/server/internal/repository/entities"
 )
 
 // Storage interface with methods that we need to implement so our storage will be able to work in service
-// Storage is responsible for checking violations, returning errors if violated (ex. trying to send message to readonly chat)
+// Storage only keeps data and reports what doesn't exist (ex. chat not found). Permissions are checked by Messenger, see permissions.go
 type Storage interface {
 	AddSession(sessionUUID string)
-	AddChat(sessionUUID string, ttl int, readOnly bool, chatUUID string) error
-	DeleteChat(sessionUUID string, chatUUID string) error
-	AddMessage(sessionUUID string, chatUUID string, messageUUID string, message string) error
-	GetHistory(chatUUID string) (history []entities.Message, err error)
-	GetActiveChats() (chats []entities.Chat)
+	// Creating chat. Creator becomes the first member of chat with owner role
+	AddChat(sessionUUID string, ttl int, readOnly bool, visibility entities.Visibility, chatUUID string, info entities.ChatInfo) error
+	// Creating direct chat of two sessions if it doesn't exist. Both sessions become members with owner role. Returns true if chat was created
+	AddDirectChat(sessionUUID string, peerSessionUUID string, chatUUID string) (bool, error)
+	// Returns settings of chat and its pinned messages, message count is not filled
+	GetChat(chatUUID string) (entities.Chat, error)
+	// Changing chat, returns changed chat with pinned messages. Message count is not filled
+	UpdateChat(chatUUID string, update entities.ChatUpdate) (entities.Chat, error)
+	DeleteChat(chatUUID string) error
+	// Storing message of session SessionUUID with MessageUUID, Text, Attachments and ReplyTo provided.
+	// Storage assigns creation time and next sequence number of chat, returns stored message.
+	// ReplyTo is root message of thread, ErrMessageNotFound if chat doesn't have it. When root is trimmed by MaxChatSize,
+	// its replies become ordinary messages without ReplyTo, the same happens to the reply that trimmed it.
+	// ExpiresAt is stored if it's set, see GetExpiringMessages
+	AddMessage(chatUUID string, message entities.Message) (entities.Message, error)
+	// Returns message of chat that is not deleted
+	GetMessage(chatUUID string, messageUUID string) (entities.Message, error)
+	// Changing text of message. Returns changed message
+	EditMessage(chatUUID string, messageUUID string, text string) (entities.Message, error)
+	// Replacing message with tombstone without text and attachments. Returns deleted message
+	DeleteMessage(chatUUID string, messageUUID string) (entities.Message, error)
+	// Returns page of history ordered by seq from oldest to newest message and cursor of the next page (empty if page is the last one).
+	// Messages that are not replies have reply count filled. Pinned messages trimmed by MaxChatSize stay in history
+	GetHistory(chatUUID string, page entities.Page) (history []entities.Message, nextCursor string, err error)
+	// Returns thread of message: root message with reply count filled followed by its replies ordered by seq, deleted ones included.
+	// Thread of reply is the thread of its root. ErrMessageNotFound if chat doesn't have such message
+	GetThread(chatUUID string, messageUUID string) ([]entities.Message, error)
+	// Returns page of messages that are not deleted and contain all terms (see repository.SearchTerms), only of chat if chatUUID is not empty.
+	// Messages are ordered from newest to oldest, every found message has cursor of the page after it. Highlights are not filled
+	SearchMessages(terms []string, chatUUID string, page entities.Page) (found []entities.FoundMessage, nextCursor string, err error)
+	// Adding reaction of session to message that is not deleted. Returns false if session already reacted to message with this emoji.
+	// Reactions are deleted together with message, tombstone of deleted message has none
+	AddReaction(chatUUID string, messageUUID string, sessionUUID string, emoji string) (bool, error)
+	// Removing reaction of session from message. Returns false if there was no such reaction
+	RemoveReaction(chatUUID string, messageUUID string, sessionUUID string, emoji string) (bool, error)
+	// Returns reactions of messages of chat by message uuid, sorted by repository.SortReactions. Messages without reactions are absent.
+	// Reacted is filled for sessionUUID, empty sessionUUID didn't react to anything
+	GetReactions(chatUUID string, messageUUIDs []string, sessionUUID string) (map[string][]entities.Reaction, error)
+	// Pinning message of chat that is not deleted if chat has less than maxPinned pinned messages, ErrTooManyPinned otherwise.
+	// Returns false if message is already pinned. Pinned message is not trimmed by MaxChatSize until it's unpinned,
+	// deleting message unpins it
+	PinMessage(chatUUID string, pin entities.Pin, maxPinned int) (bool, error)
+	// Unpinning message of chat, message trimmed by MaxChatSize while it was pinned is dropped. Returns false if message wasn't pinned
+	UnpinMessage(chatUUID string, messageUUID string) (bool, error)
+	// Storing description of attachment uploaded to chat if sizes of attachments of chat together with its size don't exceed quota
+	// (0 is no quota), ErrAttachmentQuotaExceeded otherwise. Attachments are deleted together with chat
+	AddAttachment(chatUUID string, attachment entities.Attachment, quota int64) error
+	// Returns attachment of chat, ErrAttachmentNotFound if chat doesn't have it
+	GetAttachment(chatUUID string, attachmentID string) (entities.Attachment, error)
+	// Returns attachments of chat
+	GetAttachments(chatUUID string) ([]entities.Attachment, error)
+	// Deleting attachments of chat uploaded before time provided that no message references, only attachments of ids if ids is not nil.
+	// Tombstones and trimmed messages reference nothing, pinned messages that stay after trimming reference theirs.
+	// Messages can't reference attachments concurrently. Returns deleted attachments
+	DeleteUnreferencedAttachments(chatUUID string, ids []string, uploadedBefore time.Time) ([]entities.Attachment, error)
+	// Returns page of chats that satisfy filter ordered by creation time and cursor of the next page (empty if page is the last one).
+	// Members-only chats are returned only if filter.Viewer is their member, see repository.MatchChat
+	GetActiveChats(filter entities.ChatFilter, page entities.Page) (chats []entities.Chat, nextCursor string, err error)
+	// Adding session to members of chat with member role. Joining chat again is not an error
+	JoinChat(sessionUUID string, chatUUID string) error
+	// Removing session from members of chat
+	LeaveChat(sessionUUID string, chatUUID string) error
+	// Returns member of chat, ErrNotMember if session is not a member
+	GetMember(sessionUUID string, chatUUID string) (entities.Member, error)
+	// Returns members of chat ordered by time of joining
+	GetParticipants(chatUUID string) ([]entities.Member, error)
+	// Changing role of member of chat
+	SetMemberRole(sessionUUID string, chatUUID string, role entities.Role) error
+	// Restricting session in chat. Restriction of the same kind replaces previous one
+	Restrict(chatUUID string, restriction entities.Restriction) error
+	// Lifting restriction of session in chat. Lifting restriction that doesn't exist is not an error
+	Unrestrict(sessionUUID string, chatUUID string, kind entities.RestrictionKind) error
+	// Returns restrictions of session in chat that are not expired
+	GetRestrictions(sessionUUID string, chatUUID string) ([]entities.Restriction, error)
+	// Moving last-read pointer of session in chat to message with seq provided. Pointer never moves back.
+	// Pointers are deleted together with chat
+	MarkRead(chatUUID string, sessionUUID string, seq int64) error
+	// Returns chats session is a member of sorted by repository.SortMemberChats, with number of messages that are unread (see repository.Unread)
+	GetMemberChats(sessionUUID string) ([]entities.MemberChat, error)
+	// Returns pointers of sessions that have read chat up to seq or further, sorted by repository.SortReadReceipts
+	GetReadReceipts(chatUUID string, seq int64) ([]entities.ReadReceipt, error)
+	// Storing message scheduled by session in chat. Scheduled messages are deleted together with chat
+	AddScheduled(scheduled entities.ScheduledMessage) error
+	// Returns messages scheduled by session in chat that are not sent yet, sorted by repository.SortScheduled
+	GetScheduled(chatUUID string, sessionUUID string) ([]entities.ScheduledMessage, error)
+	// Returns scheduled messages of all chats that are due before time provided, sorted by repository.SortScheduled
+	GetDueScheduled(before time.Time) ([]entities.ScheduledMessage, error)
+	// Claiming scheduled message for lease before it's sent or canceled. Returns false if chat doesn't have such message
+	// or it's claimed by someone else and lease hasn't elapsed, so only one replica sends message at a time.
+	// Message stays in storage until it's deleted, claim that elapsed doesn't prevent claiming it again
+	ClaimScheduled(chatUUID string, scheduledUUID string, lease time.Duration) (bool, error)
+	// Lifting claim of scheduled message that wasn't sent, so it can be claimed again right away
+	ReleaseScheduled(chatUUID string, scheduledUUID string) error
+	// Deleting scheduled message when it's sent or canceled. When several replicas delete the same message, only one gets true
+	DeleteScheduled(chatUUID string, scheduledUUID string) (bool, error)
+	// Returns messages that are not deleted and expire before time provided ordered by time of expiration
+	GetExpiringMessages(before time.Time) ([]entities.MessageExpiration, error)
+	// Replacing expired message with tombstone like DeleteMessage does and forgetting its expiration.
+	// When several replicas expire the same message, only one gets tombstone, others get ErrMessageNotFound
+	ExpireMessage(expiration entities.MessageExpiration) (entities.Message, error)
+	// Remembering request session sent with idempotency key until expiresAt. Returns request that is remembered with key:
+	// the one provided if key is new or its time has elapsed, the first one otherwise. Keys of different sessions don't collide
+	RememberIdempotencyKey(sessionUUID string, key string, request entities.IdempotentRequest, expiresAt time.Time) (entities.IdempotentRequest, error)
+	// Marking request remembered with idempotency key as sent if its message is messageUUID
+	MarkIdempotencyKeySent(sessionUUID string, key string, messageUUID string) error
+	// Forgetting idempotency key if messageUUID is remembered with it, so message that wasn't sent can be sent again with the same key
+	ForgetIdempotencyKey(sessionUUID string, key string, messageUUID string) error
+	// Returns chats with ttl that expire before time provided ordered by time of expiration
+	GetExpiringChats(before time.Time) ([]entities.ChatExpiration, error)
+	// Deleting chat if its ttl has elapsed. When several replicas try to expire the same chat, only one deletes it and gets true
+	ExpireChat(chatUUID string) (bool, error)
+	// Registers callback that storage invokes when it deletes chat by ttl by itself (ex. Redis key expiry).
+	// Returns false if storage doesn't delete chats by itself, then messenger deletes them with scheduler
+	OnChatExpired(fn func(expiration entities.ChatExpiration)) bool
+	// Registers callback that storage invokes when it drops chat by itself (ex. LRU eviction when MaxChats exceeded)
+	OnChatEvicted(fn func(chatUUID string))
 }
 
+// Longest idempotency key of SendMessage in bytes
+const maxIdempotencyKeyLength = 128
+
+// Request with idempotency key that isn't sent for that long is abandoned, retry sends it again
+const idempotencyInFlightTimeout = time.Minute
+
 type Messenger struct {
-	storage Storage
+	storage       Storage
+	broker        *Broker
+	expirations   *expiryScheduler
+	scheduled     *scheduler[entities.ScheduledMessage]
+	messageTTLs   *scheduler[entities.MessageExpiration]
+	hooksMu       sync.RWMutex
+	onChatExpired func(chatUUID string)
+	attachments   *attachments
+	presence      *presenceTracker
+	maxPinned     int
+	// how long idempotency keys of sent messages are remembered
+	idempotencyWindow time.Duration
+	// closed by Close, background syncs and cleanups return when it's closed
+	stop     chan struct{}
+	stopOnce sync.Once
+	workers  sync.WaitGroup
+}
+
+// maxPinned is the most messages that can be pinned in one chat. idempotencyWindow is in seconds,
+// it's how long idempotency key of sent message is remembered. 0 - keys are not remembered, every message is sent
+func NewMessenger(storage Storage, maxPinned int, idempotencyWindow int) *Messenger {
+	m := &Messenger{
+		storage:           storage,
+		broker:            NewBroker(),
+		presence:          newPresenceTracker(),
+		maxPinned:         maxPinned,
+		idempotencyWindow: time.Duration(idempotencyWindow) * time.Second,
+		stop:              make(chan struct{}),
+	}
+	m.scheduled = newMessageScheduler(m.sendScheduled)
+	m.messageTTLs = newMessageExpiryScheduler(m.expireMessage)
+	//Storage that deletes chats by ttl by itself doesn't need scheduler
+	if !storage.OnChatExpired(m.chatExpired) {
+		m.expirations = newExpiryScheduler(m.expireChat)
+	}
+	//When storage evicts chat - canceling its deletion by ttl, ending subscriptions of that chat and deleting its attachments
+	storage.OnChatEvicted(func(chatUUID string) {
+		m.cancelDeleteAfter(chatUUID)
+		m.broker.CloseChat(chatUUID, ErrChatEvicted)
+		m.deleteAttachments(chatUUID)
+	})
+	//Deletions by ttl are not lost on restart, scheduler is rebuilt from storage
+	if m.expirations != nil {
+		m.background(m.syncExpirations)
+	}
+	//Scheduled messages and deletions of messages by ttl are not lost on restart too
+	m.background(m.syncScheduled)
+	m.background(m.syncMessageExpirations)
+	m.background(m.cleanupPresence)
+	return m
+}
+
+// Running background work that Close waits for. fn has to return when m.stop is closed
+func (m *Messenger) background(fn func()) {
+	m.workers.Add(1)
+	go func() {
+		defer m.workers.Done()
+		fn()
+	}()
+}
+
+// Stopping background work: syncs with storage, cleanups and schedulers. Jobs that are running are waited for,
+// pending ones are kept in storage and are picked up after restart. Calling Close again does nothing
+func (m *Messenger) Close() {
+	m.stopOnce.Do(func() {
+		close(m.stop)
+		if m.expirations != nil {
+			m.expirations.stop()
+		}
+		m.scheduled.stop()
+		m.messageTTLs.stop()
+		m.workers.Wait()
+	})
 }
 
-func NewMessenger(storage Storage) *Messenger {
-	return &Messenger{
-		storage: storage,
+// Waiting for the next tick. false if messenger is closed
+func (m *Messenger) tick(ticker *time.Ticker) bool {
+	select {
+	case <-ticker.C:
+		return true
+	case <-m.stop:
+		return false
 	}
 }
 
@@ -40,17 +241,24 @@ func (m *Messenger) InitSession() string {
 	return id.String()
 }
 
-func (m *Messenger) CreateChat(sessionUUID string, ttl int, readOnly bool) (string, error) {
+func (m *Messenger) CreateChat(sessionUUID string, ttl int, readOnly bool, visibility entities.Visibility, info entities.ChatInfo) (string, error) {
 	//If invalid sessionUUID provided - request cannot be completed, return invalidUUID error.
 	if _, err := uuid.Parse(sessionUUID); err != nil {
 		return "", ErrInvalidSessionUUID
 	}
+	if visibility != entities.VisibilityOpen && visibility != entities.VisibilityMembersOnly {
+		return "", ErrInvalidVisibility
+	}
+	info, err := normalizeChatInfo(info)
+	if err != nil {
+		return "", err
+	}
 
 	//Creating uuid for chat
 	id, _ := uuid.NewRandom()
 
 	//Add new chat to server storage
-	err := m.storage.AddChat(sessionUUID, ttl, readOnly, id.String())
+	err = m.storage.AddChat(sessionUUID, ttl, readOnly, visibility, id.String(), info)
 	if err != nil {
 		//If nonExistent session-UUID provided - returning error
 		if errors.Is(err, repository.ErrNotFound) {
@@ -61,61 +269,462 @@ func (m *Messenger) CreateChat(sessionUUID string, ttl int, readOnly bool) (stri
 
 	//If ttl is set, chat will be deleted after time elapsed
 	if ttl > 0 {
-		DeleteAfter(ttl, sessionUUID, id.String(), m.storage)
+		m.DeleteAfter(ttl, sessionUUID, id.String())
 	}
 	return id.String(), nil
 }
 
-func (m *Messenger) SendMessage(sessionUUID string, chatUUID string, message string) error {
+// Sending message to chat. Attachments have to be uploaded to the same chat. replyTo is message of the same chat the message replies to,
+// empty if it's not a reply. expireAfter is in seconds, message is replaced with tombstone when time elapsed. 0 - message doesn't expire.
+// idempotencyKey is chosen by client, message sent again by the same session with the same key within idempotency window
+// is not stored again, the first message is returned instead (only its uuid if it's not in chat anymore). Empty key - message is always sent.
+// Retry with the same key has to be the same request, ErrIdempotencyKeyReused otherwise. Retry while the first request
+// is still being sent gets ErrIdempotencyKeyInFlight. Returns stored message with uuid, creation time and seq assigned.
+// If message is stored, but its key can't be marked as sent, stored message is returned with ErrIdempotencyKeyNotMarked:
+// retry gets ErrIdempotencyKeyInFlight until idempotencyInFlightTimeout and then finds message in chat
+func (m *Messenger) SendMessage(sessionUUID string, chatUUID string, message string, attachmentIDs []string, replyTo string, expireAfter int,
+	idempotencyKey string,
+) (entities.Message, error) {
 	//If invalid sessionUUID or chatUUID provided  - request cannot be completed, return invalidargs error.
 	if _, err := uuid.Parse(sessionUUID); err != nil {
-		return ErrInvalidSessionUUID
+		return entities.Message{}, ErrInvalidSessionUUID
 	}
 	if _, err := uuid.Parse(chatUUID); err != nil {
-		return ErrInvalidChatUUID
+		return entities.Message{}, ErrInvalidChatUUID
+	}
+	if expireAfter < 0 {
+		return entities.Message{}, ErrInvalidDuration
+	}
+	if len(idempotencyKey) > maxIdempotencyKeyLength || !utf8.ValidString(idempotencyKey) {
+		return entities.Message{}, ErrInvalidIdempotencyKey
 	}
 
 	//Creating uuid for message
 	id, _ := uuid.NewRandom()
+	if idempotencyKey == "" || m.idempotencyWindow <= 0 {
+		return m.sendMessage(sessionUUID, chatUUID, id.String(), message, attachmentIDs, replyTo, expireAfter)
+	}
+
+	//Key is remembered before message is sent, so concurrent retry doesn't send it twice
+	request := entities.IdempotentRequest{
+		MessageUUID:  id.String(),
+		ChatUUID:     chatUUID,
+		RequestHash:  requestHash(message, attachmentIDs, replyTo, expireAfter),
+		RememberedAt: time.Now(),
+	}
+	remembered, err := m.rememberIdempotencyKey(sessionUUID, idempotencyKey, request)
+	if err != nil {
+		return entities.Message{}, err
+	}
+	//Retry of message that is already sent
+	if remembered.MessageUUID != request.MessageUUID {
+		sent, err := m.storage.GetMessage(chatUUID, remembered.MessageUUID)
+		if errors.Is(err, repository.ErrMessageNotFound) {
+			return entities.Message{SessionUUID: sessionUUID, MessageUUID: remembered.MessageUUID}, nil
+		}
+		if err != nil {
+			return entities.Message{}, changeMessageError(err)
+		}
+		return sent, nil
+	}
+
+	stored, err := m.sendMessage(sessionUUID, chatUUID, request.MessageUUID, message, attachmentIDs, replyTo, expireAfter)
+	if err != nil {
+		//Message wasn't sent, so retry with the same key sends it. Key that isn't forgotten is taken over by retry after idempotencyInFlightTimeout
+		if err := m.storage.ForgetIdempotencyKey(sessionUUID, idempotencyKey, request.MessageUUID); err != nil {
+			logger.LogIdempotencyKeyUpdate(sessionUUID, chatUUID, request.MessageUUID, err)
+		}
+		return stored, err
+	}
+	if err := m.storage.MarkIdempotencyKeySent(sessionUUID, idempotencyKey, request.MessageUUID); err != nil {
+		logger.LogIdempotencyKeyUpdate(sessionUUID, chatUUID, request.MessageUUID, err)
+		return stored, fmt.Errorf("messenger: %w: %w", ErrIdempotencyKeyNotMarked, err)
+	}
+	return stored, nil
+}
+
+// Remembering idempotency key of request. Returns request remembered with key, that is sent already if it's not the one provided.
+// Request that isn't sent for idempotencyInFlightTimeout was abandoned (ex. replica stopped while sending it):
+// its message is returned if it was stored, otherwise key is remembered with request provided instead
+func (m *Messenger) rememberIdempotencyKey(sessionUUID string, key string, request entities.IdempotentRequest) (entities.IdempotentRequest, error) {
+	expiresAt := request.RememberedAt.Add(m.idempotencyWindow)
+	remembered, err := m.storage.RememberIdempotencyKey(sessionUUID, key, request, expiresAt)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserDoesntExist) {
+			return entities.IdempotentRequest{}, ErrUserDoesNotExist
+		}
+		return entities.IdempotentRequest{}, fmt.Errorf("messenger: %w", err)
+	}
+	if remembered.MessageUUID == request.MessageUUID {
+		return remembered, nil
+	}
+	if remembered.ChatUUID != request.ChatUUID || remembered.RequestHash != request.RequestHash {
+		return entities.IdempotentRequest{}, ErrIdempotencyKeyReused
+	}
+	if remembered.Sent {
+		return remembered, nil
+	}
+	if time.Since(remembered.RememberedAt) < idempotencyInFlightTimeout {
+		return entities.IdempotentRequest{}, ErrIdempotencyKeyInFlight
+	}
+
+	_, err = m.storage.GetMessage(request.ChatUUID, remembered.MessageUUID)
+	if err == nil {
+		return remembered, nil
+	}
+	if !errors.Is(err, repository.ErrMessageNotFound) {
+		return entities.IdempotentRequest{}, changeMessageError(err)
+	}
+	//Only one of concurrent retries takes over the key, others find it in flight
+	if err := m.storage.ForgetIdempotencyKey(sessionUUID, key, remembered.MessageUUID); err != nil {
+		return entities.IdempotentRequest{}, fmt.Errorf("messenger: %w", err)
+	}
+	remembered, err = m.storage.RememberIdempotencyKey(sessionUUID, key, request, expiresAt)
+	if err != nil {
+		return entities.IdempotentRequest{}, fmt.Errorf("messenger: %w", err)
+	}
+	if remembered.MessageUUID != request.MessageUUID {
+		return entities.IdempotentRequest{}, ErrIdempotencyKeyInFlight
+	}
+	return remembered, nil
+}
+
+// Hash of fields of request sent with idempotency key besides chat, so retry of another request with the same key is detected
+func requestHash(message string, attachmentIDs []string, replyTo string, expireAfter int) string {
+	request, _ := json.Marshal(struct {
+		Message       string   `json:"message"`
+		AttachmentIDs []string `json:"attachment_ids"`
+		ReplyTo       string   `json:"reply_to"`
+		ExpireAfter   int      `json:"expire_after"`
+	}{message, attachmentIDs, replyTo, expireAfter})
+	hash := sha256.Sum256(request)
+	return hex.EncodeToString(hash[:])
+}
+
+func (m *Messenger) sendMessage(sessionUUID string, chatUUID string, messageUUID string, message string, attachmentIDs []string, replyTo string,
+	expireAfter int,
+) (entities.Message, error) {
+	//Check if session has access to chat and can post to it
+	p, err := m.permissions(sessionUUID, chatUUID)
+	if err != nil {
+		return entities.Message{}, err
+	}
+	if err := p.checkRead(); err != nil {
+		return entities.Message{}, err
+	}
+	if err := p.checkPost(); err != nil {
+		return entities.Message{}, err
+	}
+	attachmentIDs, err = m.checkAttachments(chatUUID, attachmentIDs)
+	if err != nil {
+		return entities.Message{}, err
+	}
+	replyTo, err = m.threadRoot(chatUUID, replyTo)
+	if err != nil {
+		return entities.Message{}, err
+	}
+
+	var expiresAt time.Time
+	if expireAfter > 0 {
+		expiresAt = time.Now().Add(time.Duration(expireAfter) * time.Second)
+	}
 	//Adding new message to storage and if failed - returns error
-	err := m.storage.AddMessage(sessionUUID, chatUUID, id.String(), message)
+	stored, err := m.storage.AddMessage(chatUUID, entities.Message{
+		SessionUUID: sessionUUID,
+		MessageUUID: messageUUID,
+		Text:        message,
+		Attachments: attachmentIDs,
+		ReplyTo:     replyTo,
+		ExpiresAt:   expiresAt,
+	})
 	if err != nil {
 		//Check if chat not found - send error
 		if errors.Is(err, repository.ErrNotFound) {
-			return ErrChatNotFound
+			return entities.Message{}, ErrChatNotFound
 		}
 		if errors.Is(err, repository.ErrUserDoesntExist) {
-			return ErrUserDoesNotExist
+			return entities.Message{}, ErrUserDoesNotExist
+		}
+		//Message replied to was trimmed after it was checked
+		if errors.Is(err, repository.ErrMessageNotFound) {
+			return entities.Message{}, ErrMessageNotFound
 		}
-		//Check if chat is readonly
-		if errors.Is(err, repository.ErrProhibited) {
-			return ErrProhibited
+		return entities.Message{}, fmt.Errorf("messenger: %w", err)
+	}
+
+	//If ttl is set, message will be deleted after time elapsed
+	if !stored.ExpiresAt.IsZero() {
+		m.messageTTLs.schedule(entities.MessageExpiration{
+			ChatUUID:    chatUUID,
+			MessageUUID: stored.MessageUUID,
+			SessionUUID: sessionUUID,
+			ExpiresAt:   stored.ExpiresAt,
+		})
+	}
+	//Sent message ends typing of its author
+	m.presence.stopTyping(chatUUID, sessionUUID)
+	//Delivering message to subscribers of chat
+	m.broker.Publish(chatUUID, stored)
+	return stored, nil
+}
+
+// Editing text of message. Only author of message or owner of chat can edit it, muted session can't edit
+func (m *Messenger) EditMessage(sessionUUID string, chatUUID string, messageUUID string, text string) error {
+	return m.changeMessage(sessionUUID, chatUUID, messageUUID,
+		func(p permissions, message entities.Message) error {
+			if p.muted {
+				return ErrMuted
+			}
+			if !p.canEdit(message) {
+				return ErrChangeMessageProhibited
+			}
+			return nil
+		},
+		func() (entities.Message, error) {
+			return m.storage.EditMessage(chatUUID, messageUUID, text)
+		},
+	)
+}
+
+// Deleting message. Only author of message or moderators of chat can delete it, tombstone stays in history.
+// Attachments of message that no other message references are deleted with it
+func (m *Messenger) DeleteMessage(sessionUUID string, chatUUID string, messageUUID string) error {
+	var attachmentIDs []string
+	err := m.changeMessage(sessionUUID, chatUUID, messageUUID,
+		func(p permissions, message entities.Message) error {
+			if !p.canDeleteMessage(message) {
+				return ErrDeleteMessageProhibited
+			}
+			attachmentIDs = message.Attachments
+			return nil
+		},
+		func() (entities.Message, error) {
+			return m.storage.DeleteMessage(chatUUID, messageUUID)
+		},
+	)
+	if err != nil {
+		return err
+	}
+	m.releaseAttachments(chatUUID, attachmentIDs)
+	return nil
+}
+
+// Changing message after session is checked to be allowed to change it
+func (m *Messenger) changeMessage(sessionUUID string, chatUUID string, messageUUID string,
+	check func(p permissions, message entities.Message) error, change func() (entities.Message, error),
+) error {
+	//If invalid uuid provided  - request cannot be completed, return invalidargs error.
+	if _, err := uuid.Parse(sessionUUID); err != nil {
+		return ErrInvalidSessionUUID
+	}
+	if _, err := uuid.Parse(chatUUID); err != nil {
+		return ErrInvalidChatUUID
+	}
+	if _, err := uuid.Parse(messageUUID); err != nil {
+		return ErrInvalidMessageUUID
+	}
+
+	p, err := m.permissions(sessionUUID, chatUUID)
+	if err != nil {
+		return err
+	}
+	if err := p.checkRead(); err != nil {
+		return err
+	}
+	message, err := m.storage.GetMessage(chatUUID, messageUUID)
+	if err != nil {
+		return changeMessageError(err)
+	}
+	if err := check(p, message); err != nil {
+		return err
+	}
+
+	message, err = change()
+	if err != nil {
+		return changeMessageError(err)
+	}
+
+	//Delivering changed message to subscribers of chat
+	m.broker.Publish(chatUUID, message)
+	return nil
+}
+
+func changeMessageError(err error) error {
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrChatNotFound
+	}
+	if errors.Is(err, repository.ErrMessageNotFound) {
+		return ErrMessageNotFound
+	}
+	return fmt.Errorf("messenger: %w", err)
+}
+
+// Deleting chat. Only owner of chat can delete it
+func (m *Messenger) DeleteChat(sessionUUID string, chatUUID string) error {
+	//If invalid sessionUUID or chatUUID provided  - request cannot be completed, return invalidargs error.
+	if _, err := uuid.Parse(sessionUUID); err != nil {
+		return ErrInvalidSessionUUID
+	}
+	if _, err := uuid.Parse(chatUUID); err != nil {
+		return ErrInvalidChatUUID
+	}
+
+	p, err := m.permissions(sessionUUID, chatUUID)
+	if err != nil {
+		return err
+	}
+	if !p.canDeleteChat() {
+		return ErrDeleteProhibited
+	}
+
+	err = m.storage.DeleteChat(chatUUID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrChatNotFound
 		}
 		return fmt.Errorf("messenger: %w", err)
 	}
 
+	//Chat is deleted - ttl timer is not needed anymore, subscriptions are over, attachments are not needed too
+	m.cancelDeleteAfter(chatUUID)
+	m.broker.CloseChat(chatUUID, ErrChatDeleted)
+	m.deleteAttachments(chatUUID)
 	return nil
 }
 
-func (m *Messenger) GetHistory(chatUUID string) ([]entities.Message, error) {
+// Getting page of chat history. pageSize 0 means whole history. Returns token of the next page, empty if there are no more messages.
+// sessionUUID can be empty for open chats
+func (m *Messenger) GetHistory(sessionUUID string, chatUUID string, pageSize int, pageToken string, direction entities.Direction) ([]entities.Message, string, error) {
 	// if invalid chatUUID provided - request cannot be completed, return error
 	if _, err := uuid.Parse(chatUUID); err != nil {
-		return nil, ErrInvalidChatUUID
+		return nil, "", ErrInvalidChatUUID
+	}
+	if sessionUUID != "" {
+		if _, err := uuid.Parse(sessionUUID); err != nil {
+			return nil, "", ErrInvalidSessionUUID
+		}
+	}
+	if pageSize < 0 {
+		return nil, "", ErrInvalidPageSize
+	}
+	//Page token is opaque for client, storage works with cursor
+	cursor, err := base64.RawURLEncoding.DecodeString(pageToken)
+	if err != nil {
+		return nil, "", ErrInvalidPageToken
+	}
+
+	//history of members-only chat is available only to members
+	p, err := m.permissions(sessionUUID, chatUUID)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := p.checkRead(); err != nil {
+		return nil, "", err
 	}
 
 	//get history from storage with chatUUID provided
-	history, err := m.storage.GetHistory(chatUUID)
+	history, nextCursor, err := m.storage.GetHistory(chatUUID, entities.Page{
+		Cursor:    string(cursor),
+		Limit:     pageSize,
+		Direction: direction,
+	})
 	if err != nil {
 		//If chat not found - returning error
 		if errors.Is(err, repository.ErrNotFound) {
-			return nil, ErrChatNotFound
+			return nil, "", ErrChatNotFound
+		}
+		if errors.Is(err, repository.ErrInvalidCursor) {
+			return nil, "", ErrInvalidPageToken
+		}
+		return nil, "", fmt.Errorf("messenger: %w", err)
+	}
+	if err := m.fillReactions(sessionUUID, chatUUID, history); err != nil {
+		return nil, "", err
+	}
+	return history, base64.RawURLEncoding.EncodeToString([]byte(nextCursor)), nil
+}
+
+// Subscribing to messages of chat. replayLast is the number of latest messages from history that are returned to be sent first.
+// Subscription must be closed with Unsubscribe when not needed anymore. sessionUUID can be empty for open chats
+func (m *Messenger) SubscribeChat(sessionUUID string, chatUUID string, replayLast int) (*Subscription, []entities.Message, error) {
+	// if invalid chatUUID provided - request cannot be completed, return error
+	if _, err := uuid.Parse(chatUUID); err != nil {
+		return nil, nil, ErrInvalidChatUUID
+	}
+	if sessionUUID != "" {
+		if _, err := uuid.Parse(sessionUUID); err != nil {
+			return nil, nil, ErrInvalidSessionUUID
+		}
+	}
+
+	p, err := m.permissions(sessionUUID, chatUUID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := p.checkRead(); err != nil {
+		return nil, nil, err
+	}
+
+	//Subscribing before reading history so no message is lost between them. History is read even without replay to check if chat exists
+	sub := m.broker.Subscribe(chatUUID, sessionUUID)
+	history, _, err := m.storage.GetHistory(chatUUID, entities.Page{
+		Limit:     max(replayLast, 1),
+		Direction: entities.DirectionOlder,
+	})
+	if err != nil {
+		m.broker.Unsubscribe(sub)
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, nil, ErrChatNotFound
 		}
-		return nil, fmt.Errorf("messenger: %w", err)
+		return nil, nil, fmt.Errorf("messenger: %w", err)
+	}
+
+	if replayLast <= 0 {
+		return sub, nil, nil
+	}
+	if err := m.fillReactions(sessionUUID, chatUUID, history); err != nil {
+		m.broker.Unsubscribe(sub)
+		return nil, nil, err
 	}
-	return history, nil
+	return sub, history, nil
 }
 
-func (m *Messenger) GetActiveChats() []entities.Chat {
-	chats := m.storage.GetActiveChats()
-	return chats
+func (m *Messenger) Unsubscribe(sub *Subscription) {
+	m.broker.Unsubscribe(sub)
+}
+
+// Getting page of chats that satisfy filter. pageSize 0 means all chats. Returns token of the next page, empty if there are no more chats
+func (m *Messenger) GetActiveChats(filter entities.ChatFilter, pageSize int, pageToken string) ([]entities.Chat, string, error) {
+	//If invalid sessionUUID provided for filter - request cannot be completed, return invalidUUID error.
+	if filter.SessionUUID != "" {
+		if _, err := uuid.Parse(filter.SessionUUID); err != nil {
+			return nil, "", ErrInvalidSessionUUID
+		}
+	}
+	if filter.Viewer != "" {
+		if _, err := uuid.Parse(filter.Viewer); err != nil {
+			return nil, "", ErrInvalidSessionUUID
+		}
+	}
+	if pageSize < 0 {
+		return nil, "", ErrInvalidPageSize
+	}
+	cursor, err := base64.RawURLEncoding.DecodeString(pageToken)
+	if err != nil {
+		return nil, "", ErrInvalidPageToken
+	}
+	//Tags are stored normalized, so tag to search is normalized the same way
+	filter.Tag = normalizeTag(filter.Tag)
+
+	chats, nextCursor, err := m.storage.GetActiveChats(filter, entities.Page{
+		Cursor: string(cursor),
+		Limit:  pageSize,
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrInvalidCursor) {
+			return nil, "", ErrInvalidPageToken
+		}
+		return nil, "", fmt.Errorf("messenger: %w", err)
+	}
+	return chats, base64.RawURLEncoding.EncodeToString([]byte(nextCursor)), nil
 }
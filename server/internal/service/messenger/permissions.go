@@ -0,0 +1,142 @@
+package messenger
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Rolan335/grpcMessenger/server/internal/repository"
+	"github.com/Rolan335/grpcMessenger/server/internal/repository/entities"
+)
+
+// Rights of session in chat. All permission rules of chats are here, storages only keep data
+type permissions struct {
+	sessionUUID string
+	chat        entities.Chat
+	member      entities.Member
+	isMember    bool
+	banned      bool
+	muted       bool
+}
+
+// Loading chat, membership and restrictions of session in it. Empty sessionUUID is an anonymous reader
+func (m *Messenger) permissions(sessionUUID string, chatUUID string) (permissions, error) {
+	chat, err := m.storage.GetChat(chatUUID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return permissions{}, ErrChatNotFound
+		}
+		return permissions{}, fmt.Errorf("messenger: %w", err)
+	}
+	p := permissions{sessionUUID: sessionUUID, chat: chat}
+	if sessionUUID == "" {
+		return p, nil
+	}
+
+	member, err := m.storage.GetMember(sessionUUID, chatUUID)
+	switch {
+	case err == nil:
+		p.member, p.isMember = member, true
+	case errors.Is(err, repository.ErrNotMember):
+	case errors.Is(err, repository.ErrNotFound):
+		return permissions{}, ErrChatNotFound
+	default:
+		return permissions{}, fmt.Errorf("messenger: %w", err)
+	}
+
+	restrictions, err := m.storage.GetRestrictions(sessionUUID, chatUUID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return permissions{}, ErrChatNotFound
+		}
+		return permissions{}, fmt.Errorf("messenger: %w", err)
+	}
+	for _, v := range restrictions {
+		switch v.Kind {
+		case entities.RestrictionBan:
+			p.banned = true
+		case entities.RestrictionMute:
+			p.muted = true
+		}
+	}
+	return p, nil
+}
+
+// Creator of chat is always its owner, other members have role they were granted
+func (p permissions) hasRole(role entities.Role) bool {
+	if p.sessionUUID != "" && p.sessionUUID == p.chat.SessionUUID {
+		return true
+	}
+	return p.isMember && p.member.Role >= role
+}
+
+// Open chat is available to everyone, members-only chat - only to members. Banned session has no access at all,
+// anonymous reader (empty session) can read only open chats
+func (p permissions) checkRead() error {
+	if p.banned {
+		return ErrBanned
+	}
+	if p.chat.Visibility != entities.VisibilityOpen && !p.hasRole(entities.RoleMember) {
+		return ErrNotMember
+	}
+	return nil
+}
+
+// Only owner and admins can post to read-only chat. Muted session can't post at all
+func (p permissions) checkPost() error {
+	if p.muted {
+		return ErrMuted
+	}
+	if p.chat.ReadOnly && !p.hasRole(entities.RoleAdmin) {
+		return ErrProhibited
+	}
+	return nil
+}
+
+// Author can edit his message, owner can edit any message
+func (p permissions) canEdit(message entities.Message) bool {
+	return message.SessionUUID == p.sessionUUID || p.hasRole(entities.RoleOwner)
+}
+
+// Author can delete his message, moderators and higher roles can delete any message
+func (p permissions) canDeleteMessage(message entities.Message) bool {
+	return message.SessionUUID == p.sessionUUID || p.hasRole(entities.RoleModerator)
+}
+
+func (p permissions) canDeleteChat() bool {
+	return p.hasRole(entities.RoleOwner)
+}
+
+func (p permissions) canUpdateChat() bool {
+	return p.hasRole(entities.RoleOwner)
+}
+
+func (p permissions) canPin() bool {
+	return p.hasRole(entities.RoleOwner)
+}
+
+func (p permissions) canSeeReadReceipts() bool {
+	return p.hasRole(entities.RoleOwner)
+}
+
+// Only owner grants and revokes roles, his own role can't be changed. Participants of direct chat are equal
+func (p permissions) canSetRole(memberSessionUUID string) bool {
+	return !p.isDirect() && p.hasRole(entities.RoleOwner) && memberSessionUUID != p.chat.SessionUUID
+}
+
+// Owner can't leave chat, he can only delete it
+func (p permissions) canLeave() bool {
+	return !p.hasRole(entities.RoleOwner)
+}
+
+// Direct chat has only two participants, nobody can join it
+func (p permissions) isDirect() bool {
+	return p.chat.Visibility == entities.VisibilityDirect
+}
+
+// Moderators and higher roles ban and mute sessions with lower role. Owner and participants of direct chat can't be restricted
+func (p permissions) canRestrict(target permissions) bool {
+	if p.isDirect() || !p.hasRole(entities.RoleModerator) || target.hasRole(entities.RoleOwner) {
+		return false
+	}
+	return !target.isMember || p.hasRole(target.member.Role+1)
+}
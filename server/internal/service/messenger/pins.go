@@ -0,0 +1,70 @@
+package messenger
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Rolan335/grpcMessenger/server/internal/repository"
+	"github.com/Rolan335/grpcMessenger/server/internal/repository/entities"
+)
+
+// Pinning message of chat. Only owner can pin, chat has at most maxPinned pinned messages.
+// Returns false if message is already pinned
+func (m *Messenger) PinMessage(sessionUUID string, chatUUID string, messageUUID string) (bool, error) {
+	if err := m.checkPin(sessionUUID, chatUUID, messageUUID); err != nil {
+		return false, err
+	}
+	pinned, err := m.storage.PinMessage(chatUUID, entities.Pin{
+		MessageUUID: messageUUID,
+		SessionUUID: sessionUUID,
+		PinnedAt:    time.Now(),
+	}, m.maxPinned)
+	if err != nil {
+		return false, pinError(err)
+	}
+	return pinned, nil
+}
+
+// Unpinning message of chat. Unpinning message that is not pinned is not an error
+func (m *Messenger) UnpinMessage(sessionUUID string, chatUUID string, messageUUID string) error {
+	if err := m.checkPin(sessionUUID, chatUUID, messageUUID); err != nil {
+		return err
+	}
+	if _, err := m.storage.UnpinMessage(chatUUID, messageUUID); err != nil {
+		return pinError(err)
+	}
+	return nil
+}
+
+func (m *Messenger) checkPin(sessionUUID string, chatUUID string, messageUUID string) error {
+	if _, err := uuid.Parse(sessionUUID); err != nil {
+		return ErrInvalidSessionUUID
+	}
+	if _, err := uuid.Parse(chatUUID); err != nil {
+		return ErrInvalidChatUUID
+	}
+	if _, err := uuid.Parse(messageUUID); err != nil {
+		return ErrInvalidMessageUUID
+	}
+
+	p, err := m.permissions(sessionUUID, chatUUID)
+	if err != nil {
+		return err
+	}
+	if err := p.checkRead(); err != nil {
+		return err
+	}
+	if !p.canPin() {
+		return ErrPinProhibited
+	}
+	return nil
+}
+
+func pinError(err error) error {
+	if errors.Is(err, repository.ErrTooManyPinned) {
+		return ErrTooManyPinned
+	}
+	return reactionError(err)
+}
@@ -0,0 +1,220 @@
+package messenger
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// Session is online if it made request not earlier than this
+	onlineTimeout = time.Minute
+	// Session stops typing in chat if it didn't report typing again for this long
+	typingTimeout = 5 * time.Second
+	// How often sessions that are not active for presenceRetention are forgotten, their last activity is unknown after that
+	presenceCleanupInterval = 10 * time.Minute
+	presenceRetention       = 24 * time.Hour
+	// Sessions one presence request can ask about
+	maxPresenceSessions = 100
+)
+
+// Presence of session derived from its recent requests. LastSeen is zero if session made no requests
+type Presence struct {
+	SessionUUID string
+	Online      bool
+	LastSeen    time.Time
+}
+
+// Ephemeral state of sessions: when they were active and what chats they type in. State is kept in memory of replica only
+// and is never written to Storage, so it's lost on restart
+type presenceTracker struct {
+	mu       sync.Mutex
+	lastSeen map[string]time.Time
+	// Chat -> session -> typing of session in chat. Expired entries are deleted lazily
+	typing map[string]map[string]typingState
+}
+
+type typingState struct {
+	startedAt time.Time
+	expiresAt time.Time
+}
+
+func newPresenceTracker() *presenceTracker {
+	return &presenceTracker{
+		lastSeen: make(map[string]time.Time),
+		typing:   make(map[string]map[string]typingState),
+	}
+}
+
+func (t *presenceTracker) seen(sessionUUID string, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if at.After(t.lastSeen[sessionUUID]) {
+		t.lastSeen[sessionUUID] = at
+	}
+}
+
+func (t *presenceTracker) presence(sessionUUIDs []string, now time.Time) []Presence {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	presences := make([]Presence, 0, len(sessionUUIDs))
+	for _, v := range sessionUUIDs {
+		lastSeen := t.lastSeen[v]
+		presences = append(presences, Presence{
+			SessionUUID: v,
+			Online:      !lastSeen.IsZero() && now.Sub(lastSeen) < onlineTimeout,
+			LastSeen:    lastSeen,
+		})
+	}
+	return presences
+}
+
+// Reporting typing again prolongs it, time when session started typing stays the same
+func (t *presenceTracker) startTyping(chatUUID string, sessionUUID string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	chat, ok := t.typing[chatUUID]
+	if !ok {
+		chat = make(map[string]typingState)
+		t.typing[chatUUID] = chat
+	}
+	state, ok := chat[sessionUUID]
+	if !ok || !state.expiresAt.After(now) {
+		state.startedAt = now
+	}
+	state.expiresAt = now.Add(typingTimeout)
+	chat[sessionUUID] = state
+}
+
+func (t *presenceTracker) stopTyping(chatUUID string, sessionUUID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.typing[chatUUID], sessionUUID)
+	if len(t.typing[chatUUID]) == 0 {
+		delete(t.typing, chatUUID)
+	}
+}
+
+// Sessions typing in chat ordered by time they started typing
+func (t *presenceTracker) typingIn(chatUUID string, now time.Time) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	chat := t.typing[chatUUID]
+	sessions := make([]string, 0, len(chat))
+	for session, state := range chat {
+		if !state.expiresAt.After(now) {
+			delete(chat, session)
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	if len(chat) == 0 {
+		delete(t.typing, chatUUID)
+	}
+	slices.SortFunc(sessions, func(a, b string) int {
+		if c := chat[a].startedAt.Compare(chat[b].startedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	})
+	return sessions
+}
+
+// Forgetting sessions that are not active for a long time and typing that expired
+func (t *presenceTracker) cleanup(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for session, lastSeen := range t.lastSeen {
+		if now.Sub(lastSeen) > presenceRetention {
+			delete(t.lastSeen, session)
+		}
+	}
+	for chatUUID, chat := range t.typing {
+		for session, state := range chat {
+			if !state.expiresAt.After(now) {
+				delete(chat, session)
+			}
+		}
+		if len(chat) == 0 {
+			delete(t.typing, chatUUID)
+		}
+	}
+}
+
+func (m *Messenger) cleanupPresence() {
+	ticker := time.NewTicker(presenceCleanupInterval)
+	defer ticker.Stop()
+	for m.tick(ticker) {
+		m.presence.cleanup(time.Now())
+	}
+}
+
+// Registering activity of session, called for requests session made. Invalid uuids are ignored
+func (m *Messenger) Seen(sessionUUID string) {
+	if _, err := uuid.Parse(sessionUUID); err != nil {
+		return
+	}
+	m.presence.seen(sessionUUID, time.Now())
+}
+
+// Returns presence of sessions in order they were provided. Sessions that made no requests to this replica are offline
+func (m *Messenger) GetPresence(sessionUUIDs []string) ([]Presence, error) {
+	if len(sessionUUIDs) > maxPresenceSessions {
+		return nil, ErrTooManySessions
+	}
+	for _, v := range sessionUUIDs {
+		if _, err := uuid.Parse(v); err != nil {
+			return nil, ErrInvalidSessionUUID
+		}
+	}
+	return m.presence.presence(sessionUUIDs, time.Now()), nil
+}
+
+// Reporting that session is typing in chat. Typing lasts for typingTimeout or until session sends message to chat.
+// Only those who can post to chat can type in it
+func (m *Messenger) SetTyping(sessionUUID string, chatUUID string) error {
+	if _, err := uuid.Parse(sessionUUID); err != nil {
+		return ErrInvalidSessionUUID
+	}
+	if _, err := uuid.Parse(chatUUID); err != nil {
+		return ErrInvalidChatUUID
+	}
+
+	p, err := m.permissions(sessionUUID, chatUUID)
+	if err != nil {
+		return err
+	}
+	if err := p.checkRead(); err != nil {
+		return err
+	}
+	if err := p.checkPost(); err != nil {
+		return err
+	}
+	m.presence.startTyping(chatUUID, sessionUUID, time.Now())
+	return nil
+}
+
+// Returns sessions typing in chat ordered by time they started typing. Available to those who can read chat,
+// sessionUUID can be empty for open chats
+func (m *Messenger) GetTyping(sessionUUID string, chatUUID string) ([]string, error) {
+	if sessionUUID != "" {
+		if _, err := uuid.Parse(sessionUUID); err != nil {
+			return nil, ErrInvalidSessionUUID
+		}
+	}
+	if _, err := uuid.Parse(chatUUID); err != nil {
+		return nil, ErrInvalidChatUUID
+	}
+
+	p, err := m.permissions(sessionUUID, chatUUID)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.checkRead(); err != nil {
+		return nil, err
+	}
+	return m.presence.typingIn(chatUUID, time.Now()), nil
+}
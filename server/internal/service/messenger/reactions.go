@@ -0,0 +1,137 @@
+package messenger
+
+import (
+	"errors"
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/Rolan335/grpcMessenger/server/internal/repository"
+	"github.com/Rolan335/grpcMessenger/server/internal/repository/entities"
+)
+
+// Longest emoji in bytes. Emojis joined into one (ex. family, flags with tags) fit in it
+const maxEmojiLength = 32
+
+// Adding reaction of session to message. Those who can read chat and are not muted can react, read-only chat too.
+// Returns false if session already reacted to message with this emoji
+func (m *Messenger) AddReaction(sessionUUID string, chatUUID string, messageUUID string, emoji string) (bool, error) {
+	if err := m.checkReaction(sessionUUID, chatUUID, messageUUID, emoji); err != nil {
+		return false, err
+	}
+	added, err := m.storage.AddReaction(chatUUID, messageUUID, sessionUUID, emoji)
+	if err != nil {
+		return false, reactionError(err)
+	}
+	return added, nil
+}
+
+// Removing reaction of session from message. Removing reaction that doesn't exist is not an error
+func (m *Messenger) RemoveReaction(sessionUUID string, chatUUID string, messageUUID string, emoji string) error {
+	if err := m.checkReaction(sessionUUID, chatUUID, messageUUID, emoji); err != nil {
+		return err
+	}
+	if _, err := m.storage.RemoveReaction(chatUUID, messageUUID, sessionUUID, emoji); err != nil {
+		return reactionError(err)
+	}
+	return nil
+}
+
+func (m *Messenger) checkReaction(sessionUUID string, chatUUID string, messageUUID string, emoji string) error {
+	if _, err := uuid.Parse(sessionUUID); err != nil {
+		return ErrInvalidSessionUUID
+	}
+	if _, err := uuid.Parse(chatUUID); err != nil {
+		return ErrInvalidChatUUID
+	}
+	if _, err := uuid.Parse(messageUUID); err != nil {
+		return ErrInvalidMessageUUID
+	}
+	if !validEmoji(emoji) {
+		return ErrInvalidEmoji
+	}
+
+	p, err := m.permissions(sessionUUID, chatUUID)
+	if err != nil {
+		return err
+	}
+	if err := p.checkRead(); err != nil {
+		return err
+	}
+	if p.muted {
+		return ErrMuted
+	}
+	return nil
+}
+
+func reactionError(err error) error {
+	if errors.Is(err, repository.ErrUserDoesntExist) {
+		return ErrUserDoesNotExist
+	}
+	return changeMessageError(err)
+}
+
+// Emoji has at least one symbol and no letters, spaces or control characters. Modifiers, joiners and digits of keycaps are allowed
+func validEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > maxEmojiLength || !utf8.ValidString(emoji) {
+		return false
+	}
+	symbol := false
+	for _, v := range emoji {
+		if unicode.IsLetter(v) || unicode.IsSpace(v) || unicode.IsControl(v) {
+			return false
+		}
+		if unicode.Is(unicode.So, v) {
+			symbol = true
+		}
+	}
+	return symbol
+}
+
+// Filling reactions of messages of chat for session that requested them. Tombstones of deleted messages have no reactions
+func (m *Messenger) fillReactions(sessionUUID string, chatUUID string, messages []entities.Message) error {
+	messageUUIDs := make([]string, 0, len(messages))
+	for _, v := range messages {
+		if !v.Deleted {
+			messageUUIDs = append(messageUUIDs, v.MessageUUID)
+		}
+	}
+	if len(messageUUIDs) == 0 {
+		return nil
+	}
+	reactions, err := m.storage.GetReactions(chatUUID, messageUUIDs, sessionUUID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrChatNotFound
+		}
+		return fmt.Errorf("messenger: %w", err)
+	}
+	for i := range messages {
+		messages[i].Reactions = reactions[messages[i].MessageUUID]
+	}
+	return nil
+}
+
+// Filling reactions of found messages, messages of every chat are loaded at once
+func (m *Messenger) fillFoundReactions(sessionUUID string, found []entities.FoundMessage) error {
+	byChat := make(map[string][]int)
+	for i, v := range found {
+		byChat[v.ChatUUID] = append(byChat[v.ChatUUID], i)
+	}
+	for chatUUID, indexes := range byChat {
+		messages := make([]entities.Message, 0, len(indexes))
+		for _, i := range indexes {
+			messages = append(messages, found[i].Message)
+		}
+		//Chat deleted during search has no reactions to show
+		if err := m.fillReactions(sessionUUID, chatUUID, messages); err != nil && !errors.Is(err, ErrChatNotFound) {
+			return err
+		}
+		for j, i := range indexes {
+			found[i].Message.Reactions = messages[j].Reactions
+		}
+	}
+	return nil
+}
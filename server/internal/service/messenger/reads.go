@@ -0,0 +1,131 @@
+package messenger
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"github.com/Rolan335/grpcMessenger/server/internal/repository"
+	"github.com/Rolan335/grpcMessenger/server/internal/repository/entities"
+)
+
+// Marking messages of chat up to message provided as read by session. Empty messageUUID marks all messages of chat as read.
+// Marking message older than already read one changes nothing
+func (m *Messenger) MarkRead(sessionUUID string, chatUUID string, messageUUID string) error {
+	if _, err := uuid.Parse(sessionUUID); err != nil {
+		return ErrInvalidSessionUUID
+	}
+	if _, err := uuid.Parse(chatUUID); err != nil {
+		return ErrInvalidChatUUID
+	}
+	if messageUUID != "" {
+		if _, err := uuid.Parse(messageUUID); err != nil {
+			return ErrInvalidMessageUUID
+		}
+	}
+
+	p, err := m.permissions(sessionUUID, chatUUID)
+	if err != nil {
+		return err
+	}
+	if err := p.checkRead(); err != nil {
+		return err
+	}
+
+	seq, err := m.readSeq(chatUUID, messageUUID)
+	if err != nil {
+		return err
+	}
+	if err := m.storage.MarkRead(chatUUID, sessionUUID, seq); err != nil {
+		return readError(err)
+	}
+	return nil
+}
+
+// Seq of message read, seq of the last message of chat if messageUUID is empty
+func (m *Messenger) readSeq(chatUUID string, messageUUID string) (int64, error) {
+	if messageUUID != "" {
+		message, err := m.storage.GetMessage(chatUUID, messageUUID)
+		if err != nil {
+			return 0, changeMessageError(err)
+		}
+		return message.Seq, nil
+	}
+	last, _, err := m.storage.GetHistory(chatUUID, entities.Page{Limit: 1, Direction: entities.DirectionOlder})
+	if err != nil {
+		return 0, readError(err)
+	}
+	if len(last) == 0 {
+		return 0, nil
+	}
+	return last[0].Seq, nil
+}
+
+// Listing chats session is a member of with number of messages it hasn't read. Chats session is banned in are skipped
+func (m *Messenger) ListMyChats(sessionUUID string) ([]entities.MemberChat, error) {
+	if _, err := uuid.Parse(sessionUUID); err != nil {
+		return nil, ErrInvalidSessionUUID
+	}
+
+	chats, err := m.storage.GetMemberChats(sessionUUID)
+	if err != nil {
+		return nil, fmt.Errorf("messenger: %w", err)
+	}
+	readable := make(map[string]bool, len(chats))
+	mine := make([]entities.MemberChat, 0, len(chats))
+	for _, v := range chats {
+		ok, err := m.canRead(readable, sessionUUID, v.Chat.ChatUUID)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			mine = append(mine, v)
+		}
+	}
+	return mine, nil
+}
+
+// Listing sessions that have read message, the earliest readers first. Only owner of chat can see them
+func (m *Messenger) GetReadReceipts(sessionUUID string, chatUUID string, messageUUID string) ([]entities.ReadReceipt, error) {
+	if _, err := uuid.Parse(sessionUUID); err != nil {
+		return nil, ErrInvalidSessionUUID
+	}
+	if _, err := uuid.Parse(chatUUID); err != nil {
+		return nil, ErrInvalidChatUUID
+	}
+	if _, err := uuid.Parse(messageUUID); err != nil {
+		return nil, ErrInvalidMessageUUID
+	}
+
+	p, err := m.permissions(sessionUUID, chatUUID)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.checkRead(); err != nil {
+		return nil, err
+	}
+	if !p.canSeeReadReceipts() {
+		return nil, ErrReadReceiptsProhibited
+	}
+
+	message, err := m.storage.GetMessage(chatUUID, messageUUID)
+	if err != nil {
+		return nil, changeMessageError(err)
+	}
+	receipts, err := m.storage.GetReadReceipts(chatUUID, message.Seq)
+	if err != nil {
+		return nil, readError(err)
+	}
+	return receipts, nil
+}
+
+func readError(err error) error {
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrChatNotFound
+	}
+	if errors.Is(err, repository.ErrUserDoesntExist) {
+		return ErrUserDoesNotExist
+	}
+	return fmt.Errorf("messenger: %w", err)
+}
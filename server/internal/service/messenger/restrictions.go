@@ -0,0 +1,94 @@
+package messenger
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Rolan335/grpcMessenger/server/internal/repository"
+	"github.com/Rolan335/grpcMessenger/server/internal/repository/entities"
+)
+
+// Banning session in chat for duration provided, zero duration - until unbanned. Banned session can't read chat, post to it or join it,
+// its subscriptions to chat are closed
+func (m *Messenger) BanMember(sessionUUID string, chatUUID string, memberSessionUUID string, duration time.Duration) error {
+	if err := m.restrict(sessionUUID, chatUUID, memberSessionUUID, entities.RestrictionBan, duration); err != nil {
+		return err
+	}
+	m.broker.CloseSession(chatUUID, memberSessionUUID, ErrBanned)
+	return nil
+}
+
+func (m *Messenger) UnbanMember(sessionUUID string, chatUUID string, memberSessionUUID string) error {
+	return m.unrestrict(sessionUUID, chatUUID, memberSessionUUID, entities.RestrictionBan)
+}
+
+// Muting session in chat for duration provided, zero duration - until unmuted. Muted session can read chat but can't post to it
+func (m *Messenger) MuteMember(sessionUUID string, chatUUID string, memberSessionUUID string, duration time.Duration) error {
+	return m.restrict(sessionUUID, chatUUID, memberSessionUUID, entities.RestrictionMute, duration)
+}
+
+func (m *Messenger) UnmuteMember(sessionUUID string, chatUUID string, memberSessionUUID string) error {
+	return m.unrestrict(sessionUUID, chatUUID, memberSessionUUID, entities.RestrictionMute)
+}
+
+func (m *Messenger) restrict(sessionUUID string, chatUUID string, memberSessionUUID string, kind entities.RestrictionKind, duration time.Duration) error {
+	if duration < 0 {
+		return ErrInvalidDuration
+	}
+	if err := m.checkRestrict(sessionUUID, chatUUID, memberSessionUUID); err != nil {
+		return err
+	}
+
+	//Restriction expires by itself when its time is over
+	restriction := entities.Restriction{SessionUUID: memberSessionUUID, Kind: kind}
+	if duration > 0 {
+		restriction.Until = time.Now().Add(duration)
+	}
+	return restrictionError(m.storage.Restrict(chatUUID, restriction))
+}
+
+func (m *Messenger) unrestrict(sessionUUID string, chatUUID string, memberSessionUUID string, kind entities.RestrictionKind) error {
+	if err := m.checkRestrict(sessionUUID, chatUUID, memberSessionUUID); err != nil {
+		return err
+	}
+	return restrictionError(m.storage.Unrestrict(memberSessionUUID, chatUUID, kind))
+}
+
+// Checking that session can restrict member. Member doesn't have to be a member of chat, anyone can be banned from open chat
+func (m *Messenger) checkRestrict(sessionUUID string, chatUUID string, memberSessionUUID string) error {
+	if err := validateMembership(sessionUUID, chatUUID); err != nil {
+		return err
+	}
+	if _, err := uuid.Parse(memberSessionUUID); err != nil {
+		return ErrInvalidSessionUUID
+	}
+
+	p, err := m.permissions(sessionUUID, chatUUID)
+	if err != nil {
+		return err
+	}
+	target, err := m.permissions(memberSessionUUID, chatUUID)
+	if err != nil {
+		return err
+	}
+	if !p.canRestrict(target) {
+		return ErrRestrictProhibited
+	}
+	return nil
+}
+
+func restrictionError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrChatNotFound
+	}
+	if errors.Is(err, repository.ErrUserDoesntExist) {
+		return ErrUserDoesNotExist
+	}
+	return fmt.Errorf("messenger: %w", err)
+}
@@ -0,0 +1,220 @@
+package messenger
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Rolan335/grpcMessenger/server/internal/logger"
+	"github.com/Rolan335/grpcMessenger/server/internal/metric"
+	"github.com/Rolan335/grpcMessenger/server/internal/repository"
+	"github.com/Rolan335/grpcMessenger/server/internal/repository/entities"
+)
+
+// How far ahead message can be scheduled
+const maxScheduleAhead = 365 * 24 * time.Hour
+
+// How many messages one session can have scheduled in one chat
+const maxScheduledPerSession = 100
+
+// How often scheduler is synced with storage. Sync picks up messages that are due before the next sync,
+// so messages scheduled before restart or by other replicas are sent too
+const scheduledSyncInterval = time.Minute
+
+// How long message is claimed by replica that sends it. Message that is claimed longer (ex. replica stopped while sending)
+// is sent again by the sync after claim elapses
+const scheduledClaimLease = time.Minute
+
+// Prefix of idempotency key scheduled message is sent with, so message sent again after its claim elapsed isn't stored twice
+const scheduledIdempotencyPrefix = "scheduled:"
+
+// send is called for every scheduled message when its time comes, one by one
+func newMessageScheduler(send func(scheduled entities.ScheduledMessage)) *scheduler[entities.ScheduledMessage] {
+	return newScheduler(
+		func(s entities.ScheduledMessage) string { return s.ScheduledUUID },
+		func(s entities.ScheduledMessage) time.Time { return s.SendAt },
+		send,
+		func(n int) { metric.ScheduledMessagesPending.Set(float64(n)) },
+	)
+}
+
+// Scheduling message to be sent to chat at sendAt. Session has to be able to post to chat now,
+// permissions are checked again when message is sent
+func (m *Messenger) ScheduleMessage(sessionUUID string, chatUUID string, text string, sendAt time.Time) (entities.ScheduledMessage, error) {
+	if _, err := uuid.Parse(sessionUUID); err != nil {
+		return entities.ScheduledMessage{}, ErrInvalidSessionUUID
+	}
+	if _, err := uuid.Parse(chatUUID); err != nil {
+		return entities.ScheduledMessage{}, ErrInvalidChatUUID
+	}
+	now := time.Now()
+	if !sendAt.After(now) || sendAt.After(now.Add(maxScheduleAhead)) {
+		return entities.ScheduledMessage{}, ErrInvalidSendAt
+	}
+
+	p, err := m.permissions(sessionUUID, chatUUID)
+	if err != nil {
+		return entities.ScheduledMessage{}, err
+	}
+	if err := p.checkRead(); err != nil {
+		return entities.ScheduledMessage{}, err
+	}
+	if err := p.checkPost(); err != nil {
+		return entities.ScheduledMessage{}, err
+	}
+	pending, err := m.storage.GetScheduled(chatUUID, sessionUUID)
+	if err != nil {
+		return entities.ScheduledMessage{}, scheduledError(err)
+	}
+	if len(pending) >= maxScheduledPerSession {
+		return entities.ScheduledMessage{}, ErrTooManyScheduled
+	}
+
+	id, _ := uuid.NewRandom()
+	scheduled := entities.ScheduledMessage{
+		ScheduledUUID: id.String(),
+		ChatUUID:      chatUUID,
+		SessionUUID:   sessionUUID,
+		Text:          text,
+		SendAt:        sendAt,
+		CreatedAt:     now,
+	}
+	if err := m.storage.AddScheduled(scheduled); err != nil {
+		return entities.ScheduledMessage{}, scheduledError(err)
+	}
+	m.scheduled.schedule(scheduled)
+	return scheduled, nil
+}
+
+// Returns messages session scheduled in chat that are not sent yet, earliest first
+func (m *Messenger) ListScheduled(sessionUUID string, chatUUID string) ([]entities.ScheduledMessage, error) {
+	if _, err := uuid.Parse(sessionUUID); err != nil {
+		return nil, ErrInvalidSessionUUID
+	}
+	if _, err := uuid.Parse(chatUUID); err != nil {
+		return nil, ErrInvalidChatUUID
+	}
+	scheduled, err := m.storage.GetScheduled(chatUUID, sessionUUID)
+	if err != nil {
+		return nil, scheduledError(err)
+	}
+	return scheduled, nil
+}
+
+// Canceling message session scheduled in chat. Message that is already sent or is being sent right now can't be canceled
+func (m *Messenger) CancelScheduled(sessionUUID string, chatUUID string, scheduledUUID string) error {
+	if _, err := uuid.Parse(sessionUUID); err != nil {
+		return ErrInvalidSessionUUID
+	}
+	if _, err := uuid.Parse(chatUUID); err != nil {
+		return ErrInvalidChatUUID
+	}
+	if _, err := uuid.Parse(scheduledUUID); err != nil {
+		return ErrInvalidScheduledUUID
+	}
+
+	//Session can cancel only its own messages
+	scheduled, err := m.storage.GetScheduled(chatUUID, sessionUUID)
+	if err != nil {
+		return scheduledError(err)
+	}
+	found := false
+	for _, v := range scheduled {
+		found = found || v.ScheduledUUID == scheduledUUID
+	}
+	if !found {
+		return ErrScheduledNotFound
+	}
+
+	//Message is claimed like it's claimed for sending, message that is being sent right now can't be canceled
+	claimed, err := m.storage.ClaimScheduled(chatUUID, scheduledUUID, scheduledClaimLease)
+	if err != nil {
+		return scheduledError(err)
+	}
+	if !claimed {
+		return ErrScheduledNotFound
+	}
+	deleted, err := m.storage.DeleteScheduled(chatUUID, scheduledUUID)
+	if err != nil {
+		return scheduledError(err)
+	}
+	//Message was sent between requests
+	if !deleted {
+		return ErrScheduledNotFound
+	}
+	m.scheduled.cancel(scheduledUUID)
+	return nil
+}
+
+// Sending scheduled message which time has come. Message is claimed first, so it's sent only by one replica and not sent
+// if it was canceled. It's sent like any other message, so session has to be able to post to chat now.
+// Message is deleted from storage after it's sent or if it can't be sent anymore. If sending failed for other reasons (ex. storage
+// is unavailable), claim is released and message is sent again after the next sync. Message is sent with idempotency key,
+// so message that was sent by replica stopped before deleting it isn't stored twice within idempotency window
+func (m *Messenger) sendScheduled(scheduled entities.ScheduledMessage) {
+	claimed, err := m.storage.ClaimScheduled(scheduled.ChatUUID, scheduled.ScheduledUUID, scheduledClaimLease)
+	//Chat was deleted together with its scheduled messages
+	if errors.Is(err, repository.ErrNotFound) {
+		return
+	}
+	if err != nil {
+		logger.LogScheduledSend(scheduled.SessionUUID, scheduled.ChatUUID, scheduled.ScheduledUUID, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	_, err = m.SendMessage(scheduled.SessionUUID, scheduled.ChatUUID, scheduled.Text, nil, "", 0, scheduledIdempotencyPrefix+scheduled.ScheduledUUID)
+	logger.LogScheduledSend(scheduled.SessionUUID, scheduled.ChatUUID, scheduled.ScheduledUUID, err)
+	//Message is stored even if its idempotency key isn't marked as sent
+	sent := err == nil || errors.Is(err, ErrIdempotencyKeyNotMarked)
+	if !sent && !unsendable(err) {
+		if err := m.storage.ReleaseScheduled(scheduled.ChatUUID, scheduled.ScheduledUUID); err != nil && !errors.Is(err, repository.ErrNotFound) {
+			logger.LogScheduledSend(scheduled.SessionUUID, scheduled.ChatUUID, scheduled.ScheduledUUID, err)
+		}
+		return
+	}
+	if sent {
+		metric.MessagesPerChat.WithLabelValues(scheduled.ChatUUID).Inc()
+	}
+	if _, err := m.storage.DeleteScheduled(scheduled.ChatUUID, scheduled.ScheduledUUID); err != nil && !errors.Is(err, repository.ErrNotFound) {
+		logger.LogScheduledSend(scheduled.SessionUUID, scheduled.ChatUUID, scheduled.ScheduledUUID, err)
+	}
+}
+
+// Scheduled message isn't sent again if chat or session doesn't exist anymore or session can't post to chat now
+func unsendable(err error) bool {
+	return errors.Is(err, ErrChatNotFound) || errors.Is(err, ErrUserDoesNotExist) || errors.Is(err, ErrProhibited) ||
+		errors.Is(err, ErrNotMember) || errors.Is(err, ErrBanned) || errors.Is(err, ErrMuted)
+}
+
+// Loading scheduled messages from storage at start and then periodically
+func (m *Messenger) syncScheduled() {
+	ticker := time.NewTicker(scheduledSyncInterval)
+	defer ticker.Stop()
+	for {
+		scheduled, err := m.storage.GetDueScheduled(time.Now().Add(scheduledSyncInterval))
+		if err != nil {
+			logger.LogScheduledSync(err)
+		}
+		for _, v := range scheduled {
+			m.scheduled.schedule(v)
+		}
+		if !m.tick(ticker) {
+			return
+		}
+	}
+}
+
+func scheduledError(err error) error {
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrChatNotFound
+	}
+	if errors.Is(err, repository.ErrUserDoesntExist) {
+		return ErrUserDoesNotExist
+	}
+	return fmt.Errorf("messenger: %w", err)
+}
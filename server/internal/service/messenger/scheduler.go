@@ -0,0 +1,143 @@
+package messenger
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// Single scheduler of delayed jobs instead of timer per job. Pending jobs are kept in min-heap by their time,
+// the only timer is set to the nearest one. Job with the same key is kept once, scheduling it again changes its time
+type scheduler[T any] struct {
+	mu    sync.Mutex
+	queue jobQueue[T]
+	byKey map[string]*job[T]
+	timer *time.Timer
+	// held while due jobs run: timer can fire again before they finish, jobs are never run concurrently
+	running sync.Mutex
+	stopped bool
+	// key identifies job, at is time when job is due
+	key func(T) string
+	at  func(T) time.Time
+	run func(T)
+	// called with number of pending jobs when it changes
+	pending func(n int)
+}
+
+type job[T any] struct {
+	value T
+	at    time.Time
+	index int
+}
+
+// run is called for every job when its time comes, one by one
+func newScheduler[T any](key func(T) string, at func(T) time.Time, run func(T), pending func(n int)) *scheduler[T] {
+	s := &scheduler[T]{
+		byKey:   make(map[string]*job[T]),
+		key:     key,
+		at:      at,
+		run:     run,
+		pending: pending,
+	}
+	s.timer = time.AfterFunc(time.Hour, s.runDue)
+	s.timer.Stop()
+	return s
+}
+
+func (s *scheduler[T]) schedule(v T) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if pending, ok := s.byKey[s.key(v)]; ok {
+		pending.value, pending.at = v, s.at(v)
+		heap.Fix(&s.queue, pending.index)
+	} else {
+		pending = &job[T]{value: v, at: s.at(v)}
+		heap.Push(&s.queue, pending)
+		s.byKey[s.key(v)] = pending
+	}
+	s.reset()
+}
+
+func (s *scheduler[T]) cancel(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending, ok := s.byKey[key]
+	if !ok {
+		return
+	}
+	heap.Remove(&s.queue, pending.index)
+	delete(s.byKey, key)
+	s.reset()
+}
+
+// Taking all due jobs out of heap and running them, then waiting for the next one
+func (s *scheduler[T]) runDue() {
+	s.running.Lock()
+	defer s.running.Unlock()
+
+	now := time.Now()
+	var due []T
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+	for len(s.queue) > 0 && !s.queue[0].at.After(now) {
+		j := heap.Pop(&s.queue).(*job[T])
+		delete(s.byKey, s.key(j.value))
+		due = append(due, j.value)
+	}
+	s.reset()
+	s.mu.Unlock()
+
+	for _, v := range due {
+		s.run(v)
+	}
+}
+
+// Stopping timer for good and waiting for jobs that are running. Pending jobs are not run, they are kept in storage
+func (s *scheduler[T]) stop() {
+	s.mu.Lock()
+	s.stopped = true
+	s.timer.Stop()
+	s.mu.Unlock()
+
+	s.running.Lock()
+	defer s.running.Unlock()
+}
+
+// Setting timer to the nearest job. mu must be held
+func (s *scheduler[T]) reset() {
+	s.pending(len(s.queue))
+	s.timer.Stop()
+	if len(s.queue) > 0 && !s.stopped {
+		s.timer.Reset(time.Until(s.queue[0].at))
+	}
+}
+
+// Min-heap of pending jobs for container/heap
+type jobQueue[T any] []*job[T]
+
+func (q jobQueue[T]) Len() int { return len(q) }
+
+func (q jobQueue[T]) Less(i, j int) bool { return q[i].at.Before(q[j].at) }
+
+func (q jobQueue[T]) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *jobQueue[T]) Push(x any) {
+	j := x.(*job[T])
+	j.index = len(*q)
+	*q = append(*q, j)
+}
+
+func (q *jobQueue[T]) Pop() any {
+	old := *q
+	j := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return j
+}
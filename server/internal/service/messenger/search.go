@@ -0,0 +1,120 @@
+package messenger
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"github.com/Rolan335/grpcMessenger/server/internal/repository"
+	"github.com/Rolan335/grpcMessenger/server/internal/repository/entities"
+)
+
+// Limits of search: words in query, messages on one page (page size 0 is the default one)
+const (
+	maxSearchTerms        = 10
+	defaultSearchPageSize = 20
+	maxSearchPageSize     = 100
+)
+
+// Searching messages that contain all words of query. Without chatUUID messages are searched in all chats session can read:
+// open chats, members-only and direct chats session is a member of. Chats session is banned in are skipped
+func (m *Messenger) SearchMessages(sessionUUID string, query string, chatUUID string, pageSize int, pageToken string) ([]entities.FoundMessage, string, error) {
+	if sessionUUID != "" {
+		if _, err := uuid.Parse(sessionUUID); err != nil {
+			return nil, "", ErrInvalidSessionUUID
+		}
+	}
+	if chatUUID != "" {
+		if _, err := uuid.Parse(chatUUID); err != nil {
+			return nil, "", ErrInvalidChatUUID
+		}
+	}
+	terms := repository.SearchTerms(query)
+	if len(terms) == 0 || len(terms) > maxSearchTerms {
+		return nil, "", ErrInvalidQuery
+	}
+	if pageSize < 0 {
+		return nil, "", ErrInvalidPageSize
+	}
+	if pageSize == 0 {
+		pageSize = defaultSearchPageSize
+	}
+	pageSize = min(pageSize, maxSearchPageSize)
+	//Page token is opaque for client, storage works with cursor
+	cursor, err := base64.RawURLEncoding.DecodeString(pageToken)
+	if err != nil {
+		return nil, "", ErrInvalidPageToken
+	}
+
+	//Search in one chat is available to those who can read it
+	readable := make(map[string]bool)
+	if chatUUID != "" {
+		p, err := m.permissions(sessionUUID, chatUUID)
+		if err != nil {
+			return nil, "", err
+		}
+		if err := p.checkRead(); err != nil {
+			return nil, "", err
+		}
+		readable[chatUUID] = true
+	}
+
+	//Messages of chats session can't read are skipped, so storage is asked for pages until the page is full
+	var found []entities.FoundMessage
+	nextCursor := string(cursor)
+	for {
+		results, storageCursor, err := m.storage.SearchMessages(terms, chatUUID, entities.Page{Cursor: nextCursor, Limit: pageSize})
+		if err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				return nil, "", ErrChatNotFound
+			}
+			if errors.Is(err, repository.ErrInvalidCursor) {
+				return nil, "", ErrInvalidPageToken
+			}
+			return nil, "", fmt.Errorf("messenger: %w", err)
+		}
+		nextCursor = storageCursor
+		for i, v := range results {
+			ok, err := m.canRead(readable, sessionUUID, v.ChatUUID)
+			if err != nil {
+				return nil, "", err
+			}
+			if !ok {
+				continue
+			}
+			v.Highlights = repository.Highlights(v.Message.Text, terms)
+			found = append(found, v)
+			//Next page starts after the last message of this page
+			if len(found) == pageSize {
+				if i < len(results)-1 || nextCursor != "" {
+					nextCursor = v.Cursor
+				}
+				if err := m.fillFoundReactions(sessionUUID, found); err != nil {
+					return nil, "", err
+				}
+				return found, base64.RawURLEncoding.EncodeToString([]byte(nextCursor)), nil
+			}
+		}
+		if nextCursor == "" {
+			if err := m.fillFoundReactions(sessionUUID, found); err != nil {
+				return nil, "", err
+			}
+			return found, "", nil
+		}
+	}
+}
+
+// Checking if session can read chat, result is remembered for the rest of search. Chat deleted during search is not readable
+func (m *Messenger) canRead(readable map[string]bool, sessionUUID string, chatUUID string) (bool, error) {
+	if ok, checked := readable[chatUUID]; checked {
+		return ok, nil
+	}
+	p, err := m.permissions(sessionUUID, chatUUID)
+	if err != nil && !errors.Is(err, ErrChatNotFound) {
+		return false, err
+	}
+	readable[chatUUID] = err == nil && p.checkRead() == nil
+	return readable[chatUUID], nil
+}
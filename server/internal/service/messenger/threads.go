@@ -0,0 +1,60 @@
+package messenger
+
+import (
+	"github.com/google/uuid"
+
+	"github.com/Rolan335/grpcMessenger/server/internal/repository/entities"
+)
+
+// Getting thread of message: root message with number of replies and replies ordered by seq. Thread of reply is the thread of its root.
+// Deleted messages stay in thread as tombstones. sessionUUID can be empty for open chats
+func (m *Messenger) GetThread(sessionUUID string, chatUUID string, messageUUID string) (entities.Message, []entities.Message, error) {
+	if sessionUUID != "" {
+		if _, err := uuid.Parse(sessionUUID); err != nil {
+			return entities.Message{}, nil, ErrInvalidSessionUUID
+		}
+	}
+	if _, err := uuid.Parse(chatUUID); err != nil {
+		return entities.Message{}, nil, ErrInvalidChatUUID
+	}
+	if _, err := uuid.Parse(messageUUID); err != nil {
+		return entities.Message{}, nil, ErrInvalidMessageUUID
+	}
+
+	//thread is available to those who can read chat
+	p, err := m.permissions(sessionUUID, chatUUID)
+	if err != nil {
+		return entities.Message{}, nil, err
+	}
+	if err := p.checkRead(); err != nil {
+		return entities.Message{}, nil, err
+	}
+
+	thread, err := m.storage.GetThread(chatUUID, messageUUID)
+	if err != nil {
+		return entities.Message{}, nil, changeMessageError(err)
+	}
+	if err := m.fillReactions(sessionUUID, chatUUID, thread); err != nil {
+		return entities.Message{}, nil, err
+	}
+	return thread[0], thread[1:], nil
+}
+
+// Root of thread the new message replies to. Threads have one level: reply to reply goes to the same thread.
+// Deleted message can't be replied to
+func (m *Messenger) threadRoot(chatUUID string, replyTo string) (string, error) {
+	if replyTo == "" {
+		return "", nil
+	}
+	if _, err := uuid.Parse(replyTo); err != nil {
+		return "", ErrInvalidMessageUUID
+	}
+	message, err := m.storage.GetMessage(chatUUID, replyTo)
+	if err != nil {
+		return "", changeMessageError(err)
+	}
+	if message.ReplyTo != "" {
+		return message.ReplyTo, nil
+	}
+	return message.MessageUUID, nil
+}
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE INDEX idx_message_chat_uuid_created_at_message_uuid ON messages(chat_uuid, created_at, message_uuid);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_message_chat_uuid_created_at_message_uuid;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE INDEX idx_chats_created_at_chat_uuid ON chats(created_at, chat_uuid);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_chats_created_at_chat_uuid;
+-- +goose StatementEnd
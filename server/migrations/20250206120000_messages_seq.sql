@@ -0,0 +1,31 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS last_seq BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS seq BIGINT;
+
+UPDATE messages
+SET seq = numbered.seq
+FROM (
+    SELECT message_uuid, ROW_NUMBER() OVER (PARTITION BY chat_uuid ORDER BY created_at, message_uuid) AS seq
+    FROM messages
+) AS numbered
+WHERE messages.message_uuid = numbered.message_uuid;
+
+UPDATE chats
+SET last_seq = COALESCE((SELECT MAX(seq) FROM messages WHERE messages.chat_uuid = chats.chat_uuid), 0);
+
+ALTER TABLE messages ALTER COLUMN seq SET NOT NULL;
+
+CREATE UNIQUE INDEX idx_message_chat_uuid_seq ON messages(chat_uuid, seq);
+DROP INDEX IF EXISTS idx_message_chat_uuid_created_at_message_uuid;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS idx_message_chat_uuid_created_at_message_uuid ON messages(chat_uuid, created_at, message_uuid);
+DROP INDEX IF EXISTS idx_message_chat_uuid_seq;
+ALTER TABLE messages DROP COLUMN IF EXISTS seq;
+ALTER TABLE chats DROP COLUMN IF EXISTS last_seq;
+-- +goose StatementEnd
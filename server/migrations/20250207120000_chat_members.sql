@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- 0 - open, 1 - members only
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS visibility SMALLINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS chat_members(
+    chat_uuid UUID,
+    session_uuid UUID,
+    joined_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (chat_uuid, session_uuid),
+    CONSTRAINT fk_chat_members_chat_uuid FOREIGN KEY (chat_uuid) REFERENCES chats (chat_uuid) ON DELETE CASCADE,
+    CONSTRAINT fk_chat_members_session_uuid FOREIGN KEY (session_uuid) REFERENCES users (session_uuid) ON DELETE CASCADE
+);
+
+-- creators of existing chats become their members
+INSERT INTO chat_members (chat_uuid, session_uuid, joined_at)
+SELECT chat_uuid, session_uuid, created_at FROM chats
+WHERE session_uuid IS NOT NULL
+ON CONFLICT DO NOTHING;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS chat_members;
+ALTER TABLE chats DROP COLUMN IF EXISTS visibility;
+-- +goose StatementEnd
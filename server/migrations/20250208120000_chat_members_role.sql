@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- 0 - member, 1 - moderator, 2 - admin, 3 - owner
+ALTER TABLE chat_members ADD COLUMN IF NOT EXISTS role SMALLINT NOT NULL DEFAULT 0;
+
+-- creators of existing chats become their owners
+UPDATE chat_members
+SET role = 3
+FROM chats
+WHERE chats.chat_uuid = chat_members.chat_uuid AND chats.session_uuid = chat_members.session_uuid;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE chat_members DROP COLUMN IF EXISTS role;
+-- +goose StatementEnd
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- bans and mutes of sessions in chats. kind: 0 - ban, 1 - mute. until is NULL for restriction without end
+CREATE TABLE IF NOT EXISTS chat_restrictions(
+    chat_uuid UUID,
+    session_uuid UUID,
+    kind SMALLINT,
+    until TIMESTAMPTZ,
+    PRIMARY KEY (chat_uuid, session_uuid, kind),
+    CONSTRAINT fk_chat_restrictions_chat_uuid FOREIGN KEY (chat_uuid) REFERENCES chats (chat_uuid) ON DELETE CASCADE,
+    CONSTRAINT fk_chat_restrictions_session_uuid FOREIGN KEY (session_uuid) REFERENCES users (session_uuid) ON DELETE CASCADE
+);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS chat_restrictions;
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS title TEXT NOT NULL DEFAULT '';
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+
+-- searching chats by tag
+CREATE INDEX IF NOT EXISTS idx_chats_tags ON chats USING GIN (tags);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_chats_tags;
+ALTER TABLE chats DROP COLUMN IF EXISTS tags;
+ALTER TABLE chats DROP COLUMN IF EXISTS description;
+ALTER TABLE chats DROP COLUMN IF EXISTS title;
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- time of deletion by ttl, NULL - chat is not deleted
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+UPDATE chats SET expires_at = created_at + ttl * interval '1 second' WHERE ttl > 0;
+
+-- scheduler loads chats that expire soon
+CREATE INDEX IF NOT EXISTS idx_chats_expires_at ON chats (expires_at) WHERE expires_at IS NOT NULL;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_chats_expires_at;
+ALTER TABLE chats DROP COLUMN IF EXISTS expires_at;
+-- +goose StatementEnd
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- words of message for full-text search, 'simple' config only lowercases words like repository.SearchTerms does
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS search TSVECTOR
+    GENERATED ALWAYS AS (to_tsvector('simple', COALESCE(text, ''))) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_messages_search ON messages USING GIN (search);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_messages_search;
+ALTER TABLE messages DROP COLUMN IF EXISTS search;
+-- +goose StatementEnd
@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- attachments uploaded to chats. Content is kept in blob store under blob_key (SHA-256 of content)
+CREATE TABLE IF NOT EXISTS attachments(
+    chat_uuid UUID,
+    attachment_uuid UUID,
+    session_uuid UUID,
+    blob_key TEXT NOT NULL,
+    name TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size BIGINT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (chat_uuid, attachment_uuid),
+    CONSTRAINT fk_attachments_chat_uuid FOREIGN KEY (chat_uuid) REFERENCES chats (chat_uuid) ON DELETE CASCADE,
+    CONSTRAINT fk_attachments_session_uuid FOREIGN KEY (session_uuid) REFERENCES users (session_uuid) ON DELETE CASCADE
+);
+
+-- attachments of message, tombstone of deleted message has none
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS attachment_ids UUID[] NOT NULL DEFAULT '{}';
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE messages DROP COLUMN IF EXISTS attachment_ids;
+DROP TABLE IF EXISTS attachments;
+-- +goose StatementEnd
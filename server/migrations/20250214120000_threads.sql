@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- root of thread message replies to. Replies of root trimmed by MaxChatSize become ordinary messages
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS reply_to UUID
+    CONSTRAINT fk_messages_reply_to REFERENCES messages (message_uuid) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_messages_reply_to ON messages(reply_to) WHERE reply_to IS NOT NULL;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_messages_reply_to;
+ALTER TABLE messages DROP COLUMN IF EXISTS reply_to;
+-- +goose StatementEnd
@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- emoji reactions of sessions on messages. Reactions are deleted with message, tombstone of deleted message has none
+CREATE TABLE IF NOT EXISTS reactions(
+    chat_uuid UUID,
+    message_uuid UUID,
+    session_uuid UUID,
+    emoji TEXT,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (message_uuid, session_uuid, emoji),
+    CONSTRAINT fk_reactions_chat_uuid FOREIGN KEY (chat_uuid) REFERENCES chats (chat_uuid) ON DELETE CASCADE,
+    CONSTRAINT fk_reactions_message_uuid FOREIGN KEY (message_uuid) REFERENCES messages (message_uuid) ON DELETE CASCADE,
+    CONSTRAINT fk_reactions_session_uuid FOREIGN KEY (session_uuid) REFERENCES users (session_uuid) ON DELETE CASCADE
+);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS reactions;
+-- +goose StatementEnd
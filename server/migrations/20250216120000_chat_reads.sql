@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- last-read pointers of sessions in chats: seq of the last message session has read and when pointer was moved
+CREATE TABLE IF NOT EXISTS chat_reads(
+    chat_uuid UUID,
+    session_uuid UUID,
+    seq BIGINT NOT NULL,
+    read_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (chat_uuid, session_uuid),
+    CONSTRAINT fk_chat_reads_chat_uuid FOREIGN KEY (chat_uuid) REFERENCES chats (chat_uuid) ON DELETE CASCADE,
+    CONSTRAINT fk_chat_reads_session_uuid FOREIGN KEY (session_uuid) REFERENCES users (session_uuid) ON DELETE CASCADE
+);
+
+-- chats of session are listed by its memberships
+CREATE INDEX IF NOT EXISTS idx_chat_members_session_uuid ON chat_members(session_uuid);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_chat_members_session_uuid;
+DROP TABLE IF EXISTS chat_reads;
+-- +goose StatementEnd
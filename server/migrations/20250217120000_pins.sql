@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- pinned messages of chats. Pinned message is not trimmed from chat, pin is deleted with message
+CREATE TABLE IF NOT EXISTS pins(
+    message_uuid UUID PRIMARY KEY,
+    chat_uuid UUID NOT NULL,
+    session_uuid UUID NOT NULL,
+    pinned_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fk_pins_message_uuid FOREIGN KEY (message_uuid) REFERENCES messages (message_uuid) ON DELETE CASCADE,
+    CONSTRAINT fk_pins_chat_uuid FOREIGN KEY (chat_uuid) REFERENCES chats (chat_uuid) ON DELETE CASCADE,
+    CONSTRAINT fk_pins_session_uuid FOREIGN KEY (session_uuid) REFERENCES users (session_uuid) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_pins_chat_uuid ON pins(chat_uuid);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_pins_chat_uuid;
+DROP TABLE IF EXISTS pins;
+-- +goose StatementEnd
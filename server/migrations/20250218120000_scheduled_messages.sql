@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- messages that will be sent to chat later. Row is deleted when message is sent or canceled
+CREATE TABLE IF NOT EXISTS scheduled_messages(
+    scheduled_uuid UUID PRIMARY KEY,
+    chat_uuid UUID NOT NULL,
+    session_uuid UUID NOT NULL,
+    text TEXT NOT NULL,
+    send_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fk_scheduled_messages_chat_uuid FOREIGN KEY (chat_uuid) REFERENCES chats (chat_uuid) ON DELETE CASCADE,
+    CONSTRAINT fk_scheduled_messages_session_uuid FOREIGN KEY (session_uuid) REFERENCES users (session_uuid) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_scheduled_messages_send_at ON scheduled_messages(send_at);
+CREATE INDEX IF NOT EXISTS idx_scheduled_messages_chat_session ON scheduled_messages(chat_uuid, session_uuid);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_scheduled_messages_chat_session;
+DROP INDEX IF EXISTS idx_scheduled_messages_send_at;
+DROP TABLE IF EXISTS scheduled_messages;
+-- +goose StatementEnd
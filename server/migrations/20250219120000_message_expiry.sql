@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- time when message is replaced with tombstone, NULL - message doesn't expire
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_messages_expires_at ON messages(expires_at) WHERE expires_at IS NOT NULL AND NOT deleted;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_messages_expires_at;
+ALTER TABLE messages DROP COLUMN IF EXISTS expires_at;
+-- +goose StatementEnd
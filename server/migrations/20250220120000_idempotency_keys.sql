@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- idempotency keys of messages sent by sessions. Expired keys of session are deleted when session sends message with key
+CREATE TABLE IF NOT EXISTS idempotency_keys(
+    session_uuid UUID NOT NULL,
+    key TEXT NOT NULL,
+    message_uuid UUID NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (session_uuid, key),
+    CONSTRAINT fk_idempotency_keys_session_uuid FOREIGN KEY (session_uuid) REFERENCES users (session_uuid) ON DELETE CASCADE
+);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS idempotency_keys;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- time until message is claimed by replica that sends or cancels it, NULL - message is not claimed
+ALTER TABLE scheduled_messages ADD COLUMN IF NOT EXISTS claimed_until TIMESTAMPTZ;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE scheduled_messages DROP COLUMN IF EXISTS claimed_until;
+-- +goose StatementEnd
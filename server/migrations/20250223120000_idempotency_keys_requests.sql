@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- request remembered with key: chat and hash of the rest of request, so retry of another request with the same key is detected.
+-- sent is set when message is stored, keys remembered before were sent
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS chat_uuid UUID;
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS request_hash TEXT NOT NULL DEFAULT '';
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS sent BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS remembered_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS remembered_at;
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS sent;
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS request_hash;
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS chat_uuid;
+-- +goose StatementEnd
@@ -2,24 +2,33 @@ package test
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/Rolan335/grpcMessenger/server/internal/app"
 	"github.com/Rolan335/grpcMessenger/server/internal/config"
 	"github.com/Rolan335/grpcMessenger/server/internal/logger"
+	"github.com/Rolan335/grpcMessenger/server/internal/repository"
+	"github.com/Rolan335/grpcMessenger/server/internal/repository/entities"
+	"github.com/Rolan335/grpcMessenger/server/internal/repository/inmemory"
+	"github.com/Rolan335/grpcMessenger/server/internal/repository/redis"
 	"github.com/Rolan335/grpcMessenger/server/internal/service/messenger"
 	"github.com/Rolan335/grpcMessenger/server/pkg/proto"
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
+	goredis "github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type noOpWriter struct{}
@@ -28,6 +37,34 @@ func (n *noOpWriter) Write(p []byte) (int, error) {
 	return 0, nil
 }
 
+// Storage that fails to store messages or, with keepMessages, to mark idempotency keys as sent, like storage that is unavailable
+type failingStorage struct {
+	*inmemory.Storage
+	keepMessages bool
+}
+
+func (f failingStorage) AddMessage(chatUUID string, message entities.Message) (entities.Message, error) {
+	if f.keepMessages {
+		return f.Storage.AddMessage(chatUUID, message)
+	}
+	return entities.Message{}, errors.New("storage is unavailable")
+}
+
+func (f failingStorage) MarkIdempotencyKeySent(_ string, _ string, _ string) error {
+	return errors.New("storage is unavailable")
+}
+
+// Storage that stores messages only after release is closed, like storage that is slow
+type blockingStorage struct {
+	*inmemory.Storage
+	release chan struct{}
+}
+
+func (b blockingStorage) AddMessage(chatUUID string, message entities.Message) (entities.Message, error) {
+	<-b.release
+	return b.Storage.AddMessage(chatUUID, message)
+}
+
 func parseConfig() config.ServiceCfg {
 	err := godotenv.Load(".env.test")
 	if err != nil {
@@ -42,6 +79,14 @@ func parseConfig() config.ServiceCfg {
 	if err != nil {
 		panic("failed to parse maxChats .env:" + err.Error())
 	}
+	maxPinned, err := strconv.Atoi(os.Getenv("APP_MAXPINNED"))
+	if err != nil {
+		panic("failed to parse maxPinned .env:" + err.Error())
+	}
+	idempotencyWindow, err := strconv.Atoi(os.Getenv("APP_IDEMPOTENCYWINDOW"))
+	if err != nil {
+		panic("failed to parse idempotencyWindow .env:" + err.Error())
+	}
 	return config.MustConfigInit(
 		os.Getenv("APP_ADDRESS"),
 		os.Getenv("APP_PORTGRPC"),
@@ -49,6 +94,8 @@ func parseConfig() config.ServiceCfg {
 		os.Getenv("APP_ENV"),
 		maxChatSize,
 		maxChats,
+		maxPinned,
+		idempotencyWindow,
 		os.Getenv("APP_DB"),
 	)
 }
@@ -179,7 +226,7 @@ func TestRepositories(t *testing.T) {
 					SessionUuid: newUser.GetSessionUuid(),
 					Message:     "hello",
 				})
-				a.ErrorIs(err, status.Error(codes.PermissionDenied, "prohibited. Only creator can send"), "should return proper mistake")
+				a.ErrorIs(err, status.Error(codes.PermissionDenied, messenger.ErrProhibited.Error()), "should return proper mistake")
 			})
 
 			t.Run("Invalid uuid createChat", func(t *testing.T) {
@@ -321,6 +368,125 @@ func TestServer(t *testing.T) {
 		}
 	})
 
+	t.Run("GetHistory pagination", func(t *testing.T) {
+		texts := make([]string, 0, serverConfig.MaxChatSize)
+		pageToken := ""
+		for {
+			resp, err := c.GetHistory(ctx, &proto.GetHistoryRequest{
+				ChatUuid:  chatsCreated[0],
+				PageSize:  2,
+				PageToken: pageToken,
+				Direction: proto.HistoryDirection_HISTORY_DIRECTION_NEWER,
+			})
+			if !a.NoError(err, "c.GetHistory shouldn't return an error") {
+				return
+			}
+			a.LessOrEqual(len(resp.GetMessages()), 2, "page shouldn't be bigger than page size")
+			for _, v := range resp.GetMessages() {
+				texts = append(texts, v.GetText())
+			}
+			pageToken = resp.GetNextPageToken()
+			if pageToken == "" {
+				break
+			}
+		}
+		//6 messages sent, 5 should stay
+		for i, v := range texts {
+			a.Equal(messages[i+1].GetMessage(), v, "pages should contain all messages in order")
+		}
+		a.Equal(serverConfig.MaxChatSize, len(texts), "pages should contain all messages")
+	})
+
+	t.Run("SubscribeChat", func(t *testing.T) {
+		stream, err := c.SubscribeChat(ctx, &proto.SubscribeChatRequest{
+			ChatUuid:   chatsCreated[0],
+			ReplayLast: 2,
+		})
+		a.NoError(err, "c.SubscribeChat shouldn't return an error")
+		//2 last messages should be replayed
+		for _, v := range messages[len(messages)-2:] {
+			resp, err := stream.Recv()
+			if a.NoError(err, "stream.Recv shouldn't return an error") {
+				a.Equal(v.GetMessage(), resp.GetText(), "replayed messages should be equal to last sent")
+			}
+		}
+		_, err = c.SendMessage(ctx, &proto.SendMessageRequest{
+			ChatUuid:    chatsCreated[0],
+			SessionUuid: clientUuid,
+			Message:     "7",
+		})
+		a.NoError(err, "c.SendMessage shouldn't return an error")
+		resp, err := stream.Recv()
+		if a.NoError(err, "stream.Recv shouldn't return an error") {
+			a.Equal("7", resp.GetText(), "new message should be delivered to subscriber")
+		}
+	})
+
+	t.Run("Chat", func(t *testing.T) {
+		stream, err := c.Chat(ctx)
+		a.NoError(err, "c.Chat shouldn't return an error")
+		err = stream.Send(&proto.ChatRequest{
+			ChatUuid:    chatsCreated[0],
+			SessionUuid: clientUuid,
+			Message:     "8",
+		})
+		a.NoError(err, "stream.Send shouldn't return an error")
+		resp, err := stream.Recv()
+		if a.NoError(err, "stream.Recv shouldn't return an error") {
+			_, err := uuid.Parse(resp.GetAck().GetMessageUuid())
+			a.NoError(err, "acked uuid parsed successfully")
+		}
+
+		//rejected message is nacked, stream stays open
+		err = stream.Send(&proto.ChatRequest{Message: "reply", ReplyToMessageUuid: uuid.NewString()})
+		a.NoError(err, "stream.Send shouldn't return an error")
+		resp, err = stream.Recv()
+		if a.NoError(err, "stream.Recv shouldn't return an error") {
+			a.EqualValues(codes.NotFound, resp.GetNack().GetCode(), "reply to missing message should be nacked")
+		}
+		err = stream.Send(&proto.ChatRequest{Message: "8.1"})
+		a.NoError(err, "stream.Send shouldn't return an error")
+		resp, err = stream.Recv()
+		if a.NoError(err, "stream.Recv shouldn't return an error") {
+			a.NotEmpty(resp.GetAck().GetMessageUuid(), "message after nacked one should be acked")
+		}
+
+		//edit of acked message is delivered to the same stream
+		_, err = c.EditMessage(ctx, &proto.EditMessageRequest{ChatUuid: chatsCreated[0], SessionUuid: clientUuid, MessageUuid: resp.GetAck().GetMessageUuid(), Text: "8.2"})
+		a.NoError(err, "c.EditMessage shouldn't return an error")
+		resp, err = stream.Recv()
+		if a.NoError(err, "stream.Recv shouldn't return an error") {
+			a.Equal("8.2", resp.GetMessage().GetText(), "edit of own message should be delivered")
+		}
+
+		//resent message with idempotency key is acked with the first one and its edits are still delivered
+		err = stream.Send(&proto.ChatRequest{Message: "8.3", IdempotencyKey: "chat-key"})
+		a.NoError(err, "stream.Send shouldn't return an error")
+		first, err := stream.Recv()
+		a.NoError(err, "stream.Recv shouldn't return an error")
+		err = stream.Send(&proto.ChatRequest{Message: "8.3", IdempotencyKey: "chat-key"})
+		a.NoError(err, "stream.Send shouldn't return an error")
+		resp, err = stream.Recv()
+		if a.NoError(err, "stream.Recv shouldn't return an error") {
+			a.Equal(first.GetAck().GetMessageUuid(), resp.GetAck().GetMessageUuid(), "resent message shouldn't be sent again")
+		}
+		_, err = c.EditMessage(ctx, &proto.EditMessageRequest{ChatUuid: chatsCreated[0], SessionUuid: clientUuid, MessageUuid: resp.GetAck().GetMessageUuid(), Text: "8.4"})
+		a.NoError(err, "c.EditMessage shouldn't return an error")
+		resp, err = stream.Recv()
+		if a.NoError(err, "stream.Recv shouldn't return an error") {
+			a.Equal("8.4", resp.GetMessage().GetText(), "edit of resent message should be delivered")
+		}
+
+		newUser, _ := c.InitSession(ctx, &proto.InitSessionRequest{})
+		err = stream.Send(&proto.ChatRequest{
+			SessionUuid: newUser.GetSessionUuid(),
+			Message:     "9",
+		})
+		a.NoError(err, "stream.Send shouldn't return an error")
+		_, err = stream.Recv()
+		a.ErrorIs(err, status.Error(codes.InvalidArgument, messenger.ErrStreamTargetChanged.Error()), "should return proper mistake")
+	})
+
 	t.Run("GetActiveChats", func(t *testing.T) {
 		resp, err := c.GetActiveChats(ctx, &proto.GetActiveChatsRequest{})
 		a.NoError(err, "c.GetActiveChats shouldn't return an error")
@@ -329,6 +495,26 @@ func TestServer(t *testing.T) {
 		a.EqualValues(chats[0].GetChatUuid(), chatsCreated[0], "should return same uuid for chat created")
 	})
 
+	t.Run("GetActiveChats filters", func(t *testing.T) {
+		readOnly := true
+		resp, err := c.GetActiveChats(ctx, &proto.GetActiveChatsRequest{
+			SessionUuid: clientUuid,
+			ReadOnly:    &readOnly,
+			PageSize:    1,
+		})
+		a.NoError(err, "c.GetActiveChats shouldn't return an error")
+		if a.Equal(1, len(resp.GetChats()), "readonly chat of session should be returned") {
+			a.Equal(chatsCreated[0], resp.GetChats()[0].GetChatUuid(), "should return same uuid for chat created")
+			a.EqualValues(serverConfig.MaxChatSize, resp.GetChats()[0].GetMessageCount(), "should return count of messages in chat")
+		}
+		a.Empty(resp.GetNextPageToken(), "there should be no next page")
+
+		readOnly = false
+		resp, err = c.GetActiveChats(ctx, &proto.GetActiveChatsRequest{ReadOnly: &readOnly})
+		a.NoError(err, "c.GetActiveChats shouldn't return an error")
+		a.Empty(resp.GetChats(), "there should be no chats that aren't readonly")
+	})
+
 	t.Run("Test ttl", func(t *testing.T) {
 		_, err := c.CreateChat(ctx, &proto.CreateChatRequest{
 			SessionUuid: clientUuid,
@@ -348,7 +534,7 @@ func TestServer(t *testing.T) {
 			SessionUuid: newUser.GetSessionUuid(),
 			Message:     "hello",
 		})
-		a.ErrorIs(err, status.Error(codes.PermissionDenied, "prohibited. Only creator can send"), "should return proper mistake")
+		a.ErrorIs(err, status.Error(codes.PermissionDenied, messenger.ErrProhibited.Error()), "should return proper mistake")
 	})
 
 	t.Run("Invalid uuid createChat", func(t *testing.T) {
@@ -390,4 +576,914 @@ func TestServer(t *testing.T) {
 			a.ErrorIs(err, status.Error(codes.NotFound, "chat not found"), "returned proper error")
 		}
 	})
+
+	t.Run("DeleteChat", func(t *testing.T) {
+		resp, err := c.CreateChat(ctx, &proto.CreateChatRequest{
+			SessionUuid: clientUuid,
+			Ttl:         100,
+			ReadOnly:    false,
+		})
+		a.NoError(err, "no error returned")
+
+		newUser, _ := c.InitSession(ctx, &proto.InitSessionRequest{})
+		_, err = c.DeleteChat(ctx, &proto.DeleteChatRequest{
+			ChatUuid:    resp.GetChatUuid(),
+			SessionUuid: newUser.GetSessionUuid(),
+		})
+		a.ErrorIs(err, status.Error(codes.PermissionDenied, messenger.ErrDeleteProhibited.Error()), "only creator can delete chat")
+
+		_, err = c.DeleteChat(ctx, &proto.DeleteChatRequest{
+			ChatUuid:    resp.GetChatUuid(),
+			SessionUuid: clientUuid,
+		})
+		a.NoError(err, "c.DeleteChat shouldn't return an error")
+
+		_, err = c.DeleteChat(ctx, &proto.DeleteChatRequest{
+			ChatUuid:    resp.GetChatUuid(),
+			SessionUuid: clientUuid,
+		})
+		a.ErrorIs(err, status.Error(codes.NotFound, "chat not found"), "returned proper error")
+	})
+
+	t.Run("EditMessage and DeleteMessage", func(t *testing.T) {
+		chat, err := c.CreateChat(ctx, &proto.CreateChatRequest{
+			SessionUuid: clientUuid,
+			Ttl:         -1,
+			ReadOnly:    false,
+		})
+		a.NoError(err, "no error returned")
+		author, _ := c.InitSession(ctx, &proto.InitSessionRequest{})
+		_, err = c.SendMessage(ctx, &proto.SendMessageRequest{
+			ChatUuid:    chat.GetChatUuid(),
+			SessionUuid: author.GetSessionUuid(),
+			Message:     "helo",
+		})
+		a.NoError(err, "c.SendMessage shouldn't return an error")
+		history, _ := c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: chat.GetChatUuid()})
+		messageUuid := history.GetMessages()[0].GetMessageUuid()
+
+		stranger, _ := c.InitSession(ctx, &proto.InitSessionRequest{})
+		_, err = c.EditMessage(ctx, &proto.EditMessageRequest{
+			ChatUuid:    chat.GetChatUuid(),
+			SessionUuid: stranger.GetSessionUuid(),
+			MessageUuid: messageUuid,
+			Text:        "spam",
+		})
+		a.ErrorIs(err, status.Error(codes.PermissionDenied, messenger.ErrChangeMessageProhibited.Error()), "only author or creator can edit")
+
+		_, err = c.EditMessage(ctx, &proto.EditMessageRequest{
+			ChatUuid:    chat.GetChatUuid(),
+			SessionUuid: author.GetSessionUuid(),
+			MessageUuid: messageUuid,
+			Text:        "hello",
+		})
+		a.NoError(err, "c.EditMessage shouldn't return an error")
+		history, _ = c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: chat.GetChatUuid()})
+		a.Equal("hello", history.GetMessages()[0].GetText(), "text should be changed")
+		a.NotNil(history.GetMessages()[0].GetEditedAt(), "edit time should be set")
+
+		//chat creator deletes message
+		_, err = c.DeleteMessage(ctx, &proto.DeleteMessageRequest{
+			ChatUuid:    chat.GetChatUuid(),
+			SessionUuid: clientUuid,
+			MessageUuid: messageUuid,
+		})
+		a.NoError(err, "c.DeleteMessage shouldn't return an error")
+		history, _ = c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: chat.GetChatUuid()})
+		a.True(history.GetMessages()[0].GetDeleted(), "tombstone should stay in history")
+		a.Empty(history.GetMessages()[0].GetText(), "text of deleted message should be removed")
+	})
+
+	t.Run("Message seq and created_at", func(t *testing.T) {
+		chat, err := c.CreateChat(ctx, &proto.CreateChatRequest{
+			SessionUuid: clientUuid,
+			Ttl:         -1,
+			ReadOnly:    false,
+		})
+		a.NoError(err, "no error returned")
+		for _, text := range []string{"first", "second", "third"} {
+			_, err := c.SendMessage(ctx, &proto.SendMessageRequest{
+				ChatUuid:    chat.GetChatUuid(),
+				SessionUuid: clientUuid,
+				Message:     text,
+			})
+			a.NoError(err, "c.SendMessage shouldn't return an error")
+		}
+
+		history, err := c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: chat.GetChatUuid()})
+		a.NoError(err, "c.GetHistory shouldn't return an error")
+		a.Len(history.GetMessages(), 3, "all messages should be returned")
+		for i, message := range history.GetMessages() {
+			a.NotNil(message.GetCreatedAt(), "creation time should be set by server")
+			if i > 0 {
+				a.Greater(message.GetSeq(), history.GetMessages()[i-1].GetSeq(), "history should be ordered by seq")
+			}
+		}
+		a.Equal("third", history.GetMessages()[2].GetText(), "last sent message should have biggest seq")
+	})
+
+	t.Run("Chat membership", func(t *testing.T) {
+		title := uuid.NewString()
+		chat, err := c.CreateChat(ctx, &proto.CreateChatRequest{
+			SessionUuid: clientUuid,
+			Ttl:         -1,
+			ReadOnly:    false,
+			Visibility:  proto.ChatVisibility_CHAT_VISIBILITY_MEMBERS_ONLY,
+			Title:       title,
+		})
+		a.NoError(err, "no error returned")
+		guest, _ := c.InitSession(ctx, &proto.InitSessionRequest{})
+
+		active, err := c.GetActiveChats(ctx, &proto.GetActiveChatsRequest{Title: title, ViewerSessionUuid: guest.GetSessionUuid()})
+		a.NoError(err, "c.GetActiveChats shouldn't return an error")
+		a.Empty(active.GetChats(), "members-only chat is hidden from non-members")
+		active, _ = c.GetActiveChats(ctx, &proto.GetActiveChatsRequest{Title: title})
+		a.Empty(active.GetChats(), "members-only chat is hidden without viewer")
+		active, _ = c.GetActiveChats(ctx, &proto.GetActiveChatsRequest{Title: title, ViewerSessionUuid: clientUuid})
+		a.Len(active.GetChats(), 1, "members-only chat is shown to its members")
+
+		_, err = c.SendMessage(ctx, &proto.SendMessageRequest{
+			ChatUuid:    chat.GetChatUuid(),
+			SessionUuid: guest.GetSessionUuid(),
+			Message:     "hello",
+		})
+		a.ErrorIs(err, status.Error(codes.PermissionDenied, messenger.ErrNotMember.Error()), "only members can send to members-only chat")
+		_, err = c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: guest.GetSessionUuid()})
+		a.ErrorIs(err, status.Error(codes.PermissionDenied, messenger.ErrNotMember.Error()), "only members can read members-only chat")
+
+		_, err = c.JoinChat(ctx, &proto.JoinChatRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: guest.GetSessionUuid()})
+		a.NoError(err, "c.JoinChat shouldn't return an error")
+		_, err = c.SendMessage(ctx, &proto.SendMessageRequest{
+			ChatUuid:    chat.GetChatUuid(),
+			SessionUuid: guest.GetSessionUuid(),
+			Message:     "hello",
+		})
+		a.NoError(err, "member can send message")
+		stream, err := c.SubscribeChat(ctx, &proto.SubscribeChatRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: guest.GetSessionUuid()})
+		a.NoError(err, "c.SubscribeChat shouldn't return an error")
+		participants, err := c.ListParticipants(ctx, &proto.ListParticipantsRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: guest.GetSessionUuid()})
+		a.NoError(err, "c.ListParticipants shouldn't return an error")
+		a.Len(participants.GetParticipants(), 2, "creator and guest are participants")
+		a.Equal(clientUuid, participants.GetParticipants()[0].GetSessionUuid(), "creator joined first")
+
+		_, err = c.LeaveChat(ctx, &proto.LeaveChatRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid})
+		a.ErrorIs(err, status.Error(codes.PermissionDenied, messenger.ErrLeaveProhibited.Error()), "creator can't leave chat")
+		_, err = c.LeaveChat(ctx, &proto.LeaveChatRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: guest.GetSessionUuid()})
+		a.NoError(err, "c.LeaveChat shouldn't return an error")
+		_, err = stream.Recv()
+		a.ErrorIs(err, io.EOF, "subscription of session is closed after leaving")
+		a.Equal([]string{messenger.ErrChatLeft.Error()}, stream.Trailer().Get("chat-closed"))
+		_, err = c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: guest.GetSessionUuid()})
+		a.ErrorIs(err, status.Error(codes.PermissionDenied, messenger.ErrNotMember.Error()), "history is not available after leaving")
+	})
+
+	t.Run("Chat roles", func(t *testing.T) {
+		chat, err := c.CreateChat(ctx, &proto.CreateChatRequest{
+			SessionUuid: clientUuid,
+			Ttl:         -1,
+			ReadOnly:    true,
+		})
+		a.NoError(err, "no error returned")
+		admin, _ := c.InitSession(ctx, &proto.InitSessionRequest{})
+		moderator, _ := c.InitSession(ctx, &proto.InitSessionRequest{})
+		for _, session := range []string{admin.GetSessionUuid(), moderator.GetSessionUuid()} {
+			_, err := c.JoinChat(ctx, &proto.JoinChatRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: session})
+			a.NoError(err, "c.JoinChat shouldn't return an error")
+		}
+
+		_, err = c.SendMessage(ctx, &proto.SendMessageRequest{
+			ChatUuid:    chat.GetChatUuid(),
+			SessionUuid: admin.GetSessionUuid(),
+			Message:     "hello",
+		})
+		a.ErrorIs(err, status.Error(codes.PermissionDenied, messenger.ErrProhibited.Error()), "member can't send to read-only chat")
+		_, err = c.SetMemberRole(ctx, &proto.SetMemberRoleRequest{
+			ChatUuid:          chat.GetChatUuid(),
+			SessionUuid:       admin.GetSessionUuid(),
+			MemberSessionUuid: admin.GetSessionUuid(),
+			Role:              proto.ChatRole_CHAT_ROLE_ADMIN,
+		})
+		a.ErrorIs(err, status.Error(codes.PermissionDenied, messenger.ErrSetRoleProhibited.Error()), "only owner can grant roles")
+
+		_, err = c.SetMemberRole(ctx, &proto.SetMemberRoleRequest{
+			ChatUuid:          chat.GetChatUuid(),
+			SessionUuid:       clientUuid,
+			MemberSessionUuid: admin.GetSessionUuid(),
+			Role:              proto.ChatRole_CHAT_ROLE_ADMIN,
+		})
+		a.NoError(err, "c.SetMemberRole shouldn't return an error")
+		_, err = c.SetMemberRole(ctx, &proto.SetMemberRoleRequest{
+			ChatUuid:          chat.GetChatUuid(),
+			SessionUuid:       clientUuid,
+			MemberSessionUuid: moderator.GetSessionUuid(),
+			Role:              proto.ChatRole_CHAT_ROLE_MODERATOR,
+		})
+		a.NoError(err, "c.SetMemberRole shouldn't return an error")
+
+		_, err = c.SendMessage(ctx, &proto.SendMessageRequest{
+			ChatUuid:    chat.GetChatUuid(),
+			SessionUuid: admin.GetSessionUuid(),
+			Message:     "hello",
+		})
+		a.NoError(err, "admin can send to read-only chat")
+		history, _ := c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: chat.GetChatUuid()})
+		_, err = c.DeleteMessage(ctx, &proto.DeleteMessageRequest{
+			ChatUuid:    chat.GetChatUuid(),
+			SessionUuid: moderator.GetSessionUuid(),
+			MessageUuid: history.GetMessages()[0].GetMessageUuid(),
+		})
+		a.NoError(err, "moderator can delete message of other member")
+
+		participants, _ := c.ListParticipants(ctx, &proto.ListParticipantsRequest{ChatUuid: chat.GetChatUuid()})
+		a.Equal(proto.ChatRole_CHAT_ROLE_OWNER, participants.GetParticipants()[0].GetRole(), "creator is owner")
+		a.Equal(proto.ChatRole_CHAT_ROLE_ADMIN, participants.GetParticipants()[1].GetRole(), "role should be granted")
+	})
+
+	t.Run("Ban and mute members", func(t *testing.T) {
+		chat, err := c.CreateChat(ctx, &proto.CreateChatRequest{
+			SessionUuid: clientUuid,
+			Ttl:         -1,
+		})
+		a.NoError(err, "no error returned")
+		spammer, _ := c.InitSession(ctx, &proto.InitSessionRequest{})
+
+		_, err = c.BanMember(ctx, &proto.RestrictMemberRequest{
+			ChatUuid:          chat.GetChatUuid(),
+			SessionUuid:       spammer.GetSessionUuid(),
+			MemberSessionUuid: clientUuid,
+		})
+		a.ErrorIs(err, status.Error(codes.PermissionDenied, messenger.ErrRestrictProhibited.Error()), "only moderators can ban")
+
+		_, err = c.BanMember(ctx, &proto.RestrictMemberRequest{
+			ChatUuid:          chat.GetChatUuid(),
+			SessionUuid:       clientUuid,
+			MemberSessionUuid: spammer.GetSessionUuid(),
+			DurationSeconds:   1,
+		})
+		a.NoError(err, "c.BanMember shouldn't return an error")
+		_, err = c.SendMessage(ctx, &proto.SendMessageRequest{
+			ChatUuid:    chat.GetChatUuid(),
+			SessionUuid: spammer.GetSessionUuid(),
+			Message:     "spam",
+		})
+		a.ErrorIs(err, status.Error(codes.PermissionDenied, messenger.ErrBanned.Error()), "banned session can't send")
+		_, err = c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: spammer.GetSessionUuid()})
+		a.ErrorIs(err, status.Error(codes.PermissionDenied, messenger.ErrBanned.Error()), "banned session can't read")
+
+		stream, err := c.SubscribeChat(ctx, &proto.SubscribeChatRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: spammer.GetSessionUuid()})
+		a.NoError(err, "c.SubscribeChat shouldn't return an error")
+		_, err = stream.Recv()
+		a.ErrorIs(err, status.Error(codes.PermissionDenied, messenger.ErrBanned.Error()), "banned session can't subscribe")
+
+		//ban expires by itself
+		time.Sleep(time.Second + 100*time.Millisecond)
+		_, err = c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: spammer.GetSessionUuid()})
+		a.NoError(err, "ban should expire")
+
+		_, err = c.MuteMember(ctx, &proto.RestrictMemberRequest{
+			ChatUuid:          chat.GetChatUuid(),
+			SessionUuid:       clientUuid,
+			MemberSessionUuid: spammer.GetSessionUuid(),
+		})
+		a.NoError(err, "c.MuteMember shouldn't return an error")
+		_, err = c.SendMessage(ctx, &proto.SendMessageRequest{
+			ChatUuid:    chat.GetChatUuid(),
+			SessionUuid: spammer.GetSessionUuid(),
+			Message:     "spam",
+		})
+		a.ErrorIs(err, status.Error(codes.PermissionDenied, messenger.ErrMuted.Error()), "muted session can't send")
+		_, err = c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: spammer.GetSessionUuid()})
+		a.NoError(err, "muted session can read")
+	})
+
+	t.Run("Direct chats", func(t *testing.T) {
+		peer, _ := c.InitSession(ctx, &proto.InitSessionRequest{})
+		stranger, _ := c.InitSession(ctx, &proto.InitSessionRequest{})
+
+		direct, err := c.OpenDirectChat(ctx, &proto.OpenDirectChatRequest{SessionUuid: clientUuid, PeerSessionUuid: peer.GetSessionUuid()})
+		a.NoError(err, "c.OpenDirectChat shouldn't return an error")
+		reopened, err := c.OpenDirectChat(ctx, &proto.OpenDirectChatRequest{SessionUuid: peer.GetSessionUuid(), PeerSessionUuid: clientUuid})
+		a.NoError(err, "c.OpenDirectChat shouldn't return an error")
+		a.Equal(direct.GetChatUuid(), reopened.GetChatUuid(), "the same sessions should get the same chat")
+
+		_, err = c.SendMessage(ctx, &proto.SendMessageRequest{
+			ChatUuid:    direct.GetChatUuid(),
+			SessionUuid: peer.GetSessionUuid(),
+			Message:     "hello",
+		})
+		a.NoError(err, "peer can send to direct chat")
+		_, err = c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: direct.GetChatUuid(), SessionUuid: stranger.GetSessionUuid()})
+		a.ErrorIs(err, status.Error(codes.PermissionDenied, messenger.ErrNotMember.Error()), "only participants can read direct chat")
+		_, err = c.JoinChat(ctx, &proto.JoinChatRequest{ChatUuid: direct.GetChatUuid(), SessionUuid: stranger.GetSessionUuid()})
+		a.ErrorIs(err, status.Error(codes.PermissionDenied, messenger.ErrDirectChatProhibited.Error()), "direct chat can't be joined")
+
+		chats, err := c.GetActiveChats(ctx, &proto.GetActiveChatsRequest{})
+		a.NoError(err, "c.GetActiveChats shouldn't return an error")
+		for _, v := range chats.GetChats() {
+			a.NotEqual(direct.GetChatUuid(), v.GetChatUuid(), "direct chat shouldn't be listed")
+		}
+	})
+
+	t.Run("Chat title, description and tags", func(t *testing.T) {
+		chat, err := c.CreateChat(ctx, &proto.CreateChatRequest{
+			SessionUuid: clientUuid,
+			Ttl:         -1,
+			Title:       "Gophers",
+			Description: "everything about go",
+			Tags:        []string{"Go", "backend"},
+		})
+		a.NoError(err, "no error returned")
+
+		chats, err := c.GetActiveChats(ctx, &proto.GetActiveChatsRequest{Title: "gopher", Tag: "go"})
+		a.NoError(err, "c.GetActiveChats shouldn't return an error")
+		a.Len(chats.GetChats(), 1, "chat should be found by title and tag")
+		a.Equal([]string{"backend", "go"}, chats.GetChats()[0].GetTags(), "tags should be normalized")
+
+		other, _ := c.InitSession(ctx, &proto.InitSessionRequest{})
+		title := "Rustaceans"
+		_, err = c.UpdateChat(ctx, &proto.UpdateChatRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: other.GetSessionUuid(), Title: &title})
+		a.ErrorIs(err, status.Error(codes.PermissionDenied, messenger.ErrUpdateProhibited.Error()), "only owner can update chat")
+
+		updated, err := c.UpdateChat(ctx, &proto.UpdateChatRequest{
+			ChatUuid:    chat.GetChatUuid(),
+			SessionUuid: clientUuid,
+			Title:       &title,
+			Tags:        &proto.ChatTags{Tags: []string{"rust"}},
+		})
+		a.NoError(err, "c.UpdateChat shouldn't return an error")
+		a.Equal(title, updated.GetChat().GetTitle(), "title should be changed")
+		a.Equal("everything about go", updated.GetChat().GetDescription(), "description should stay the same")
+
+		chats, _ = c.GetActiveChats(ctx, &proto.GetActiveChatsRequest{Tag: "go"})
+		for _, v := range chats.GetChats() {
+			a.NotEqual(chat.GetChatUuid(), v.GetChatUuid(), "chat shouldn't be found by removed tag")
+		}
+	})
+
+	t.Run("Change ttl and read-only", func(t *testing.T) {
+		chat, err := c.CreateChat(ctx, &proto.CreateChatRequest{SessionUuid: clientUuid, Ttl: 1})
+		a.NoError(err, "no error returned")
+		member, _ := c.InitSession(ctx, &proto.InitSessionRequest{})
+
+		ttl := int32(0)
+		_, err = c.UpdateChat(ctx, &proto.UpdateChatRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: member.GetSessionUuid(), Ttl: &ttl})
+		a.ErrorIs(err, status.Error(codes.PermissionDenied, messenger.ErrUpdateProhibited.Error()), "only owner can change ttl")
+		_, err = c.UpdateChat(ctx, &proto.UpdateChatRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, Ttl: &ttl})
+		a.NoError(err, "c.UpdateChat shouldn't return an error")
+		time.Sleep(1500 * time.Millisecond)
+		_, err = c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid})
+		a.NoError(err, "chat without ttl shouldn't be deleted")
+
+		readOnly := true
+		_, err = c.UpdateChat(ctx, &proto.UpdateChatRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, ReadOnly: &readOnly})
+		a.NoError(err, "c.UpdateChat shouldn't return an error")
+		_, err = c.SendMessage(ctx, &proto.SendMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: member.GetSessionUuid(), Message: "hi"})
+		a.Equal(codes.PermissionDenied, status.Code(err), "can't send to chat made read-only")
+
+		readOnly = false
+		_, err = c.UpdateChat(ctx, &proto.UpdateChatRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, ReadOnly: &readOnly})
+		a.NoError(err, "c.UpdateChat shouldn't return an error")
+		_, err = c.SendMessage(ctx, &proto.SendMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: member.GetSessionUuid(), Message: "hi"})
+		a.NoError(err, "can send to chat made writable again")
+	})
+
+	t.Run("Chat expiration restored from storage", func(t *testing.T) {
+		//chat with ttl stored before start of messenger is deleted by scheduler rebuilt from storage
+		storage := inmemory.NewStorage(serverConfig.MaxChatSize, serverConfig.MaxChats)
+		sessionUUID, chatUUID := uuid.NewString(), uuid.NewString()
+		storage.AddSession(sessionUUID)
+		a.NoError(storage.AddChat(sessionUUID, 1, false, entities.VisibilityOpen, chatUUID, entities.ChatInfo{}), "storage.AddChat shouldn't return an error")
+
+		m := messenger.NewMessenger(storage, serverConfig.MaxPinned, serverConfig.IdempotencyWindow)
+		defer m.Close()
+		expired := make(chan string, 1)
+		m.OnChatExpired(func(chatUUID string) { expired <- chatUUID })
+		select {
+		case v := <-expired:
+			a.Equal(chatUUID, v, "expired chat should be reported")
+		case <-time.After(3 * time.Second):
+			a.Fail("chat should expire")
+		}
+		_, err := storage.GetChat(chatUUID)
+		a.ErrorIs(err, repository.ErrNotFound, "expired chat should be deleted")
+	})
+
+	t.Run("Redis finds expired chats it wasn't notified about", func(t *testing.T) {
+		//chat deleted without notification (ex. while connection to Redis was lost) is found by periodic sweep
+		storage := redis.NewStorage(redis.Config{
+			Addr:                 os.Getenv("REDIS_ADDRESS"),
+			Password:             os.Getenv("REDIS_PASSWORD"),
+			KeyspaceEvents:       true,
+			ExpiredSweepInterval: 200 * time.Millisecond,
+		}, serverConfig.MaxChatSize, serverConfig.MaxChats)
+		defer storage.Close()
+		sessionUUID, chatUUID := uuid.NewString(), uuid.NewString()
+		expired := make(chan string, 1)
+		native := storage.OnChatExpired(func(expiration entities.ChatExpiration) {
+			if expiration.ChatUUID == chatUUID {
+				expired <- expiration.ChatUUID
+			}
+		})
+		a.True(native, "redis should delete chats by ttl by itself")
+		storage.AddSession(sessionUUID)
+		a.NoError(storage.AddChat(sessionUUID, 1, false, entities.VisibilityOpen, chatUUID, entities.ChatInfo{}), "storage.AddChat shouldn't return an error")
+
+		client := goredis.NewClient(&goredis.Options{Addr: os.Getenv("REDIS_ADDRESS"), Password: os.Getenv("REDIS_PASSWORD")})
+		defer client.Close()
+		a.NoError(client.Del(ctx, "chat:"+chatUUID).Err(), "deleted key isn't reported as expired")
+		select {
+		case v := <-expired:
+			a.Equal(chatUUID, v, "expired chat should be reported")
+		case <-time.After(3 * time.Second):
+			a.Fail("expired chat should be found by sweep")
+		}
+	})
+
+	t.Run("Closed messenger stops expiring chats", func(t *testing.T) {
+		storage := inmemory.NewStorage(serverConfig.MaxChatSize, serverConfig.MaxChats)
+		sessionUUID, chatUUID := uuid.NewString(), uuid.NewString()
+		storage.AddSession(sessionUUID)
+		a.NoError(storage.AddChat(sessionUUID, 1, false, entities.VisibilityOpen, chatUUID, entities.ChatInfo{}), "storage.AddChat shouldn't return an error")
+
+		m := messenger.NewMessenger(storage, serverConfig.MaxPinned, serverConfig.IdempotencyWindow)
+		m.Close()
+		m.Close()
+		time.Sleep(time.Second + 500*time.Millisecond)
+		_, err := storage.GetChat(chatUUID)
+		a.NoError(err, "chat shouldn't be deleted after messenger is closed")
+	})
+
+	t.Run("Search messages", func(t *testing.T) {
+		chat, err := c.CreateChat(ctx, &proto.CreateChatRequest{SessionUuid: clientUuid, Ttl: -1})
+		a.NoError(err, "no error returned")
+		private, err := c.CreateChat(ctx, &proto.CreateChatRequest{SessionUuid: clientUuid, Ttl: -1, Visibility: proto.ChatVisibility_CHAT_VISIBILITY_MEMBERS_ONLY})
+		a.NoError(err, "no error returned")
+		query := uuid.NewString()
+		c.SendMessage(ctx, &proto.SendMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, Message: "first " + query})
+		c.SendMessage(ctx, &proto.SendMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, Message: "second " + query})
+		c.SendMessage(ctx, &proto.SendMessageRequest{ChatUuid: private.GetChatUuid(), SessionUuid: clientUuid, Message: "secret " + query})
+
+		found, err := c.SearchMessages(ctx, &proto.SearchMessagesRequest{Query: "SECOND " + query, SessionUuid: clientUuid})
+		a.NoError(err, "c.SearchMessages shouldn't return an error")
+		a.Len(found.GetResults(), 1, "message should contain all words of query")
+		a.Equal("second "+query, found.GetResults()[0].GetMessage().GetText())
+		a.Equal(int32(0), found.GetResults()[0].GetHighlights()[0].GetStart(), "matched words should be highlighted")
+
+		found, err = c.SearchMessages(ctx, &proto.SearchMessagesRequest{Query: query, SessionUuid: clientUuid, PageSize: 2})
+		a.NoError(err, "c.SearchMessages shouldn't return an error")
+		a.Len(found.GetResults(), 2, "page should be full")
+		a.Equal("secret "+query, found.GetResults()[0].GetMessage().GetText(), "newest messages go first")
+		next, err := c.SearchMessages(ctx, &proto.SearchMessagesRequest{Query: query, SessionUuid: clientUuid, PageSize: 2, PageToken: found.GetNextPageToken()})
+		a.NoError(err, "c.SearchMessages shouldn't return an error")
+		a.Len(next.GetResults(), 1, "rest of messages should be on the next page")
+		a.Empty(next.GetNextPageToken(), "last page has no next page token")
+		found, err = c.SearchMessages(ctx, &proto.SearchMessagesRequest{Query: query, SessionUuid: clientUuid, ChatUuid: private.GetChatUuid()})
+		a.NoError(err, "c.SearchMessages shouldn't return an error")
+		if a.Len(found.GetResults(), 1, "only messages of chat provided should be found") {
+			a.Equal(private.GetChatUuid(), found.GetResults()[0].GetChatUuid())
+		}
+
+		other, _ := c.InitSession(ctx, &proto.InitSessionRequest{})
+		found, err = c.SearchMessages(ctx, &proto.SearchMessagesRequest{Query: query, SessionUuid: other.GetSessionUuid()})
+		a.NoError(err, "c.SearchMessages shouldn't return an error")
+		a.Len(found.GetResults(), 2, "messages of members-only chat shouldn't be found by non-member")
+
+		_, err = c.SearchMessages(ctx, &proto.SearchMessagesRequest{Query: " ,. "})
+		a.ErrorIs(err, status.Error(codes.InvalidArgument, messenger.ErrInvalidQuery.Error()), "query without words is invalid")
+	})
+
+	t.Run("Attachments", func(t *testing.T) {
+		chat, err := c.CreateChat(ctx, &proto.CreateChatRequest{SessionUuid: clientUuid, Ttl: -1})
+		a.NoError(err, "no error returned")
+		content := []byte("attachment " + uuid.NewString())
+
+		upload, err := c.UploadAttachment(ctx)
+		a.NoError(err, "c.UploadAttachment shouldn't return an error")
+		upload.Send(&proto.UploadAttachmentRequest{Payload: &proto.UploadAttachmentRequest_Info{Info: &proto.UploadAttachmentInfo{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, Name: "notes.txt"}}})
+		upload.Send(&proto.UploadAttachmentRequest{Payload: &proto.UploadAttachmentRequest_Chunk{Chunk: content[:5]}})
+		upload.Send(&proto.UploadAttachmentRequest{Payload: &proto.UploadAttachmentRequest_Chunk{Chunk: content[5:]}})
+		uploaded, err := upload.CloseAndRecv()
+		a.NoError(err, "upload.CloseAndRecv shouldn't return an error")
+		attachment := uploaded.GetAttachment()
+		a.Equal("text/plain", attachment.GetContentType(), "type should be detected from content")
+		a.Equal(int64(len(content)), attachment.GetSize())
+
+		_, err = c.SendMessage(ctx, &proto.SendMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, AttachmentIds: []string{attachment.GetAttachmentId()}})
+		a.NoError(err, "message can consist of attachments only")
+		history, err := c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: chat.GetChatUuid()})
+		a.NoError(err, "c.GetHistory shouldn't return an error")
+		a.Equal([]string{attachment.GetAttachmentId()}, history.GetMessages()[0].GetAttachmentIds())
+		_, err = c.SendMessage(ctx, &proto.SendMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, AttachmentIds: []string{uuid.NewString()}})
+		a.ErrorIs(err, status.Error(codes.NotFound, messenger.ErrAttachmentNotFound.Error()), "attachment should be uploaded to chat")
+
+		download, err := c.DownloadAttachment(ctx, &proto.DownloadAttachmentRequest{ChatUuid: chat.GetChatUuid(), AttachmentId: attachment.GetAttachmentId()})
+		a.NoError(err, "c.DownloadAttachment shouldn't return an error")
+		first, err := download.Recv()
+		a.NoError(err, "download.Recv shouldn't return an error")
+		a.Equal("notes.txt", first.GetAttachment().GetName(), "description of attachment goes first")
+		var downloaded []byte
+		for {
+			resp, err := download.Recv()
+			if err != nil {
+				break
+			}
+			downloaded = append(downloaded, resp.GetChunk()...)
+		}
+		a.Equal(content, downloaded, "downloaded content should match uploaded")
+
+		upload, _ = c.UploadAttachment(ctx)
+		upload.Send(&proto.UploadAttachmentRequest{Payload: &proto.UploadAttachmentRequest_Info{Info: &proto.UploadAttachmentInfo{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, Name: "app.exe"}}})
+		upload.Send(&proto.UploadAttachmentRequest{Payload: &proto.UploadAttachmentRequest_Chunk{Chunk: []byte("MZ\x90\x00\x03\x00\x00\x00\x04\x00\x00\x00\xff\xff")}})
+		_, err = upload.CloseAndRecv()
+		a.ErrorIs(err, status.Error(codes.InvalidArgument, messenger.ErrAttachmentTypeNotAllowed.Error()), "type of attachment should be allowed")
+	})
+
+	t.Run("Attachments quota and deletion", func(t *testing.T) {
+		chat, err := c.CreateChat(ctx, &proto.CreateChatRequest{SessionUuid: clientUuid, Ttl: -1})
+		a.NoError(err, "no error returned")
+		upload := func(content []byte) (*proto.Attachment, error) {
+			stream, err := c.UploadAttachment(ctx)
+			a.NoError(err, "c.UploadAttachment shouldn't return an error")
+			stream.Send(&proto.UploadAttachmentRequest{Payload: &proto.UploadAttachmentRequest_Info{Info: &proto.UploadAttachmentInfo{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, Name: "notes.txt"}}})
+			stream.Send(&proto.UploadAttachmentRequest{Payload: &proto.UploadAttachmentRequest_Chunk{Chunk: content}})
+			uploaded, err := stream.CloseAndRecv()
+			return uploaded.GetAttachment(), err
+		}
+
+		//test quota is 4096 bytes per chat
+		attachment, err := upload([]byte(strings.Repeat("a", 4096)))
+		a.NoError(err, "attachment that fits quota should be uploaded")
+		_, err = upload([]byte("one byte more"))
+		a.ErrorIs(err, status.Error(codes.ResourceExhausted, messenger.ErrAttachmentQuotaExceeded.Error()), "chat quota shouldn't be exceeded")
+
+		_, err = c.SendMessage(ctx, &proto.SendMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, AttachmentIds: []string{attachment.GetAttachmentId()}})
+		a.NoError(err, "c.SendMessage shouldn't return an error")
+		history, err := c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: chat.GetChatUuid()})
+		a.NoError(err, "c.GetHistory shouldn't return an error")
+		_, err = c.DeleteMessage(ctx, &proto.DeleteMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, MessageUuid: history.GetMessages()[0].GetMessageUuid()})
+		a.NoError(err, "c.DeleteMessage shouldn't return an error")
+
+		download, err := c.DownloadAttachment(ctx, &proto.DownloadAttachmentRequest{ChatUuid: chat.GetChatUuid(), AttachmentId: attachment.GetAttachmentId()})
+		a.NoError(err, "c.DownloadAttachment shouldn't return an error")
+		_, err = download.Recv()
+		a.ErrorIs(err, status.Error(codes.NotFound, messenger.ErrAttachmentNotFound.Error()), "attachment of deleted message should be deleted with it")
+		_, err = upload([]byte("one byte more"))
+		a.NoError(err, "deleted attachment shouldn't count towards quota")
+	})
+
+	t.Run("Threads", func(t *testing.T) {
+		chat, err := c.CreateChat(ctx, &proto.CreateChatRequest{SessionUuid: clientUuid, Ttl: -1})
+		a.NoError(err, "no error returned")
+		c.SendMessage(ctx, &proto.SendMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, Message: "root"})
+		history, err := c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: chat.GetChatUuid()})
+		a.NoError(err, "c.GetHistory shouldn't return an error")
+		root := history.GetMessages()[0].GetMessageUuid()
+
+		_, err = c.SendMessage(ctx, &proto.SendMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, Message: "reply", ReplyToMessageUuid: root})
+		a.NoError(err, "c.SendMessage shouldn't return an error")
+		history, _ = c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: chat.GetChatUuid()})
+		reply := history.GetMessages()[1].GetMessageUuid()
+		_, err = c.SendMessage(ctx, &proto.SendMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, Message: "reply to reply", ReplyToMessageUuid: reply})
+		a.NoError(err, "c.SendMessage shouldn't return an error")
+
+		history, _ = c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: chat.GetChatUuid()})
+		a.Equal(int32(2), history.GetMessages()[0].GetReplyCount(), "history should have reply count of root")
+		a.Equal(root, history.GetMessages()[2].GetReplyToMessageUuid(), "reply to reply goes to the same thread")
+
+		thread, err := c.GetThread(ctx, &proto.GetThreadRequest{ChatUuid: chat.GetChatUuid(), RootMessageUuid: reply})
+		a.NoError(err, "c.GetThread shouldn't return an error")
+		a.Equal(root, thread.GetRoot().GetMessageUuid(), "thread of reply is the thread of its root")
+		a.Len(thread.GetReplies(), 2)
+		a.Equal("reply", thread.GetReplies()[0].GetText(), "replies are ordered by seq")
+
+		_, err = c.SendMessage(ctx, &proto.SendMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, Message: "reply", ReplyToMessageUuid: uuid.NewString()})
+		a.ErrorIs(err, status.Error(codes.NotFound, messenger.ErrMessageNotFound.Error()), "message replied to should be in chat")
+	})
+
+	t.Run("Reactions", func(t *testing.T) {
+		chat, err := c.CreateChat(ctx, &proto.CreateChatRequest{SessionUuid: clientUuid, Ttl: -1})
+		a.NoError(err, "no error returned")
+		c.SendMessage(ctx, &proto.SendMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, Message: "react to me"})
+		history, err := c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: chat.GetChatUuid()})
+		a.NoError(err, "c.GetHistory shouldn't return an error")
+		message := history.GetMessages()[0].GetMessageUuid()
+		other, _ := c.InitSession(ctx, &proto.InitSessionRequest{})
+
+		_, err = c.AddReaction(ctx, &proto.AddReactionRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, MessageUuid: message, Emoji: "👍"})
+		a.NoError(err, "c.AddReaction shouldn't return an error")
+		_, err = c.AddReaction(ctx, &proto.AddReactionRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, MessageUuid: message, Emoji: "👍"})
+		a.NoError(err, "reacting twice with the same emoji is not an error")
+		c.AddReaction(ctx, &proto.AddReactionRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: other.GetSessionUuid(), MessageUuid: message, Emoji: "👍"})
+		c.AddReaction(ctx, &proto.AddReactionRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: other.GetSessionUuid(), MessageUuid: message, Emoji: "🎉"})
+
+		history, _ = c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid})
+		reactions := history.GetMessages()[0].GetReactions()
+		a.Len(reactions, 2)
+		a.Equal("👍", reactions[0].GetEmoji(), "the most popular reaction goes first")
+		a.Equal(int32(2), reactions[0].GetCount(), "reaction of session is counted once")
+		a.True(reactions[0].GetReacted(), "caller reacted with 👍")
+		a.False(reactions[1].GetReacted(), "caller didn't react with 🎉")
+
+		_, err = c.RemoveReaction(ctx, &proto.RemoveReactionRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: other.GetSessionUuid(), MessageUuid: message, Emoji: "🎉"})
+		a.NoError(err, "c.RemoveReaction shouldn't return an error")
+		history, _ = c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: chat.GetChatUuid()})
+		a.Len(history.GetMessages()[0].GetReactions(), 1, "removed reaction shouldn't be counted")
+
+		_, err = c.AddReaction(ctx, &proto.AddReactionRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, MessageUuid: message, Emoji: "like"})
+		a.ErrorIs(err, status.Error(codes.InvalidArgument, messenger.ErrInvalidEmoji.Error()), "reaction should be emoji")
+
+		c.DeleteMessage(ctx, &proto.DeleteMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, MessageUuid: message})
+		history, _ = c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: chat.GetChatUuid()})
+		a.Empty(history.GetMessages()[0].GetReactions(), "reactions are deleted with message")
+		_, err = c.AddReaction(ctx, &proto.AddReactionRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, MessageUuid: message, Emoji: "👍"})
+		a.ErrorIs(err, status.Error(codes.NotFound, messenger.ErrMessageNotFound.Error()), "deleted message can't be reacted to")
+	})
+
+	t.Run("Read receipts", func(t *testing.T) {
+		reader, _ := c.InitSession(ctx, &proto.InitSessionRequest{})
+		chat, err := c.CreateChat(ctx, &proto.CreateChatRequest{SessionUuid: clientUuid, Ttl: -1})
+		a.NoError(err, "no error returned")
+		_, err = c.JoinChat(ctx, &proto.JoinChatRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: reader.GetSessionUuid()})
+		a.NoError(err, "c.JoinChat shouldn't return an error")
+		for _, v := range []string{"first", "second", "third"} {
+			c.SendMessage(ctx, &proto.SendMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, Message: v})
+		}
+		history, _ := c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: chat.GetChatUuid()})
+		unread := func() int32 {
+			chats, err := c.ListMyChats(ctx, &proto.ListMyChatsRequest{SessionUuid: reader.GetSessionUuid()})
+			a.NoError(err, "c.ListMyChats shouldn't return an error")
+			for _, v := range chats.GetChats() {
+				if v.GetChat().GetChatUuid() == chat.GetChatUuid() {
+					return v.GetUnreadCount()
+				}
+			}
+			t.Fatal("joined chat should be listed")
+			return 0
+		}
+		a.Equal(int32(3), unread())
+
+		_, err = c.MarkRead(ctx, &proto.MarkReadRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: reader.GetSessionUuid(), UpToMessageUuid: history.GetMessages()[1].GetMessageUuid()})
+		a.NoError(err, "c.MarkRead shouldn't return an error")
+		a.Equal(int32(1), unread())
+		c.MarkRead(ctx, &proto.MarkReadRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: reader.GetSessionUuid(), UpToMessageUuid: history.GetMessages()[0].GetMessageUuid()})
+		a.Equal(int32(1), unread(), "read pointer only moves forward")
+
+		receipts, err := c.GetReadReceipts(ctx, &proto.GetReadReceiptsRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, MessageUuid: history.GetMessages()[1].GetMessageUuid()})
+		a.NoError(err, "c.GetReadReceipts shouldn't return an error")
+		a.Len(receipts.GetReceipts(), 1)
+		a.Equal(reader.GetSessionUuid(), receipts.GetReceipts()[0].GetSessionUuid())
+		receipts, _ = c.GetReadReceipts(ctx, &proto.GetReadReceiptsRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, MessageUuid: history.GetMessages()[2].GetMessageUuid()})
+		a.Empty(receipts.GetReceipts(), "last message isn't read yet")
+		_, err = c.GetReadReceipts(ctx, &proto.GetReadReceiptsRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: reader.GetSessionUuid(), MessageUuid: history.GetMessages()[1].GetMessageUuid()})
+		a.ErrorIs(err, status.Error(codes.PermissionDenied, messenger.ErrReadReceiptsProhibited.Error()), "only owner can see read receipts")
+
+		c.MarkRead(ctx, &proto.MarkReadRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: reader.GetSessionUuid()})
+		a.Equal(int32(0), unread(), "without message all messages are read")
+	})
+
+	t.Run("Typing and presence", func(t *testing.T) {
+		typist, _ := c.InitSession(ctx, &proto.InitSessionRequest{})
+		chat, err := c.CreateChat(ctx, &proto.CreateChatRequest{SessionUuid: clientUuid, Ttl: -1})
+		a.NoError(err, "no error returned")
+		c.JoinChat(ctx, &proto.JoinChatRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: typist.GetSessionUuid()})
+
+		_, err = c.SetTyping(ctx, &proto.SetTypingRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: typist.GetSessionUuid()})
+		a.NoError(err, "c.SetTyping shouldn't return an error")
+		typing, err := c.GetTyping(ctx, &proto.GetTypingRequest{ChatUuid: chat.GetChatUuid()})
+		a.NoError(err, "c.GetTyping shouldn't return an error")
+		a.Equal([]string{typist.GetSessionUuid()}, typing.GetSessionUuids())
+
+		c.SendMessage(ctx, &proto.SendMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: typist.GetSessionUuid(), Message: "typed"})
+		typing, _ = c.GetTyping(ctx, &proto.GetTypingRequest{ChatUuid: chat.GetChatUuid()})
+		a.Empty(typing.GetSessionUuids(), "sent message ends typing")
+		history, _ := c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: chat.GetChatUuid()})
+		a.Len(history.GetMessages(), 1, "typing is not written to history")
+
+		presence, err := c.GetPresence(ctx, &proto.GetPresenceRequest{SessionUuids: []string{typist.GetSessionUuid(), uuid.NewString()}})
+		a.NoError(err, "c.GetPresence shouldn't return an error")
+		a.Len(presence.GetPresences(), 2)
+		a.True(presence.GetPresences()[0].GetOnline(), "session that made requests is online")
+		a.NotNil(presence.GetPresences()[0].GetLastSeen())
+		a.False(presence.GetPresences()[1].GetOnline(), "session without requests is offline")
+		a.Nil(presence.GetPresences()[1].GetLastSeen())
+
+		_, err = c.GetPresence(ctx, &proto.GetPresenceRequest{SessionUuids: []string{"not uuid"}})
+		a.ErrorIs(err, status.Error(codes.InvalidArgument, messenger.ErrInvalidSessionUUID.Error()))
+	})
+
+	t.Run("Pinned messages", func(t *testing.T) {
+		member, _ := c.InitSession(ctx, &proto.InitSessionRequest{})
+		chat, err := c.CreateChat(ctx, &proto.CreateChatRequest{SessionUuid: clientUuid, Ttl: -1})
+		a.NoError(err, "no error returned")
+		c.JoinChat(ctx, &proto.JoinChatRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: member.GetSessionUuid()})
+		c.SendMessage(ctx, &proto.SendMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, Message: "announcement"})
+		history, _ := c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: chat.GetChatUuid()})
+		announcement := history.GetMessages()[0].GetMessageUuid()
+
+		_, err = c.PinMessage(ctx, &proto.PinMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: member.GetSessionUuid(), MessageUuid: announcement})
+		a.ErrorIs(err, status.Error(codes.PermissionDenied, messenger.ErrPinProhibited.Error()))
+		_, err = c.PinMessage(ctx, &proto.PinMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, MessageUuid: announcement})
+		a.NoError(err, "c.PinMessage shouldn't return an error")
+
+		//pinned message is kept when chat is trimmed by max chat size
+		for range serverConfig.MaxChatSize {
+			c.SendMessage(ctx, &proto.SendMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: member.GetSessionUuid(), Message: "chatter"})
+		}
+		history, _ = c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: chat.GetChatUuid()})
+		if a.Len(history.GetMessages(), serverConfig.MaxChatSize+1) {
+			a.Equal(announcement, history.GetMessages()[0].GetMessageUuid())
+		}
+		chats, _ := c.ListMyChats(ctx, &proto.ListMyChatsRequest{SessionUuid: clientUuid})
+		for _, v := range chats.GetChats() {
+			if v.GetChat().GetChatUuid() == chat.GetChatUuid() && a.Len(v.GetChat().GetPinned(), 1) {
+				a.Equal(announcement, v.GetChat().GetPinned()[0].GetMessageUuid())
+				a.Equal(clientUuid, v.GetChat().GetPinned()[0].GetSessionUuid())
+			}
+		}
+
+		//pins are limited
+		for _, v := range history.GetMessages()[1:serverConfig.MaxPinned] {
+			c.PinMessage(ctx, &proto.PinMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, MessageUuid: v.GetMessageUuid()})
+		}
+		_, err = c.PinMessage(ctx, &proto.PinMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, MessageUuid: history.GetMessages()[serverConfig.MaxPinned].GetMessageUuid()})
+		a.ErrorIs(err, status.Error(codes.FailedPrecondition, messenger.ErrTooManyPinned.Error()))
+
+		//unpinned message is trimmed
+		_, err = c.UnpinMessage(ctx, &proto.UnpinMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, MessageUuid: announcement})
+		a.NoError(err, "c.UnpinMessage shouldn't return an error")
+		history, _ = c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: chat.GetChatUuid()})
+		a.Len(history.GetMessages(), serverConfig.MaxChatSize)
+	})
+
+	t.Run("Scheduled messages", func(t *testing.T) {
+		chat, err := c.CreateChat(ctx, &proto.CreateChatRequest{SessionUuid: clientUuid, Ttl: -1})
+		a.NoError(err, "no error returned")
+
+		_, err = c.ScheduleMessage(ctx, &proto.ScheduleMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, Text: "too late", SendAt: timestamppb.New(time.Now().Add(-time.Second))})
+		a.ErrorIs(err, status.Error(codes.InvalidArgument, messenger.ErrInvalidSendAt.Error()))
+
+		sent, err := c.ScheduleMessage(ctx, &proto.ScheduleMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, Text: "later", SendAt: timestamppb.New(time.Now().Add(time.Second))})
+		a.NoError(err, "c.ScheduleMessage shouldn't return an error")
+		canceled, _ := c.ScheduleMessage(ctx, &proto.ScheduleMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, Text: "never", SendAt: timestamppb.New(time.Now().Add(2 * time.Second))})
+		scheduled, err := c.ListScheduled(ctx, &proto.ListScheduledRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid})
+		a.NoError(err, "c.ListScheduled shouldn't return an error")
+		if a.Len(scheduled.GetMessages(), 2) {
+			a.Equal(sent.GetScheduledUuid(), scheduled.GetMessages()[0].GetScheduledUuid())
+			a.Equal("later", scheduled.GetMessages()[0].GetText())
+		}
+
+		//canceled message is not sent
+		_, err = c.CancelScheduled(ctx, &proto.CancelScheduledRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, ScheduledUuid: canceled.GetScheduledUuid()})
+		a.NoError(err, "c.CancelScheduled shouldn't return an error")
+		_, err = c.CancelScheduled(ctx, &proto.CancelScheduledRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, ScheduledUuid: canceled.GetScheduledUuid()})
+		a.ErrorIs(err, status.Error(codes.NotFound, messenger.ErrScheduledNotFound.Error()))
+
+		time.Sleep(3 * time.Second)
+		history, _ := c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: chat.GetChatUuid()})
+		if a.Len(history.GetMessages(), 1) {
+			a.Equal("later", history.GetMessages()[0].GetText())
+			a.Equal(clientUuid, history.GetMessages()[0].GetSessionUuid())
+		}
+		scheduled, _ = c.ListScheduled(ctx, &proto.ListScheduledRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid})
+		a.Empty(scheduled.GetMessages())
+	})
+
+	t.Run("Scheduled message is kept when sending fails", func(t *testing.T) {
+		storage := failingStorage{Storage: inmemory.NewStorage(serverConfig.MaxChatSize, serverConfig.MaxChats)}
+		sessionUUID, chatUUID := uuid.NewString(), uuid.NewString()
+		storage.AddSession(sessionUUID)
+		a.NoError(storage.AddChat(sessionUUID, 0, false, entities.VisibilityOpen, chatUUID, entities.ChatInfo{}), "storage.AddChat shouldn't return an error")
+
+		m := messenger.NewMessenger(storage, serverConfig.MaxPinned, serverConfig.IdempotencyWindow)
+		defer m.Close()
+		scheduled, err := m.ScheduleMessage(sessionUUID, chatUUID, "later", time.Now().Add(time.Second))
+		a.NoError(err, "m.ScheduleMessage shouldn't return an error")
+		time.Sleep(2 * time.Second)
+		pending, err := m.ListScheduled(sessionUUID, chatUUID)
+		a.NoError(err, "m.ListScheduled shouldn't return an error")
+		if a.Len(pending, 1, "message that wasn't sent should be sent again") {
+			a.Equal(scheduled.ScheduledUUID, pending[0].ScheduledUUID)
+		}
+
+		//message that is being sent can't be canceled
+		claimed, err := storage.ClaimScheduled(chatUUID, scheduled.ScheduledUUID, time.Minute)
+		a.NoError(err, "storage.ClaimScheduled shouldn't return an error")
+		a.True(claimed, "claim of message that failed to send should be released")
+		a.ErrorIs(m.CancelScheduled(sessionUUID, chatUUID, scheduled.ScheduledUUID), messenger.ErrScheduledNotFound)
+		a.NoError(storage.ReleaseScheduled(chatUUID, scheduled.ScheduledUUID), "storage.ReleaseScheduled shouldn't return an error")
+		a.NoError(m.CancelScheduled(sessionUUID, chatUUID, scheduled.ScheduledUUID), "released message can be canceled")
+	})
+
+	t.Run("Self-destructing messages", func(t *testing.T) {
+		chat, err := c.CreateChat(ctx, &proto.CreateChatRequest{SessionUuid: clientUuid, Ttl: -1})
+		a.NoError(err, "no error returned")
+
+		_, err = c.SendMessage(ctx, &proto.SendMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, Message: "never", ExpireAfterSeconds: -1})
+		a.ErrorIs(err, status.Error(codes.InvalidArgument, messenger.ErrInvalidDuration.Error()))
+
+		_, err = c.SendMessage(ctx, &proto.SendMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, Message: "one-time password", ExpireAfterSeconds: 1})
+		a.NoError(err, "c.SendMessage shouldn't return an error")
+		c.SendMessage(ctx, &proto.SendMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, Message: "stays"})
+		//Chat stream sends self-destructing messages too
+		stream, err := c.Chat(ctx)
+		a.NoError(err, "c.Chat shouldn't return an error")
+		err = stream.Send(&proto.ChatRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, Message: "streamed password", ExpireAfterSeconds: 1})
+		a.NoError(err, "stream.Send shouldn't return an error")
+		resp, err := stream.Recv()
+		if a.NoError(err, "stream.Recv shouldn't return an error") {
+			a.NotEmpty(resp.GetAck().GetMessageUuid(), "message should be acked")
+		}
+		history, _ := c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: chat.GetChatUuid()})
+		if a.Len(history.GetMessages(), 3) {
+			a.NotNil(history.GetMessages()[0].GetExpiresAt())
+			a.Nil(history.GetMessages()[1].GetExpiresAt())
+			a.NotNil(history.GetMessages()[2].GetExpiresAt())
+		}
+
+		//expired message leaves tombstone
+		time.Sleep(2 * time.Second)
+		history, _ = c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: chat.GetChatUuid()})
+		if a.Len(history.GetMessages(), 3) {
+			a.True(history.GetMessages()[0].GetDeleted())
+			a.Empty(history.GetMessages()[0].GetText())
+			a.Equal("stays", history.GetMessages()[1].GetText())
+			a.True(history.GetMessages()[2].GetDeleted())
+		}
+	})
+
+	t.Run("Idempotent SendMessage", func(t *testing.T) {
+		chat, err := c.CreateChat(ctx, &proto.CreateChatRequest{SessionUuid: clientUuid, Ttl: -1})
+		a.NoError(err, "no error returned")
+
+		_, err = c.SendMessage(ctx, &proto.SendMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, Message: "never", IdempotencyKey: strings.Repeat("k", 129)})
+		a.ErrorIs(err, status.Error(codes.InvalidArgument, messenger.ErrInvalidIdempotencyKey.Error()))
+
+		sent, err := c.SendMessage(ctx, &proto.SendMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, Message: "once", IdempotencyKey: "key-1"})
+		a.NoError(err, "c.SendMessage shouldn't return an error")
+		a.NotEmpty(sent.GetMessageUuid())
+
+		//retry returns the first message and doesn't send it again
+		retried, err := c.SendMessage(ctx, &proto.SendMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, Message: "once", IdempotencyKey: "key-1"})
+		a.NoError(err, "retry shouldn't return an error")
+		a.Equal(sent.GetMessageUuid(), retried.GetMessageUuid())
+		other, _ := c.SendMessage(ctx, &proto.SendMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, Message: "twice", IdempotencyKey: "key-2"})
+		a.NotEqual(sent.GetMessageUuid(), other.GetMessageUuid())
+
+		history, _ := c.GetHistory(ctx, &proto.GetHistoryRequest{ChatUuid: chat.GetChatUuid()})
+		if a.Len(history.GetMessages(), 2) {
+			a.Equal(sent.GetMessageUuid(), history.GetMessages()[0].GetMessageUuid())
+			a.Equal(other.GetMessageUuid(), history.GetMessages()[1].GetMessageUuid())
+		}
+
+		//key can't be reused for another request
+		_, err = c.SendMessage(ctx, &proto.SendMessageRequest{ChatUuid: chat.GetChatUuid(), SessionUuid: clientUuid, Message: "changed", IdempotencyKey: "key-1"})
+		a.ErrorIs(err, status.Error(codes.InvalidArgument, messenger.ErrIdempotencyKeyReused.Error()))
+		another, _ := c.CreateChat(ctx, &proto.CreateChatRequest{SessionUuid: clientUuid, Ttl: -1})
+		_, err = c.SendMessage(ctx, &proto.SendMessageRequest{ChatUuid: another.GetChatUuid(), SessionUuid: clientUuid, Message: "once", IdempotencyKey: "key-1"})
+		a.ErrorIs(err, status.Error(codes.InvalidArgument, messenger.ErrIdempotencyKeyReused.Error()))
+	})
+
+	t.Run("Idempotent SendMessage in progress", func(t *testing.T) {
+		storage := blockingStorage{Storage: inmemory.NewStorage(serverConfig.MaxChatSize, serverConfig.MaxChats), release: make(chan struct{})}
+		sessionUUID, chatUUID := uuid.NewString(), uuid.NewString()
+		storage.AddSession(sessionUUID)
+		a.NoError(storage.AddChat(sessionUUID, 0, false, entities.VisibilityOpen, chatUUID, entities.ChatInfo{}), "storage.AddChat shouldn't return an error")
+		m := messenger.NewMessenger(storage, serverConfig.MaxPinned, serverConfig.IdempotencyWindow)
+		defer m.Close()
+
+		first := make(chan entities.Message)
+		go func() {
+			sent, _ := m.SendMessage(sessionUUID, chatUUID, "once", nil, "", 0, "key")
+			first <- sent
+		}()
+		time.Sleep(100 * time.Millisecond)
+		_, err := m.SendMessage(sessionUUID, chatUUID, "once", nil, "", 0, "key")
+		a.ErrorIs(err, messenger.ErrIdempotencyKeyInFlight, "retry shouldn't be sent while the first request is in progress")
+
+		close(storage.release)
+		sent := <-first
+		retried, err := m.SendMessage(sessionUUID, chatUUID, "once", nil, "", 0, "key")
+		a.NoError(err, "retry after the first request is sent shouldn't return an error")
+		a.Equal(sent.MessageUUID, retried.MessageUUID)
+	})
+
+	t.Run("Idempotent SendMessage when key isn't marked as sent", func(t *testing.T) {
+		storage := failingStorage{Storage: inmemory.NewStorage(serverConfig.MaxChatSize, serverConfig.MaxChats), keepMessages: true}
+		sessionUUID, chatUUID := uuid.NewString(), uuid.NewString()
+		storage.AddSession(sessionUUID)
+		a.NoError(storage.AddChat(sessionUUID, 0, false, entities.VisibilityOpen, chatUUID, entities.ChatInfo{}), "storage.AddChat shouldn't return an error")
+		m := messenger.NewMessenger(storage, serverConfig.MaxPinned, serverConfig.IdempotencyWindow)
+		defer m.Close()
+
+		sent, err := m.SendMessage(sessionUUID, chatUUID, "once", nil, "", 0, "key")
+		a.ErrorIs(err, messenger.ErrIdempotencyKeyNotMarked, "failure to mark key should be returned")
+		a.NotEmpty(sent.MessageUUID, "stored message should be returned")
+		history, _, err := m.GetHistory(sessionUUID, chatUUID, 0, "", entities.DirectionOlder)
+		a.NoError(err, "m.GetHistory shouldn't return an error")
+		if a.Len(history, 1, "message should be stored") {
+			a.Equal(sent.MessageUUID, history[0].MessageUUID)
+		}
+		_, err = m.SendMessage(sessionUUID, chatUUID, "once", nil, "", 0, "key")
+		a.ErrorIs(err, messenger.ErrIdempotencyKeyInFlight, "retry shouldn't send message again while key isn't marked")
+	})
 }